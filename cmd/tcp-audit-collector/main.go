@@ -0,0 +1,167 @@
+// Command tcp-audit-collector receives TCP state-change events from many
+// tcp-audit agents over HTTP, and stores them using the PostgreSQL sink, so
+// that only the collector holds the database credentials.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/pgconfig"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/pgsqlsink"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "tcp-audit-collector: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("tcp-audit-collector", flag.ContinueOnError)
+	unixPath := flags.String("unix", "", "path of a Unix socket on which to listen")
+	tcpAddr := flags.String("listen", "", "TCP address on which to listen, such as :8443")
+	tokensPath := flags.String("tokens", "", "file of agent hosts and their tokens (required)")
+	tlsCert := flags.String("tls-cert", "", "TLS certificate file for the TCP listener")
+	tlsKey := flags.String("tls-key", "", "TLS private key file for the TCP listener")
+	shutdownTimeout := flags.Duration("shutdown-timeout", 10*time.Second, "time allowed for requests to complete on shutdown")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *unixPath == "" && *tcpAddr == "" {
+		return errors.New("at least one of -unix and -listen is required")
+	}
+
+	if *tokensPath == "" {
+		return errors.New("-tokens is required")
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+
+	tokens, err := loadTokens(*tokensPath)
+	if err != nil {
+		return fmt.Errorf("loading tokens: %w", err)
+	}
+
+	config, err := pgsqlsink.ConfigFromEnv()
+	if err != nil {
+		return fmt.Errorf("getting sink config: %w", err)
+	}
+	config.RemoteEvents = true
+
+	if config.ConnString, err = new(pgconfig.EnvVarConfigGetter).Config(); err != nil {
+		return fmt.Errorf("getting connection string from config: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	sinker, err := pgsqlsink.New(ctx, config, pgsqlsink.WithLogger(logger))
+	if err != nil {
+		return fmt.Errorf("creating sink: %w", err)
+	}
+	defer func() {
+		if err := sinker.Close(); err != nil {
+			logger.Printf("Error closing sink: %v", err)
+		}
+	}()
+
+	httpServer := &http.Server{
+		Handler:           newServer(sinker, tokens, logger),
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          logger,
+	}
+
+	serveErrs := make(chan error, 2)
+	if *unixPath != "" {
+		listener, err := listenUnix(*unixPath)
+		if err != nil {
+			return err
+		}
+
+		logger.Printf("Listening on %s", *unixPath)
+		go func() {
+			serveErrs <- httpServer.Serve(listener)
+		}()
+	}
+
+	if *tcpAddr != "" {
+		listener, err := net.Listen("tcp", *tcpAddr)
+		if err != nil {
+			return fmt.Errorf("listening on %s: %w", *tcpAddr, err)
+		}
+
+		if *tlsCert == "" {
+			logger.Printf("Warning: listening on %s without TLS, so tokens are sent in the clear", listener.Addr())
+		} else {
+			logger.Printf("Listening on %s with TLS", listener.Addr())
+		}
+
+		go func() {
+			if *tlsCert != "" {
+				serveErrs <- httpServer.ServeTLS(listener, *tlsCert, *tlsKey)
+				return
+			}
+
+			serveErrs <- httpServer.Serve(listener)
+		}()
+	}
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+	case serveErr = <-serveErrs:
+		serveErr = fmt.Errorf("serving: %w", serveErr)
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancelShutdown()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Printf("Error shutting down server: %v", err)
+	}
+
+	return serveErr
+}
+
+// ListenUnix listens on a Unix socket, removing a socket left behind at the
+// path by a previous run.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale socket %s: %w", path, err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", path, err)
+	}
+
+	return listener, nil
+}
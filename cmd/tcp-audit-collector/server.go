@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/event"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/socketstate"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+)
+
+const (
+	eventsPath = "/v1/events"
+	healthPath = "/healthz"
+
+	maxRequestBytes = 8 << 20
+)
+
+// HostSinker is an interface which describes objects which store events
+// which occurred on a given host. It is satisfied by *pgsqlsink.Sinker.
+type hostSinker interface {
+	SinkFrom(host string, event *event.Event) error
+}
+
+// EventsResponse is the body of the response to a request to store events.
+type eventsResponse struct {
+	// Stored is the number of events stored, in the order they were sent.
+	// If less than the number sent, the remainder may be resent.
+	Stored int    `json:"stored"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Server receives events from agents over HTTP, and stores them as having
+// occurred on the host authenticated by the agent's token.
+type server struct {
+	tokens tokens
+	logger *log.Logger
+
+	// The sinker is not safe for concurrent use
+	mu     sync.Mutex
+	sinker hostSinker
+}
+
+func newServer(sinker hostSinker, tokens tokens, logger *log.Logger) *server {
+	return &server{
+		sinker: sinker,
+		tokens: tokens,
+		logger: logger,
+	}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case eventsPath:
+		s.serveEvents(w, r)
+	case healthPath:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// ServeEvents stores the JSON array of events in the request body.
+// Events are stored in order, stopping at the first which cannot be stored.
+func (s *server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeResponse(w, http.StatusMethodNotAllowed, &eventsResponse{Error: "method not allowed"})
+		return
+	}
+
+	host, ok := s.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeResponse(w, http.StatusUnauthorized, &eventsResponse{Error: "invalid or missing token"})
+		return
+	}
+
+	var events []*event.Event
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&events); err != nil {
+		writeResponse(w, http.StatusBadRequest, &eventsResponse{Error: fmt.Sprintf("decoding events: %v", err)})
+		return
+	}
+
+	for i, event := range events {
+		if err := validateEvent(event); err != nil {
+			writeResponse(w, http.StatusBadRequest, &eventsResponse{Error: fmt.Sprintf("event %d: %v", i, err)})
+			return
+		}
+	}
+
+	stored, err := s.sink(host, events)
+	if err != nil {
+		s.logger.Printf("Error storing events from %s: %v", host, err)
+		writeResponse(w, http.StatusInternalServerError, &eventsResponse{Stored: stored, Error: "storing events failed"})
+		return
+	}
+
+	writeResponse(w, http.StatusOK, &eventsResponse{Stored: stored})
+}
+
+// Authenticate returns the host authenticated by the request's bearer token.
+func (s *server) authenticate(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, prefix) {
+		return "", false
+	}
+
+	return s.tokens.host(strings.TrimPrefix(authorization, prefix))
+}
+
+// Sink stores the events, returning the number stored.
+func (s *server) sink(host string, events []*event.Event) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, event := range events {
+		if err := s.sinker.SinkFrom(host, event); err != nil {
+			return i, fmt.Errorf("storing event %d: %w", i, err)
+		}
+	}
+
+	return len(events), nil
+}
+
+// ValidateEvent checks that the decoded event can be stored.
+func validateEvent(event *event.Event) error {
+	if event == nil {
+		return fmt.Errorf("null event")
+	}
+
+	if event.SourceIP == nil || event.DestIP == nil {
+		return fmt.Errorf("missing IP address")
+	}
+
+	if _, err := tcpstate.FromString(event.OldState.String()); err != nil {
+		return fmt.Errorf("invalid old state: %w", err)
+	}
+
+	if _, err := tcpstate.FromString(event.NewState.String()); err != nil {
+		return fmt.Errorf("invalid new state: %w", err)
+	}
+
+	if event.SocketInfo != nil {
+		if _, err := socketstate.FromInt(uint8(event.SocketInfo.SocketState)); err != nil {
+			return fmt.Errorf("invalid socket state: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func writeResponse(w http.ResponseWriter, status int, response *eventsResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
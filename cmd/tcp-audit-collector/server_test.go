@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/event"
+)
+
+const mockEventsJSON = `[
+	{"Time": "2021-08-31T22:46:05Z", "SourceIP": "1.2.3.4", "DestIP": "7.3.3.7", "SourcePort": 1234, "DestPort": 443, "OldState": "SYN-SENT", "NewState": "ESTABLISHED"},
+	{"Time": "2021-08-31T22:46:06Z", "SourceIP": "1.2.3.4", "DestIP": "7.3.3.7", "SourcePort": 1234, "DestPort": 443, "OldState": "ESTABLISHED", "NewState": "FIN-WAIT-1"}
+]`
+
+type mockHostSinker struct {
+	errorToReturn error
+	errorAfter    int
+
+	receivedHosts  []string
+	receivedEvents []*event.Event
+}
+
+func (ms *mockHostSinker) SinkFrom(host string, event *event.Event) error {
+	if ms.errorToReturn != nil && len(ms.receivedEvents) >= ms.errorAfter {
+		return ms.errorToReturn
+	}
+
+	ms.receivedHosts = append(ms.receivedHosts, host)
+	ms.receivedEvents = append(ms.receivedEvents, event)
+	return nil
+}
+
+func newTestServer(t *testing.T, sinker hostSinker) *server {
+	tokens, err := parseTokens(strings.NewReader("web-1 mock-token\n"))
+	if err != nil {
+		t.Fatalf("test bootstrapping: unable to parse tokens: %v", err)
+	}
+
+	return newServer(sinker, tokens, log.New(ioutil.Discard, "", 0))
+}
+
+func postEvents(server *server, token, body string) (*httptest.ResponseRecorder, *eventsResponse) {
+	request := httptest.NewRequest(http.MethodPost, eventsPath, strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+
+	response := new(eventsResponse)
+	json.NewDecoder(recorder.Body).Decode(response)
+	return recorder, response
+}
+
+func TestServeEvents(t *testing.T) {
+	mockSinker := new(mockHostSinker)
+	server := newTestServer(t, mockSinker)
+
+	recorder, response := postEvents(server, "mock-token", mockEventsJSON)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d (%q)", http.StatusOK, recorder.Code, response.Error)
+	}
+
+	if response.Stored != 2 || len(mockSinker.receivedEvents) != 2 {
+		t.Fatalf("expected %d events to be stored, got %d", 2, len(mockSinker.receivedEvents))
+	}
+
+	if mockSinker.receivedHosts[0] != "web-1" {
+		t.Errorf("expected event host to be %q, got %q", "web-1", mockSinker.receivedHosts[0])
+	}
+
+	if mockSinker.receivedEvents[1].DestPort != 443 {
+		t.Errorf("expected event destination port %d, got %d", 443, mockSinker.receivedEvents[1].DestPort)
+	}
+}
+
+func TestServeEventsUnauthorized(t *testing.T) {
+	mockSinker := new(mockHostSinker)
+	server := newTestServer(t, mockSinker)
+
+	for _, token := range []string{"", "bogus-token"} {
+		recorder, _ := postEvents(server, token, mockEventsJSON)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("expected status %d for token %q, got %d", http.StatusUnauthorized, token, recorder.Code)
+		}
+	}
+
+	if len(mockSinker.receivedEvents) != 0 {
+		t.Errorf("expected no events to be stored, got %d", len(mockSinker.receivedEvents))
+	}
+}
+
+func TestServeEventsInvalidState(t *testing.T) {
+	mockSinker := new(mockHostSinker)
+	server := newTestServer(t, mockSinker)
+
+	body := strings.Replace(mockEventsJSON, "FIN-WAIT-1", "BOGUS", 1)
+	recorder, response := postEvents(server, "mock-token", body)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+
+	t.Logf("got error %q", response.Error)
+
+	if len(mockSinker.receivedEvents) != 0 {
+		t.Errorf("expected no events to be stored, got %d", len(mockSinker.receivedEvents))
+	}
+}
+
+func TestServeEventsPartiallyStored(t *testing.T) {
+	mockSinker := &mockHostSinker{errorToReturn: errors.New("mock sink error"), errorAfter: 1}
+	server := newTestServer(t, mockSinker)
+
+	recorder, response := postEvents(server, "mock-token", mockEventsJSON)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, recorder.Code)
+	}
+
+	if response.Stored != 1 {
+		t.Errorf("expected %d events to be reported stored, got %d", 1, response.Stored)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// TokenDigest is the SHA-256 digest of an agent token. Tokens are looked up
+// by digest so that the time taken does not depend on how much of a guessed
+// token is correct.
+type tokenDigest [sha256.Size]byte
+
+// Tokens maps the digests of agent tokens to the hosts they authenticate.
+type tokens map[tokenDigest]string
+
+// LoadTokens reads agent tokens from a file.
+func loadTokens(path string) (tokens, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening tokens file: %w", err)
+	}
+	defer file.Close()
+
+	return parseTokens(file)
+}
+
+// ParseTokens parses agent tokens, one per line as the name of the host the
+// token authenticates followed by the token, separated by whitespace.
+// Blank lines and lines starting with '#' are ignored.
+func parseTokens(r io.Reader) (tokens, error) {
+	tokens := make(tokens)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected host and token", line)
+		}
+
+		digest := sha256.Sum256([]byte(fields[1]))
+		if _, ok := tokens[digest]; ok {
+			return nil, fmt.Errorf("line %d: duplicate token", line)
+		}
+
+		tokens[digest] = fields[0]
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading tokens: %w", err)
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens")
+	}
+
+	return tokens, nil
+}
+
+// Host returns the host authenticated by the token, if any.
+func (t tokens) host(token string) (string, bool) {
+	host, ok := t[sha256.Sum256([]byte(token))]
+	return host, ok
+}
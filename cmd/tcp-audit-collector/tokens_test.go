@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTokens(t *testing.T) {
+	tokens, err := parseTokens(strings.NewReader(`
+# host token
+web-1   mock-token-1
+web-2	mock-token-2
+`))
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if host, ok := tokens.host("mock-token-2"); !ok || host != "web-2" {
+		t.Errorf("expected token to authenticate host %q, got %q", "web-2", host)
+	}
+
+	if _, ok := tokens.host("mock-token"); ok {
+		t.Error("expected unknown token to not authenticate a host, but did")
+	}
+}
+
+func TestParseTokensErrorDuplicateToken(t *testing.T) {
+	_, err := parseTokens(strings.NewReader("web-1 mock-token\nweb-2 mock-token\n"))
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+}
+
+func TestParseTokensErrorMalformedLine(t *testing.T) {
+	_, err := parseTokens(strings.NewReader("web-1\n"))
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+}
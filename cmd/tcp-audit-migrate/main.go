@@ -0,0 +1,58 @@
+// Command tcp-audit-migrate creates and migrates the tables in which the
+// PostgreSQL sink stores events, using credentials with the privilege to
+// create tables, and grants the privileges required by a sink with the same
+// configuration to the roles which run it. Sinks may then run with TCP_AUDIT_PGSQL_SCHEMA_MODE=verify,
+// using credentials with the privilege to insert only.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/pgconfig"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/pgsqlsink"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "tcp-audit-migrate: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("tcp-audit-migrate", flag.ContinueOnError)
+	grant := flags.String("grant", "", "comma-separated list of roles to grant the privileges required by the sink")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var roles []string
+	for _, role := range strings.Split(*grant, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+
+	config, err := pgsqlsink.ConfigFromEnv()
+	if err != nil {
+		return fmt.Errorf("getting sink config: %w", err)
+	}
+
+	if config.ConnString, err = new(pgconfig.EnvVarConfigGetter).Config(); err != nil {
+		return fmt.Errorf("getting connection string from config: %w", err)
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	if err := pgsqlsink.Migrate(context.Background(), config, roles, pgsqlsink.WithLogger(logger)); err != nil {
+		return fmt.Errorf("migrating: %w", err)
+	}
+
+	logger.Printf("Migration complete")
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/query"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+// FilterFlags holds the values of the command-line flags which are
+// common to all commands, and used to build a query filter.
+type filterFlags struct {
+	from, to                string
+	host                    string
+	network, srcNet, dstNet string
+	port, srcPort, dstPort  uint
+	comm                    string
+	pid                     string
+	oldState, newState      string
+	containerID             string
+	namespace, pod          string
+	container               string
+	direction               string
+}
+
+func (ff *filterFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&ff.from, "from", "", "only events at or after this time (RFC 3339, or a duration ago such as 1h)")
+	flags.StringVar(&ff.to, "to", "", "only events before this time (RFC 3339, or a duration ago such as 1h)")
+	flags.StringVar(&ff.host, "host", "", "only events recorded on this host")
+	flags.StringVar(&ff.network, "net", "", "only events where either endpoint is within this IP or CIDR")
+	flags.StringVar(&ff.srcNet, "src-net", "", "only events where the source is within this IP or CIDR")
+	flags.StringVar(&ff.dstNet, "dst-net", "", "only events where the destination is within this IP or CIDR")
+	flags.UintVar(&ff.port, "port", 0, "only events where either endpoint has this port")
+	flags.UintVar(&ff.srcPort, "src-port", 0, "only events with this source port")
+	flags.UintVar(&ff.dstPort, "dst-port", 0, "only events with this destination port")
+	flags.StringVar(&ff.comm, "comm", "", "only events where the on-CPU command matches this pattern ('*' is a wildcard)")
+	flags.StringVar(&ff.pid, "pid", "", "only events where the on-CPU process has this PID")
+	flags.StringVar(&ff.oldState, "old-state", "", "only events transitioning from this TCP state")
+	flags.StringVar(&ff.newState, "new-state", "", "only events transitioning to this TCP state")
+	flags.StringVar(&ff.containerID, "container-id", "", "only events where the on-CPU process is in this container")
+	flags.StringVar(&ff.namespace, "namespace", "", "only events where the on-CPU process is in a pod in this Kubernetes namespace")
+	flags.StringVar(&ff.pod, "pod", "", "only events where the on-CPU process is in this Kubernetes pod")
+	flags.StringVar(&ff.direction, "direction", "", "only connections in this direction (inbound, outbound or loopback)")
+	flags.StringVar(&ff.container, "container", "", "only events where the on-CPU process is in this Kubernetes container")
+}
+
+// Filter builds a query filter from the flag values, relative to the
+// given current time.
+func (ff *filterFlags) filter(now time.Time) (*query.Filter, error) {
+	filter := &query.Filter{
+		Host:          ff.host,
+		Command:       ff.comm,
+		ContainerID:   ff.containerID,
+		PodNamespace:  ff.namespace,
+		PodName:       ff.pod,
+		ContainerName: ff.container,
+	}
+
+	var err error
+	if filter.From, err = parseTime(ff.from, now); err != nil {
+		return nil, fmt.Errorf("parsing -from: %w", err)
+	}
+
+	if filter.To, err = parseTime(ff.to, now); err != nil {
+		return nil, fmt.Errorf("parsing -to: %w", err)
+	}
+
+	if filter.Net, err = parseNet(ff.network); err != nil {
+		return nil, fmt.Errorf("parsing -net: %w", err)
+	}
+
+	if filter.SourceNet, err = parseNet(ff.srcNet); err != nil {
+		return nil, fmt.Errorf("parsing -src-net: %w", err)
+	}
+
+	if filter.DestNet, err = parseNet(ff.dstNet); err != nil {
+		return nil, fmt.Errorf("parsing -dst-net: %w", err)
+	}
+
+	if filter.Port, err = toPort(ff.port); err != nil {
+		return nil, fmt.Errorf("parsing -port: %w", err)
+	}
+
+	if filter.SourcePort, err = toPort(ff.srcPort); err != nil {
+		return nil, fmt.Errorf("parsing -src-port: %w", err)
+	}
+
+	if filter.DestPort, err = toPort(ff.dstPort); err != nil {
+		return nil, fmt.Errorf("parsing -dst-port: %w", err)
+	}
+
+	if ff.pid != "" {
+		pid, err := strconv.Atoi(ff.pid)
+		if err != nil {
+			return nil, fmt.Errorf("parsing -pid: %w", err)
+		}
+		filter.PID = &pid
+	}
+
+	if ff.oldState != "" {
+		if filter.OldState, err = tcpstate.FromString(strings.ToUpper(ff.oldState)); err != nil {
+			return nil, fmt.Errorf("parsing -old-state: %w", err)
+		}
+	}
+
+	if ff.newState != "" {
+		if filter.NewState, err = tcpstate.FromString(strings.ToUpper(ff.newState)); err != nil {
+			return nil, fmt.Errorf("parsing -new-state: %w", err)
+		}
+	}
+
+	if ff.direction != "" {
+		if filter.Direction, err = parseDirection(ff.direction); err != nil {
+			return nil, fmt.Errorf("parsing -direction: %w", err)
+		}
+	}
+
+	return filter, nil
+}
+
+// ParseTime parses a time given either in RFC 3339 format, or as a duration
+// before now. An empty string returns the zero time.
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time %q is neither RFC 3339 nor a duration", value)
+	}
+
+	// Event times are stored as local wall-clock times
+	return t.Local(), nil
+}
+
+// ParseNet parses either a CIDR or a single IP address, which is converted
+// to a network with a full-length mask. An empty string returns nil.
+func parseNet(value string) (*net.IPNet, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+
+		return network, nil
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", value)
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func toPort(value uint) (uint16, error) {
+	if value > 0xFFFF {
+		return 0, fmt.Errorf("port %d out of range", value)
+	}
+
+	return uint16(value), nil
+}
+
+// ParseDirection validates a connection direction.
+func parseDirection(direction string) (string, error) {
+	direction = strings.ToLower(direction)
+	for _, valid := range schema.Directions {
+		if direction == valid {
+			return direction, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown direction %q", direction)
+}
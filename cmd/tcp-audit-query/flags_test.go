@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+)
+
+func TestParseTimeDuration(t *testing.T) {
+	now := time.Now()
+	parsed, err := parseTime("1h", now)
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if expected := now.Add(-time.Hour); !parsed.Equal(expected) {
+		t.Errorf("expected time %v, got %v", expected, parsed)
+	}
+}
+
+func TestParseTimeRFC3339(t *testing.T) {
+	parsed, err := parseTime("2021-08-31T22:46:05Z", time.Now())
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if expected := time.Date(2021, 8, 31, 22, 46, 5, 0, time.UTC); !parsed.Equal(expected) {
+		t.Errorf("expected time %v, got %v", expected, parsed)
+	}
+}
+
+func TestParseTimeError(t *testing.T) {
+	_, err := parseTime("yesterday", time.Now())
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+}
+
+func TestParseNet(t *testing.T) {
+	for value, expected := range map[string]string{
+		"10.1.2.3":      "10.1.2.3/32",
+		"10.0.0.0/8":    "10.0.0.0/8",
+		"2001:db8::1":   "2001:db8::1/128",
+		"2001:db8::/32": "2001:db8::/32",
+	} {
+		network, err := parseNet(value)
+		if err != nil {
+			t.Errorf("expected nil error for %q, got %q (of type %T)", value, err, err)
+			continue
+		}
+
+		if network.String() != expected {
+			t.Errorf("expected %q to parse to network %q, got %q", value, expected, network)
+		}
+	}
+}
+
+func TestParseNetError(t *testing.T) {
+	_, err := parseNet("not-an-ip")
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+}
+
+func TestParseEndpoint(t *testing.T) {
+	network, port, err := parseEndpoint("172.217.16.225:443")
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if network.String() != "172.217.16.225/32" {
+		t.Errorf("expected network %q, got %q", "172.217.16.225/32", network)
+	}
+
+	if port != 443 {
+		t.Errorf("expected port %d, got %d", 443, port)
+	}
+}
+
+func TestFilterFlags(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	filterFlags := new(filterFlags)
+	filterFlags.register(flags)
+	if err := flags.Parse([]string{
+		"-from", "2h",
+		"-host", "mock-host",
+		"-dst-net", "172.217.0.0/16",
+		"-port", "443",
+		"-comm", "kworker/*",
+		"-pid", "0",
+		"-new-state", "closed",
+	}); err != nil {
+		t.Fatalf("test bootstrapping: unable to parse flags: %v", err)
+	}
+
+	now := time.Now()
+	filter, err := filterFlags.filter(now)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if expected := now.Add(-2 * time.Hour); !filter.From.Equal(expected) {
+		t.Errorf("expected filter from time %v, got %v", expected, filter.From)
+	}
+
+	if !filter.To.IsZero() {
+		t.Errorf("expected zero filter to time, got %v", filter.To)
+	}
+
+	if filter.Host != "mock-host" {
+		t.Errorf("expected filter host %q, got %q", "mock-host", filter.Host)
+	}
+
+	if filter.DestNet == nil || filter.DestNet.String() != "172.217.0.0/16" {
+		t.Errorf("expected filter destination network %q, got %v", "172.217.0.0/16", filter.DestNet)
+	}
+
+	if filter.Port != 443 {
+		t.Errorf("expected filter port %d, got %d", 443, filter.Port)
+	}
+
+	if filter.PID == nil || *filter.PID != 0 {
+		t.Errorf("expected filter PID %d, got %v", 0, filter.PID)
+	}
+
+	if filter.NewState != tcpstate.StateClosed {
+		t.Errorf("expected filter new state %q, got %q", tcpstate.StateClosed, filter.NewState)
+	}
+}
+
+func TestFilterFlagsBadState(t *testing.T) {
+	filterFlags := &filterFlags{oldState: "HALF-OPEN"}
+	_, err := filterFlags.filter(time.Now())
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+}
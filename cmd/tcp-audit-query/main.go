@@ -0,0 +1,370 @@
+// Command tcp-audit-query queries and exports the TCP state-change events
+// stored by the tcp-audit PostgreSQL sink.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/notify"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/pgconfig"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/query"
+)
+
+const usage = `Usage: tcp-audit-query <command> [flags]
+
+Commands:
+  list      list the most recent events
+  follow    print new events as they are stored
+  timeline  show the state timeline of a connection
+  export    export events to CSV, JSON Lines or Parquet
+
+Database connection configuration is read from the standard PostgreSQL
+environment variables (PGHOST, PGPORT, PGDATABASE, PGUSER, PGPASSWORD).
+
+Run 'tcp-audit-query <command> -h' for the flags of each command.
+`
+
+// Command is a subcommand of the tool.
+type command func(ctx context.Context, args []string, out io.Writer) error
+
+var commands = map[string]command{
+	"list":     list,
+	"follow":   follow,
+	"timeline": timeline,
+	"export":   export,
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "tcp-audit-query: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return errors.New("no command given")
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := cmd(ctx, args[1:], out); err != nil && !errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+
+	return nil
+}
+
+// Connect connects to the database configured by the environment.
+// The returned Querier must be closed when no longer needed.
+func connect(ctx context.Context) (*query.Querier, error) {
+	querier, err := query.Connect(ctx, new(pgconfig.EnvVarConfigGetter))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to database: %w", err)
+	}
+
+	return querier, nil
+}
+
+func list(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	filterFlags := new(filterFlags)
+	filterFlags.register(flags)
+	limit := flags.Int("limit", 50, "maximum number of events to list")
+	format := flags.String("format", formatText, "output format: text, csv or jsonl")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter, err := filterFlags.filter(time.Now())
+	if err != nil {
+		return err
+	}
+
+	writer, err := newEventWriter(*format, out)
+	if err != nil {
+		return err
+	}
+
+	querier, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer querier.Close(context.Background())
+
+	events, _, err := querier.List(ctx, filter, &query.Page{Limit: *limit, Descending: true})
+	if err != nil {
+		return fmt.Errorf("listing events: %w", err)
+	}
+
+	// Print the most recent events in the order they occurred
+	for i := len(events) - 1; i >= 0; i-- {
+		if err := writer.write(events[i]); err != nil {
+			return fmt.Errorf("writing event: %w", err)
+		}
+	}
+
+	return writer.close()
+}
+
+// NotifyChannelEnvVar is the environment variable naming the channel on
+// which the sinks send a notification for each stored event.
+const notifyChannelEnvVar = "TCP_AUDIT_PGSQL_NOTIFY_CHANNEL"
+
+func follow(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("follow", flag.ContinueOnError)
+	filterFlags := new(filterFlags)
+	filterFlags.register(flags)
+	channel := flags.String("channel", os.Getenv(notifyChannelEnvVar),
+		"channel on which the sinks notify stored events (default "+notifyChannelEnvVar+")")
+	format := flags.String("format", formatText, "output format: text, csv or jsonl")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *channel == "" {
+		return fmt.Errorf("no notification channel given: set -channel or %s to the channel of the sinks",
+			notifyChannelEnvVar)
+	}
+
+	filter, err := filterFlags.filter(time.Now())
+	if err != nil {
+		return err
+	}
+
+	writer, err := newEventWriter(*format, out)
+	if err != nil {
+		return err
+	}
+	defer writer.close()
+
+	querier, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer querier.Close(context.Background())
+
+	// Listen before reading any earlier events, so that none are missed
+	// between the two
+	subscriber, err := notify.Subscribe(ctx, new(pgconfig.EnvVarConfigGetter), *channel)
+	if err != nil {
+		return fmt.Errorf("subscribing to notifications: %w", err)
+	}
+	defer subscriber.Close(context.Background())
+
+	return followEvents(ctx, querier, subscriber, filter, writer)
+}
+
+// EventStreamer is an interface which describes objects which stream the
+// stored events matching a filter, such as *query.Querier.
+type eventStreamer interface {
+	Stream(ctx context.Context, filter *query.Filter, fn func(*query.Event) error) error
+}
+
+// EventSubscriber is an interface which describes objects which receive
+// the events as they are stored, such as *notify.Subscriber.
+type eventSubscriber interface {
+	Next(ctx context.Context) (*query.Event, error)
+}
+
+// FollowEvents writes the events matching the filter as they are stored,
+// until the context is done. If the filter has a start time, the events
+// already stored since then are written first.
+// Notifications are sent in the order the events are committed, rather
+// than the order in which they occurred, so events stored late (such as
+// those of a host with a slow clock, or sent later via the collector) are
+// not missed. Each notified event is read back from the database, as the
+// notification payload does not hold every field matched by the filter.
+func followEvents(ctx context.Context,
+	streamer eventStreamer,
+	subscriber eventSubscriber,
+	filter *query.Filter,
+	writer eventWriter) error {
+	// Events stored while the earlier events are read are also notified.
+	// These are notified before any stored after, as notifications are sent
+	// in commit order, so the UIDs need only be kept until the first other
+	// event is notified.
+	var written map[string]bool
+	if !filter.From.IsZero() {
+		written = make(map[string]bool)
+		if err := streamer.Stream(ctx, filter, func(event *query.Event) error {
+			written[event.UID] = true
+			return writer.write(event)
+		}); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("reading earlier events: %w", err)
+		}
+	}
+
+	for {
+		notified, err := subscriber.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("receiving events: %w", err)
+		}
+
+		if written[notified.UID] {
+			continue
+		}
+		written = nil
+
+		eventFilter := *filter
+		eventFilter.UID = notified.UID
+		if err := streamer.Stream(ctx, &eventFilter, writer.write); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("reading event %s: %w", notified.UID, err)
+		}
+	}
+}
+
+func timeline(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("timeline", flag.ContinueOnError)
+	filterFlags := new(filterFlags)
+	filterFlags.register(flags)
+	src := flags.String("src", "", "source endpoint of the connection, as IP:port (required)")
+	dst := flags.String("dst", "", "destination endpoint of the connection, as IP:port (required)")
+	format := flags.String("format", formatText, "output format: text, csv or jsonl")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter, err := filterFlags.filter(time.Now())
+	if err != nil {
+		return err
+	}
+
+	if filter.SourceNet, filter.SourcePort, err = parseEndpoint(*src); err != nil {
+		return fmt.Errorf("parsing -src: %w", err)
+	}
+
+	if filter.DestNet, filter.DestPort, err = parseEndpoint(*dst); err != nil {
+		return fmt.Errorf("parsing -dst: %w", err)
+	}
+
+	querier, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer querier.Close(context.Background())
+
+	return writeEvents(ctx, querier, filter, *format, out)
+}
+
+func export(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	filterFlags := new(filterFlags)
+	filterFlags.register(flags)
+	format := flags.String("format", formatCSV, "output format: csv, jsonl or parquet")
+	output := flags.String("o", "", "file to write to (default standard output)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter, err := filterFlags.filter(time.Now())
+	if err != nil {
+		return err
+	}
+
+	querier, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer querier.Close(context.Background())
+
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("creating output file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	return writeEvents(ctx, querier, filter, *format, out)
+}
+
+// WriteEvents streams all the events matching the filter to the output,
+// oldest first.
+func writeEvents(ctx context.Context,
+	querier *query.Querier,
+	filter *query.Filter,
+	format string,
+	out io.Writer) error {
+	writer, err := newEventWriter(format, out)
+	if err != nil {
+		return err
+	}
+
+	if err := querier.Stream(ctx, filter, writer.write); err != nil {
+		return fmt.Errorf("streaming events: %w", err)
+	}
+
+	if err := writer.close(); err != nil {
+		return fmt.Errorf("closing %s writer: %w", format, err)
+	}
+
+	return nil
+}
+
+// ParseEndpoint parses an endpoint given as IP:port into a network with a
+// full-length mask and a port.
+func parseEndpoint(endpoint string) (*net.IPNet, uint16, error) {
+	if endpoint == "" {
+		return nil, 0, errors.New("endpoint not set")
+	}
+
+	host, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	network, err := parseNet(host)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid port %q", portStr)
+	}
+
+	return network, uint16(port), nil
+}
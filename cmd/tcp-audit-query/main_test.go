@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/event"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/query"
+)
+
+// MockStore holds the stored events, and streams those matching the UID and
+// time range of a filter.
+type mockStore struct {
+	events []*query.Event
+}
+
+func (ms *mockStore) Stream(ctx context.Context, filter *query.Filter, fn func(*query.Event) error) error {
+	for _, event := range ms.events {
+		if (filter.UID != "" && event.UID != filter.UID) ||
+			(!filter.From.IsZero() && event.Time.Before(filter.From)) {
+			continue
+		}
+
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MockSubscriber notifies the events in order, storing any not already
+// stored, then cancels the context.
+type mockSubscriber struct {
+	store    *mockStore
+	notified []*query.Event
+	cancel   context.CancelFunc
+}
+
+func (ms *mockSubscriber) Next(ctx context.Context) (*query.Event, error) {
+	if len(ms.notified) == 0 {
+		ms.cancel()
+		return nil, ctx.Err()
+	}
+
+	event := ms.notified[0]
+	ms.notified = ms.notified[1:]
+	for _, stored := range ms.store.events {
+		if stored == event {
+			return event, nil
+		}
+	}
+
+	ms.store.events = append(ms.store.events, event)
+	return event, nil
+}
+
+type mockEventWriter struct {
+	written []string
+}
+
+func (mw *mockEventWriter) write(event *query.Event) error {
+	mw.written = append(mw.written, event.UID)
+	return nil
+}
+
+func (mw *mockEventWriter) close() error {
+	return nil
+}
+
+func mockEvent(uid string, t time.Time) *query.Event {
+	return &query.Event{UID: uid, Event: event.Event{Time: t}}
+}
+
+func TestFollowEventsLateEventWithOlderTimestamp(t *testing.T) {
+	now := time.Now()
+	newer := mockEvent("mock-uid-b", now)
+	late := mockEvent("mock-uid-a", now.Add(-time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := new(mockStore)
+	subscriber := &mockSubscriber{store, []*query.Event{newer, late}, cancel}
+	writer := new(mockEventWriter)
+
+	if err := followEvents(ctx, store, subscriber, new(query.Filter), writer); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if strings.Join(writer.written, ",") != "mock-uid-b,mock-uid-a" {
+		t.Errorf("expected both events to be written, got %q", writer.written)
+	}
+}
+
+func TestFollowEventsFromStart(t *testing.T) {
+	now := time.Now()
+	tooEarly := mockEvent("mock-uid-a", now.Add(-2*time.Hour))
+	earlier := mockEvent("mock-uid-b", now.Add(-time.Hour))
+	during := mockEvent("mock-uid-c", now.Add(-time.Second))
+	later := mockEvent("mock-uid-d", now)
+	lateTooEarly := mockEvent("mock-uid-e", now.Add(-3*time.Hour))
+
+	// The event stored while listening, before the earlier events are
+	// read, is also notified, but is written once
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := &mockStore{[]*query.Event{tooEarly, earlier, during}}
+	subscriber := &mockSubscriber{store, []*query.Event{during, later, lateTooEarly}, cancel}
+	writer := new(mockEventWriter)
+
+	filter := &query.Filter{From: now.Add(-90 * time.Minute)}
+	if err := followEvents(ctx, store, subscriber, filter, writer); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if strings.Join(writer.written, ",") != "mock-uid-b,mock-uid-c,mock-uid-d" {
+		t.Errorf("expected events matching the filter to be written once, got %q", writer.written)
+	}
+}
+
+func TestFollowEventsError(t *testing.T) {
+	mockError := errors.New("mock subscriber error")
+	subscriber := &erroringSubscriber{mockError}
+
+	err := followEvents(context.Background(), new(mockStore), subscriber, new(query.Filter), new(mockEventWriter))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+type erroringSubscriber struct {
+	err error
+}
+
+func (es *erroringSubscriber) Next(ctx context.Context) (*query.Event, error) {
+	return nil, es.err
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/query"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	formatText         = "text"
+	formatCSV          = "csv"
+	formatJSONLines    = "jsonl"
+	formatParquet      = "parquet"
+	timestampLayout    = "2006-01-02 15:04:05.000000"
+	parquetParallelism = 1
+)
+
+// EventWriter is an interface which describes objects which write
+// TCP state-change events in some output format.
+type eventWriter interface {
+	write(event *query.Event) error
+	close() error
+}
+
+func newEventWriter(format string, w io.Writer) (eventWriter, error) {
+	switch format {
+	case formatText:
+		return newTextEventWriter(w), nil
+	case formatCSV:
+		return newCSVEventWriter(w), nil
+	case formatJSONLines:
+		return newJSONLinesEventWriter(w), nil
+	case formatParquet:
+		return newParquetEventWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// Record is the flattened form of an event used for export.
+type record struct {
+	UID           string  `json:"uid" parquet:"name=uid, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Host          string  `json:"host,omitempty" parquet:"name=host, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Timestamp     int64   `json:"-" parquet:"name=timestamp, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	Time          string  `json:"timestamp"`
+	PIDOnCPU      int32   `json:"pid_on_cpu" parquet:"name=pid_on_cpu, type=INT32"`
+	CommOnCPU     string  `json:"comm_on_cpu" parquet:"name=comm_on_cpu, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SrcIP         string  `json:"src_ip" parquet:"name=src_ip, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DstIP         string  `json:"dst_ip" parquet:"name=dst_ip, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SrcPort       int32   `json:"src_port" parquet:"name=src_port, type=INT32"`
+	DstPort       int32   `json:"dst_port" parquet:"name=dst_port, type=INT32"`
+	OldState      string  `json:"old_state" parquet:"name=old_state, type=BYTE_ARRAY, convertedtype=UTF8"`
+	NewState      string  `json:"new_state" parquet:"name=new_state, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SocketID      *string `json:"socket_id,omitempty" parquet:"name=socket_id, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	SocketINode   *int64  `json:"socket_inode,omitempty" parquet:"name=socket_inode, type=INT64, repetitiontype=OPTIONAL"`
+	SocketUID     *int64  `json:"socket_user_id,omitempty" parquet:"name=socket_user_id, type=INT64, repetitiontype=OPTIONAL"`
+	SocketGID     *int64  `json:"socket_group_id,omitempty" parquet:"name=socket_group_id, type=INT64, repetitiontype=OPTIONAL"`
+	SocketState   *string `json:"socket_state,omitempty" parquet:"name=socket_state, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	ContainerID   *string `json:"container_id,omitempty" parquet:"name=container_id, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	PodNamespace  *string `json:"pod_namespace,omitempty" parquet:"name=pod_namespace, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	PodName       *string `json:"pod_name,omitempty" parquet:"name=pod_name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	ContainerName *string `json:"container_name,omitempty" parquet:"name=container_name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Direction     *string `json:"direction,omitempty" parquet:"name=direction, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	LocalIP       *string `json:"local_ip,omitempty" parquet:"name=local_ip, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	LocalPort     *int32  `json:"local_port,omitempty" parquet:"name=local_port, type=INT32, repetitiontype=OPTIONAL"`
+	RemoteIP      *string `json:"remote_ip,omitempty" parquet:"name=remote_ip, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	RemotePort    *int32  `json:"remote_port,omitempty" parquet:"name=remote_port, type=INT32, repetitiontype=OPTIONAL"`
+}
+
+func newRecord(event *query.Event) *record {
+	r := &record{
+		UID:       event.UID,
+		Host:      event.Host,
+		Timestamp: event.Time.UnixNano() / int64(time.Microsecond),
+		Time:      event.Time.Format(timestampLayout),
+		PIDOnCPU:  int32(event.PIDOnCPU),
+		CommOnCPU: event.CommandOnCPU,
+		SrcIP:     event.SourceIP.String(),
+		DstIP:     event.DestIP.String(),
+		SrcPort:   int32(event.SourcePort),
+		DstPort:   int32(event.DestPort),
+		OldState:  event.OldState.String(),
+		NewState:  event.NewState.String(),
+	}
+
+	if event.SocketInfo != nil {
+		id := event.SocketInfo.ID
+		iNode := int64(event.SocketInfo.INode)
+		uid := int64(event.SocketInfo.UID)
+		gid := int64(event.SocketInfo.GID)
+		state := event.SocketInfo.SocketState.String()
+
+		r.SocketID = &id
+		r.SocketINode = &iNode
+		r.SocketUID = &uid
+		r.SocketGID = &gid
+		r.SocketState = &state
+	}
+
+	if event.Endpoints != nil {
+		localIP := event.Endpoints.LocalIP.String()
+		localPort := int32(event.Endpoints.LocalPort)
+		remoteIP := event.Endpoints.RemoteIP.String()
+		remotePort := int32(event.Endpoints.RemotePort)
+
+		r.Direction = &event.Endpoints.Direction
+		r.LocalIP = &localIP
+		r.LocalPort = &localPort
+		r.RemoteIP = &remoteIP
+		r.RemotePort = &remotePort
+	}
+
+	if event.Workload != nil {
+		r.ContainerID = &event.Workload.ContainerID
+
+		if event.Workload.PodName != "" {
+			r.PodNamespace = &event.Workload.PodNamespace
+			r.PodName = &event.Workload.PodName
+			r.ContainerName = &event.Workload.ContainerName
+		}
+	}
+
+	return r
+}
+
+// TextEventWriter writes events as human-readable aligned columns.
+type textEventWriter struct {
+	w *tabwriter.Writer
+}
+
+func newTextEventWriter(w io.Writer) *textEventWriter {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIMESTAMP\tHOST\tPID\tCOMMAND\tSOURCE\tDESTINATION\tTRANSITION\tSOCKET")
+	return &textEventWriter{tw}
+}
+
+func (tw *textEventWriter) write(event *query.Event) error {
+	socket := "-"
+	if event.SocketInfo != nil {
+		socket = fmt.Sprintf("%s (inode %d, %s)",
+			event.SocketInfo.ID,
+			event.SocketInfo.INode,
+			event.SocketInfo.SocketState)
+	}
+
+	if _, err := fmt.Fprintf(tw.w, "%s\t%s\t%d\t%s\t%s:%d\t%s:%d\t%s -> %s\t%s\n",
+		event.Time.Format(timestampLayout),
+		event.Host,
+		event.PIDOnCPU,
+		event.CommandOnCPU,
+		event.SourceIP,
+		event.SourcePort,
+		event.DestIP,
+		event.DestPort,
+		event.OldState,
+		event.NewState,
+		socket); err != nil {
+		return err
+	}
+
+	// Flush each event so that followed events are seen immediately,
+	// at the expense of column alignment being per-event
+	return tw.w.Flush()
+}
+
+func (tw *textEventWriter) close() error {
+	return tw.w.Flush()
+}
+
+// CSVEventWriter writes events as comma-separated values, with a header row.
+type csvEventWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+var csvHeader = []string{
+	"uid",
+	"host",
+	"timestamp",
+	"pid_on_cpu",
+	"comm_on_cpu",
+	"src_ip",
+	"dst_ip",
+	"src_port",
+	"dst_port",
+	"old_state",
+	"new_state",
+	"container_id",
+	"pod_namespace",
+	"pod_name",
+	"container_name",
+	"direction",
+	"local_ip",
+	"local_port",
+	"remote_ip",
+	"remote_port",
+	"socket_id",
+	"socket_inode",
+	"socket_user_id",
+	"socket_group_id",
+	"socket_state",
+}
+
+func newCSVEventWriter(w io.Writer) *csvEventWriter {
+	return &csvEventWriter{w: csv.NewWriter(w)}
+}
+
+func (cw *csvEventWriter) write(event *query.Event) error {
+	if !cw.headerWritten {
+		if err := cw.w.Write(csvHeader); err != nil {
+			return err
+		}
+		cw.headerWritten = true
+	}
+
+	r := newRecord(event)
+	return cw.w.Write([]string{
+		r.UID,
+		r.Host,
+		r.Time,
+		strconv.Itoa(int(r.PIDOnCPU)),
+		r.CommOnCPU,
+		r.SrcIP,
+		r.DstIP,
+		strconv.Itoa(int(r.SrcPort)),
+		strconv.Itoa(int(r.DstPort)),
+		r.OldState,
+		r.NewState,
+		optionalString(r.ContainerID),
+		optionalString(r.PodNamespace),
+		optionalString(r.PodName),
+		optionalString(r.ContainerName),
+		optionalString(r.Direction),
+		optionalString(r.LocalIP),
+		optionalInt32(r.LocalPort),
+		optionalString(r.RemoteIP),
+		optionalInt32(r.RemotePort),
+		optionalString(r.SocketID),
+		optionalInt(r.SocketINode),
+		optionalInt(r.SocketUID),
+		optionalInt(r.SocketGID),
+		optionalString(r.SocketState),
+	})
+}
+
+func (cw *csvEventWriter) close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func optionalString(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
+
+func optionalInt(i *int64) string {
+	if i == nil {
+		return ""
+	}
+
+	return strconv.FormatInt(*i, 10)
+}
+
+func optionalInt32(i *int32) string {
+	if i == nil {
+		return ""
+	}
+
+	return strconv.Itoa(int(*i))
+}
+
+// JSONLinesEventWriter writes events as one JSON object per line.
+type jsonLinesEventWriter struct {
+	encoder *json.Encoder
+}
+
+func newJSONLinesEventWriter(w io.Writer) *jsonLinesEventWriter {
+	return &jsonLinesEventWriter{json.NewEncoder(w)}
+}
+
+func (jw *jsonLinesEventWriter) write(event *query.Event) error {
+	return jw.encoder.Encode(newRecord(event))
+}
+
+func (jw *jsonLinesEventWriter) close() error {
+	return nil
+}
+
+// ParquetEventWriter writes events in Apache Parquet columnar format.
+// The file footer is only written on close.
+type parquetEventWriter struct {
+	w *writer.ParquetWriter
+}
+
+func newParquetEventWriter(w io.Writer) (*parquetEventWriter, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(record), parquetParallelism)
+	if err != nil {
+		return nil, fmt.Errorf("creating parquet writer: %w", err)
+	}
+
+	return &parquetEventWriter{pw}, nil
+}
+
+func (pw *parquetEventWriter) write(event *query.Event) error {
+	return pw.w.Write(newRecord(event))
+}
+
+func (pw *parquetEventWriter) close() error {
+	return pw.w.WriteStop()
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/event"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/socketstate"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/query"
+)
+
+func newMockEvent(withSocketInfo bool) *query.Event {
+	mockEvent := &query.Event{
+		UID:  "mock-uid",
+		Host: "mock-host",
+		Event: event.Event{
+			Time:         time.Date(2021, 8, 31, 22, 46, 5, 428515000, time.UTC),
+			PIDOnCPU:     31615,
+			CommandOnCPU: "kworker/u8:2",
+			SourceIP:     net.ParseIP("192.168.1.3"),
+			DestIP:       net.ParseIP("172.217.16.225"),
+			SourcePort:   58248,
+			DestPort:     443,
+			OldState:     tcpstate.StateFinWait2,
+			NewState:     tcpstate.StateClosed,
+		},
+	}
+
+	if withSocketInfo {
+		mockEvent.SocketInfo = &event.SocketInfo{
+			ID:          "ffff9e45710b3d40",
+			INode:       1718963,
+			SocketState: socketstate.StateUnconnected,
+		}
+	}
+
+	return mockEvent
+}
+
+func TestCSVEventWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writer, err := newEventWriter(formatCSV, buf)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if err := writer.write(newMockEvent(false)); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if err := writer.write(newMockEvent(true)); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if err := writer.close(); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatalf("expected nil error reading CSV, got %q (of type %T)", err, err)
+	}
+
+	if len(rows) != 3 {
+		t.Fatalf("expected %d rows (including header), got %d", 3, len(rows))
+	}
+
+	expectedRow := []string{
+		"mock-uid",
+		"mock-host",
+		"2021-08-31 22:46:05.428515",
+		"31615",
+		"kworker/u8:2",
+		"192.168.1.3",
+		"172.217.16.225",
+		"58248",
+		"443",
+		"FIN-WAIT-2",
+		"CLOSED",
+		"",
+		"",
+		"",
+		"",
+		"",
+		"",
+		"",
+		"",
+		"",
+		"",
+		"",
+		"",
+		"",
+		"",
+	}
+
+	for i, value := range rows[1] {
+		if value != expectedRow[i] {
+			t.Errorf("expected CSV column %q to be %q, got %q", csvHeader[i], expectedRow[i], value)
+		}
+	}
+
+	if rows[2][len(csvHeader)-1] != "UNCONNECTED" {
+		t.Errorf("expected CSV socket state to be %q, got %q", "UNCONNECTED", rows[2][len(csvHeader)-1])
+	}
+}
+
+func TestJSONLinesEventWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writer, err := newEventWriter(formatJSONLines, buf)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if err := writer.write(newMockEvent(false)); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if err := writer.write(newMockEvent(true)); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if err := writer.close(); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		line := make(map[string]interface{})
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("expected nil error decoding JSON line, got %q (of type %T)", err, err)
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) != 2 {
+		t.Fatalf("expected %d lines, got %d", 2, len(lines))
+	}
+
+	if lines[0]["dst_ip"] != "172.217.16.225" {
+		t.Errorf("expected JSON destination IP %q, got %v", "172.217.16.225", lines[0]["dst_ip"])
+	}
+
+	if _, ok := lines[0]["socket_state"]; ok {
+		t.Error("expected JSON socket state to be omitted for event without socket info, but was not")
+	}
+
+	if lines[1]["socket_state"] != "UNCONNECTED" {
+		t.Errorf("expected JSON socket state %q, got %v", "UNCONNECTED", lines[1]["socket_state"])
+	}
+}
+
+func TestParquetEventWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writer, err := newEventWriter(formatParquet, buf)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if err := writer.write(newMockEvent(true)); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if err := writer.close(); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	// Parquet files both begin and end with a magic number
+	magic := []byte("PAR1")
+	if !bytes.HasPrefix(buf.Bytes(), magic) || !bytes.HasSuffix(buf.Bytes(), magic) {
+		t.Error("expected output to be framed by Parquet magic number, but was not")
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := newEventWriter("xml", new(bytes.Buffer))
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+}
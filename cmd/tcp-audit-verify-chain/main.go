@@ -0,0 +1,103 @@
+// Command tcp-audit-verify-chain verifies the hash chains linking the events
+// stored by sinks with TCP_AUDIT_PGSQL_HASH_CHAIN enabled, reporting events
+// which have been modified or deleted since they were stored, and exits
+// with a non-zero status if any are found.
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/pgconfig"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/pgsqlsink"
+)
+
+// ErrChainBroken is returned if a chain is not intact.
+var errChainBroken = errors.New("hash chain is not intact")
+
+func main() {
+	if err := run(os.Args[1:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "tcp-audit-verify-chain: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("tcp-audit-verify-chain", flag.ContinueOnError)
+	host := flags.String("host", "", "comma-separated list of hosts whose chains are verified (default every host)")
+	publicKey := flags.String("public-key", "", "comma-separated list of PEM files of the public keys which verify checkpoints")
+	retention := flags.Duration("retention", 0, "period after which events are deleted, so that events checkpointed before it are not reported missing (default none)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var publicKeys []ed25519.PublicKey
+	for _, path := range splitList(*publicKey) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading public key: %w", err)
+		}
+
+		key, err := pgsqlsink.ParseChainPublicKey(data)
+		if err != nil {
+			return fmt.Errorf("parsing public key %s: %w", path, err)
+		}
+
+		publicKeys = append(publicKeys, key)
+	}
+
+	config, err := pgsqlsink.ConfigFromEnv()
+	if err != nil {
+		return fmt.Errorf("getting sink config: %w", err)
+	}
+
+	if config.ConnString, err = new(pgconfig.EnvVarConfigGetter).Config(); err != nil {
+		return fmt.Errorf("getting connection string from config: %w", err)
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	report, err := pgsqlsink.VerifyChain(context.Background(),
+		config,
+		splitList(*host),
+		publicKeys,
+		*retention,
+		pgsqlsink.WithLogger(logger))
+	if err != nil {
+		return fmt.Errorf("verifying: %w", err)
+	}
+
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
+	}
+
+	logger.Printf("Verified %d events and %d checkpoints of %d hosts: %d problems found",
+		report.Events,
+		report.Checkpoints,
+		report.Hosts,
+		len(report.Problems))
+
+	if len(report.Problems) != 0 {
+		return errChainBroken
+	}
+
+	return nil
+}
+
+// SplitList splits a comma-separated list, ignoring empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -10,4 +10,6 @@ require (
 	github.com/jackc/pgerrcode v0.0.0-20201024163028-a0d42d470451
 	github.com/jackc/pgx/v4 v4.13.0
 	github.com/jhwbarlow/tcp-audit-common v0.0.0-20210928211236-5e6841819533
+	github.com/oschwald/maxminddb-golang v1.8.0
+	github.com/xitongsys/parquet-go v1.6.2
 )
@@ -0,0 +1,105 @@
+// Package notify provides the encoding of the notifications which the sink
+// may send via PostgreSQL LISTEN/NOTIFY for each stored event, and a
+// subscriber to receive them.
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/event"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/query"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+// Payload is the payload of the notification sent for each stored event.
+// Short field names are used to keep the payload compact, as PostgreSQL
+// limits notification payloads to 8000 bytes.
+type Payload struct {
+	UID        string             `json:"u"`
+	Host       string             `json:"h,omitempty"`
+	Time       time.Time          `json:"t"`
+	PIDOnCPU   int                `json:"p"`
+	CommOnCPU  string             `json:"c"`
+	SrcIP      net.IP             `json:"si"`
+	DstIP      net.IP             `json:"di"`
+	SrcPort    uint16             `json:"sp"`
+	DstPort    uint16             `json:"dp"`
+	OldState   string             `json:"os"`
+	NewState   string             `json:"ns"`
+	SocketInfo *SocketInfoPayload `json:"s,omitempty"`
+}
+
+// SocketInfoPayload is the part of the payload describing the socket
+// information related to the event, if available.
+type SocketInfoPayload struct {
+	ID      string `json:"id"`
+	INode   uint32 `json:"i"`
+	UserID  uint32 `json:"u"`
+	GroupID uint32 `json:"g"`
+	State   string `json:"s"`
+}
+
+// Encode returns the payload as a notification payload string.
+func (p *Payload) Encode() (string, error) {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+
+	return string(payload), nil
+}
+
+// Decode parses a notification payload string into the event it describes.
+func Decode(payload string) (*query.Event, error) {
+	p := new(Payload)
+	if err := json.Unmarshal([]byte(payload), p); err != nil {
+		return nil, fmt.Errorf("unmarshalling payload: %w", err)
+	}
+
+	oldState, err := tcpstate.FromString(p.OldState)
+	if err != nil {
+		return nil, fmt.Errorf("parsing old state: %w", err)
+	}
+
+	newState, err := tcpstate.FromString(p.NewState)
+	if err != nil {
+		return nil, fmt.Errorf("parsing new state: %w", err)
+	}
+
+	decoded := &query.Event{
+		UID:  p.UID,
+		Host: p.Host,
+		Event: event.Event{
+			Time:         p.Time,
+			PIDOnCPU:     p.PIDOnCPU,
+			CommandOnCPU: p.CommOnCPU,
+			SourceIP:     p.SrcIP,
+			DestIP:       p.DstIP,
+			SourcePort:   p.SrcPort,
+			DestPort:     p.DstPort,
+			OldState:     oldState,
+			NewState:     newState,
+		},
+	}
+
+	if p.SocketInfo != nil {
+		socketState, err := schema.SocketStateFromString(p.SocketInfo.State)
+		if err != nil {
+			return nil, fmt.Errorf("parsing socket state: %w", err)
+		}
+
+		decoded.SocketInfo = &event.SocketInfo{
+			ID:          p.SocketInfo.ID,
+			INode:       p.SocketInfo.INode,
+			UID:         p.SocketInfo.UserID,
+			GID:         p.SocketInfo.GroupID,
+			SocketState: socketState,
+		}
+	}
+
+	return decoded, nil
+}
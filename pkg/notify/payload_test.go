@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/socketstate"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	mockPayload := &Payload{
+		UID:       "mock-uid",
+		Host:      "mock-host",
+		Time:      time.Date(2021, 8, 31, 22, 46, 5, 0, time.UTC),
+		PIDOnCPU:  7337,
+		CommOnCPU: "mock-command",
+		SrcIP:     net.ParseIP("1.2.3.4"),
+		DstIP:     net.ParseIP("7.3.3.7"),
+		SrcPort:   1234,
+		DstPort:   443,
+		OldState:  tcpstate.StateSynSent.String(),
+		NewState:  tcpstate.StateEstablished.String(),
+		SocketInfo: &SocketInfoPayload{
+			ID:      "mock-socket-id",
+			INode:   0xF00DF00D,
+			UserID:  0xCAFEBABE,
+			GroupID: 0xDEADBEEF,
+			State:   socketstate.StateConnected.String(),
+		},
+	}
+
+	payload, err := mockPayload.Encode()
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+	t.Logf("got payload %q", payload)
+
+	event, err := Decode(payload)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if event.UID != mockPayload.UID {
+		t.Errorf("expected UID %q, got %q", mockPayload.UID, event.UID)
+	}
+
+	if event.Host != mockPayload.Host {
+		t.Errorf("expected host %q, got %q", mockPayload.Host, event.Host)
+	}
+
+	if !event.Time.Equal(mockPayload.Time) {
+		t.Errorf("expected time %v, got %v", mockPayload.Time, event.Time)
+	}
+
+	if !event.SourceIP.Equal(mockPayload.SrcIP) || event.SourcePort != mockPayload.SrcPort {
+		t.Errorf("expected source %v:%d, got %v:%d",
+			mockPayload.SrcIP,
+			mockPayload.SrcPort,
+			event.SourceIP,
+			event.SourcePort)
+	}
+
+	if event.OldState != tcpstate.StateSynSent || event.NewState != tcpstate.StateEstablished {
+		t.Errorf("expected transition %q -> %q, got %q -> %q",
+			tcpstate.StateSynSent,
+			tcpstate.StateEstablished,
+			event.OldState,
+			event.NewState)
+	}
+
+	if event.SocketInfo == nil {
+		t.Fatal("expected socket info to be non-nil, but was nil")
+	}
+
+	if event.SocketInfo.INode != mockPayload.SocketInfo.INode {
+		t.Errorf("expected socket inode %d, got %d", mockPayload.SocketInfo.INode, event.SocketInfo.INode)
+	}
+
+	if event.SocketInfo.SocketState != socketstate.StateConnected {
+		t.Errorf("expected socket state %q, got %q", socketstate.StateConnected, event.SocketInfo.SocketState)
+	}
+}
+
+func TestDecodeBadState(t *testing.T) {
+	_, err := Decode(`{"u":"mock-uid","os":"HALF-OPEN","ns":"CLOSED"}`)
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+}
+
+func TestDecodeBadJSON(t *testing.T) {
+	_, err := Decode(`not JSON`)
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+}
@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/pgconfig"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/query"
+)
+
+// Conn is an interface which is a wrapper around the *pgx.Conn struct.
+type Conn interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	WaitForNotification(ctx context.Context) (*pgconn.Notification, error)
+	Close(ctx context.Context) error
+}
+
+// Subscriber receives the events stored by the sink, as they are stored,
+// from the notifications sent on a channel.
+type Subscriber struct {
+	conn Conn
+}
+
+// NewSubscriber creates a Subscriber which listens on the given channel using
+// the provided connection. The connection must not be used for anything else.
+func NewSubscriber(ctx context.Context, conn Conn, channel string) (*Subscriber, error) {
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return nil, fmt.Errorf("listening on channel %q: %w", channel, err)
+	}
+
+	return &Subscriber{conn}, nil
+}
+
+// Subscribe creates a Subscriber listening on the given channel of the
+// PostgreSQL database described by the configuration returned by the
+// ConfigGetter.
+func Subscribe(ctx context.Context,
+	configGetter pgconfig.ConfigGetter,
+	channel string) (*Subscriber, error) {
+	connString, err := configGetter.Config()
+	if err != nil {
+		return nil, fmt.Errorf("getting connection string from config: %w", err)
+	}
+
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		return nil, fmt.Errorf("establishing connection to database: %w", err)
+	}
+
+	subscriber, err := NewSubscriber(ctx, conn, channel)
+	if err != nil {
+		conn.Close(ctx)
+		return nil, err
+	}
+
+	return subscriber, nil
+}
+
+// Next blocks until the next event is received, or the context is done.
+func (s *Subscriber) Next(ctx context.Context) (*query.Event, error) {
+	notification, err := s.conn.WaitForNotification(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for notification: %w", err)
+	}
+
+	event, err := Decode(notification.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("decoding notification payload: %w", err)
+	}
+
+	return event, nil
+}
+
+// Close releases the resources held by this Subscriber, namely the
+// database connection.
+func (s *Subscriber) Close(ctx context.Context) error {
+	if err := s.conn.Close(ctx); err != nil {
+		return fmt.Errorf("closing connection: %w", err)
+	}
+
+	return nil
+}
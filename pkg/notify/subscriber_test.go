@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgconn"
+)
+
+type mockConn struct {
+	execErrorToReturn    error
+	notificationToReturn *pgconn.Notification
+
+	receivedSQL string
+	closeCalled bool
+}
+
+func newMockConn(execErrorToReturn error, notificationToReturn *pgconn.Notification) *mockConn {
+	return &mockConn{
+		execErrorToReturn:    execErrorToReturn,
+		notificationToReturn: notificationToReturn,
+	}
+}
+
+func (mc *mockConn) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	mc.receivedSQL = sql
+
+	if mc.execErrorToReturn != nil {
+		return nil, mc.execErrorToReturn
+	}
+
+	return nil, nil
+}
+
+func (mc *mockConn) WaitForNotification(ctx context.Context) (*pgconn.Notification, error) {
+	return mc.notificationToReturn, nil
+}
+
+func (mc *mockConn) Close(ctx context.Context) error {
+	mc.closeCalled = true
+	return nil
+}
+
+func TestSubscriberListens(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	if _, err := NewSubscriber(context.TODO(), mockConn, "Mock Channel"); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	expectedSQL := `LISTEN "Mock Channel"`
+	if mockConn.receivedSQL != expectedSQL {
+		t.Errorf("expected SQL %q, got %q", expectedSQL, mockConn.receivedSQL)
+	}
+}
+
+func TestSubscriberListenError(t *testing.T) {
+	mockError := errors.New("mock exec error")
+	mockConn := newMockConn(mockError, nil)
+	_, err := NewSubscriber(context.TODO(), mockConn, "mock-channel")
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+func TestSubscriberNext(t *testing.T) {
+	mockNotification := &pgconn.Notification{
+		Channel: "mock-channel",
+		Payload: `{"u":"mock-uid","t":"2021-08-31T22:46:05Z","si":"1.2.3.4","di":"7.3.3.7","sp":1234,"dp":443,"os":"SYN-SENT","ns":"ESTABLISHED"}`,
+	}
+	mockConn := newMockConn(nil, mockNotification)
+	subscriber, err := NewSubscriber(context.TODO(), mockConn, "mock-channel")
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	event, err := subscriber.Next(context.TODO())
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if event.UID != "mock-uid" {
+		t.Errorf("expected UID %q, got %q", "mock-uid", event.UID)
+	}
+
+	if event.SocketInfo != nil {
+		t.Error("expected nil socket info, but was not")
+	}
+}
@@ -1,4 +1,6 @@
-package main
+// Package pgconfig provides the PostgreSQL connection configuration shared by
+// the sink plugin and the tools which read the data it stores.
+package pgconfig
 
 import (
 	"fmt"
@@ -16,19 +18,19 @@ const (
 
 // ConfigGetter is an interface which describes objects which provide
 // a datastore connection string based upon some configuration source.
-type configGetter interface {
-	config() (string, error)
+type ConfigGetter interface {
+	Config() (string, error)
 }
 
 // EnvVarConfigGetter provides a PostgreSQL database connection string
 // from configuration provided in the standard PostgreSQL client environment
 // variables.
 // See https://www.postgresql.org/docs/current/libpq-envars.html
-type envVarConfigGetter struct{}
+type EnvVarConfigGetter struct{}
 
 // Config returns a PostgreSQL database connection string based upon values
 // provided in environment variables.
-func (cg *envVarConfigGetter) config() (string, error) {
+func (cg *EnvVarConfigGetter) Config() (string, error) {
 	host := os.Getenv(hostEnvVar)
 	if host == "" {
 		return "", fmt.Errorf("environment variable %s not set", hostEnvVar)
@@ -1,4 +1,4 @@
-package main
+package pgconfig
 
 import (
 	"fmt"
@@ -49,8 +49,8 @@ func TestGetConfigFromEnv(t *testing.T) {
 		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
 	}
 
-	configGetter := new(envVarConfigGetter)
-	connStr, err := configGetter.config()
+	configGetter := new(EnvVarConfigGetter)
+	connStr, err := configGetter.Config()
 	if err != nil {
 		t.Errorf("expected nil error, got %q (of type %T)", err, err)
 	}
@@ -71,8 +71,8 @@ func TestGetConfigErrorNoHostFromEnv(t *testing.T) {
 		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
 	}
 
-	configGetter := new(envVarConfigGetter)
-	_, err := configGetter.config()
+	configGetter := new(EnvVarConfigGetter)
+	_, err := configGetter.Config()
 	if err == nil {
 		t.Error("expected error, got nil")
 	}
@@ -94,8 +94,8 @@ func TestGetConfigErrorBadPortFromEnv(t *testing.T) {
 		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
 	}
 
-	configGetter := new(envVarConfigGetter)
-	_, err := configGetter.config()
+	configGetter := new(EnvVarConfigGetter)
+	_, err := configGetter.Config()
 	if err == nil {
 		t.Error("expected error, got nil")
 	}
@@ -117,8 +117,8 @@ func TestGetConfigErrorNoDBFromEnv(t *testing.T) {
 		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
 	}
 
-	configGetter := new(envVarConfigGetter)
-	_, err := configGetter.config()
+	configGetter := new(EnvVarConfigGetter)
+	_, err := configGetter.Config()
 	if err == nil {
 		t.Error("expected error, got nil")
 	}
@@ -140,8 +140,8 @@ func TestGetConfigErrorNoUserFromEnv(t *testing.T) {
 		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
 	}
 
-	configGetter := new(envVarConfigGetter)
-	_, err := configGetter.config()
+	configGetter := new(EnvVarConfigGetter)
+	_, err := configGetter.Config()
 	if err == nil {
 		t.Error("expected error, got nil")
 	}
@@ -163,8 +163,8 @@ func TestGetConfigErrorNoPasswordFromEnv(t *testing.T) {
 		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
 	}
 
-	configGetter := new(envVarConfigGetter)
-	_, err := configGetter.config()
+	configGetter := new(EnvVarConfigGetter)
+	_, err := configGetter.Config()
 	if err == nil {
 		t.Error("expected error, got nil")
 	}
@@ -0,0 +1,210 @@
+package pgsqlsink
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+const (
+	appendOnlyTriggerName = "tcp_audit_append_only"
+
+	// AppendOnlyFunctionCreateSQL creates the function of the triggers
+	// protecting the append-only tables. Updates and deletes are rejected,
+	// unless the session or current user is a member of the retention role
+	// given as the trigger's argument, if any. The session user is checked
+	// as rows deleted by a cascading foreign key are deleted as the owner
+	// of their table.
+	appendOnlyFunctionCreateSQL = `
+CREATE OR REPLACE FUNCTION tcp_audit_reject_modification() RETURNS TRIGGER AS $$
+BEGIN
+	IF TG_NARGS > 0 AND (pg_has_role(session_user, TG_ARGV[0], 'MEMBER')
+	                     OR pg_has_role(current_user, TG_ARGV[0], 'MEMBER')) THEN
+		IF TG_OP = 'DELETE' THEN
+			RETURN OLD;
+		END IF;
+
+		RETURN NEW;
+	END IF;
+
+	RAISE EXCEPTION '% on % is not permitted: the table is append-only', TG_OP, TG_TABLE_NAME
+		USING ERRCODE = 'insufficient_privilege';
+END
+$$ LANGUAGE plpgsql`
+
+	// AppendOnlyStatusSQL returns the tables of the current schema with the
+	// given names which have the append-only trigger, whether it is enabled,
+	// and its retention role, if any.
+	appendOnlyStatusSQL = `
+SELECT c.relname::TEXT, t.tgenabled IN ('O', 'A'),
+	CASE WHEN t.tgnargs > 0
+	     THEN convert_from(substring(t.tgargs FROM 1 FOR position('\x00'::BYTEA IN t.tgargs) - 1), 'UTF8')
+	END
+FROM pg_catalog.pg_trigger t
+JOIN pg_catalog.pg_class c ON c.oid = t.tgrelid
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = current_schema()
+AND c.relname = ANY($1)
+AND t.tgname = '` + appendOnlyTriggerName + `'`
+
+	// AppendOnlyGranteesSQL returns the roles, other than the owner, granted
+	// the privilege to update, delete or truncate the table of the current
+	// schema with the given name. PUBLIC is returned as NULL.
+	appendOnlyGranteesSQL = `
+SELECT DISTINCT CASE WHEN a.grantee <> 0 THEN pg_get_userbyid(a.grantee)::TEXT END
+FROM pg_catalog.pg_class c
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace,
+LATERAL aclexplode(c.relacl) a
+WHERE n.nspname = current_schema()
+AND c.relname = $1
+AND a.privilege_type IN ('UPDATE', 'DELETE', 'TRUNCATE')
+AND a.grantee <> c.relowner`
+)
+
+// AppendOnlyTables are the tables protected against updates and deletes:
+// the events, the rows related to them, and the checkpoints of their hash
+// chains. The sockets and checkpoints are inserted with ON CONFLICT DO
+// NOTHING, which does not update the existing rows.
+var appendOnlyTables = []string{
+	schema.EventsTable,
+	schema.SocketsTable,
+	schema.SocketInfoTable,
+	schema.ProcessInfoTable,
+	schema.ChainCheckpointsTable,
+}
+
+// AppendOnlyConfig holds the configuration of the protection of the
+// append-only tables.
+type appendOnlyConfig struct {
+	// RetentionRole is the role whose members may delete rows, such as to
+	// expire old events. If empty, no role may. No role may update rows.
+	retentionRole string
+}
+
+// AppendOnlyTriggerDropSQL returns the SQL to drop the append-only trigger
+// of the table, if it exists.
+func appendOnlyTriggerDropSQL(table string) string {
+	return fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s", appendOnlyTriggerName, table)
+}
+
+// AppendOnlyTriggerCreateSQL returns the SQL to create the append-only
+// trigger of the table, exempting members of the retention role, if any.
+func appendOnlyTriggerCreateSQL(table, retentionRole string) string {
+	var arguments string
+	if retentionRole != "" {
+		arguments = quoteLiteral(retentionRole)
+	}
+
+	return fmt.Sprintf("CREATE TRIGGER %s BEFORE UPDATE OR DELETE ON %s "+
+		"FOR EACH ROW EXECUTE PROCEDURE tcp_audit_reject_modification(%s)",
+		appendOnlyTriggerName,
+		table,
+		arguments)
+}
+
+// AppendOnlyRevokeSQL returns the SQL to revoke the privileges from the
+// grantee, or from PUBLIC if empty.
+func appendOnlyRevokeSQL(table, grantee string, privileges []string) string {
+	if grantee == "" {
+		grantee = "PUBLIC"
+	} else {
+		grantee = quoteIdentifier(grantee)
+	}
+
+	return fmt.Sprintf("REVOKE %s ON %s FROM %s", strings.Join(privileges, ", "), table, grantee)
+}
+
+// AppendOnlyGrantSQL returns the SQL to grant the privileges required to
+// delete rows from the table to the retention role.
+func appendOnlyGrantSQL(table, retentionRole string) string {
+	return fmt.Sprintf("GRANT SELECT, DELETE ON %s TO %s", table, quoteIdentifier(retentionRole))
+}
+
+// QuoteLiteral quotes a SQL string literal.
+func quoteLiteral(literal string) string {
+	return "'" + strings.ReplaceAll(literal, "'", "''") + "'"
+}
+
+// AppendOnlyStatus is the protection of an append-only table found in the
+// database.
+type appendOnlyStatus struct {
+	installed     bool
+	enabled       bool
+	retentionRole string
+}
+
+// QueryAppendOnlyStatus returns the protection of each append-only table.
+func queryAppendOnlyStatus(ctx context.Context, conn conn) (map[string]*appendOnlyStatus, error) {
+	rows, err := conn.query(ctx, appendOnlyStatusSQL, appendOnlyTables)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]*appendOnlyStatus, len(appendOnlyTables))
+	for _, table := range appendOnlyTables {
+		statuses[table] = new(appendOnlyStatus)
+	}
+
+	for _, row := range rows {
+		if status, ok := statuses[stringValue(row[0])]; ok {
+			status.installed = true
+			status.enabled = boolValue(row[1])
+			status.retentionRole = stringValue(row[2])
+		}
+	}
+
+	return statuses, nil
+}
+
+// AppendOnlyReportingTableCreator logs the protection of the append-only
+// tables after creating the tables, warning if they are configured to be
+// protected but are not.
+type appendOnlyReportingTableCreator struct {
+	tableCreator tableCreator
+	conn         conn
+	appendOnly   *appendOnlyConfig
+	logger       Logger
+}
+
+func newAppendOnlyReportingTableCreator(tableCreator tableCreator,
+	conn conn,
+	appendOnly *appendOnlyConfig,
+	logger Logger) *appendOnlyReportingTableCreator {
+	return &appendOnlyReportingTableCreator{tableCreator, conn, appendOnly, logger}
+}
+
+// CreateTables creates the tables, then logs their protection.
+func (tc *appendOnlyReportingTableCreator) createTables(ctx context.Context) error {
+	if err := tc.tableCreator.createTables(ctx); err != nil {
+		return err
+	}
+
+	statuses, err := queryAppendOnlyStatus(ctx, tc.conn)
+	if err != nil {
+		return fmt.Errorf("querying append-only protection: %w", err)
+	}
+
+	for _, table := range appendOnlyTables {
+		status := statuses[table]
+		switch {
+		case status.installed && !status.enabled:
+			tc.logger.Printf("Warning: append-only protection of %s is installed, but its trigger is disabled", table)
+		case !status.installed && tc.appendOnly != nil:
+			tc.logger.Printf("Warning: append-only protection of %s is not installed: "+
+				"the tables must be migrated with append-only protection enabled", table)
+		case !status.installed:
+			tc.logger.Printf("Append-only protection of %s is not enabled", table)
+		case status.retentionRole == "":
+			tc.logger.Printf("Append-only protection of %s is enabled: updates and deletes are rejected", table)
+		default:
+			tc.logger.Printf("Append-only protection of %s is enabled: "+
+				"updates and deletes are rejected, except by members of %s",
+				table,
+				status.retentionRole)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,275 @@
+package pgsqlsink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+func TestCreateTablesAppendOnly(t *testing.T) {
+	mockTx := newMockTx(nil, nil)
+	mockConn := newMockConn(mockTx, nil)
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		appendOnlyGranteesSQL: {{nil}, {"mock-writer"}, {"mock-retention"}},
+	}
+	appendOnly := &appendOnlyConfig{retentionRole: "mock-retention"}
+	tableCreator := newSQLTableCreator(mockConn, compatModes[compatModePostgreSQL], nil, false, appendOnly, discardLogger)
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	executed := strings.Join(mockConn.receivedSQL, "\n")
+	expectedSQLs := []string{
+		appendOnlyFunctionCreateSQL,
+		"REVOKE UPDATE, DELETE, TRUNCATE ON tcp_events FROM PUBLIC",
+		`REVOKE UPDATE, DELETE, TRUNCATE ON tcp_events FROM "mock-writer"`,
+		`REVOKE UPDATE, TRUNCATE ON tcp_events FROM "mock-retention"`,
+	}
+	for _, table := range appendOnlyTables {
+		expectedSQLs = append(expectedSQLs,
+			"REVOKE UPDATE, DELETE, TRUNCATE ON "+table+" FROM PUBLIC",
+			`GRANT SELECT, DELETE ON `+table+` TO "mock-retention"`)
+	}
+	for _, sql := range expectedSQLs {
+		if !strings.Contains(executed, sql) {
+			t.Errorf("expected SQL %q to be executed, but was not", sql)
+		}
+	}
+
+	var expectedTxSQLs []string
+	for _, table := range appendOnlyTables {
+		expectedTxSQLs = append(expectedTxSQLs,
+			"DROP TRIGGER IF EXISTS tcp_audit_append_only ON "+table,
+			"CREATE TRIGGER tcp_audit_append_only BEFORE UPDATE OR DELETE ON "+table+" "+
+				"FOR EACH ROW EXECUTE PROCEDURE tcp_audit_reject_modification('mock-retention')")
+	}
+	if strings.Join(mockTx.receivedSQL, "\n") != strings.Join(expectedTxSQLs, "\n") {
+		t.Errorf("expected triggers to be replaced with:\n%s\ngot:\n%s",
+			strings.Join(expectedTxSQLs, "\n"),
+			strings.Join(mockTx.receivedSQL, "\n"))
+	}
+
+	if !mockTx.commitCalled {
+		t.Error("expected transaction to be committed, but was not")
+	}
+}
+
+func TestCreateTablesAppendOnlyWithoutRetentionRole(t *testing.T) {
+	mockTx := newMockTx(nil, nil)
+	mockConn := newMockConn(mockTx, nil)
+	tableCreator := newSQLTableCreator(mockConn,
+		compatModes[compatModePostgreSQL],
+		nil,
+		false,
+		new(appendOnlyConfig),
+		discardLogger)
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if strings.Contains(strings.Join(mockConn.receivedSQL, "\n"), "GRANT") {
+		t.Error("expected no privileges to be granted, but were")
+	}
+
+	if !strings.Contains(strings.Join(mockTx.receivedSQL, "\n"), "tcp_audit_reject_modification()") {
+		t.Errorf("expected triggers to exempt no role, got %q", mockTx.receivedSQL)
+	}
+}
+
+func TestCreateTablesAppendOnlyAlreadyInstalled(t *testing.T) {
+	mockConn := newMockConn(newMockTx(nil, nil), nil)
+	var statuses [][]interface{}
+	for _, table := range appendOnlyTables {
+		statuses = append(statuses, []interface{}{table, true, "mock-retention"})
+	}
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		appendOnlyStatusSQL: statuses,
+	}
+	appendOnly := &appendOnlyConfig{retentionRole: "mock-retention"}
+	tableCreator := newSQLTableCreator(mockConn, compatModes[compatModePostgreSQL], nil, false, appendOnly, discardLogger)
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if mockConn.beginCalled {
+		t.Error("expected triggers to not be replaced, but were")
+	}
+
+	// Triggers exempting another role, or disabled, are replaced
+	mockConn.queryResultsToReturn[appendOnlyStatusSQL] = [][]interface{}{
+		{schema.EventsTable, true, "mock-other-role"},
+		{schema.SocketInfoTable, false, "mock-retention"},
+	}
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockConn.beginCalled {
+		t.Error("expected triggers to be replaced, but were not")
+	}
+}
+
+func TestCreateTablesAppendOnlyTriggerError(t *testing.T) {
+	mockError := errors.New("mock exec error")
+	mockTx := newMockTx(mockError, nil)
+	mockConn := newMockConn(mockTx, nil)
+	tableCreator := newSQLTableCreator(mockConn,
+		compatModes[compatModePostgreSQL],
+		nil,
+		false,
+		new(appendOnlyConfig),
+		discardLogger)
+
+	err := tableCreator.createTables(context.TODO())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+
+	if !mockTx.rollbackCalled {
+		t.Error("expected transaction to be rolled-back, but was not")
+	}
+
+	if mockTx.commitCalled {
+		t.Error("expected transaction to not be committed, but was")
+	}
+}
+
+func TestCreateTablesNotAppendOnly(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	tableCreator := newSQLTableCreator(mockConn, compatModes[compatModePostgreSQL], nil, false, nil, discardLogger)
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if strings.Contains(strings.Join(mockConn.receivedSQL, "\n"), appendOnlyFunctionCreateSQL) {
+		t.Error("expected tables to not be protected, but were")
+	}
+}
+
+func TestReportAppendOnly(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		appendOnlyStatusSQL: {{schema.EventsTable, true, "mock-retention"}},
+	}
+	mockTableCreator := newMockTableCreator(nil)
+	var logged bytes.Buffer
+	tableCreator := newAppendOnlyReportingTableCreator(mockTableCreator,
+		mockConn,
+		nil,
+		log.New(&logged, "", 0))
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockTableCreator.createTablesCalled {
+		t.Error("expected tables to be created, but were not")
+	}
+
+	expectedLog := "Append-only protection of tcp_events is enabled: " +
+		"updates and deletes are rejected, except by members of mock-retention\n" +
+		"Append-only protection of tcp_sockets is not enabled\n" +
+		"Append-only protection of tcp_events_socket_info is not enabled\n" +
+		"Append-only protection of tcp_events_process_info is not enabled\n" +
+		"Append-only protection of tcp_events_chain_checkpoints is not enabled\n"
+	if logged.String() != expectedLog {
+		t.Errorf("expected log:\n%s\ngot:\n%s", expectedLog, logged.String())
+	}
+}
+
+func TestReportAppendOnlyWarns(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		appendOnlyStatusSQL: {{schema.EventsTable, false, nil}},
+	}
+	var logged bytes.Buffer
+	tableCreator := newAppendOnlyReportingTableCreator(newMockTableCreator(nil),
+		mockConn,
+		new(appendOnlyConfig),
+		log.New(&logged, "", 0))
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	expectedLog := "Warning: append-only protection of tcp_events is installed, but its trigger is disabled\n"
+	for _, table := range appendOnlyTables[1:] {
+		expectedLog += "Warning: append-only protection of " + table + " is not installed: " +
+			"the tables must be migrated with append-only protection enabled\n"
+	}
+	if logged.String() != expectedLog {
+		t.Errorf("expected log:\n%s\ngot:\n%s", expectedLog, logged.String())
+	}
+}
+
+func TestReportAppendOnlyQueryError(t *testing.T) {
+	mockError := errors.New("mock query error")
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryErrorToReturn = mockError
+	tableCreator := newAppendOnlyReportingTableCreator(newMockTableCreator(nil), mockConn, nil, discardLogger)
+
+	err := tableCreator.createTables(context.TODO())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+func TestCreateTablesAppendOnlyProtectsRelatedTables(t *testing.T) {
+	for _, table := range []string{schema.SocketsTable, schema.ProcessInfoTable, schema.ChainCheckpointsTable} {
+		mockTx := newMockTx(nil, nil)
+		mockConn := newMockConn(mockTx, nil)
+		mockConn.queryResultsToReturn = map[string][][]interface{}{
+			appendOnlyGranteesSQL: {{"mock-writer"}},
+		}
+		tableCreator := newSQLTableCreator(mockConn,
+			compatModes[compatModePostgreSQL],
+			nil,
+			false,
+			&appendOnlyConfig{retentionRole: "mock-retention"},
+			discardLogger)
+
+		if err := tableCreator.createTables(context.TODO()); err != nil {
+			t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+		}
+
+		revokeSQL := `REVOKE UPDATE, DELETE, TRUNCATE ON ` + table + ` FROM "mock-writer"`
+		if !strings.Contains(strings.Join(mockConn.receivedSQL, "\n"), revokeSQL) {
+			t.Errorf("expected SQL %q to be executed, but was not", revokeSQL)
+		}
+
+		triggerSQL := "CREATE TRIGGER tcp_audit_append_only BEFORE UPDATE OR DELETE ON " + table + " " +
+			"FOR EACH ROW EXECUTE PROCEDURE tcp_audit_reject_modification('mock-retention')"
+		if !strings.Contains(strings.Join(mockTx.receivedSQL, "\n"), triggerSQL) {
+			t.Errorf("expected SQL %q to be executed, but was not", triggerSQL)
+		}
+	}
+}
+
+func TestAppendOnlyTriggerCreateSQLQuotesRole(t *testing.T) {
+	sql := appendOnlyTriggerCreateSQL(schema.EventsTable, "mock-o'role")
+	if !strings.HasSuffix(sql, "tcp_audit_reject_modification('mock-o''role')") {
+		t.Errorf("expected role to be quoted, got %q", sql)
+	}
+}
@@ -0,0 +1,412 @@
+package pgsqlsink
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultChainCheckpointInterval = time.Minute
+
+	// ChainHeadSQL returns the last link of the hash chain of a host.
+	chainHeadSQL = `
+SELECT chain_seq, chain_hash FROM tcp_events
+WHERE host = $1 AND chain_seq IS NOT NULL
+ORDER BY chain_seq DESC
+LIMIT 1`
+
+	// ChainCheckpointHeadSQL returns the last checkpoint of the hash chain
+	// of a host.
+	chainCheckpointHeadSQL = `
+SELECT chain_seq, chain_hash FROM tcp_events_chain_checkpoints
+WHERE host = $1
+ORDER BY chain_seq DESC
+LIMIT 1`
+
+	// The checkpoint is not inserted if the link has already been
+	// checkpointed, e.g. by a checkpoint written before a restart.
+	insertChainCheckpointSQL = `
+INSERT INTO tcp_events_chain_checkpoints (
+	host,
+	chain_seq,
+	chain_hash,
+	created_at,
+	key_id,
+	signature
+) VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT DO NOTHING`
+
+	// The hash of the first link of a chain is computed from that of this
+	// empty, imaginary link.
+	chainGenesisHash = ""
+)
+
+// HashChainConfig holds the configuration of the hash chain linking the
+// stored events of each host.
+type hashChainConfig struct {
+	// KeyFile is the path of the private key which signs checkpoints.
+	// If empty, checkpoints are not stored.
+	keyFile            string
+	checkpointInterval time.Duration
+}
+
+// ChainedColumns are the columns of tcp_events from which the hash of each
+// link is computed, in order, as selected by the verifier. They are the
+// columns inserted by the sink, other than those of the chain itself.
+// Enumerated types are selected as text, as their values are inserted.
+var chainedColumns = []string{
+	"uid", "timestamp", "pid_on_cpu", "comm_on_cpu", "src_ip", "dst_ip", "src_port", "dst_port",
+	"old_state::TEXT", "new_state::TEXT", "host", "sample_rate", "src_name", "dst_name", "src_service",
+	"dst_service", "container_id", "pod_namespace", "pod_name", "container_name", "src_country",
+	"dst_country", "src_asn", "dst_asn", "src_as_org", "dst_as_org", "direction::TEXT", "local_ip",
+	"local_port", "remote_ip", "remote_port", "socket_id", "socket_inode", "socket_state::TEXT",
+}
+
+// ChainedSocketColumns are the columns of the event's socket in tcp_sockets,
+// and ChainedProcessInfoColumns those of its row of tcp_events_process_info,
+// from which the hash is also computed, in order after the chained columns,
+// so that these rows are protected by the chain too. They are NULL if the
+// event has no socket or process info.
+var (
+	chainedSocketColumns      = []string{"user_id", "group_id"}
+	chainedProcessInfoColumns = []string{
+		"cmdline", "exe", "parent_pid", "user_id", "user_name", "cgroup", "container_id",
+		"socket_user_name", "socket_group_name",
+	}
+)
+
+// ChainLink is the position of an event in the hash chain of its host.
+type chainLink struct {
+	seq  int64
+	hash string
+}
+
+// ChainHead is the last link of the hash chain of a host, and the last link
+// checkpointed.
+type chainHead struct {
+	link             chainLink
+	checkpointedSeq  int64
+	checkpointedTime time.Time
+}
+
+// HashChain links each event stored for a host to the previous event stored
+// for that host, by storing with it a sequence number and a hash of the
+// event and the previous hash. An event modified or deleted after it is
+// stored then breaks the chain.
+// If a signing key is set, a checkpoint of the last link, signed by the key,
+// is stored every checkpoint interval, so that a chain which has been
+// rewritten in its entirety, or truncated, is detected too.
+// The chains must not be written concurrently, so each host's events must be
+// stored by a single sink.
+type hashChain struct {
+	conn               conn
+	execer             execer
+	signingKey         ed25519.PrivateKey
+	checkpointInterval time.Duration
+	now                func() time.Time
+	logger             Logger
+
+	heads map[string]*chainHead
+}
+
+func newHashChain(conn conn,
+	execer execer,
+	signingKey ed25519.PrivateKey,
+	checkpointInterval time.Duration,
+	now func() time.Time,
+	logger Logger) *hashChain {
+	return &hashChain{
+		conn:               conn,
+		execer:             execer,
+		signingKey:         signingKey,
+		checkpointInterval: checkpointInterval,
+		now:                now,
+		logger:             logger,
+		heads:              make(map[string]*chainHead),
+	}
+}
+
+// Next returns the link of the next event of the host, with the values of
+// its chained columns. The head of the chain is not advanced until the
+// event has been stored, and the link appended.
+func (c *hashChain) next(ctx context.Context, host string, values []interface{}) (*chainLink, error) {
+	head, ok := c.heads[host]
+	if !ok {
+		var err error
+		if head, err = c.load(ctx, host); err != nil {
+			return nil, fmt.Errorf("loading head of hash chain: %w", err)
+		}
+
+		c.heads[host] = head
+	}
+
+	seq := head.link.seq + 1
+	return &chainLink{seq, chainHash(head.link.hash, seq, values)}, nil
+}
+
+// Load returns the head of the chain of the host as stored. If the chain
+// ends before its last checkpoint, events have been deleted from its end,
+// and the chain continues from the checkpoint, so that the deleted events
+// are reported as missing rather than their links reused.
+func (c *hashChain) load(ctx context.Context, host string) (*chainHead, error) {
+	head := &chainHead{
+		link:             chainLink{0, chainGenesisHash},
+		checkpointedTime: c.now(),
+	}
+
+	rows, err := c.conn.query(ctx, chainHeadSQL, host)
+	if err != nil {
+		return nil, fmt.Errorf("querying last event: %w", err)
+	}
+
+	if len(rows) == 1 {
+		head.link = chainLink{int64Value(rows[0][0]), stringValue(rows[0][1])}
+	}
+
+	if c.signingKey == nil {
+		return head, nil
+	}
+
+	if rows, err = c.conn.query(ctx, chainCheckpointHeadSQL, host); err != nil {
+		return nil, fmt.Errorf("querying last checkpoint: %w", err)
+	}
+
+	if len(rows) == 1 {
+		checkpoint := chainLink{int64Value(rows[0][0]), stringValue(rows[0][1])}
+		if checkpoint.seq > head.link.seq {
+			c.logger.Printf("Hash chain of host %s ends at %d, before its last checkpoint at %d: continuing from the checkpoint",
+				host,
+				head.link.seq,
+				checkpoint.seq)
+			head.link = checkpoint
+		}
+
+		head.checkpointedSeq = checkpoint.seq
+	}
+
+	return head, nil
+}
+
+// Append advances the head of the chain of the host to the link of a
+// stored event, and stores a checkpoint if one is due. Failing to store the
+// checkpoint is logged, and it is retried after the next event.
+func (c *hashChain) append(ctx context.Context, host string, link *chainLink) {
+	head := c.heads[host]
+	head.link = *link
+
+	if c.signingKey == nil || c.now().Sub(head.checkpointedTime) < c.checkpointInterval {
+		return
+	}
+
+	if err := c.checkpoint(ctx, host, head); err != nil {
+		c.logger.Printf("Error storing hash chain checkpoint: %v", err)
+	}
+}
+
+// Reset forgets the head of the chain of the host, so that it is loaded
+// again before the next event. This is required after failing to store an
+// event, as it is not known whether the event was stored.
+func (c *hashChain) reset(host string) {
+	delete(c.heads, host)
+}
+
+// Checkpoint stores a checkpoint of the head of the chain of the host.
+func (c *hashChain) checkpoint(ctx context.Context, host string, head *chainHead) error {
+	// The time is signed as it is stored, in UTC to the microsecond
+	now := c.now()
+	createdAt := now.UTC().Truncate(time.Microsecond)
+	publicKey := c.signingKey.Public().(ed25519.PublicKey)
+	signature := ed25519.Sign(c.signingKey, chainCheckpointMessage(host, head.link.seq, head.link.hash, createdAt))
+
+	if err := c.execer.exec(ctx,
+		insertChainCheckpointSQL,
+		host,
+		head.link.seq,
+		head.link.hash,
+		createdAt,
+		chainKeyID(publicKey),
+		hex.EncodeToString(signature)); err != nil {
+		return fmt.Errorf("inserting into tcp_events_chain_checkpoints: %w", err)
+	}
+
+	head.checkpointedSeq = head.link.seq
+	head.checkpointedTime = now
+	return nil
+}
+
+// Close stores a checkpoint of each chain with links which have not been
+// checkpointed.
+func (c *hashChain) close(ctx context.Context) {
+	if c.signingKey == nil {
+		return
+	}
+
+	for host, head := range c.heads {
+		if head.link.seq == head.checkpointedSeq {
+			continue
+		}
+
+		if err := c.checkpoint(ctx, host, head); err != nil {
+			c.logger.Printf("Error storing hash chain checkpoint: %v", err)
+		}
+	}
+}
+
+// ChainHash returns the hash of a link, computed from the hash of the
+// previous link, the sequence number of the link and the values of the
+// chained columns of its event.
+func chainHash(prevHash string, seq int64, values []interface{}) string {
+	h := sha256.New()
+	writeChainField(h, prevHash, true)
+	writeChainField(h, strconv.FormatInt(seq, 10), true)
+	for _, value := range values {
+		s, ok := chainValue(value)
+		writeChainField(h, s, ok)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// WriteChainField writes a field to the hash, prefixed with its length, so
+// that no two sequences of fields are written alike. NULLs are written as N.
+func writeChainField(h hash.Hash, s string, ok bool) {
+	if !ok {
+		fmt.Fprint(h, "N;")
+		return
+	}
+
+	fmt.Fprintf(h, "%d:%s;", len(s), s)
+}
+
+// ChainValue returns the canonical form of a value as inserted by the sink
+// or as returned by a query of the stored event, which may be of different
+// types (e.g. a uint16 port is returned as an int32), or false if the
+// value is NULL. Timestamps are formatted without a time zone, as they are
+// stored.
+func chainValue(value interface{}) (string, bool) {
+	v := reflect.ValueOf(value)
+	for v.IsValid() && v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", false
+		}
+
+		v = v.Elem()
+	}
+
+	if !v.IsValid() {
+		return "", false
+	}
+
+	switch value := v.Interface().(type) {
+	case time.Time:
+		return value.Format("2006-01-02 15:04:05.000000"), true
+	case net.IP:
+		if len(value) == 0 {
+			return "", false
+		}
+
+		return value.String(), true
+	case net.IPNet:
+		return value.IP.String(), true
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), true
+	default:
+		return fmt.Sprint(v.Interface()), true
+	}
+}
+
+// ChainCheckpointMessage returns the message signed by a checkpoint. The
+// time at which it was created is signed, so that it may be trusted as the
+// time by which the events it covers were stored.
+func chainCheckpointMessage(host string, seq int64, hash string, createdAt time.Time) []byte {
+	created, _ := chainValue(createdAt)
+	return []byte(fmt.Sprintf("tcp-audit-pgsql-sink checkpoint\n%s\n%d\n%s\n%s", host, seq, hash, created))
+}
+
+// ChainKeyID returns the identifier of a public key stored with the
+// checkpoints it signs, so that the verifier may choose between keys.
+func chainKeyID(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:8])
+}
+
+// LoadChainSigningKey reads the Ed25519 private key which signs
+// checkpoints from a PEM-encoded PKCS #8 file, as written by
+// openssl genpkey -algorithm ed25519.
+func loadChainSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+
+	signingKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key is a %T, not an Ed25519 private key", key)
+	}
+
+	return signingKey, nil
+}
+
+// ParseChainPublicKey parses the PEM-encoded PKIX Ed25519 public key which
+// verifies checkpoints, as written by openssl pkey -pubout.
+func ParseChainPublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key is a %T, not an Ed25519 public key", key)
+	}
+
+	return publicKey, nil
+}
+
+// Int64Value returns an integer value returned by a query, or 0 if it is
+// NULL.
+func int64Value(value interface{}) int64 {
+	switch value := value.(type) {
+	case int64:
+		return value
+	case int32:
+		return int64(value)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,666 @@
+package pgsqlsink
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newMockSigningKey(t *testing.T) ed25519.PrivateKey {
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("test bootstrapping: unable to generate key: %v", err)
+	}
+
+	return signingKey
+}
+
+// MockChainValues returns the values of the chained columns of an event with
+// the given UID, and of its socket and process info.
+func mockChainValues(uid string) []interface{} {
+	values := []interface{}{uid, time.Date(2021, 1, 2, 3, 4, 5, 6000, time.UTC)}
+	for len(values) < len(chainedColumns)+len(chainedSocketColumns)+len(chainedProcessInfoColumns) {
+		values = append(values, nil)
+	}
+
+	return values
+}
+
+func TestChainValueMatchesStoredValue(t *testing.T) {
+	mockString := "mock-string"
+	mockASN := int64(64512)
+	local := time.FixedZone("mock-zone", 3600)
+
+	// Each value as inserted, and as returned by a query of the stored event
+	for _, tc := range []struct {
+		inserted, stored interface{}
+	}{
+		{"mock-string", "mock-string"},
+		{&mockString, "mock-string"},
+		{(*string)(nil), nil},
+		{7337, int32(7337)},
+		{uint16(443), int32(443)},
+		{uint32(0xF00DF00D), int64(0xF00DF00D)},
+		{&mockASN, int64(64512)},
+		{0.25, 0.25},
+		{net.ParseIP("1.2.3.4").To4(), &net.IPNet{IP: net.IPv4(1, 2, 3, 4), Mask: net.CIDRMask(32, 32)}},
+		{net.ParseIP("2001:db8::1"), &net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(128, 128)}},
+		{net.ParseIP("2001:db8::1").To4(), nil},
+		{time.Date(2021, 1, 2, 3, 4, 5, 6000, local), time.Date(2021, 1, 2, 3, 4, 5, 6000, time.UTC)},
+	} {
+		inserted, insertedOK := chainValue(tc.inserted)
+		stored, storedOK := chainValue(tc.stored)
+		if inserted != stored || insertedOK != storedOK {
+			t.Errorf("expected inserted value %#v (%q, %v) to match stored value %#v (%q, %v)",
+				tc.inserted, inserted, insertedOK,
+				tc.stored, stored, storedOK)
+		}
+	}
+}
+
+func TestChainHashCoversEveryField(t *testing.T) {
+	values := mockChainValues("mock-uid")
+	hash := chainHash("mock-prev-hash", 1, values)
+
+	if chainHash("mock-other-hash", 1, values) == hash {
+		t.Error("expected hash to depend on previous hash, but did not")
+	}
+
+	if chainHash("mock-prev-hash", 2, values) == hash {
+		t.Error("expected hash to depend on sequence number, but did not")
+	}
+
+	columns := append(append(append([]string{}, chainedColumns...), chainedSocketColumns...),
+		chainedProcessInfoColumns...)
+	for i := range values {
+		modified := append([]interface{}{}, values...)
+		modified[i] = ""
+		if chainHash("mock-prev-hash", 1, modified) == hash {
+			t.Errorf("expected hash to depend on %s, but did not", columns[i])
+		}
+	}
+}
+
+// InsertedColumns returns the columns inserted into the table by the SQL,
+// other than those excluded.
+func insertedColumns(sql, table string, excluded func(column string) bool) []string {
+	start := strings.Index(sql, "INSERT INTO "+table+" (")
+	end := strings.Index(sql[start:], ")") + start
+	var inserted []string
+	for _, column := range strings.Split(sql[start+len("INSERT INTO "+table+" ("):end], ",") {
+		if column = strings.TrimSpace(column); !excluded(column) {
+			inserted = append(inserted, column)
+		}
+	}
+
+	return inserted
+}
+
+func TestChainedColumnsAreInserted(t *testing.T) {
+	// The chained columns are the inserted columns of tcp_events, in order
+	inserted := insertedColumns(insertTCPEventsTableSQL, "tcp_events", func(column string) bool {
+		return strings.HasPrefix(column, "chain_")
+	})
+
+	var chained []string
+	for _, column := range chainedColumns {
+		chained = append(chained, strings.TrimSuffix(column, "::TEXT"))
+	}
+
+	if strings.Join(chained, ", ") != strings.Join(inserted, ", ") {
+		t.Errorf("expected chained columns %q, got %q", inserted, chained)
+	}
+
+	// Of the sockets, those not referenced by the event are chained
+	inserted = insertedColumns(insertTCPEventsTableSQL, "tcp_sockets", func(column string) bool {
+		return column == "host" || column == "id" || column == "inode"
+	})
+	if strings.Join(chainedSocketColumns, ", ") != strings.Join(inserted, ", ") {
+		t.Errorf("expected chained socket columns %q, got %q", inserted, chainedSocketColumns)
+	}
+
+	inserted = insertedColumns(insertTCPEventsTableSQLProcessInfo, "tcp_events_process_info", func(column string) bool {
+		return column == "tcp_event_uid"
+	})
+	if strings.Join(chainedProcessInfoColumns, ", ") != strings.Join(inserted, ", ") {
+		t.Errorf("expected chained process info columns %q, got %q", inserted, chainedProcessInfoColumns)
+	}
+}
+
+func TestHashChainLinks(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	chain := newHashChain(mockConn, newMockExecer(nil), nil, time.Minute, time.Now, discardLogger)
+
+	first, err := chain.next(context.TODO(), "mock-host", mockChainValues("mock-uid-1"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if first.seq != 1 || first.hash != chainHash(chainGenesisHash, 1, mockChainValues("mock-uid-1")) {
+		t.Errorf("expected first link to start the chain, got %+v", first)
+	}
+
+	// The head is not advanced until the link is appended
+	if again, _ := chain.next(context.TODO(), "mock-host", mockChainValues("mock-uid-1")); *again != *first {
+		t.Errorf("expected link %+v, got %+v", first, again)
+	}
+
+	chain.append(context.TODO(), "mock-host", first)
+
+	second, err := chain.next(context.TODO(), "mock-host", mockChainValues("mock-uid-2"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if second.seq != 2 || second.hash != chainHash(first.hash, 2, mockChainValues("mock-uid-2")) {
+		t.Errorf("expected second link to follow the first, got %+v", second)
+	}
+
+	// Other hosts have chains of their own
+	other, err := chain.next(context.TODO(), "mock-other-host", mockChainValues("mock-uid-3"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if other.seq != 1 {
+		t.Errorf("expected other host's chain to start at 1, got %d", other.seq)
+	}
+}
+
+func TestHashChainLoadsHead(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		chainHeadSQL: {{int64(5), "mock-hash-5"}},
+	}
+	chain := newHashChain(mockConn, newMockExecer(nil), nil, time.Minute, time.Now, discardLogger)
+
+	link, err := chain.next(context.TODO(), "mock-host", mockChainValues("mock-uid"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if link.seq != 6 || link.hash != chainHash("mock-hash-5", 6, mockChainValues("mock-uid")) {
+		t.Errorf("expected link to follow the stored head, got %+v", link)
+	}
+
+	// After failing to store an event, the head is loaded again
+	mockConn.queryResultsToReturn[chainHeadSQL] = [][]interface{}{{int64(6), "mock-hash-6"}}
+	chain.reset("mock-host")
+
+	if link, err = chain.next(context.TODO(), "mock-host", mockChainValues("mock-uid")); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if link.seq != 7 {
+		t.Errorf("expected link to follow the reloaded head, got %+v", link)
+	}
+}
+
+func TestHashChainContinuesFromCheckpoint(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		chainHeadSQL:           {{int64(5), "mock-hash-5"}},
+		chainCheckpointHeadSQL: {{int64(7), "mock-hash-7"}},
+	}
+	chain := newHashChain(mockConn, newMockExecer(nil), newMockSigningKey(t), time.Minute, time.Now, discardLogger)
+
+	link, err := chain.next(context.TODO(), "mock-host", mockChainValues("mock-uid"))
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if link.seq != 8 || link.hash != chainHash("mock-hash-7", 8, mockChainValues("mock-uid")) {
+		t.Errorf("expected link to follow the checkpoint, got %+v", link)
+	}
+}
+
+func TestHashChainLoadError(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	mockError := errors.New("mock query error")
+	mockConn.queryErrorToReturn = mockError
+	chain := newHashChain(mockConn, newMockExecer(nil), nil, time.Minute, time.Now, discardLogger)
+
+	_, err := chain.next(context.TODO(), "mock-host", mockChainValues("mock-uid"))
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+func TestHashChainCheckpoints(t *testing.T) {
+	now := time.Now()
+	signingKey := newMockSigningKey(t)
+	mockExecer := newMockExecer(nil)
+	chain := newHashChain(newMockConn(nil, nil),
+		mockExecer,
+		signingKey,
+		time.Minute,
+		func() time.Time { return now },
+		discardLogger)
+
+	link, _ := chain.next(context.TODO(), "mock-host", mockChainValues("mock-uid-1"))
+	chain.append(context.TODO(), "mock-host", link)
+
+	if mockExecer.execCalled {
+		t.Error("expected no checkpoint before the interval, but was stored")
+	}
+
+	now = now.Add(time.Minute)
+	link, _ = chain.next(context.TODO(), "mock-host", mockChainValues("mock-uid-2"))
+	chain.append(context.TODO(), "mock-host", link)
+
+	if mockExecer.receivedSQL != insertChainCheckpointSQL {
+		t.Fatalf("expected checkpoint to be stored, but was not")
+	}
+
+	args := mockExecer.receivedArgs
+	if args[0] != "mock-host" || args[1] != link.seq || args[2] != link.hash {
+		t.Errorf("expected checkpoint of link %+v, got %v", link, args[:3])
+	}
+
+	if args[4] != chainKeyID(signingKey.Public().(ed25519.PublicKey)) {
+		t.Errorf("expected checkpoint to identify the key, got %v", args[4])
+	}
+
+	signature, err := hex.DecodeString(args[5].(string))
+	if err != nil {
+		t.Fatalf("expected hex signature, got %q", args[5])
+	}
+
+	if !ed25519.Verify(signingKey.Public().(ed25519.PublicKey),
+		chainCheckpointMessage("mock-host", link.seq, link.hash, args[3].(time.Time)),
+		signature) {
+		t.Error("expected valid signature, but was invalid")
+	}
+
+	// A final checkpoint is stored on close, if there are new links
+	mockExecer.execCalled = false
+	chain.close(context.TODO())
+
+	if mockExecer.execCalled {
+		t.Error("expected no checkpoint on close without new links, but was stored")
+	}
+
+	link, _ = chain.next(context.TODO(), "mock-host", mockChainValues("mock-uid-3"))
+	chain.append(context.TODO(), "mock-host", link)
+	chain.close(context.TODO())
+
+	if !mockExecer.execCalled || mockExecer.receivedArgs[1] != link.seq {
+		t.Error("expected checkpoint of the last link on close, but was not stored")
+	}
+}
+
+func TestInsertWithHashChain(t *testing.T) {
+	mockExecer := newMockExecer(nil)
+	mockCmdline := "curl https://example.com"
+	chain := newHashChain(newMockConn(nil, nil), mockExecer, nil, time.Minute, time.Now, discardLogger)
+	mockEvent := &tcpEvent{
+		uid:         "mock-uid",
+		host:        "mock-host",
+		time:        time.Date(2021, 1, 2, 3, 4, 5, 6789, time.UTC),
+		srcIP:       net.ParseIP("1.2.3.4"),
+		dstIP:       net.ParseIP("7.3.3.7"),
+		sampleRate:  1,
+		processInfo: &processInfo{cmdline: &mockCmdline},
+	}
+	mockSocketInfo := &socketInfo{
+		id:      "mock-socket-id",
+		iNode:   0xF00DF00D,
+		userID:  0xCAFEBABE,
+		groupID: 0xDEADBEEF,
+		state:   "mock-socket-state",
+	}
+
+	inserter := newPreparedStatementInserter(newMockStatementPreparer(nil, 0), mockExecer, "", true, true, chain)
+	if err := inserter.insert(context.TODO(), mockEvent, mockSocketInfo); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	args := mockExecer.receivedArgs
+	if args[1] != time.Date(2021, 1, 2, 3, 4, 5, 6000, time.UTC) {
+		t.Errorf("expected time to be truncated to the microsecond, got %v", args[1])
+	}
+
+	values := append(append([]interface{}{}, args[:socketInfoArgsOffset]...),
+		mockSocketInfo.id,
+		mockSocketInfo.iNode,
+		mockSocketInfo.state,
+		mockSocketInfo.userID,
+		mockSocketInfo.groupID,
+		&mockCmdline)
+	for len(values) < len(chainedColumns)+len(chainedSocketColumns)+len(chainedProcessInfoColumns) {
+		values = append(values, nil)
+	}
+	if args[chainArgsOffset] != int64(1) || args[chainArgsOffset+1] != chainHash(chainGenesisHash, 1, values) {
+		t.Errorf("expected first link of the chain, got %v", args[chainArgsOffset:chainArgsOffset+2])
+	}
+
+	// The head is advanced once the event is stored
+	if head := chain.heads["mock-host"]; head.link.seq != 1 {
+		t.Errorf("expected head of the chain to be 1, got %d", head.link.seq)
+	}
+}
+
+func TestInsertWithHashChainError(t *testing.T) {
+	mockError := errors.New("mock exec error")
+	chain := newHashChain(newMockConn(nil, nil), newMockExecer(nil), nil, time.Minute, time.Now, discardLogger)
+	mockEvent := &tcpEvent{uid: "mock-uid", host: "mock-host", time: time.Now()}
+
+	inserter := newPreparedStatementInserter(newMockStatementPreparer(nil, 0), newMockExecer(mockError), "", true, false, chain)
+	if err := inserter.insert(context.TODO(), mockEvent, nil); !errors.Is(err, mockError) {
+		t.Fatalf("expected error chain to include %q, got %v", mockError, err)
+	}
+
+	// It is not known whether the event was stored, so the head is reloaded
+	if _, ok := chain.heads["mock-host"]; ok {
+		t.Error("expected head of the chain to be forgotten, but was not")
+	}
+}
+
+// MockChain returns the rows of a chain of events of mock-host, as returned
+// by chainEventsSQL.
+func mockChain(length int) [][]interface{} {
+	var rows [][]interface{}
+	prevHash := chainGenesisHash
+	for seq := int64(1); seq <= int64(length); seq++ {
+		values := mockChainValues("mock-uid-" + string(rune('a'+seq)))
+		hash := chainHash(prevHash, seq, values)
+		rows = append(rows, append([]interface{}{seq, hash}, values...))
+		prevHash = hash
+	}
+
+	return rows
+}
+
+// MockCheckpointTime is the time at which mock checkpoints are created,
+// unless otherwise given.
+var mockCheckpointTime = time.Date(2021, 1, 2, 3, 4, 5, 6000, time.UTC)
+
+// MockCheckpoint returns the row of a checkpoint of a link of mock-host, as
+// returned by chainCheckpointsSQL.
+func mockCheckpoint(signingKey ed25519.PrivateKey, seq int64, hash string, createdAt time.Time) []interface{} {
+	signature := ed25519.Sign(signingKey, chainCheckpointMessage("mock-host", seq, hash, createdAt))
+	return []interface{}{
+		seq,
+		hash,
+		chainKeyID(signingKey.Public().(ed25519.PublicKey)),
+		hex.EncodeToString(signature),
+		createdAt,
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	signingKey := newMockSigningKey(t)
+	events := mockChain(5)
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		chainHostsSQL:       {{"mock-host"}},
+		chainEventsSQL:      events,
+		chainCheckpointsSQL: {mockCheckpoint(signingKey, 5, events[4][1].(string), mockCheckpointTime)},
+	}
+	verifier := newChainVerifier(mockConn, []ed25519.PublicKey{signingKey.Public().(ed25519.PublicKey)}, 0, time.Now)
+
+	report, err := verifier.verify(context.TODO(), nil)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if len(report.Problems) != 0 {
+		t.Errorf("expected no problems, got %q", report.Problems)
+	}
+
+	if report.Hosts != 1 || report.Events != 5 || report.Checkpoints != 1 {
+		t.Errorf("expected 1 host, 5 events and 1 checkpoint to be verified, got %+v", report)
+	}
+}
+
+func TestVerifyChainReportsProblems(t *testing.T) {
+	signingKey := newMockSigningKey(t)
+	otherKey := newMockSigningKey(t)
+	events := mockChain(10)
+
+	// Event 2 is modified, 4 and 5 are deleted, 7 is duplicated, and 9 is
+	// modified and its hash, and those after it, recomputed. 10 is
+	// deleted, but was checkpointed.
+	events[1][5] = "mock-modified-comm"
+	duplicate := append([]interface{}{}, events[6]...)
+	duplicate[2] = "mock-uid-z"
+	events[8][5] = "mock-modified-comm"
+	events[8][1] = chainHash(events[7][1].(string), 9, events[8][2:])
+	events = append(append(append(events[:3:3], events[5:7]...), duplicate), events[7:9]...)
+
+	checkpoint9 := mockChain(9)[8][1].(string)
+	checkpoint10 := mockChain(10)[9][1].(string)
+
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		chainEventsSQL: events,
+		chainCheckpointsSQL: {
+			mockCheckpoint(otherKey, 3, events[2][1].(string), mockCheckpointTime),
+			{int64(6), events[3][1], chainKeyID(signingKey.Public().(ed25519.PublicKey)), "00", mockCheckpointTime},
+			mockCheckpoint(signingKey, 9, checkpoint9, mockCheckpointTime),
+			mockCheckpoint(signingKey, 10, checkpoint10, mockCheckpointTime),
+		},
+	}
+	verifier := newChainVerifier(mockConn, []ed25519.PublicKey{signingKey.Public().(ed25519.PublicKey)}, 0, time.Now)
+
+	report, err := verifier.verify(context.TODO(), []string{"mock-host"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	expectedProblems := []string{
+		"host mock-host: checkpoint at sequence number 3 is signed by unknown key " +
+			chainKeyID(otherKey.Public().(ed25519.PublicKey)),
+		"host mock-host: checkpoint at sequence number 6 has an invalid signature",
+		"host mock-host: event mock-uid-c (sequence number 2) has been modified",
+		"host mock-host: events 4 to 5 are missing",
+		"host mock-host: event mock-uid-z duplicates sequence number 7",
+		"host mock-host: event mock-uid-j (sequence number 9) does not match its signed checkpoint: " +
+			"it or the events before it have been modified",
+		"host mock-host: event 10 is missing from the end of the chain, before its checkpoint at 10",
+	}
+
+	if strings.Join(report.Problems, "\n") != strings.Join(expectedProblems, "\n") {
+		t.Errorf("expected problems:\n%s\ngot:\n%s",
+			strings.Join(expectedProblems, "\n"),
+			strings.Join(report.Problems, "\n"))
+	}
+}
+
+func TestVerifyChainRetention(t *testing.T) {
+	signingKey := newMockSigningKey(t)
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	events := mockChain(10)
+
+	// Events 1 to 6 are deleted, and events 1 to 4 had been checkpointed
+	// longer ago than the retention period
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		chainEventsSQL: events[6:],
+		chainCheckpointsSQL: {
+			mockCheckpoint(signingKey, 2, events[1][1].(string), now.Add(-100*24*time.Hour)),
+			mockCheckpoint(signingKey, 4, events[3][1].(string), now.Add(-91*24*time.Hour)),
+			mockCheckpoint(signingKey, 8, events[7][1].(string), now.Add(-89*24*time.Hour)),
+		},
+	}
+	verifier := newChainVerifier(mockConn,
+		[]ed25519.PublicKey{signingKey.Public().(ed25519.PublicKey)},
+		90*24*time.Hour,
+		func() time.Time { return now })
+
+	report, err := verifier.verify(context.TODO(), []string{"mock-host"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	expectedProblems := []string{"host mock-host: events 5 to 6 are missing"}
+	if strings.Join(report.Problems, "\n") != strings.Join(expectedProblems, "\n") {
+		t.Errorf("expected problems:\n%s\ngot:\n%s",
+			strings.Join(expectedProblems, "\n"),
+			strings.Join(report.Problems, "\n"))
+	}
+
+	// Every event is deleted, and the retention period has passed for all
+	// but the last checkpoint
+	mockConn.queryResultsToReturn[chainEventsSQL] = nil
+
+	report, err = verifier.verify(context.TODO(), []string{"mock-host"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	expectedProblems = []string{
+		"host mock-host: events 5 to 8 are missing from the end of the chain, before its checkpoint at 8",
+	}
+	if strings.Join(report.Problems, "\n") != strings.Join(expectedProblems, "\n") {
+		t.Errorf("expected problems:\n%s\ngot:\n%s",
+			strings.Join(expectedProblems, "\n"),
+			strings.Join(report.Problems, "\n"))
+	}
+}
+
+func TestVerifyChainCheckpointTimeIsSigned(t *testing.T) {
+	signingKey := newMockSigningKey(t)
+	events := mockChain(2)
+
+	// A checkpoint backdated to hide deleted events is not trusted
+	checkpoint := mockCheckpoint(signingKey, 2, events[1][1].(string), mockCheckpointTime)
+	checkpoint[4] = mockCheckpointTime.Add(-365 * 24 * time.Hour)
+
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		chainCheckpointsSQL: {checkpoint},
+	}
+	verifier := newChainVerifier(mockConn,
+		[]ed25519.PublicKey{signingKey.Public().(ed25519.PublicKey)},
+		24*time.Hour,
+		func() time.Time { return mockCheckpointTime })
+
+	report, err := verifier.verify(context.TODO(), []string{"mock-host"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	expectedProblems := []string{"host mock-host: checkpoint at sequence number 2 has an invalid signature"}
+	if strings.Join(report.Problems, "\n") != strings.Join(expectedProblems, "\n") {
+		t.Errorf("expected problems:\n%s\ngot:\n%s",
+			strings.Join(expectedProblems, "\n"),
+			strings.Join(report.Problems, "\n"))
+	}
+}
+
+func TestVerifyChainReportsModifiedSocketAndProcessInfo(t *testing.T) {
+	events := mockChain(3)
+
+	// The user owning the socket of event 1, and the command line of
+	// event 3, are modified
+	events[0][2+len(chainedColumns)] = int64(0)
+	events[2][2+len(chainedColumns)+len(chainedSocketColumns)] = "mock-modified-cmdline"
+
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		chainEventsSQL: events,
+	}
+	verifier := newChainVerifier(mockConn, nil, 0, time.Now)
+
+	report, err := verifier.verify(context.TODO(), []string{"mock-host"})
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	expectedProblems := []string{
+		"host mock-host: event mock-uid-b (sequence number 1) has been modified",
+		"host mock-host: event mock-uid-d (sequence number 3) has been modified",
+	}
+
+	if strings.Join(report.Problems, "\n") != strings.Join(expectedProblems, "\n") {
+		t.Errorf("expected problems:\n%s\ngot:\n%s",
+			strings.Join(expectedProblems, "\n"),
+			strings.Join(report.Problems, "\n"))
+	}
+}
+
+func TestVerifyChainQueryError(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	mockError := errors.New("mock query error")
+	mockConn.queryErrorToReturn = mockError
+	verifier := newChainVerifier(mockConn, nil, 0, time.Now)
+
+	_, err := verifier.verify(context.TODO(), []string{"mock-host"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+func TestLoadChainKeys(t *testing.T) {
+	signingKey := newMockSigningKey(t)
+	privateDER, err := x509.MarshalPKCS8PrivateKey(signingKey)
+	if err != nil {
+		t.Fatalf("test bootstrapping: unable to marshal private key: %v", err)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(signingKey.Public())
+	if err != nil {
+		t.Fatalf("test bootstrapping: unable to marshal public key: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "chain-key")
+	if err != nil {
+		t.Fatalf("test bootstrapping: unable to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600); err != nil {
+		t.Fatalf("test bootstrapping: unable to write key file: %v", err)
+	}
+
+	loadedKey, err := loadChainSigningKey(path)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !loadedKey.Equal(signingKey) {
+		t.Error("expected loaded key to equal the written key, but did not")
+	}
+
+	publicKey, err := ParseChainPublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !publicKey.Equal(signingKey.Public()) {
+		t.Error("expected parsed key to equal the public key, but did not")
+	}
+
+	if _, err := ParseChainPublicKey([]byte("mock-not-pem")); err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	if _, err := loadChainSigningKey(filepath.Join(dir, "mock-missing.pem")); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
@@ -0,0 +1,289 @@
+package pgsqlsink
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	chainVerifyPageSize = 1000
+
+	// ChainHostsSQL returns the hosts with a hash chain.
+	chainHostsSQL = `
+SELECT host FROM tcp_events WHERE chain_seq IS NOT NULL
+UNION
+SELECT host FROM tcp_events_chain_checkpoints
+ORDER BY 1`
+
+	// ChainCheckpointsSQL returns the checkpoints of the chain of a host,
+	// in order.
+	chainCheckpointsSQL = `
+SELECT chain_seq, chain_hash, key_id, signature, created_at FROM tcp_events_chain_checkpoints
+WHERE host = $1
+ORDER BY chain_seq`
+)
+
+// ChainEventsSQL returns a page of the chained events of a host, in order,
+// after the given sequence number and UID, with the chained columns of their
+// sockets and process info. Events are ordered by UID too, so that pages do
+// not split events with the same sequence number.
+var chainEventsSQL = `
+SELECT e.chain_seq, e.chain_hash, e.` + strings.Join(chainedColumns, ", e.") + `,
+	s.` + strings.Join(chainedSocketColumns, ", s.") + `,
+	p.` + strings.Join(chainedProcessInfoColumns, ", p.") + `
+FROM tcp_events e
+LEFT JOIN tcp_sockets s ON s.host = e.host AND s.id = e.socket_id AND s.inode = e.socket_inode
+LEFT JOIN tcp_events_process_info p ON p.tcp_event_uid = e.uid
+WHERE e.host = $1 AND (e.chain_seq, e.uid) > ($2::BIGINT, $3::TEXT)
+ORDER BY e.chain_seq, e.uid
+LIMIT ` + strconv.Itoa(chainVerifyPageSize)
+
+// ChainReport is the result of verifying the hash chains of the stored
+// events.
+type ChainReport struct {
+	// Hosts, Events and Checkpoints are the numbers of each verified.
+	Hosts       int
+	Events      int64
+	Checkpoints int
+
+	// Problems describes each modification, gap or other break found in
+	// the chains. If empty, the chains are intact.
+	Problems []string
+}
+
+// VerifyChain verifies the hash chains linking the events stored for each
+// of the hosts, or for every host if none are given, reporting events which
+// have been modified or deleted since they were stored. The checkpoints of
+// the chains are verified with the public keys, and checkpoints signed by
+// other keys are reported.
+// If the retention period is non-zero, events are expected to be deleted
+// once they are older than it, so the events covered by a checkpoint
+// created longer ago than it are not reported if missing.
+func VerifyChain(ctx context.Context,
+	config *Config,
+	hosts []string,
+	publicKeys []ed25519.PublicKey,
+	retention time.Duration,
+	opts ...Option) (*ChainReport, error) {
+	options := &options{
+		logger: log.New(os.Stderr, "", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	sinkConfig, err := newSinkConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("validating sink config: %w", err)
+	}
+
+	connector := newPGXConnector(config.ConnString, sinkConfig.statementMode)
+	conn, err := connector.connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to database: %w", err)
+	}
+	defer func() {
+		if err := conn.close(ctx); err != nil {
+			options.logger.Printf("Error closing database connection: %v", err)
+		}
+	}()
+
+	return newChainVerifier(conn, publicKeys, retention, time.Now).verify(ctx, hosts)
+}
+
+// ChainVerifier walks the hash chains of the stored events, recomputing
+// the hash of each event.
+type chainVerifier struct {
+	conn       conn
+	publicKeys map[string]ed25519.PublicKey
+	retention  time.Duration
+	now        func() time.Time
+}
+
+func newChainVerifier(conn conn,
+	publicKeys []ed25519.PublicKey,
+	retention time.Duration,
+	now func() time.Time) *chainVerifier {
+	keys := make(map[string]ed25519.PublicKey, len(publicKeys))
+	for _, publicKey := range publicKeys {
+		keys[chainKeyID(publicKey)] = publicKey
+	}
+
+	return &chainVerifier{conn, keys, retention, now}
+}
+
+// Verify verifies the chains of the hosts, or of every host if none are
+// given.
+func (v *chainVerifier) verify(ctx context.Context, hosts []string) (*ChainReport, error) {
+	if len(hosts) == 0 {
+		rows, err := v.conn.query(ctx, chainHostsSQL)
+		if err != nil {
+			return nil, fmt.Errorf("querying hosts: %w", err)
+		}
+
+		for _, row := range rows {
+			hosts = append(hosts, stringValue(row[0]))
+		}
+	}
+
+	report := new(ChainReport)
+	for _, host := range hosts {
+		if err := v.verifyHost(ctx, host, report); err != nil {
+			return nil, fmt.Errorf("verifying chain of host %s: %w", host, err)
+		}
+
+		report.Hosts++
+	}
+
+	return report, nil
+}
+
+// VerifyHost verifies the chain of a host, adding the problems found to the
+// report.
+// The hash of each event is recomputed from the stored hash of the previous
+// event, so that a modified event is reported alone rather than with every
+// event after it. As the hash of an event deleted from the chain is not
+// known, the event after it is only verified by the checkpoints after it.
+// Events up to the retention cut-point may have been deleted by retention,
+// so are not reported if missing.
+func (v *chainVerifier) verifyHost(ctx context.Context, host string, report *ChainReport) error {
+	problemf := func(format string, a ...interface{}) {
+		report.Problems = append(report.Problems, "host "+host+": "+fmt.Sprintf(format, a...))
+	}
+
+	checkpoints, cutSeq, err := v.checkpoints(ctx, host, problemf)
+	if err != nil {
+		return err
+	}
+
+	report.Checkpoints += len(checkpoints)
+
+	expectedSeq, prevHash, prevKnown := int64(1), chainGenesisHash, true
+	lastSeq, lastUID := int64(0), ""
+	for {
+		rows, err := v.conn.query(ctx, chainEventsSQL, host, lastSeq, lastUID)
+		if err != nil {
+			return fmt.Errorf("querying events: %w", err)
+		}
+
+		for _, row := range rows {
+			seq, hash, values := int64Value(row[0]), stringValue(row[1]), row[2:]
+			uid := stringValue(values[0])
+			lastSeq, lastUID = seq, uid
+			report.Events++
+
+			if seq < expectedSeq {
+				problemf("event %s duplicates sequence number %d", uid, seq)
+				continue
+			}
+
+			if seq > expectedSeq {
+				if first := firstUnprunedSeq(expectedSeq, cutSeq); first < seq {
+					problemf("%s missing", describeChainGap(first, seq-1))
+				}
+				prevKnown = false
+			}
+
+			if prevKnown && chainHash(prevHash, seq, values) != hash {
+				problemf("event %s (sequence number %d) has been modified", uid, seq)
+			}
+
+			if checkpointHash, ok := checkpoints[seq]; ok && checkpointHash != hash {
+				problemf("event %s (sequence number %d) does not match its signed checkpoint: "+
+					"it or the events before it have been modified", uid, seq)
+			}
+
+			expectedSeq, prevHash, prevKnown = seq+1, hash, true
+		}
+
+		if len(rows) < chainVerifyPageSize {
+			break
+		}
+	}
+
+	// Events deleted from the end of the chain are only known to have
+	// existed if they were checkpointed
+	lastCheckpointSeq := int64(0)
+	for seq := range checkpoints {
+		if seq > lastCheckpointSeq {
+			lastCheckpointSeq = seq
+		}
+	}
+
+	if first := firstUnprunedSeq(expectedSeq, cutSeq); lastCheckpointSeq >= first {
+		problemf("%s missing from the end of the chain, before its checkpoint at %d",
+			describeChainGap(first, lastCheckpointSeq),
+			lastCheckpointSeq)
+	}
+
+	return nil
+}
+
+// Checkpoints returns the hashes of the checkpoints of the chain of a host
+// with valid signatures, by sequence number, and the retention cut-point:
+// the sequence number of the last such checkpoint created longer ago than
+// the retention period, if set, or else 0. Checkpoints signed by unknown
+// keys, or with invalid signatures, are reported.
+func (v *chainVerifier) checkpoints(ctx context.Context,
+	host string,
+	problemf func(format string, a ...interface{})) (map[int64]string, int64, error) {
+	rows, err := v.conn.query(ctx, chainCheckpointsSQL, host)
+	if err != nil {
+		return nil, 0, fmt.Errorf("querying checkpoints: %w", err)
+	}
+
+	// Creation times are stored in UTC
+	retainedFrom := v.now().UTC().Add(-v.retention)
+
+	checkpoints := make(map[int64]string, len(rows))
+	cutSeq := int64(0)
+	for _, row := range rows {
+		seq, hash, keyID := int64Value(row[0]), stringValue(row[1]), stringValue(row[2])
+		createdAt, _ := row[4].(time.Time)
+
+		publicKey, ok := v.publicKeys[keyID]
+		if !ok {
+			problemf("checkpoint at sequence number %d is signed by unknown key %s", seq, keyID)
+			continue
+		}
+
+		signature, err := hex.DecodeString(stringValue(row[3]))
+		if err != nil || !ed25519.Verify(publicKey, chainCheckpointMessage(host, seq, hash, createdAt), signature) {
+			problemf("checkpoint at sequence number %d has an invalid signature", seq)
+			continue
+		}
+
+		checkpoints[seq] = hash
+		if v.retention > 0 && !createdAt.After(retainedFrom) && seq > cutSeq {
+			cutSeq = seq
+		}
+	}
+
+	return checkpoints, cutSeq, nil
+}
+
+// FirstUnprunedSeq returns the first sequence number, from the given one,
+// after the retention cut-point.
+func firstUnprunedSeq(seq, cutSeq int64) int64 {
+	if seq <= cutSeq {
+		return cutSeq + 1
+	}
+
+	return seq
+}
+
+// DescribeChainGap describes the events missing from a chain.
+func describeChainGap(first, last int64) string {
+	if first == last {
+		return fmt.Sprintf("event %d is", first)
+	}
+
+	return fmt.Sprintf("events %d to %d are", first, last)
+}
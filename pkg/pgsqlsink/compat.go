@@ -0,0 +1,92 @@
+package pgsqlsink
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	compatModePostgreSQL  = "postgresql"
+	compatModeCockroachDB = "cockroachdb"
+	compatModeYugabyteDB  = "yugabytedb"
+
+	// Distributed databases report conflicts between concurrent transactions
+	// far more often than PostgreSQL, and expect the client to retry.
+	distributedSerializationRetries = 10
+	serializationRetryBackoff       = 10 * time.Millisecond
+)
+
+// CompatMode describes the adjustments made to the DDL and transaction
+// handling to target a PostgreSQL-wire-compatible database.
+type compatMode struct {
+	name string
+
+	// RollupKeyLeadsWithHost orders the rollup table primary keys by host
+	// before bucket. Distributed databases split tables into ranges by
+	// primary key, so a key leading with the time would send all writes to
+	// the range holding the latest bucket.
+	rollupKeyLeadsWithHost bool
+
+	// DOBlocks is whether anonymous code blocks are supported. They are
+	// required to migrate the state columns of tables created by earlier
+	// versions, so these migrations are skipped if not.
+	doBlocks bool
+
+	// Notify is whether LISTEN/NOTIFY is supported.
+	notify bool
+
+	// IndexMethods is whether the BRIN and SP-GiST index methods are
+	// supported. If not, all indexes are created as B-trees.
+	indexMethods bool
+
+	// CatalogChecks is whether the system catalogs report the types and
+	// indexes of the tables as PostgreSQL does, so that the schema may be
+	// compared to the layout expected by the sink.
+	catalogChecks bool
+
+	// Triggers is whether PL/pgSQL trigger functions are supported, as
+	// required to protect the events tables against updates and deletes.
+	triggers bool
+
+	// SerializationRetries is the number of times a transaction is retried
+	// after failing with a serialization failure.
+	serializationRetries int
+}
+
+var compatModes = map[string]*compatMode{
+	compatModePostgreSQL: {
+		name:          compatModePostgreSQL,
+		doBlocks:      true,
+		notify:        true,
+		indexMethods:  true,
+		catalogChecks: true,
+		triggers:      true,
+	},
+	compatModeCockroachDB: {
+		name:                   compatModeCockroachDB,
+		rollupKeyLeadsWithHost: true,
+		serializationRetries:   distributedSerializationRetries,
+	},
+	compatModeYugabyteDB: {
+		name:                   compatModeYugabyteDB,
+		rollupKeyLeadsWithHost: true,
+		serializationRetries:   distributedSerializationRetries,
+	},
+}
+
+// ParseCompatMode parses the name of a compatibility mode. If empty,
+// vanilla PostgreSQL is targeted.
+func parseCompatMode(name string) (*compatMode, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = compatModePostgreSQL
+	}
+
+	mode, ok := compatModes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown compatibility mode %q", name)
+	}
+
+	return mode, nil
+}
@@ -0,0 +1,648 @@
+package pgsqlsink
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	notifyChannelEnvVar           = "TCP_AUDIT_PGSQL_NOTIFY_CHANNEL"
+	filterIncludeEnvVar           = "TCP_AUDIT_PGSQL_FILTER_INCLUDE"
+	filterExcludeEnvVar           = "TCP_AUDIT_PGSQL_FILTER_EXCLUDE"
+	samplingModeEnvVar            = "TCP_AUDIT_PGSQL_SAMPLING_MODE"
+	samplingRatioEnvVar           = "TCP_AUDIT_PGSQL_SAMPLING_RATIO"
+	samplingSourceRateEnvVar      = "TCP_AUDIT_PGSQL_SAMPLING_SOURCE_RATE"
+	samplingSourceBurstEnvVar     = "TCP_AUDIT_PGSQL_SAMPLING_SOURCE_BURST"
+	samplingSummaryIntervalEnvVar = "TCP_AUDIT_PGSQL_SAMPLING_SUMMARY_INTERVAL"
+	rollupsEnvVar                 = "TCP_AUDIT_PGSQL_ROLLUPS"
+	rollupFlushIntervalEnvVar     = "TCP_AUDIT_PGSQL_ROLLUP_FLUSH_INTERVAL"
+	rollupRetentionEnvVarFormat   = "TCP_AUDIT_PGSQL_ROLLUP_%s_RETENTION"
+	enrichmentsEnvVar             = "TCP_AUDIT_PGSQL_ENRICHMENTS"
+	dnsTimeoutEnvVar              = "TCP_AUDIT_PGSQL_DNS_TIMEOUT"
+	dnsCacheSizeEnvVar            = "TCP_AUDIT_PGSQL_DNS_CACHE_SIZE"
+	dnsCacheTTLEnvVar             = "TCP_AUDIT_PGSQL_DNS_CACHE_TTL"
+	servicesFileEnvVar            = "TCP_AUDIT_PGSQL_SERVICES_FILE"
+	procRootEnvVar                = "TCP_AUDIT_PGSQL_PROC_ROOT"
+	containerMetadataFileEnvVar   = "TCP_AUDIT_PGSQL_CONTAINER_METADATA_FILE"
+	geoIPCountryDBEnvVar          = "TCP_AUDIT_PGSQL_GEOIP_COUNTRY_DB"
+	geoIPASNDBEnvVar              = "TCP_AUDIT_PGSQL_GEOIP_ASN_DB"
+	compatModeEnvVar              = "TCP_AUDIT_PGSQL_COMPAT_MODE"
+	hypertableEnvVar              = "TCP_AUDIT_PGSQL_HYPERTABLE"
+	hypertableChunkIntervalEnvVar = "TCP_AUDIT_PGSQL_HYPERTABLE_CHUNK_INTERVAL"
+	hypertableCompressAfterEnvVar = "TCP_AUDIT_PGSQL_HYPERTABLE_COMPRESS_AFTER"
+	hypertableRetentionEnvVar     = "TCP_AUDIT_PGSQL_HYPERTABLE_RETENTION"
+	statementModeEnvVar           = "TCP_AUDIT_PGSQL_STATEMENT_MODE"
+	indexesEnvVar                 = "TCP_AUDIT_PGSQL_INDEXES"
+	schemaModeEnvVar              = "TCP_AUDIT_PGSQL_SCHEMA_MODE"
+	schemaCheckEnvVar             = "TCP_AUDIT_PGSQL_SCHEMA_CHECK"
+	hashChainEnvVar               = "TCP_AUDIT_PGSQL_HASH_CHAIN"
+	hashChainKeyFileEnvVar        = "TCP_AUDIT_PGSQL_HASH_CHAIN_KEY_FILE"
+	hashChainCheckpointEnvVar     = "TCP_AUDIT_PGSQL_HASH_CHAIN_CHECKPOINT_INTERVAL"
+	appendOnlyEnvVar              = "TCP_AUDIT_PGSQL_APPEND_ONLY"
+	retentionRoleEnvVar           = "TCP_AUDIT_PGSQL_RETENTION_ROLE"
+
+	defaultSamplingSummaryInterval = time.Minute
+)
+
+// SinkConfig holds the configuration of the optional behaviour of the sink.
+type sinkConfig struct {
+	// NotifyChannel is the channel on which a notification is sent for
+	// each stored event. If empty, notifications are not sent.
+	notifyChannel string
+
+	// IncludeRules and ExcludeRules decide which events are stored.
+	// See parseFilterRules for their format.
+	includeRules, excludeRules []*filterRule
+
+	// SamplingMode is the strategy used to limit the rate at which events
+	// are stored. The ratio applies to the ratio and flow modes, and the
+	// source rate (per second) and burst to the source mode.
+	samplingMode                            string
+	samplingRatio                           float64
+	samplingSourceRate, samplingSourceBurst float64
+	samplingSummaryInterval                 time.Duration
+
+	// RollupGranularities are the rollup tables maintained, each with
+	// its own retention. The counts are flushed to the tables every
+	// rollup flush interval.
+	rollupGranularities []*rollupGranularity
+	rollupFlushInterval time.Duration
+
+	// Enrichments are the names of the enrichers which add information to
+	// events before they are stored, in order.
+	enrichments  []string
+	dnsTimeout   time.Duration
+	dnsCacheSize int
+	dnsCacheTTL  time.Duration
+	servicesFile string
+	procRoot     string
+
+	// ContainerMetadataFile maps container IDs to their workload metadata.
+	// If empty, only container IDs are stored.
+	containerMetadataFile string
+
+	// GeoIPCountryDB and GeoIPASNDB are the paths of the MaxMind-format
+	// databases used by GeoIP enrichment. Either may be empty.
+	geoIPCountryDB, geoIPASNDB string
+
+	// CompatMode adjusts the DDL and transaction handling for the database
+	// targeted.
+	compatMode *compatMode
+
+	// Hypertable configures tcp_events as a TimescaleDB hypertable.
+	// If nil, it is a plain table.
+	hypertable *hypertableConfig
+
+	// RemoteEvents is whether the events stored occurred on other hosts.
+	remoteEvents bool
+
+	// StatementMode is how SQL statements are executed.
+	// See parseStatementMode.
+	statementMode string
+
+	// Indexes is whether the indexes which speed up common queries are
+	// created.
+	indexes bool
+
+	// SchemaMode is how the schema is prepared when the sink starts.
+	// See parseSchemaMode.
+	schemaMode string
+
+	// SchemaCheck is the action taken when the schema differs from the
+	// layout expected. See parseSchemaCheck.
+	schemaCheck string
+
+	// HashChain configures the hash chain linking the stored events of each
+	// host. If nil, events are not linked.
+	hashChain *hashChainConfig
+
+	// AppendOnly configures the protection of the events tables against
+	// updates and deletes. If nil, the tables are not protected.
+	appendOnly *appendOnlyConfig
+}
+
+// Config holds the configuration of the sink. The zero value of each
+// optional field selects its default. See the README for a full
+// description of each setting.
+type Config struct {
+	// ConnString is the connection string of the PostgreSQL database in
+	// which events are stored, as a URL or as keyword/value pairs.
+	ConnString string
+
+	// Host is the name of the host recorded with each event. If empty,
+	// the hostname of the machine on which the sink runs is used.
+	Host string
+
+	// NotifyChannel is the channel on which a notification is sent for
+	// each stored event. If empty, notifications are not sent.
+	NotifyChannel string
+
+	// FilterInclude and FilterExclude are semicolon-separated lists of
+	// rules which decide which events are stored.
+	FilterInclude, FilterExclude string
+
+	// SamplingMode is one of none (the default), ratio, flow or source.
+	SamplingMode                            string
+	SamplingRatio                           float64
+	SamplingSourceRate, SamplingSourceBurst float64
+	SamplingSummaryInterval                 time.Duration
+
+	// Rollups is a comma-separated list of the rollup tables maintained,
+	// minute and/or hour. RollupRetention holds the retention of each, by
+	// name. Rollups without a retention are kept forever.
+	Rollups             string
+	RollupRetention     map[string]time.Duration
+	RollupFlushInterval time.Duration
+
+	// Enrichments is a comma-separated list of the enrichers which add
+	// information to events before they are stored, in order.
+	Enrichments           string
+	DNSTimeout            time.Duration
+	DNSCacheSize          int
+	DNSCacheTTL           time.Duration
+	ServicesFile          string
+	ProcRoot              string
+	ContainerMetadataFile string
+	GeoIPCountryDB        string
+	GeoIPASNDB            string
+
+	// CompatMode is one of postgresql (the default), cockroachdb or
+	// yugabytedb.
+	CompatMode string
+
+	// StatementMode is one of prepared (the default), describe or simple.
+	// The describe and simple modes work through connection poolers in
+	// transaction mode, such as PgBouncer.
+	StatementMode string
+
+	// RemoteEvents is whether the events stored occurred on other hosts,
+	// as when the sink is embedded in a collector. Enrichments which read
+	// the state of the local host, including connection direction, are then
+	// unavailable.
+	RemoteEvents bool
+
+	// Hypertable makes tcp_events a TimescaleDB hypertable.
+	Hypertable              bool
+	HypertableChunkInterval time.Duration
+	HypertableCompressAfter time.Duration
+	HypertableRetention     time.Duration
+
+	// Indexes creates indexes which speed up queries by time, address, port
+	// and command. Building them on large existing tables may take a long
+	// time, during which the sink does not start.
+	Indexes bool
+
+	// SchemaMode is migrate (the default), in which the tables are created
+	// and migrated when the sink starts, or verify, in which the sink only
+	// verifies that they have been, so that it may run without the
+	// privilege to create tables. Tables are created by Migrate (and the
+	// tcp-audit-migrate command) for sinks in the verify mode, which applies
+	// the hypertable, index and append-only settings in place of the sinks.
+	SchemaMode string
+
+	// SchemaCheck is the action taken when the column types, nullability,
+	// constraints or indexes of the tables differ from those expected by
+	// the sink when it starts: warn (the default) logs the differences, fail
+	// fails to start the sink, and off does not check. The check is only
+	// supported in the postgresql compatibility mode, and is off by default
+	// in the others. The hypertable and index settings should match those
+	// with which the tables were created.
+	SchemaCheck string
+
+	// HashChain links each stored event to the previous event of its host
+	// by a hash, so that events modified or deleted after they are stored
+	// are detected by the tcp-audit-verify-chain command. If
+	// HashChainKeyFile is set, a checkpoint of each chain, signed by the
+	// Ed25519 private key in the file, is stored every
+	// HashChainCheckpointInterval (by default, every minute).
+	HashChain                   bool
+	HashChainKeyFile            string
+	HashChainCheckpointInterval time.Duration
+
+	// AppendOnly installs triggers rejecting updates and deletes of the
+	// stored events and their socket information when the tables are
+	// created, and revokes the privileges to update, delete and truncate
+	// them, so that events cannot be changed once stored. Members of
+	// RetentionRole, if set, may still delete (or update) them, and it is
+	// granted the privilege to delete. AppendOnly is only supported in the
+	// postgresql compatibility mode.
+	AppendOnly    bool
+	RetentionRole string
+}
+
+// ConfigError is an error in the value of a Config field.
+type configError struct {
+	field string
+	err   error
+}
+
+func (e *configError) Error() string {
+	return fmt.Sprintf("config field %s %v", e.field, e.err)
+}
+
+func (e *configError) Unwrap() error {
+	return e.err
+}
+
+// InvalidConfigValue returns an error describing an invalid value of
+// a Config field.
+func invalidConfigValue(field string, err error) *configError {
+	return &configError{field, fmt.Errorf("has invalid value: %w", err)}
+}
+
+// NewSinkConfig validates the Config and applies its defaults.
+func newSinkConfig(c *Config) (*sinkConfig, error) {
+	// Zero selects the default, so as with the environment variables, only
+	// positive values may be set
+	if c.DNSCacheSize < 0 {
+		return nil, &configError{"DNSCacheSize", errors.New("must not be negative")}
+	}
+
+	for _, duration := range []struct {
+		field string
+		value time.Duration
+	}{
+		{"SamplingSummaryInterval", c.SamplingSummaryInterval},
+		{"RollupFlushInterval", c.RollupFlushInterval},
+		{"DNSTimeout", c.DNSTimeout},
+		{"DNSCacheTTL", c.DNSCacheTTL},
+		{"HypertableChunkInterval", c.HypertableChunkInterval},
+		{"HypertableCompressAfter", c.HypertableCompressAfter},
+		{"HypertableRetention", c.HypertableRetention},
+	} {
+		if duration.value < 0 {
+			return nil, &configError{duration.field, errors.New("must not be negative")}
+		}
+	}
+
+	config := &sinkConfig{
+		notifyChannel:           c.NotifyChannel,
+		samplingRatio:           c.SamplingRatio,
+		samplingSourceRate:      c.SamplingSourceRate,
+		samplingSourceBurst:     c.SamplingSourceBurst,
+		samplingSummaryInterval: durationOrDefault(c.SamplingSummaryInterval, defaultSamplingSummaryInterval),
+		rollupFlushInterval:     durationOrDefault(c.RollupFlushInterval, defaultRollupFlushInterval),
+		dnsTimeout:              durationOrDefault(c.DNSTimeout, defaultDNSTimeout),
+		dnsCacheSize:            c.DNSCacheSize,
+		dnsCacheTTL:             durationOrDefault(c.DNSCacheTTL, defaultDNSCacheTTL),
+		servicesFile:            c.ServicesFile,
+		procRoot:                c.ProcRoot,
+		containerMetadataFile:   c.ContainerMetadataFile,
+		geoIPCountryDB:          c.GeoIPCountryDB,
+		geoIPASNDB:              c.GeoIPASNDB,
+		remoteEvents:            c.RemoteEvents,
+		indexes:                 c.Indexes,
+	}
+
+	var err error
+	if config.compatMode, err = parseCompatMode(c.CompatMode); err != nil {
+		return nil, invalidConfigValue("CompatMode", err)
+	}
+
+	if config.statementMode, err = parseStatementMode(c.StatementMode); err != nil {
+		return nil, invalidConfigValue("StatementMode", err)
+	}
+
+	if config.schemaMode, err = parseSchemaMode(c.SchemaMode); err != nil {
+		return nil, invalidConfigValue("SchemaMode", err)
+	}
+
+	if config.schemaCheck, err = parseSchemaCheck(c.SchemaCheck, config.compatMode); err != nil {
+		return nil, invalidConfigValue("SchemaCheck", err)
+	}
+
+	if config.notifyChannel != "" && !config.compatMode.notify {
+		return nil, &configError{"NotifyChannel",
+			fmt.Errorf("is not supported in %s compatibility mode", config.compatMode.name)}
+	}
+
+	if config.includeRules, err = parseFilterRules(c.FilterInclude); err != nil {
+		return nil, invalidConfigValue("FilterInclude", err)
+	}
+
+	if config.excludeRules, err = parseFilterRules(c.FilterExclude); err != nil {
+		return nil, invalidConfigValue("FilterExclude", err)
+	}
+
+	if config.samplingMode, err = parseSamplingMode(c.SamplingMode); err != nil {
+		return nil, invalidConfigValue("SamplingMode", err)
+	}
+
+	switch config.samplingMode {
+	case samplingModeRatio, samplingModeFlow:
+		if config.samplingRatio <= 0 || config.samplingRatio > 1 {
+			return nil, &configError{"SamplingRatio", errors.New("must be greater than 0 and at most 1")}
+		}
+	case samplingModeSource:
+		if config.samplingSourceRate <= 0 {
+			return nil, &configError{"SamplingSourceRate", errors.New("must be greater than 0")}
+		}
+
+		if config.samplingSourceBurst == 0 {
+			config.samplingSourceBurst = math.Max(1, config.samplingSourceRate)
+		}
+
+		if config.samplingSourceBurst < 1 {
+			return nil, &configError{"SamplingSourceBurst", errors.New("must be at least 1")}
+		}
+	}
+
+	if config.rollupGranularities, err = parseRollupGranularities(c.Rollups); err != nil {
+		return nil, invalidConfigValue("Rollups", err)
+	}
+
+	for name, retention := range c.RollupRetention {
+		if _, ok := rollupGranularities[name]; !ok {
+			return nil, invalidConfigValue("RollupRetention", fmt.Errorf("unknown rollup granularity %q", name))
+		}
+
+		if retention < 0 {
+			return nil, invalidConfigValue("RollupRetention", fmt.Errorf("negative retention for %q", name))
+		}
+	}
+
+	for _, granularity := range config.rollupGranularities {
+		granularity.retention = c.RollupRetention[granularity.name]
+	}
+
+	if config.enrichments, err = parseEnrichments(c.Enrichments); err != nil {
+		return nil, invalidConfigValue("Enrichments", err)
+	}
+
+	if config.remoteEvents {
+		for _, enrichment := range config.enrichments {
+			if enrichment == enrichmentProcess || enrichment == enrichmentContainer {
+				return nil, &configError{"Enrichments",
+					fmt.Errorf("includes %s, which is not supported for remote events", enrichment)}
+			}
+		}
+	}
+
+	if config.dnsCacheSize == 0 {
+		config.dnsCacheSize = defaultDNSCacheSize
+	}
+
+	if config.servicesFile == "" {
+		config.servicesFile = defaultServicesFile
+	}
+
+	if config.procRoot == "" {
+		config.procRoot = defaultProcRoot
+	}
+
+	if c.Hypertable {
+		if !config.compatMode.doBlocks {
+			return nil, &configError{"Hypertable",
+				fmt.Errorf("is not supported in %s compatibility mode", config.compatMode.name)}
+		}
+
+		config.hypertable = &hypertableConfig{
+			chunkInterval: durationOrDefault(c.HypertableChunkInterval, defaultHypertableChunkInterval),
+			compressAfter: c.HypertableCompressAfter,
+			retention:     c.HypertableRetention,
+		}
+	}
+
+	if c.HashChain {
+		if c.HashChainCheckpointInterval < 0 {
+			return nil, &configError{"HashChainCheckpointInterval", errors.New("must not be negative")}
+		}
+
+		config.hashChain = &hashChainConfig{
+			keyFile:            c.HashChainKeyFile,
+			checkpointInterval: durationOrDefault(c.HashChainCheckpointInterval, defaultChainCheckpointInterval),
+		}
+	} else if c.HashChainKeyFile != "" {
+		return nil, &configError{"HashChainKeyFile", errors.New("is set, but HashChain is not enabled")}
+	}
+
+	if c.AppendOnly {
+		if !config.compatMode.triggers {
+			return nil, &configError{"AppendOnly",
+				fmt.Errorf("is not supported in %s compatibility mode", config.compatMode.name)}
+		}
+
+		config.appendOnly = &appendOnlyConfig{retentionRole: c.RetentionRole}
+	} else if c.RetentionRole != "" {
+		return nil, &configError{"RetentionRole", errors.New("is set, but AppendOnly is not enabled")}
+	}
+
+	return config, nil
+}
+
+// DurationOrDefault returns the duration, or the default if it is zero.
+func durationOrDefault(d, defaultValue time.Duration) time.Duration {
+	if d == 0 {
+		return defaultValue
+	}
+
+	return d
+}
+
+// ConfigEnvVars maps the Config fields to the environment variables from
+// which they are read.
+var configEnvVars = map[string]string{
+	"NotifyChannel":               notifyChannelEnvVar,
+	"FilterInclude":               filterIncludeEnvVar,
+	"FilterExclude":               filterExcludeEnvVar,
+	"SamplingMode":                samplingModeEnvVar,
+	"SamplingRatio":               samplingRatioEnvVar,
+	"SamplingSourceRate":          samplingSourceRateEnvVar,
+	"SamplingSourceBurst":         samplingSourceBurstEnvVar,
+	"Rollups":                     rollupsEnvVar,
+	"Enrichments":                 enrichmentsEnvVar,
+	"CompatMode":                  compatModeEnvVar,
+	"StatementMode":               statementModeEnvVar,
+	"Hypertable":                  hypertableEnvVar,
+	"Indexes":                     indexesEnvVar,
+	"SchemaMode":                  schemaModeEnvVar,
+	"SchemaCheck":                 schemaCheckEnvVar,
+	"HashChain":                   hashChainEnvVar,
+	"HashChainKeyFile":            hashChainKeyFileEnvVar,
+	"HashChainCheckpointInterval": hashChainCheckpointEnvVar,
+	"AppendOnly":                  appendOnlyEnvVar,
+	"RetentionRole":               retentionRoleEnvVar,
+}
+
+// ConfigFromEnv returns the configuration of the sink from the
+// TCP_AUDIT_PGSQL_ environment variables. The connection string is not
+// read, as it is provided by the standard PostgreSQL client environment
+// variables (see the pgconfig package).
+func ConfigFromEnv() (*Config, error) {
+	config := &Config{
+		NotifyChannel:         os.Getenv(notifyChannelEnvVar),
+		FilterInclude:         os.Getenv(filterIncludeEnvVar),
+		FilterExclude:         os.Getenv(filterExcludeEnvVar),
+		SamplingMode:          os.Getenv(samplingModeEnvVar),
+		Rollups:               os.Getenv(rollupsEnvVar),
+		Enrichments:           os.Getenv(enrichmentsEnvVar),
+		ServicesFile:          os.Getenv(servicesFileEnvVar),
+		ProcRoot:              os.Getenv(procRootEnvVar),
+		ContainerMetadataFile: os.Getenv(containerMetadataFileEnvVar),
+		GeoIPCountryDB:        os.Getenv(geoIPCountryDBEnvVar),
+		GeoIPASNDB:            os.Getenv(geoIPASNDBEnvVar),
+		CompatMode:            os.Getenv(compatModeEnvVar),
+		StatementMode:         os.Getenv(statementModeEnvVar),
+		SchemaMode:            os.Getenv(schemaModeEnvVar),
+		SchemaCheck:           os.Getenv(schemaCheckEnvVar),
+		HashChainKeyFile:      os.Getenv(hashChainKeyFileEnvVar),
+		RetentionRole:         os.Getenv(retentionRoleEnvVar),
+	}
+
+	var err error
+	if config.SamplingRatio, err = getFloatEnvVar(samplingRatioEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	if config.SamplingSourceRate, err = getFloatEnvVar(samplingSourceRateEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	if config.SamplingSourceBurst, err = getFloatEnvVar(samplingSourceBurstEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	if config.SamplingSummaryInterval, err = getDurationEnvVar(samplingSummaryIntervalEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	config.RollupRetention = make(map[string]time.Duration)
+	for name := range rollupGranularities {
+		retentionEnvVar := fmt.Sprintf(rollupRetentionEnvVarFormat, strings.ToUpper(name))
+		if config.RollupRetention[name], err = getDurationEnvVar(retentionEnvVar, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.RollupFlushInterval, err = getDurationEnvVar(rollupFlushIntervalEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	if config.DNSTimeout, err = getDurationEnvVar(dnsTimeoutEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	if config.DNSCacheSize, err = getIntEnvVar(dnsCacheSizeEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	if config.DNSCacheTTL, err = getDurationEnvVar(dnsCacheTTLEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	if config.Hypertable, err = getBoolEnvVar(hypertableEnvVar); err != nil {
+		return nil, err
+	}
+
+	if config.HypertableChunkInterval, err = getDurationEnvVar(hypertableChunkIntervalEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	if config.HypertableCompressAfter, err = getDurationEnvVar(hypertableCompressAfterEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	if config.HypertableRetention, err = getDurationEnvVar(hypertableRetentionEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	if config.Indexes, err = getBoolEnvVar(indexesEnvVar); err != nil {
+		return nil, err
+	}
+
+	if config.HashChain, err = getBoolEnvVar(hashChainEnvVar); err != nil {
+		return nil, err
+	}
+
+	if config.HashChainCheckpointInterval, err = getDurationEnvVar(hashChainCheckpointEnvVar, 0); err != nil {
+		return nil, err
+	}
+
+	if config.AppendOnly, err = getBoolEnvVar(appendOnlyEnvVar); err != nil {
+		return nil, err
+	}
+
+	// Validate now, so that errors name the environment variable rather
+	// than the Config field
+	if _, err := newSinkConfig(config); err != nil {
+		var configErr *configError
+		if errors.As(err, &configErr) {
+			if envVar, ok := configEnvVars[configErr.field]; ok {
+				return nil, fmt.Errorf("environment variable %s %w", envVar, configErr.err)
+			}
+		}
+
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// GetFloatEnvVar returns the value of the environment variable parsed as
+// a float, or the default if it is not set.
+func getFloatEnvVar(name string, defaultValue float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("environment variable %s has invalid value", name)
+	}
+
+	return f, nil
+}
+
+// GetBoolEnvVar returns the value of the environment variable parsed as
+// a boolean, or false if it is not set.
+func getBoolEnvVar(name string) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("environment variable %s has invalid value", name)
+	}
+
+	return b, nil
+}
+
+// GetIntEnvVar returns the value of the environment variable parsed as
+// a positive integer, or the default if it is not set.
+func getIntEnvVar(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil || i <= 0 {
+		return 0, fmt.Errorf("environment variable %s has invalid value", name)
+	}
+
+	return i, nil
+}
+
+// GetDurationEnvVar returns the value of the environment variable parsed as
+// a positive duration, or the default if it is not set.
+func getDurationEnvVar(name string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("environment variable %s has invalid value", name)
+	}
+
+	return d, nil
+}
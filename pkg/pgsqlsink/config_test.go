@@ -0,0 +1,520 @@
+package pgsqlsink
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// SinkConfigFromEnv returns the validated configuration of the sink read
+// from the environment.
+func sinkConfigFromEnv() (*sinkConfig, error) {
+	config, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return newSinkConfig(config)
+}
+
+func TestGetSinkConfigFromEnv(t *testing.T) {
+	defer os.Unsetenv(notifyChannelEnvVar)
+	if err := os.Setenv(notifyChannelEnvVar, "mock-channel"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	config, err := sinkConfigFromEnv()
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if config.notifyChannel != "mock-channel" {
+		t.Errorf("expected notify channel %q, got %q", "mock-channel", config.notifyChannel)
+	}
+}
+
+func TestGetSinkConfigDefaultsFromEnv(t *testing.T) {
+	config, err := sinkConfigFromEnv()
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if config.notifyChannel != "" {
+		t.Errorf("expected empty notify channel, got %q", config.notifyChannel)
+	}
+}
+
+func TestGetSinkConfigFilterRulesFromEnv(t *testing.T) {
+	defer os.Unsetenv(filterIncludeEnvVar)
+	defer os.Unsetenv(filterExcludeEnvVar)
+	if err := os.Setenv(filterIncludeEnvVar, "dst-port=443"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	if err := os.Setenv(filterExcludeEnvVar, "net=127.0.0.0/8; comm=kworker/*"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	config, err := sinkConfigFromEnv()
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if len(config.includeRules) != 1 {
+		t.Errorf("expected %d include rules, got %d", 1, len(config.includeRules))
+	}
+
+	if len(config.excludeRules) != 2 {
+		t.Errorf("expected %d exclude rules, got %d", 2, len(config.excludeRules))
+	}
+}
+
+func TestGetSinkConfigErrorBadFilterRuleFromEnv(t *testing.T) {
+	defer os.Unsetenv(filterExcludeEnvVar)
+	if err := os.Setenv(filterExcludeEnvVar, "bogus=1"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	_, err := sinkConfigFromEnv()
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !strings.Contains(err.Error(), filterExcludeEnvVar) {
+		t.Errorf("expected error to contain env var name %q, but did not", filterExcludeEnvVar)
+	}
+}
+
+func TestGetSinkConfigSamplingFromEnv(t *testing.T) {
+	defer os.Unsetenv(samplingModeEnvVar)
+	defer os.Unsetenv(samplingSourceRateEnvVar)
+	defer os.Unsetenv(samplingSummaryIntervalEnvVar)
+	if err := os.Setenv(samplingModeEnvVar, samplingModeSource); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	if err := os.Setenv(samplingSourceRateEnvVar, "5"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	if err := os.Setenv(samplingSummaryIntervalEnvVar, "30s"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	config, err := sinkConfigFromEnv()
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if config.samplingMode != samplingModeSource {
+		t.Errorf("expected sampling mode %q, got %q", samplingModeSource, config.samplingMode)
+	}
+
+	if config.samplingSourceRate != 5 {
+		t.Errorf("expected sampling source rate %v, got %v", 5, config.samplingSourceRate)
+	}
+
+	if config.samplingSourceBurst != 5 {
+		t.Errorf("expected default sampling source burst %v, got %v", 5, config.samplingSourceBurst)
+	}
+
+	if config.samplingSummaryInterval != 30*time.Second {
+		t.Errorf("expected sampling summary interval %v, got %v", 30*time.Second, config.samplingSummaryInterval)
+	}
+}
+
+func TestGetSinkConfigErrorBadSamplingRatioFromEnv(t *testing.T) {
+	defer os.Unsetenv(samplingModeEnvVar)
+	defer os.Unsetenv(samplingRatioEnvVar)
+	if err := os.Setenv(samplingModeEnvVar, samplingModeRatio); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	if err := os.Setenv(samplingRatioEnvVar, "1.5"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	_, err := sinkConfigFromEnv()
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !strings.Contains(err.Error(), samplingRatioEnvVar) {
+		t.Errorf("expected error to contain env var name %q, but did not", samplingRatioEnvVar)
+	}
+}
+
+func TestGetSinkConfigRollupsFromEnv(t *testing.T) {
+	defer os.Unsetenv(rollupsEnvVar)
+	defer os.Unsetenv("TCP_AUDIT_PGSQL_ROLLUP_MINUTE_RETENTION")
+	if err := os.Setenv(rollupsEnvVar, "minute,hour"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	if err := os.Setenv("TCP_AUDIT_PGSQL_ROLLUP_MINUTE_RETENTION", "168h"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	config, err := sinkConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if len(config.rollupGranularities) != 2 {
+		t.Fatalf("expected %d rollup granularities, got %d", 2, len(config.rollupGranularities))
+	}
+
+	if config.rollupGranularities[0].retention != 168*time.Hour {
+		t.Errorf("expected minute rollup retention %v, got %v", 168*time.Hour, config.rollupGranularities[0].retention)
+	}
+
+	if config.rollupGranularities[1].retention != 0 {
+		t.Errorf("expected hour rollup retention to be unset, got %v", config.rollupGranularities[1].retention)
+	}
+
+	if config.rollupFlushInterval != defaultRollupFlushInterval {
+		t.Errorf("expected rollup flush interval %v, got %v", defaultRollupFlushInterval, config.rollupFlushInterval)
+	}
+}
+
+func TestGetSinkConfigEnrichmentsFromEnv(t *testing.T) {
+	defer os.Unsetenv(enrichmentsEnvVar)
+	defer os.Unsetenv(dnsCacheSizeEnvVar)
+	if err := os.Setenv(enrichmentsEnvVar, "dns, services"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	if err := os.Setenv(dnsCacheSizeEnvVar, "100"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	config, err := sinkConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if len(config.enrichments) != 2 || config.enrichments[0] != enrichmentDNS || config.enrichments[1] != enrichmentServices {
+		t.Errorf("expected enrichments %q, got %q", []string{enrichmentDNS, enrichmentServices}, config.enrichments)
+	}
+
+	if config.dnsCacheSize != 100 {
+		t.Errorf("expected DNS cache size %d, got %d", 100, config.dnsCacheSize)
+	}
+
+	if config.servicesFile != defaultServicesFile {
+		t.Errorf("expected services file %q, got %q", defaultServicesFile, config.servicesFile)
+	}
+}
+
+func TestGetSinkConfigCompatModeFromEnv(t *testing.T) {
+	defer os.Unsetenv(compatModeEnvVar)
+	if err := os.Setenv(compatModeEnvVar, compatModeCockroachDB); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	config, err := sinkConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if config.compatMode.name != compatModeCockroachDB {
+		t.Errorf("expected compatibility mode %q, got %q", compatModeCockroachDB, config.compatMode.name)
+	}
+
+	if config.compatMode.serializationRetries == 0 {
+		t.Error("expected serialization failures to be retried, but were not")
+	}
+}
+
+func TestGetSinkConfigErrorNotifyUnsupportedByCompatMode(t *testing.T) {
+	defer os.Unsetenv(compatModeEnvVar)
+	defer os.Unsetenv(notifyChannelEnvVar)
+	if err := os.Setenv(compatModeEnvVar, compatModeYugabyteDB); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	if err := os.Setenv(notifyChannelEnvVar, "mock-channel"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	_, err := sinkConfigFromEnv()
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !strings.Contains(err.Error(), notifyChannelEnvVar) {
+		t.Errorf("expected error to contain env var name %q, but did not", notifyChannelEnvVar)
+	}
+}
+
+func TestGetSinkConfigHypertableFromEnv(t *testing.T) {
+	defer os.Unsetenv(hypertableEnvVar)
+	defer os.Unsetenv(hypertableRetentionEnvVar)
+	if err := os.Setenv(hypertableEnvVar, "true"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	if err := os.Setenv(hypertableRetentionEnvVar, "720h"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	config, err := sinkConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if config.hypertable == nil {
+		t.Fatal("expected hypertable config, got nil")
+	}
+
+	if config.hypertable.chunkInterval != defaultHypertableChunkInterval {
+		t.Errorf("expected chunk interval %v, got %v", defaultHypertableChunkInterval, config.hypertable.chunkInterval)
+	}
+
+	if config.hypertable.compressAfter != 0 {
+		t.Errorf("expected compression to be disabled, got %v", config.hypertable.compressAfter)
+	}
+
+	if config.hypertable.retention != 720*time.Hour {
+		t.Errorf("expected retention %v, got %v", 720*time.Hour, config.hypertable.retention)
+	}
+}
+
+func TestGetSinkConfigIndexesFromEnv(t *testing.T) {
+	defer os.Unsetenv(indexesEnvVar)
+	if err := os.Setenv(indexesEnvVar, "true"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	config, err := sinkConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !config.indexes {
+		t.Error("expected indexes to be enabled, but were not")
+	}
+}
+
+func TestGetSinkConfigSchemaModeFromEnv(t *testing.T) {
+	defer os.Unsetenv(schemaModeEnvVar)
+	if err := os.Setenv(schemaModeEnvVar, schemaModeVerify); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	config, err := sinkConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if config.schemaMode != schemaModeVerify {
+		t.Errorf("expected schema mode %q, got %q", schemaModeVerify, config.schemaMode)
+	}
+}
+
+func TestGetSinkConfigSchemaCheckFromEnv(t *testing.T) {
+	defer os.Unsetenv(schemaCheckEnvVar)
+	if err := os.Setenv(schemaCheckEnvVar, schemaCheckFail); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	config, err := sinkConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if config.schemaCheck != schemaCheckFail {
+		t.Errorf("expected schema check %q, got %q", schemaCheckFail, config.schemaCheck)
+	}
+}
+
+func TestGetSinkConfigHashChainFromEnv(t *testing.T) {
+	defer os.Unsetenv(hashChainEnvVar)
+	defer os.Unsetenv(hashChainKeyFileEnvVar)
+	defer os.Unsetenv(hashChainCheckpointEnvVar)
+	for envVar, value := range map[string]string{
+		hashChainEnvVar:           "true",
+		hashChainKeyFileEnvVar:    "/mock/chain-key.pem",
+		hashChainCheckpointEnvVar: "5m",
+	} {
+		if err := os.Setenv(envVar, value); err != nil {
+			t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+		}
+	}
+
+	config, err := sinkConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if config.hashChain == nil {
+		t.Fatal("expected hash chain to be enabled, but was not")
+	}
+
+	if config.hashChain.keyFile != "/mock/chain-key.pem" {
+		t.Errorf("expected key file %q, got %q", "/mock/chain-key.pem", config.hashChain.keyFile)
+	}
+
+	if config.hashChain.checkpointInterval != 5*time.Minute {
+		t.Errorf("expected checkpoint interval %v, got %v", 5*time.Minute, config.hashChain.checkpointInterval)
+	}
+}
+
+func TestNewSinkConfigErrorHashChainKeyFileWithoutHashChain(t *testing.T) {
+	_, err := newSinkConfig(&Config{HashChainKeyFile: "/mock/chain-key.pem"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !strings.Contains(err.Error(), "HashChainKeyFile") {
+		t.Errorf("expected error to contain field name %q, but did not", "HashChainKeyFile")
+	}
+}
+
+func TestGetSinkConfigAppendOnlyFromEnv(t *testing.T) {
+	defer os.Unsetenv(appendOnlyEnvVar)
+	defer os.Unsetenv(retentionRoleEnvVar)
+	if err := os.Setenv(appendOnlyEnvVar, "true"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	if err := os.Setenv(retentionRoleEnvVar, "mock-retention"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	config, err := sinkConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if config.appendOnly == nil {
+		t.Fatal("expected append-only protection to be enabled, but was not")
+	}
+
+	if config.appendOnly.retentionRole != "mock-retention" {
+		t.Errorf("expected retention role %q, got %q", "mock-retention", config.appendOnly.retentionRole)
+	}
+}
+
+func TestNewSinkConfigErrorRetentionRoleWithoutAppendOnly(t *testing.T) {
+	_, err := newSinkConfig(&Config{RetentionRole: "mock-retention"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !strings.Contains(err.Error(), "RetentionRole") {
+		t.Errorf("expected error to contain field name %q, but did not", "RetentionRole")
+	}
+}
+
+func TestNewSinkConfigErrorAppendOnlyNotSupported(t *testing.T) {
+	_, err := newSinkConfig(&Config{AppendOnly: true, CompatMode: compatModeCockroachDB})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !strings.Contains(err.Error(), "AppendOnly") {
+		t.Errorf("expected error to contain field name %q, but did not", "AppendOnly")
+	}
+}
+
+func TestNewSinkConfigErrorNamesField(t *testing.T) {
+	_, err := newSinkConfig(&Config{SamplingMode: samplingModeRatio, SamplingRatio: 2})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !strings.Contains(err.Error(), "SamplingRatio") {
+		t.Errorf("expected error to contain field name %q, but did not", "SamplingRatio")
+	}
+}
+
+func TestNewSinkConfigErrorNegative(t *testing.T) {
+	for field, config := range map[string]*Config{
+		"DNSCacheSize":            {DNSCacheSize: -1},
+		"DNSTimeout":              {DNSTimeout: -time.Second},
+		"DNSCacheTTL":             {DNSCacheTTL: -time.Hour},
+		"RollupFlushInterval":     {RollupFlushInterval: -time.Second},
+		"SamplingSummaryInterval": {SamplingSummaryInterval: -time.Minute},
+		"HypertableRetention":     {Hypertable: true, HypertableRetention: -time.Hour},
+	} {
+		_, err := newSinkConfig(config)
+		if err == nil {
+			t.Errorf("expected error for negative %s, got nil", field)
+			continue
+		}
+
+		t.Logf("got error %q (of type %T)", err, err)
+
+		var configErr *configError
+		if !errors.As(err, &configErr) || configErr.field != field {
+			t.Errorf("expected config error for field %q, got %q", field, err)
+		}
+	}
+}
+
+func TestNewSinkConfigDefaults(t *testing.T) {
+	config, err := newSinkConfig(new(Config))
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if config.compatMode.name != compatModePostgreSQL {
+		t.Errorf("expected compatibility mode %q, got %q", compatModePostgreSQL, config.compatMode.name)
+	}
+
+	if config.dnsCacheSize != defaultDNSCacheSize {
+		t.Errorf("expected DNS cache size %d, got %d", defaultDNSCacheSize, config.dnsCacheSize)
+	}
+
+	if config.rollupFlushInterval != defaultRollupFlushInterval {
+		t.Errorf("expected rollup flush interval %v, got %v", defaultRollupFlushInterval, config.rollupFlushInterval)
+	}
+}
+
+func TestNewSinkConfigErrorLocalEnrichmentOfRemoteEvents(t *testing.T) {
+	_, err := newSinkConfig(&Config{RemoteEvents: true, Enrichments: "dns,process"})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !strings.Contains(err.Error(), enrichmentProcess) {
+		t.Errorf("expected error to contain enrichment name %q, but did not", enrichmentProcess)
+	}
+}
+
+func TestGetSinkConfigErrorBadStatementModeFromEnv(t *testing.T) {
+	defer os.Unsetenv(statementModeEnvVar)
+	if err := os.Setenv(statementModeEnvVar, "bogus"); err != nil {
+		t.Fatalf("test bootstrapping: unable to set environment: %v", err)
+	}
+
+	_, err := sinkConfigFromEnv()
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !strings.Contains(err.Error(), statementModeEnvVar) {
+		t.Errorf("expected error to contain env var name %q, but did not", statementModeEnvVar)
+	}
+}
@@ -0,0 +1,77 @@
+package pgsqlsink
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrDuplicateObject is matched by the errors returned by a backend when
+	// creating an object, such as a type, which already exists.
+	errDuplicateObject = errors.New("object already exists")
+
+	// ErrDuplicateTable is matched by the errors returned by a backend when
+	// creating a table which already exists.
+	errDuplicateTable = errors.New("table already exists")
+
+	// ErrUndefinedObject is matched by the errors returned by a backend when
+	// an object referenced does not exist.
+	errUndefinedObject = errors.New("object does not exist")
+
+	// ErrFeatureNotSupported is matched by the errors returned by a backend
+	// when a statement is valid but not supported, such as building an index
+	// concurrently on a TimescaleDB hypertable.
+	errFeatureNotSupported = errors.New("feature not supported")
+
+	// ErrSerializationFailure is matched by the errors returned by a backend
+	// when a transaction conflicts with a concurrent transaction, and may
+	// succeed if retried.
+	errSerializationFailure = errors.New("serialization failure")
+)
+
+// Conn is an interface which describes a connection to a SQL database,
+// independent of the driver used to access it.
+// The SQL passed to exec may be the name of a statement previously prepared
+// on the connection, in place of the statement itself.
+type conn interface {
+	exec(ctx context.Context, sql string, arguments ...interface{}) error
+	begin(ctx context.Context) (transaction, error)
+	prepare(ctx context.Context, name, sql string) error
+	close(ctx context.Context) error
+
+	// Query returns the values of each row returned by the SQL. It is
+	// intended for small results, such as those of the system catalogs.
+	query(ctx context.Context, sql string, arguments ...interface{}) ([][]interface{}, error)
+
+	// Describe returns a description of the database connected to, for
+	// logging.
+	describe() string
+}
+
+// Transaction is an interface which describes a transaction on a connection to
+// a SQL database, independent of the driver used to access it.
+type transaction interface {
+	exec(ctx context.Context, sql string, arguments ...interface{}) error
+	commit(ctx context.Context) error
+	rollback(ctx context.Context) error
+}
+
+// BackendError is an error returned by a database driver, classified as one
+// of the backend-neutral errors above, so that callers can handle it without
+// knowledge of the driver. The driver's error remains in the error chain.
+type backendError struct {
+	kind error
+	err  error
+}
+
+func (e *backendError) Error() string {
+	return e.err.Error()
+}
+
+func (e *backendError) Unwrap() error {
+	return e.err
+}
+
+func (e *backendError) Is(target error) bool {
+	return target == e.kind
+}
@@ -0,0 +1,54 @@
+package pgsqlsink
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgconn/stmtcache"
+	"github.com/jackc/pgx/v4"
+)
+
+const statementCacheCapacity = 512
+
+// PGXConnector creates a connection to a PostgreSQL database using the
+// PGX library.
+type pgxConnector struct {
+	connString    string
+	statementMode string
+}
+
+func newPGXConnector(connString, statementMode string) *pgxConnector {
+	return &pgxConnector{connString, statementMode}
+}
+
+// Connect creates a connection to the PostgreSQL database described by the
+// connection string supplied in the constructor.
+func (c *pgxConnector) connect(ctx context.Context) (conn, error) {
+	if c.connString == "" {
+		return nil, fmt.Errorf("no connection string configured")
+	}
+
+	config, err := pgx.ParseConfig(c.connString)
+	if err != nil {
+		return nil, fmt.Errorf("parsing connection string: %w", err)
+	}
+
+	// By default, PGX also prepares named statements for any SQL executed
+	switch c.statementMode {
+	case statementModeDescribe:
+		config.BuildStatementCache = func(conn *pgconn.PgConn) stmtcache.Cache {
+			return stmtcache.New(conn, stmtcache.ModeDescribe, statementCacheCapacity)
+		}
+	case statementModeSimple:
+		config.BuildStatementCache = nil
+		config.PreferSimpleProtocol = true
+	}
+
+	pgxConn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("establishing connection to database: %w", err)
+	}
+
+	return newPGXConn(pgxConn), nil
+}
@@ -0,0 +1,155 @@
+package pgsqlsink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	enrichmentContainer = "container"
+
+	// How often the metadata file is checked for changes
+	metadataFileReloadInterval = 10 * time.Second
+)
+
+// ContainerMetadata describes the Kubernetes workload to which a container
+// belongs.
+type containerMetadata struct {
+	PodNamespace  string `json:"namespace"`
+	PodName       string `json:"pod"`
+	ContainerName string `json:"container"`
+}
+
+// MetadataResolver is an interface which describes objects which resolve
+// a container ID to the metadata of the workload to which it belongs.
+// A nil metadata and nil error is returned if the container is unknown.
+type metadataResolver interface {
+	resolve(ctx context.Context, containerID string) (*containerMetadata, error)
+}
+
+// FileMetadataResolver resolves container metadata from a JSON file mapping
+// container IDs to their metadata, for example:
+//
+//	{"<container ID>": {"namespace": "default", "pod": "web-0", "container": "nginx"}}
+//
+// The file is maintained outside of the sink, e.g. by a node agent, and is
+// reloaded when it changes.
+type fileMetadataResolver struct {
+	path string
+	now  func() time.Time
+
+	metadata    map[string]*containerMetadata
+	modTime     time.Time
+	lastChecked time.Time
+}
+
+func newFileMetadataResolver(path string, now func() time.Time) (*fileMetadataResolver, error) {
+	r := &fileMetadataResolver{path: path, now: now}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *fileMetadataResolver) resolve(ctx context.Context, containerID string) (*containerMetadata, error) {
+	if now := r.now(); now.Sub(r.lastChecked) >= metadataFileReloadInterval {
+		r.lastChecked = now
+
+		if err := r.reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	return r.metadata[containerID], nil
+}
+
+// Reload reads the metadata file if it has been modified since it was
+// last read. If it cannot be read, the previous metadata is kept.
+func (r *fileMetadataResolver) reload() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return fmt.Errorf("getting metadata file info: %w", err)
+	}
+
+	if r.metadata != nil && info.ModTime().Equal(r.modTime) {
+		return nil
+	}
+
+	contents, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("reading metadata file: %w", err)
+	}
+
+	var metadata map[string]*containerMetadata
+	if err := json.Unmarshal(contents, &metadata); err != nil {
+		return fmt.Errorf("parsing metadata file: %w", err)
+	}
+
+	r.metadata = metadata
+	r.modTime = info.ModTime()
+	return nil
+}
+
+// ContainerEnricher adds the ID of the container in which the on-CPU process
+// is running to events, and the metadata of its workload if a resolver is set.
+type containerEnricher struct {
+	procRoot string
+	resolver metadataResolver
+}
+
+func newContainerEnricher(procRoot string, resolver metadataResolver) *containerEnricher {
+	return &containerEnricher{
+		procRoot: procRoot,
+		resolver: resolver,
+	}
+}
+
+func (e *containerEnricher) enrich(ctx context.Context, event *tcpEvent, socketInfo *socketInfo) {
+	event.containerID = e.containerID(event)
+	if event.containerID == nil || e.resolver == nil {
+		return
+	}
+
+	metadata, err := e.resolver.resolve(ctx, *event.containerID)
+	if err != nil || metadata == nil {
+		return
+	}
+
+	event.podNamespace = &metadata.PodNamespace
+	event.podName = &metadata.PodName
+	event.containerName = &metadata.ContainerName
+}
+
+func (e *containerEnricher) close() error {
+	return nil
+}
+
+// ContainerID returns the container ID of the on-CPU process, reusing that
+// found by the process enricher if it has run.
+func (e *containerEnricher) containerID(event *tcpEvent) *string {
+	if event.processInfo != nil && event.processInfo.containerID != nil {
+		return event.processInfo.containerID
+	}
+
+	if event.pid <= 0 {
+		return nil
+	}
+
+	cgroup, err := readProcessCgroup(filepath.Join(e.procRoot, strconv.Itoa(event.pid), "cgroup"))
+	if err != nil {
+		return nil
+	}
+
+	if containerID := containerIDRegexp.FindString(cgroup); containerID != "" {
+		return &containerID
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package pgsqlsink
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeMetadataFile(t *testing.T, path, contents string, modTime time.Time) {
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("test bootstrapping: unable to write metadata file: %v", err)
+	}
+
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("test bootstrapping: unable to set metadata file time: %v", err)
+	}
+}
+
+func TestFileMetadataResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.json")
+	modTime := time.Now().Add(-time.Hour)
+	writeMetadataFile(t,
+		path,
+		`{"`+mockContainerID+`": {"namespace": "default", "pod": "web-0", "container": "nginx"}}`,
+		modTime)
+
+	now := time.Now()
+	resolver, err := newFileMetadataResolver(path, func() time.Time { return now })
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	metadata, err := resolver.resolve(context.TODO(), mockContainerID)
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if metadata == nil || metadata.PodNamespace != "default" || metadata.PodName != "web-0" || metadata.ContainerName != "nginx" {
+		t.Errorf("expected metadata of web-0, got %+v", metadata)
+	}
+
+	if metadata, _ := resolver.resolve(context.TODO(), "unknown"); metadata != nil {
+		t.Errorf("expected nil metadata for unknown container, got %+v", metadata)
+	}
+
+	// The file is reloaded when it changes, once the reload interval has elapsed
+	writeMetadataFile(t,
+		path,
+		`{"`+mockContainerID+`": {"namespace": "default", "pod": "web-1", "container": "nginx"}}`,
+		modTime.Add(time.Minute))
+
+	if metadata, _ := resolver.resolve(context.TODO(), mockContainerID); metadata.PodName != "web-0" {
+		t.Errorf("expected metadata not to be reloaded before reload interval, got %+v", metadata)
+	}
+
+	now = now.Add(metadataFileReloadInterval)
+	if metadata, _ := resolver.resolve(context.TODO(), mockContainerID); metadata.PodName != "web-1" {
+		t.Errorf("expected metadata to be reloaded after reload interval, got %+v", metadata)
+	}
+}
+
+func TestFileMetadataResolverBadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.json")
+	writeMetadataFile(t, path, "not JSON", time.Now())
+
+	if _, err := newFileMetadataResolver(path, time.Now); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+type mockMetadataResolver struct {
+	metadata map[string]*containerMetadata
+}
+
+func (r *mockMetadataResolver) resolve(ctx context.Context, containerID string) (*containerMetadata, error) {
+	return r.metadata[containerID], nil
+}
+
+func TestContainerEnricher(t *testing.T) {
+	procRoot := newMockProc(t, "7337")
+	resolver := &mockMetadataResolver{map[string]*containerMetadata{
+		mockContainerID: {PodNamespace: "default", PodName: "web-0", ContainerName: "nginx"},
+	}}
+	enricher := newContainerEnricher(procRoot, resolver)
+
+	event := &tcpEvent{pid: 7337}
+	enricher.enrich(context.TODO(), event, nil)
+
+	if event.containerID == nil || *event.containerID != mockContainerID {
+		t.Errorf("expected container ID %q, got %v", mockContainerID, event.containerID)
+	}
+
+	if event.podName == nil || *event.podName != "web-0" {
+		t.Errorf("expected pod name %q, got %v", "web-0", event.podName)
+	}
+}
+
+func TestContainerEnricherUnresolved(t *testing.T) {
+	procRoot := newMockProc(t, "7337")
+	enricher := newContainerEnricher(procRoot, nil)
+
+	event := &tcpEvent{pid: 7337}
+	enricher.enrich(context.TODO(), event, nil)
+
+	if event.containerID == nil || *event.containerID != mockContainerID {
+		t.Errorf("expected container ID %q, got %v", mockContainerID, event.containerID)
+	}
+
+	if event.podNamespace != nil || event.podName != nil || event.containerName != nil {
+		t.Error("expected no workload metadata without a resolver, but was set")
+	}
+
+	// Processes outside of containers have no container ID
+	event = &tcpEvent{pid: 4242}
+	enricher.enrich(context.TODO(), event, nil)
+	if event.containerID != nil {
+		t.Errorf("expected nil container ID, got %q", *event.containerID)
+	}
+}
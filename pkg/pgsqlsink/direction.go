@@ -0,0 +1,247 @@
+package pgsqlsink
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+const (
+	// How often the host's addresses and listening ports are re-read
+	localEndpointsRefreshInterval = 10 * time.Second
+
+	// The state of a listening socket in /proc/net/tcp
+	procNetTCPListenState = "0A"
+)
+
+// Endpoints are the local and remote endpoints of a connection, and its
+// direction relative to the host.
+type endpoints struct {
+	direction             string
+	localIP, remoteIP     net.IP
+	localPort, remotePort uint16
+}
+
+// LocalEndpoints is an interface which describes objects which know the
+// addresses and listening ports of the host.
+type localEndpoints interface {
+	isLocalIP(ip net.IP) bool
+	isListeningPort(port uint16) bool
+}
+
+// DirectionEnricher normalises the source and destination of events, as
+// reported by the kernel, into their local and remote endpoints, and
+// classifies connections as inbound, outbound or loopback.
+type directionEnricher struct {
+	local localEndpoints
+}
+
+func newDirectionEnricher(local localEndpoints) *directionEnricher {
+	return &directionEnricher{local}
+}
+
+func (e *directionEnricher) enrich(ctx context.Context, event *tcpEvent, socketInfo *socketInfo) {
+	event.endpoints = e.classify(event)
+}
+
+func (e *directionEnricher) close() error {
+	return nil
+}
+
+// Classify returns the endpoints of the event, or nil if neither endpoint
+// is local to the host (e.g. for events in other network namespaces whose
+// addresses are not visible to the sink).
+//
+// The side of the connection which is local is decided by the host's
+// addresses. The direction is decided by the TCP state where it is implied
+// by the handshake, and otherwise by whether the local port is listening.
+func (e *directionEnricher) classify(event *tcpEvent) *endpoints {
+	srcLocal := isLoopback(event.srcIP) || e.local.isLocalIP(event.srcIP)
+	dstLocal := isLoopback(event.dstIP) || e.local.isLocalIP(event.dstIP)
+
+	classified := &endpoints{
+		localIP:    event.srcIP,
+		localPort:  event.srcPort,
+		remoteIP:   event.dstIP,
+		remotePort: event.dstPort,
+	}
+
+	switch {
+	case srcLocal && dstLocal:
+		classified.direction = schema.DirectionLoopback
+		return classified
+	case dstLocal:
+		classified.localIP, classified.remoteIP = event.dstIP, event.srcIP
+		classified.localPort, classified.remotePort = event.dstPort, event.srcPort
+	case !srcLocal:
+		return nil
+	}
+
+	classified.direction = schema.DirectionOutbound
+	if isPassiveOpen(event) || e.local.isListeningPort(classified.localPort) {
+		classified.direction = schema.DirectionInbound
+	}
+
+	return classified
+}
+
+func isLoopback(ip net.IP) bool {
+	return ip != nil && ip.IsLoopback()
+}
+
+// IsPassiveOpen returns whether the event is a transition of a connection
+// accepted by a listening socket, which is therefore inbound.
+func isPassiveOpen(event *tcpEvent) bool {
+	return event.oldState == tcpstate.StateListen.String() ||
+		event.oldState == tcpstate.StateSynReceived.String() ||
+		event.newState == tcpstate.StateSynReceived.String()
+}
+
+// ProcLocalEndpoints reads the addresses of the host's interfaces, and the
+// ports on which the host is listening from /proc/net/tcp and tcp6.
+// These are re-read periodically, as they change over time. The refresh is
+// driven by lookups, so that it happens on the same goroutine.
+type procLocalEndpoints struct {
+	procRoot       string
+	interfaceAddrs func() ([]net.Addr, error)
+	now            func() time.Time
+	logger         Logger
+
+	addrs       map[string]bool
+	ports       map[uint16]bool
+	lastRefresh time.Time
+}
+
+func newProcLocalEndpoints(procRoot string,
+	interfaceAddrs func() ([]net.Addr, error),
+	now func() time.Time,
+	logger Logger) *procLocalEndpoints {
+	return &procLocalEndpoints{
+		procRoot:       procRoot,
+		interfaceAddrs: interfaceAddrs,
+		now:            now,
+		logger:         logger,
+	}
+}
+
+func (l *procLocalEndpoints) isLocalIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	l.refreshIfDue()
+	return l.addrs[ip.String()]
+}
+
+func (l *procLocalEndpoints) isListeningPort(port uint16) bool {
+	l.refreshIfDue()
+	return l.ports[port]
+}
+
+// RefreshIfDue re-reads the addresses and listening ports if the refresh
+// interval has elapsed. If either cannot be read, the previous values are
+// kept.
+func (l *procLocalEndpoints) refreshIfDue() {
+	now := l.now()
+	if l.addrs != nil && now.Sub(l.lastRefresh) < localEndpointsRefreshInterval {
+		return
+	}
+	l.lastRefresh = now
+
+	if addrs, err := l.readAddrs(); err != nil {
+		l.logger.Printf("Error reading interface addresses: %v", err)
+	} else {
+		l.addrs = addrs
+	}
+
+	if ports, err := l.readListeningPorts(); err != nil {
+		l.logger.Printf("Error reading listening ports: %v", err)
+	} else {
+		l.ports = ports
+	}
+
+	if l.addrs == nil {
+		l.addrs = make(map[string]bool)
+	}
+}
+
+func (l *procLocalEndpoints) readAddrs() (map[string]bool, error) {
+	interfaceAddrs, err := l.interfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make(map[string]bool, len(interfaceAddrs))
+	for _, addr := range interfaceAddrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			addrs[ipNet.IP.String()] = true
+		}
+	}
+
+	return addrs, nil
+}
+
+func (l *procLocalEndpoints) readListeningPorts() (map[uint16]bool, error) {
+	ports := make(map[uint16]bool)
+	for _, name := range []string{"tcp", "tcp6"} {
+		path := filepath.Join(l.procRoot, "net", name)
+		if err := readProcNetTCPListeningPorts(path, ports); err != nil {
+			// IPv6 may be disabled
+			if name == "tcp6" && os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, err
+		}
+	}
+
+	return ports, nil
+}
+
+// ReadProcNetTCPListeningPorts adds the ports of the listening sockets in
+// a /proc/net/tcp format file to the set of ports. Each line after the
+// header is of the form:
+//
+//	sl local_address rem_address st ...
+//
+// where the addresses are hex-encoded address:port and st is the hex state.
+func readProcNetTCPListeningPorts(path string, ports map[uint16]bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // Skip the header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[3] != procNetTCPListenState {
+			continue
+		}
+
+		localAddress := fields[1]
+		colon := strings.LastIndexByte(localAddress, ':')
+		if colon == -1 {
+			return fmt.Errorf("invalid local address %q", localAddress)
+		}
+
+		port, err := strconv.ParseUint(localAddress[colon+1:], 16, 16)
+		if err != nil {
+			return fmt.Errorf("invalid local port %q", localAddress[colon+1:])
+		}
+
+		ports[uint16(port)] = true
+	}
+
+	return scanner.Err()
+}
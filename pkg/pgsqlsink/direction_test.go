@@ -0,0 +1,260 @@
+package pgsqlsink
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+type mockLocalEndpoints struct {
+	addrs []string
+	ports []uint16
+}
+
+func (l *mockLocalEndpoints) isLocalIP(ip net.IP) bool {
+	for _, addr := range l.addrs {
+		if net.ParseIP(addr).Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *mockLocalEndpoints) isListeningPort(port uint16) bool {
+	for _, p := range l.ports {
+		if p == port {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestDirectionEnricherClassify(t *testing.T) {
+	local := &mockLocalEndpoints{addrs: []string{"192.168.1.3"}, ports: []uint16{22}}
+	enricher := newDirectionEnricher(local)
+
+	tests := []struct {
+		name               string
+		srcIP, dstIP       string
+		srcPort, dstPort   uint16
+		oldState, newState string
+
+		expectedDirection  string
+		expectedLocal      string
+		expectedRemote     string
+		expectedLocalPort  uint16
+		expectedRemotePort uint16
+	}{
+		{
+			name:  "outbound",
+			srcIP: "192.168.1.3", srcPort: 58248, dstIP: "172.217.16.225", dstPort: 443,
+			oldState: "SYN-SENT", newState: "ESTABLISHED",
+			expectedDirection: schema.DirectionOutbound,
+			expectedLocal:     "192.168.1.3", expectedLocalPort: 58248,
+			expectedRemote: "172.217.16.225", expectedRemotePort: 443,
+		},
+		{
+			name:  "inbound by state",
+			srcIP: "192.168.1.3", srcPort: 8080, dstIP: "10.0.0.1", dstPort: 51234,
+			oldState: "SYN-RECEIVED", newState: "ESTABLISHED",
+			expectedDirection: schema.DirectionInbound,
+			expectedLocal:     "192.168.1.3", expectedLocalPort: 8080,
+			expectedRemote: "10.0.0.1", expectedRemotePort: 51234,
+		},
+		{
+			name:  "inbound by listening port",
+			srcIP: "192.168.1.3", srcPort: 22, dstIP: "10.0.0.1", dstPort: 51234,
+			oldState: "ESTABLISHED", newState: "FIN-WAIT-1",
+			expectedDirection: schema.DirectionInbound,
+			expectedLocal:     "192.168.1.3", expectedLocalPort: 22,
+			expectedRemote: "10.0.0.1", expectedRemotePort: 51234,
+		},
+		{
+			name:  "inbound reported from the remote peer",
+			srcIP: "10.0.0.1", srcPort: 51234, dstIP: "192.168.1.3", dstPort: 22,
+			oldState: "ESTABLISHED", newState: "CLOSE-WAIT",
+			expectedDirection: schema.DirectionInbound,
+			expectedLocal:     "192.168.1.3", expectedLocalPort: 22,
+			expectedRemote: "10.0.0.1", expectedRemotePort: 51234,
+		},
+		{
+			name:  "loopback",
+			srcIP: "127.0.0.1", srcPort: 5432, dstIP: "127.0.0.1", dstPort: 40000,
+			oldState: "ESTABLISHED", newState: "CLOSE-WAIT",
+			expectedDirection: schema.DirectionLoopback,
+			expectedLocal:     "127.0.0.1", expectedLocalPort: 5432,
+			expectedRemote: "127.0.0.1", expectedRemotePort: 40000,
+		},
+	}
+
+	for _, test := range tests {
+		event := &tcpEvent{
+			srcIP:    net.ParseIP(test.srcIP),
+			dstIP:    net.ParseIP(test.dstIP),
+			srcPort:  test.srcPort,
+			dstPort:  test.dstPort,
+			oldState: test.oldState,
+			newState: test.newState,
+		}
+		enricher.enrich(context.TODO(), event, nil)
+
+		endpoints := event.endpoints
+		if endpoints == nil {
+			t.Errorf("%s: expected endpoints, got nil", test.name)
+			continue
+		}
+
+		if endpoints.direction != test.expectedDirection {
+			t.Errorf("%s: expected direction %q, got %q", test.name, test.expectedDirection, endpoints.direction)
+		}
+
+		if !endpoints.localIP.Equal(net.ParseIP(test.expectedLocal)) || endpoints.localPort != test.expectedLocalPort {
+			t.Errorf("%s: expected local endpoint %s:%d, got %s:%d",
+				test.name,
+				test.expectedLocal,
+				test.expectedLocalPort,
+				endpoints.localIP,
+				endpoints.localPort)
+		}
+
+		if !endpoints.remoteIP.Equal(net.ParseIP(test.expectedRemote)) || endpoints.remotePort != test.expectedRemotePort {
+			t.Errorf("%s: expected remote endpoint %s:%d, got %s:%d",
+				test.name,
+				test.expectedRemote,
+				test.expectedRemotePort,
+				endpoints.remoteIP,
+				endpoints.remotePort)
+		}
+	}
+}
+
+func TestDirectionEnricherNoLocalEndpoint(t *testing.T) {
+	enricher := newDirectionEnricher(new(mockLocalEndpoints))
+
+	event := &tcpEvent{
+		srcIP: net.ParseIP("10.244.1.5"),
+		dstIP: net.ParseIP("172.217.16.225"),
+	}
+	enricher.enrich(context.TODO(), event, nil)
+
+	if event.endpoints != nil {
+		t.Errorf("expected nil endpoints, got %+v", event.endpoints)
+	}
+}
+
+func newMockProcNet(t *testing.T, withTCP6 bool) string {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "net"), 0755); err != nil {
+		t.Fatalf("test bootstrapping: unable to create mock proc: %v", err)
+	}
+
+	tcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 12345 1 0000000000000000 100 0 0 10 0
+   1: 0301A8C0:E388 E110D9AC:01BB 01 00000000:00000000 00:00000000 00000000  1000        0 23456 1 0000000000000000 20 4 30 10 -1
+`
+	if err := ioutil.WriteFile(filepath.Join(root, "net", "tcp"), []byte(tcp), 0644); err != nil {
+		t.Fatalf("test bootstrapping: unable to write mock proc file: %v", err)
+	}
+
+	if withTCP6 {
+		tcp6 := `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:1F90 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 34567 1 0000000000000000 100 0 0 10 0
+`
+		if err := ioutil.WriteFile(filepath.Join(root, "net", "tcp6"), []byte(tcp6), 0644); err != nil {
+			t.Fatalf("test bootstrapping: unable to write mock proc file: %v", err)
+		}
+	}
+
+	return root
+}
+
+func mockInterfaceAddrs(addrs ...string) func() ([]net.Addr, error) {
+	return func() ([]net.Addr, error) {
+		var netAddrs []net.Addr
+		for _, addr := range addrs {
+			ip, ipNet, err := net.ParseCIDR(addr)
+			if err != nil {
+				return nil, err
+			}
+
+			ipNet.IP = ip
+			netAddrs = append(netAddrs, ipNet)
+		}
+
+		return netAddrs, nil
+	}
+}
+
+func TestProcLocalEndpoints(t *testing.T) {
+	procRoot := newMockProcNet(t, true)
+	local := newProcLocalEndpoints(procRoot, mockInterfaceAddrs("192.168.1.3/24", "fe80::1/64"), time.Now, discardLogger)
+
+	if !local.isLocalIP(net.ParseIP("192.168.1.3")) {
+		t.Error("expected interface address to be local, but was not")
+	}
+
+	if local.isLocalIP(net.ParseIP("192.168.1.4")) {
+		t.Error("expected other address in interface network to not be local, but was")
+	}
+
+	for _, port := range []uint16{22, 8080} {
+		if !local.isListeningPort(port) {
+			t.Errorf("expected port %d to be listening, but was not", port)
+		}
+	}
+
+	if local.isListeningPort(58248) {
+		t.Errorf("expected established port %d to not be listening, but was", 58248)
+	}
+}
+
+func TestProcLocalEndpointsNoTCP6(t *testing.T) {
+	procRoot := newMockProcNet(t, false)
+	local := newProcLocalEndpoints(procRoot, mockInterfaceAddrs(), time.Now, discardLogger)
+
+	if !local.isListeningPort(22) {
+		t.Errorf("expected port %d to be listening, but was not", 22)
+	}
+}
+
+func TestProcLocalEndpointsRefresh(t *testing.T) {
+	procRoot := newMockProcNet(t, true)
+	addrs := []string{"192.168.1.3/24"}
+	reads := 0
+	interfaceAddrs := func() ([]net.Addr, error) {
+		reads++
+		if reads > 1 {
+			return nil, errors.New("mock interface addresses error")
+		}
+
+		return mockInterfaceAddrs(addrs...)()
+	}
+
+	now := time.Now()
+	local := newProcLocalEndpoints(procRoot, interfaceAddrs, func() time.Time { return now }, discardLogger)
+
+	local.isLocalIP(net.ParseIP("192.168.1.3"))
+	local.isLocalIP(net.ParseIP("192.168.1.3"))
+	if reads != 1 {
+		t.Errorf("expected addresses to be read %d time before refresh interval, got %d", 1, reads)
+	}
+
+	// A failed refresh keeps the previous addresses
+	now = now.Add(localEndpointsRefreshInterval)
+	if !local.isLocalIP(net.ParseIP("192.168.1.3")) {
+		t.Error("expected address to remain local after failed refresh, but was not")
+	}
+
+	if reads != 2 {
+		t.Errorf("expected addresses to be read %d times after refresh interval, got %d", 2, reads)
+	}
+}
@@ -0,0 +1,419 @@
+package pgsqlsink
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	enrichmentDNS      = "dns"
+	enrichmentServices = "services"
+
+	defaultDNSTimeout   = 200 * time.Millisecond
+	defaultDNSCacheSize = 10000
+	defaultDNSCacheTTL  = time.Hour
+	defaultServicesFile = "/etc/services"
+
+	// DNSNegativeCacheTTL is how long failed and timed-out reverse-DNS
+	// lookups are cached, at most the configured cache TTL, so that names
+	// which could not be resolved are retried soon.
+	dnsNegativeCacheTTL = time.Minute
+
+	// MaxPendingDNSLookups bounds the number of reverse-DNS lookups made
+	// concurrently in the background.
+	maxPendingDNSLookups = 100
+)
+
+// Enricher is an interface which describes objects which add information
+// to a TCP state-change event before it is stored.
+// Enrichment is best-effort: information which cannot be found is left unset.
+type enricher interface {
+	enrich(ctx context.Context, event *tcpEvent, socketInfo *socketInfo)
+	close() error
+}
+
+// CloseEnrichers closes the enrichers, releasing any resources they hold.
+// Errors are logged rather than returned, as they do not affect the events
+// already stored.
+func closeEnrichers(enrichers []enricher, logger Logger) {
+	for _, enricher := range enrichers {
+		if err := enricher.close(); err != nil {
+			logger.Printf("Error closing enricher: %v", err)
+		}
+	}
+}
+
+// ParseEnrichments parses a comma-separated list of enrichment names.
+func parseEnrichments(spec string) ([]string, error) {
+	var enrichments []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		switch name {
+		case enrichmentDNS, enrichmentServices, enrichmentProcess, enrichmentContainer, enrichmentGeoIP:
+		default:
+			return nil, fmt.Errorf("unknown enrichment %q", name)
+		}
+
+		for _, existing := range enrichments {
+			if existing == name {
+				return nil, fmt.Errorf("duplicate enrichment %q", name)
+			}
+		}
+
+		enrichments = append(enrichments, name)
+	}
+
+	return enrichments, nil
+}
+
+// HasEnrichment returns whether the enrichment is enabled by the sink
+// configuration.
+func hasEnrichment(config *sinkConfig, enrichment string) bool {
+	for _, enabled := range config.enrichments {
+		if enabled == enrichment {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewEnrichers returns the enrichers described by the sink configuration,
+// in the configured order, after the direction enricher which always runs
+// unless the events occurred on other hosts. If any cannot be created, those
+// already created are closed.
+func newEnrichers(config *sinkConfig, logger Logger) ([]enricher, error) {
+	var enrichers []enricher
+	if !config.remoteEvents {
+		local := newProcLocalEndpoints(config.procRoot, net.InterfaceAddrs, time.Now, logger)
+		enrichers = append(enrichers, newDirectionEnricher(local))
+	}
+
+	for _, enrichment := range config.enrichments {
+		switch enrichment {
+		case enrichmentDNS:
+			negativeTTL := dnsNegativeCacheTTL
+			if config.dnsCacheTTL < negativeTTL {
+				negativeTTL = config.dnsCacheTTL
+			}
+
+			cache := newDNSCache(config.dnsCacheSize, config.dnsCacheTTL, negativeTTL, time.Now)
+			enrichers = append(enrichers, newDNSEnricher(net.DefaultResolver, cache, config.dnsTimeout))
+		case enrichmentServices:
+			services, err := loadServices(config.servicesFile)
+			if err != nil {
+				closeEnrichers(enrichers, logger)
+				return nil, fmt.Errorf("loading services: %w", err)
+			}
+
+			enrichers = append(enrichers, newServiceEnricher(services))
+		case enrichmentProcess:
+			enrichers = append(enrichers, newProcessEnricher(config.procRoot, lookupUserName, lookupGroupName))
+		case enrichmentContainer:
+			var resolver metadataResolver
+			if config.containerMetadataFile != "" {
+				fileResolver, err := newFileMetadataResolver(config.containerMetadataFile, time.Now)
+				if err != nil {
+					closeEnrichers(enrichers, logger)
+					return nil, fmt.Errorf("creating container metadata resolver: %w", err)
+				}
+
+				resolver = fileResolver
+			}
+
+			enrichers = append(enrichers, newContainerEnricher(config.procRoot, resolver))
+		case enrichmentGeoIP:
+			geoIPEnricher, err := newGeoIPEnricherFromFiles(config.geoIPCountryDB, config.geoIPASNDB, logger)
+			if err != nil {
+				closeEnrichers(enrichers, logger)
+				return nil, fmt.Errorf("creating GeoIP enricher: %w", err)
+			}
+
+			enrichers = append(enrichers, geoIPEnricher)
+		}
+	}
+
+	return enrichers, nil
+}
+
+// Resolver is an interface which describes objects which resolve IP
+// addresses to host names. It is satisfied by *net.Resolver.
+type resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// DNSEnricher adds the reverse-DNS names of the source and destination
+// IP addresses to events. Names are resolved in the background so that
+// storing events never waits for the resolver: an address which is not
+// cached is stored without a name, and its name is added to the events
+// which follow once it has been resolved. Results, including failures, are
+// cached so that the resolver is not consulted for every event.
+type dnsEnricher struct {
+	resolver resolver
+	cache    *dnsCache
+	timeout  time.Duration
+
+	mu      sync.Mutex
+	pending map[string]struct{}
+	wg      sync.WaitGroup
+}
+
+func newDNSEnricher(resolver resolver, cache *dnsCache, timeout time.Duration) *dnsEnricher {
+	return &dnsEnricher{
+		resolver: resolver,
+		cache:    cache,
+		timeout:  timeout,
+		pending:  make(map[string]struct{}),
+	}
+}
+
+func (e *dnsEnricher) enrich(ctx context.Context, event *tcpEvent, socketInfo *socketInfo) {
+	event.srcName = e.lookup(event.srcIP)
+	event.dstName = e.lookup(event.dstIP)
+}
+
+// Lookup returns the cached name of the IP address, or nil if it has none
+// or is not cached, in which case it is resolved in the background.
+func (e *dnsEnricher) lookup(ip net.IP) *string {
+	if ip == nil {
+		return nil
+	}
+
+	addr := ip.String()
+	if name, ok := e.cache.get(addr); ok {
+		return name
+	}
+
+	e.resolveInBackground(addr)
+	return nil
+}
+
+// ResolveInBackground resolves the address and caches the result, unless
+// it is already being resolved or too many lookups are pending.
+func (e *dnsEnricher) resolveInBackground(addr string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.pending[addr]; ok || len(e.pending) >= maxPendingDNSLookups {
+		return
+	}
+
+	e.pending[addr] = struct{}{}
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+
+		e.cache.put(addr, e.resolve(addr))
+
+		e.mu.Lock()
+		delete(e.pending, addr)
+		e.mu.Unlock()
+	}()
+}
+
+// Resolve returns the name of the address, or nil if it has none or it
+// could not be resolved within the timeout.
+func (e *dnsEnricher) resolve(addr string) *string {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	names, err := e.resolver.LookupAddr(ctx, addr)
+	if err != nil || len(names) == 0 {
+		return nil
+	}
+
+	name := strings.TrimSuffix(names[0], ".")
+	return &name
+}
+
+// Wait waits for the pending lookups to complete.
+func (e *dnsEnricher) wait() {
+	e.wg.Wait()
+}
+
+// Close waits for the pending lookups, which are bounded by the timeout, to
+// complete.
+func (e *dnsEnricher) close() error {
+	e.wait()
+	return nil
+}
+
+// DNSCache is a least-recently-used cache of reverse-DNS names, bounded in
+// size, whose entries expire after a time-to-live. Addresses without a name
+// expire after the negative time-to-live, which is usually shorter.
+// It is safe for concurrent use.
+type dnsCache struct {
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type dnsCacheEntry struct {
+	addr    string
+	name    *string
+	expires time.Time
+}
+
+func newDNSCache(size int, ttl, negativeTTL time.Duration, now func() time.Time) *dnsCache {
+	return &dnsCache{
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		now:         now,
+		entries:     make(map[string]*list.Element, size),
+		order:       list.New(),
+	}
+}
+
+// Get returns the cached name of the address, which is nil if the address
+// has no name, and whether the address was cached.
+func (c *dnsCache) get(addr string) (*string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[addr]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*dnsCacheEntry)
+	if c.now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, addr)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.name, true
+}
+
+// Put caches the name of the address, evicting the least-recently-used
+// entry if the cache is full.
+func (c *dnsCache) put(addr string, name *string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[addr]; ok {
+		c.order.Remove(element)
+		delete(c.entries, addr)
+	}
+
+	if c.order.Len() >= c.size {
+		if oldest := c.order.Back(); oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*dnsCacheEntry).addr)
+		}
+	}
+
+	ttl := c.ttl
+	if name == nil {
+		ttl = c.negativeTTL
+	}
+
+	entry := &dnsCacheEntry{
+		addr:    addr,
+		name:    name,
+		expires: c.now().Add(ttl),
+	}
+	c.entries[addr] = c.order.PushFront(entry)
+}
+
+// ServiceEnricher adds the well-known service names of the source and
+// destination ports to events.
+type serviceEnricher struct {
+	services map[uint16]string
+}
+
+func newServiceEnricher(services map[uint16]string) *serviceEnricher {
+	return &serviceEnricher{services}
+}
+
+func (e *serviceEnricher) enrich(ctx context.Context, event *tcpEvent, socketInfo *socketInfo) {
+	event.srcService = e.lookup(event.srcPort)
+	event.dstService = e.lookup(event.dstPort)
+}
+
+func (e *serviceEnricher) close() error {
+	return nil
+}
+
+func (e *serviceEnricher) lookup(port uint16) *string {
+	if service, ok := e.services[port]; ok {
+		return &service
+	}
+
+	return nil
+}
+
+// LoadServices reads the TCP service names from a file in the format of
+// /etc/services.
+func loadServices(path string) (map[uint16]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseServices(file)
+}
+
+// ParseServices parses the TCP service names from the format of
+// /etc/services, where each line is of the form:
+//
+//	name port/protocol [aliases...] [# comment]
+//
+// Where a port has more than one name, the first is used.
+func parseServices(reader io.Reader) (map[uint16]string, error) {
+	services := make(map[uint16]string)
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if comment := strings.IndexByte(line, '#'); comment != -1 {
+			line = line[:comment]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		portProtocol := strings.SplitN(fields[1], "/", 2)
+		if len(portProtocol) != 2 || portProtocol[1] != "tcp" {
+			continue
+		}
+
+		port, err := strconv.ParseUint(portProtocol[0], 10, 16)
+		if err != nil {
+			continue
+		}
+
+		if _, ok := services[uint16(port)]; !ok {
+			services[uint16(port)] = fields[0]
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading services: %w", err)
+	}
+
+	return services, nil
+}
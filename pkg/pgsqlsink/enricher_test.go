@@ -0,0 +1,259 @@
+package pgsqlsink
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type stubResolver struct {
+	names map[string][]string
+	delay time.Duration
+
+	mu      sync.Mutex
+	lookups int
+}
+
+func newStubResolver(names map[string][]string, delay time.Duration) *stubResolver {
+	return &stubResolver{names: names, delay: delay}
+}
+
+func (r *stubResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.mu.Lock()
+	r.lookups++
+	delay := r.delay
+	r.mu.Unlock()
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	names, ok := r.names[addr]
+	if !ok {
+		return nil, errors.New("mock not found error")
+	}
+
+	return names, nil
+}
+
+func (r *stubResolver) setDelay(delay time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.delay = delay
+}
+
+func (r *stubResolver) lookupCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.lookups
+}
+
+func TestDNSEnricher(t *testing.T) {
+	resolver := newStubResolver(map[string][]string{
+		"172.217.16.225": {"lhr25s11-in-f1.1e100.net.", "other.example."},
+	}, 0)
+	cache := newDNSCache(10, time.Hour, time.Minute, time.Now)
+	enricher := newDNSEnricher(resolver, cache, time.Second)
+
+	event := &tcpEvent{
+		srcIP: net.ParseIP("10.0.0.1"),
+		dstIP: net.ParseIP("172.217.16.225"),
+	}
+
+	// Names are not known until they have been resolved in the background
+	enricher.enrich(context.TODO(), event, nil)
+	if event.srcName != nil || event.dstName != nil {
+		t.Errorf("expected nil names before resolution, got %v and %v", event.srcName, event.dstName)
+	}
+
+	enricher.wait()
+	enricher.enrich(context.TODO(), event, nil)
+
+	if event.srcName != nil {
+		t.Errorf("expected nil source name, got %q", *event.srcName)
+	}
+
+	if event.dstName == nil || *event.dstName != "lhr25s11-in-f1.1e100.net" {
+		t.Errorf("expected destination name %q, got %v", "lhr25s11-in-f1.1e100.net", event.dstName)
+	}
+
+	// Both the found and the not found names are cached
+	enricher.enrich(context.TODO(), event, nil)
+	enricher.wait()
+	if lookups := resolver.lookupCount(); lookups != 2 {
+		t.Errorf("expected %d lookups, got %d", 2, lookups)
+	}
+}
+
+func TestDNSEnricherPendingLookup(t *testing.T) {
+	resolver := newStubResolver(map[string][]string{
+		"172.217.16.225": {"lhr25s11-in-f1.1e100.net."},
+	}, 200*time.Millisecond)
+	cache := newDNSCache(10, time.Hour, time.Minute, time.Now)
+	enricher := newDNSEnricher(resolver, cache, time.Second)
+
+	event := &tcpEvent{dstIP: net.ParseIP("172.217.16.225")}
+
+	start := time.Now()
+	enricher.enrich(context.TODO(), event, nil)
+	enricher.enrich(context.TODO(), event, nil)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("expected enrichment not to wait for the resolver, but took %v", elapsed)
+	}
+
+	enricher.wait()
+	if lookups := resolver.lookupCount(); lookups != 1 {
+		t.Errorf("expected %d lookup, got %d", 1, lookups)
+	}
+}
+
+func TestDNSEnricherTimeout(t *testing.T) {
+	resolver := newStubResolver(map[string][]string{
+		"172.217.16.225": {"lhr25s11-in-f1.1e100.net."},
+	}, time.Second)
+	now := time.Now()
+	cache := newDNSCache(10, time.Hour, time.Minute, func() time.Time { return now })
+	enricher := newDNSEnricher(resolver, cache, 10*time.Millisecond)
+
+	event := &tcpEvent{dstIP: net.ParseIP("172.217.16.225")}
+
+	start := time.Now()
+	enricher.enrich(context.TODO(), event, nil)
+	enricher.wait()
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected lookup to time out, but took %v", elapsed)
+	}
+
+	// The timed-out lookup is cached until the negative TTL expires
+	resolver.setDelay(0)
+	enricher.enrich(context.TODO(), event, nil)
+	enricher.wait()
+	if event.dstName != nil {
+		t.Errorf("expected nil destination name, got %q", *event.dstName)
+	}
+
+	if lookups := resolver.lookupCount(); lookups != 1 {
+		t.Errorf("expected %d lookup, got %d", 1, lookups)
+	}
+
+	// After which the address is resolved again
+	now = now.Add(2 * time.Minute)
+	enricher.enrich(context.TODO(), event, nil)
+	enricher.wait()
+	enricher.enrich(context.TODO(), event, nil)
+
+	if lookups := resolver.lookupCount(); lookups != 2 {
+		t.Errorf("expected %d lookups, got %d", 2, lookups)
+	}
+
+	if event.dstName == nil || *event.dstName != "lhr25s11-in-f1.1e100.net" {
+		t.Errorf("expected destination name %q, got %v", "lhr25s11-in-f1.1e100.net", event.dstName)
+	}
+}
+
+func TestDNSCacheEviction(t *testing.T) {
+	cache := newDNSCache(2, time.Hour, time.Hour, time.Now)
+	name := "mock-name"
+
+	cache.put("1.1.1.1", &name)
+	cache.put("2.2.2.2", nil)
+	cache.get("1.1.1.1")
+	cache.put("3.3.3.3", &name)
+
+	if _, ok := cache.get("2.2.2.2"); ok {
+		t.Error("expected least-recently-used entry to be evicted, but was not")
+	}
+
+	if cached, ok := cache.get("1.1.1.1"); !ok || *cached != name {
+		t.Error("expected recently-used entry to be cached, but was not")
+	}
+
+	if len(cache.entries) != 2 || cache.order.Len() != 2 {
+		t.Errorf("expected cache size to be bounded to %d, got %d", 2, len(cache.entries))
+	}
+}
+
+func TestDNSCacheExpiry(t *testing.T) {
+	now := time.Now()
+	cache := newDNSCache(2, time.Hour, time.Minute, func() time.Time { return now })
+	name := "mock-name"
+
+	cache.put("1.1.1.1", nil)
+	cache.put("2.2.2.2", &name)
+	if _, ok := cache.get("1.1.1.1"); !ok {
+		t.Error("expected entry to be cached, but was not")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := cache.get("1.1.1.1"); ok {
+		t.Error("expected negative entry to have expired, but had not")
+	}
+
+	if _, ok := cache.get("2.2.2.2"); !ok {
+		t.Error("expected entry to be cached, but was not")
+	}
+
+	now = now.Add(time.Hour)
+	if _, ok := cache.get("2.2.2.2"); ok {
+		t.Error("expected entry to have expired, but had not")
+	}
+}
+
+func TestParseServices(t *testing.T) {
+	services, err := parseServices(strings.NewReader(`
+# Network services, Internet style
+http		80/tcp		www		# WorldWideWeb HTTP
+https		443/tcp				# http protocol over TLS/SSL
+https		443/udp
+domain		53/udp
+bogus		notaport/tcp
+www-alias	80/tcp
+`))
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	expected := map[uint16]string{80: "http", 443: "https"}
+	if len(services) != len(expected) {
+		t.Errorf("expected %d services, got %d: %v", len(expected), len(services), services)
+	}
+
+	for port, name := range expected {
+		if services[port] != name {
+			t.Errorf("expected port %d to be service %q, got %q", port, name, services[port])
+		}
+	}
+}
+
+func TestServiceEnricher(t *testing.T) {
+	enricher := newServiceEnricher(map[uint16]string{443: "https"})
+
+	event := &tcpEvent{srcPort: 43210, dstPort: 443}
+	enricher.enrich(context.TODO(), event, nil)
+
+	if event.srcService != nil {
+		t.Errorf("expected nil source service, got %q", *event.srcService)
+	}
+
+	if event.dstService == nil || *event.dstService != "https" {
+		t.Errorf("expected destination service %q, got %v", "https", event.dstService)
+	}
+}
+
+func TestParseEnrichmentsError(t *testing.T) {
+	if _, err := parseEnrichments("dns,bogus"); err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	if _, err := parseEnrichments("dns,dns"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
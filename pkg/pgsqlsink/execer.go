@@ -0,0 +1,127 @@
+package pgsqlsink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SQLStatement defines a SQL statement with the SQL and arguments.
+type sqlStatement struct {
+	sql       string
+	arguments []interface{}
+}
+
+func newSQLStatement(sql string, arguments ...interface{}) *sqlStatement {
+	return &sqlStatement{sql, arguments}
+}
+
+// Execer is an interface which describes objects which execute
+// SQL statements.
+type execer interface {
+	exec(ctx context.Context, sql string, arguments ...interface{}) error
+	execMultiple(ctx context.Context, stmts ...*sqlStatement) error
+	close(ctx context.Context) error
+}
+
+// SQLExecer executes SQL statements on a database connection.
+// Statements which fail with a serialization failure are retried, with
+// exponential backoff, up to the given number of times.
+type sqlExecer struct {
+	conn                 conn
+	serializationRetries int
+	sleep                func(time.Duration)
+	logger               Logger
+}
+
+func newSQLExecer(conn conn,
+	serializationRetries int,
+	sleep func(time.Duration),
+	logger Logger) *sqlExecer {
+	return &sqlExecer{conn, serializationRetries, sleep, logger}
+}
+
+// Exec executes the provided SQL statement using the provided arguments.
+func (e *sqlExecer) exec(ctx context.Context,
+	sql string,
+	arguments ...interface{}) error {
+	return e.retry(func() error {
+		if err := e.conn.exec(ctx, sql, arguments...); err != nil {
+			return fmt.Errorf("execing SQL on connection: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// ExecMultiple executes the provided SQL statement(s) using the provided arguments.
+// If more than one statement is provided, they are executed atomically
+// (i.e. in a transaction). The whole transaction is retried on
+// serialization failure.
+func (e *sqlExecer) execMultiple(ctx context.Context, stmts ...*sqlStatement) error {
+	return e.retry(func() error {
+		return e.execInTx(ctx, stmts...)
+	})
+}
+
+// Retry calls f until it returns an error other than a serialization
+// failure, or the retries are exhausted.
+func (e *sqlExecer) retry(f func() error) error {
+	backoff := serializationRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || !errors.Is(err, errSerializationFailure) || attempt >= e.serializationRetries {
+			return err
+		}
+
+		e.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// ExecInTx executes the provided SQL statement(s) in a single transaction.
+func (e *sqlExecer) execInTx(ctx context.Context, stmts ...*sqlStatement) (err error) {
+
+	tx, err := e.conn.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("starting database transaction: %w", err)
+	}
+
+	// "Finally" block
+	defer func(ctx context.Context, tx transaction) {
+		if err != nil {
+			if rollbackErr := tx.rollback(ctx); rollbackErr != nil {
+				e.logger.Printf("Error rolling-back database transaction: %v", rollbackErr)
+			}
+			return
+		}
+
+		if commitErr := tx.commit(ctx); commitErr != nil {
+			// Replace the nil-error return from the parent function with this error
+			err = fmt.Errorf("committing database transaction: %w", commitErr)
+		}
+	}(ctx, tx)
+
+	for i, stmt := range stmts {
+		sql := stmt.sql
+		args := stmt.arguments
+
+		if err := tx.exec(ctx, sql, args...); err != nil {
+			return fmt.Errorf("execing SQL statement %d within transaction: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Close releases the resources held by this Execer, namely the
+// database connection.
+func (e *sqlExecer) close(ctx context.Context) error {
+	e.logger.Printf("Closing database connection: %s", e.conn.describe())
+	if err := e.conn.close(ctx); err != nil {
+		return fmt.Errorf("closing connection: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,301 @@
+package pgsqlsink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type mockConn struct {
+	txToReturn         transaction
+	beginErrorToReturn error
+
+	// ExecErrorsToReturn are returned from exec by SQL.
+	execErrorsToReturn map[string]error
+	receivedSQL        []string
+
+	// QueryResultsToReturn are returned from query by SQL.
+	queryResultsToReturn map[string][][]interface{}
+	queryErrorToReturn   error
+
+	execCalled     bool
+	closeCalled    bool
+	beginCalled    bool
+	describeCalled bool
+	prepareCalled  bool
+}
+
+func newMockConn(txToReturn transaction, beginErrorToReturn error) *mockConn {
+	return &mockConn{
+		txToReturn:         txToReturn,
+		beginErrorToReturn: beginErrorToReturn,
+	}
+}
+
+func (mc *mockConn) exec(ctx context.Context, sql string, arguments ...interface{}) error {
+	mc.execCalled = true
+	mc.receivedSQL = append(mc.receivedSQL, sql)
+	return mc.execErrorsToReturn[sql]
+}
+
+func (mc *mockConn) begin(ctx context.Context) (transaction, error) {
+	mc.beginCalled = true
+
+	if mc.beginErrorToReturn != nil {
+		return nil, mc.beginErrorToReturn
+	}
+
+	return mc.txToReturn, nil
+}
+
+func (mc *mockConn) query(ctx context.Context, sql string, arguments ...interface{}) ([][]interface{}, error) {
+	if mc.queryErrorToReturn != nil {
+		return nil, mc.queryErrorToReturn
+	}
+
+	return mc.queryResultsToReturn[sql], nil
+}
+
+func (mc *mockConn) prepare(ctx context.Context, name, sql string) error {
+	mc.prepareCalled = true
+	return nil
+}
+
+func (mc *mockConn) close(ctx context.Context) error {
+	mc.closeCalled = true
+	return nil
+}
+
+func (mc *mockConn) describe() string {
+	mc.describeCalled = true
+	return "mock-conn"
+}
+
+type mockTx struct {
+	execErrorToReturn   error
+	commitErrorToReturn error
+
+	// ExecErrorLimit, if non-zero, is the number of execs which return the
+	// exec error, after which execs succeed.
+	execErrorLimit int
+
+	execCalls      int
+	execCalled     bool
+	receivedSQL    []string
+	commitCalled   bool
+	rollbackCalled bool
+}
+
+func newMockTx(execErrorToReturn, commitErrorToReturn error) *mockTx {
+	return &mockTx{
+		execErrorToReturn:   execErrorToReturn,
+		commitErrorToReturn: commitErrorToReturn,
+	}
+}
+
+func (mt *mockTx) exec(ctx context.Context, sql string, arguments ...interface{}) error {
+	mt.execCalled = true
+	mt.execCalls++
+	mt.receivedSQL = append(mt.receivedSQL, sql)
+
+	if mt.execErrorToReturn != nil && (mt.execErrorLimit == 0 || mt.execCalls <= mt.execErrorLimit) {
+		return mt.execErrorToReturn
+	}
+
+	return nil
+}
+
+func (mt *mockTx) commit(ctx context.Context) error {
+	mt.commitCalled = true
+
+	if mt.commitErrorToReturn != nil {
+		return mt.commitErrorToReturn
+	}
+
+	return nil
+}
+
+func (mt *mockTx) rollback(ctx context.Context) error {
+	mt.rollbackCalled = true
+	return nil
+}
+
+func TestExecerCommitTxOnNoExecError(t *testing.T) {
+	mockTx := newMockTx(nil, nil)
+	mockConn := newMockConn(mockTx, nil)
+	mockStmt1 := newSQLStatement("INSERT INTO foo (foo, bar) VALUES ($1, $2)", "foo", "bar")
+	mockStmt2 := newSQLStatement("INSERT INTO bar (baz, bosh) VALUES ($1, $2)", "baz", "qux")
+
+	execer := newSQLExecer(mockConn, 0, nil, discardLogger)
+
+	if err := execer.execMultiple(context.TODO(), mockStmt1, mockStmt2); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockConn.beginCalled {
+		t.Error("expected conn Begin() to be called, but was not")
+	}
+
+	if !mockTx.commitCalled {
+		t.Error("expected Tx Commit() to be called, but was not")
+	}
+}
+
+func TestExecerRollbackTxOnExecError(t *testing.T) {
+	mockError := errors.New("mock tx exec error")
+	mockTx := newMockTx(mockError, nil)
+	mockConn := newMockConn(mockTx, nil)
+	mockStmt1 := newSQLStatement("INSERT INTO foo (foo, bar) VALUES ($1, $2)", "foo", "bar")
+	mockStmt2 := newSQLStatement("INSERT INTO bar (baz, bosh) VALUES ($1, $2)", "baz", "qux")
+
+	execer := newSQLExecer(mockConn, 0, nil, discardLogger)
+
+	err := execer.execMultiple(context.TODO(), mockStmt1, mockStmt2)
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+
+	if !mockConn.beginCalled {
+		t.Error("expected conn Begin() to be called, but was not")
+	}
+
+	if !mockTx.rollbackCalled {
+		t.Error("expected Tx Rollback() to be called, but was not")
+	}
+
+	if mockTx.commitCalled {
+		t.Error("expected Tx Commit() to not be called, but was")
+	}
+}
+
+func TestExecerErrorOnCommitError(t *testing.T) {
+	mockError := errors.New("mock tx commit error")
+	mockTx := newMockTx(nil, mockError)
+	mockConn := newMockConn(mockTx, nil)
+	mockStmt1 := newSQLStatement("INSERT INTO foo (foo, bar) VALUES ($1, $2)", "foo", "bar")
+	mockStmt2 := newSQLStatement("INSERT INTO bar (baz, bosh) VALUES ($1, $2)", "baz", "qux")
+
+	execer := newSQLExecer(mockConn, 0, nil, discardLogger)
+
+	err := execer.execMultiple(context.TODO(), mockStmt1, mockStmt2)
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+
+	if !mockConn.beginCalled {
+		t.Error("expected conn Begin() to be called, but was not")
+	}
+
+	if !mockTx.commitCalled {
+		t.Error("expected Tx Commit() to be called, but was not")
+	}
+
+	if mockTx.rollbackCalled {
+		t.Error("expected Tx Rollback() to not be called, but was")
+	}
+}
+
+func TestExecerErrorOnTxBeginError(t *testing.T) {
+	mockError := errors.New("mock conn begin error")
+	mockConn := newMockConn(nil, mockError)
+	mockStmt1 := newSQLStatement("INSERT INTO foo (foo, bar) VALUES ($1, $2)", "foo", "bar")
+	mockStmt2 := newSQLStatement("INSERT INTO bar (baz, bosh) VALUES ($1, $2)", "baz", "qux")
+
+	execer := newSQLExecer(mockConn, 0, nil, discardLogger)
+
+	err := execer.execMultiple(context.TODO(), mockStmt1, mockStmt2)
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+
+	if !mockConn.beginCalled {
+		t.Error("expected conn Begin() to be called, but was not")
+	}
+}
+
+func TestExecerRetriesTxOnSerializationFailure(t *testing.T) {
+	mockError := &backendError{errSerializationFailure, errors.New("mock serialization failure")}
+	mockTx := newMockTx(mockError, nil)
+	mockTx.execErrorLimit = 2
+	mockConn := newMockConn(mockTx, nil)
+	mockStmt := newSQLStatement("INSERT INTO foo (foo, bar) VALUES ($1, $2)", "foo", "bar")
+
+	var sleeps []time.Duration
+	execer := newSQLExecer(mockConn, 5, func(d time.Duration) {
+		sleeps = append(sleeps, d)
+	}, discardLogger)
+
+	if err := execer.execMultiple(context.TODO(), mockStmt); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if mockTx.execCalls != 3 {
+		t.Errorf("expected Tx Exec() to be called %d times, but was called %d times", 3, mockTx.execCalls)
+	}
+
+	if len(sleeps) != 2 || sleeps[1] != 2*sleeps[0] {
+		t.Errorf("expected 2 exponentially increasing backoffs, got %v", sleeps)
+	}
+
+	if !mockTx.commitCalled {
+		t.Error("expected Tx Commit() to be called, but was not")
+	}
+}
+
+func TestExecerErrorOnSerializationFailureRetriesExhausted(t *testing.T) {
+	mockError := &backendError{errSerializationFailure, errors.New("mock serialization failure")}
+	mockTx := newMockTx(mockError, nil)
+	mockConn := newMockConn(mockTx, nil)
+	mockStmt := newSQLStatement("INSERT INTO foo (foo, bar) VALUES ($1, $2)", "foo", "bar")
+
+	execer := newSQLExecer(mockConn, 2, func(time.Duration) {}, discardLogger)
+
+	err := execer.execMultiple(context.TODO(), mockStmt)
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, errSerializationFailure) {
+		t.Errorf("expected error chain to include %q, but did not", errSerializationFailure)
+	}
+
+	if mockTx.execCalls != 3 {
+		t.Errorf("expected Tx Exec() to be called %d times, but was called %d times", 3, mockTx.execCalls)
+	}
+}
+
+func TestExecerNoRetryOnOtherError(t *testing.T) {
+	mockError := errors.New("mock tx exec error")
+	mockTx := newMockTx(mockError, nil)
+	mockConn := newMockConn(mockTx, nil)
+	mockStmt := newSQLStatement("INSERT INTO foo (foo, bar) VALUES ($1, $2)", "foo", "bar")
+
+	execer := newSQLExecer(mockConn, 5, func(time.Duration) {
+		t.Error("expected no backoff, but slept")
+	}, discardLogger)
+
+	if err := execer.execMultiple(context.TODO(), mockStmt); !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, got %q", mockError, err)
+	}
+
+	if mockTx.execCalls != 1 {
+		t.Errorf("expected Tx Exec() to be called %d times, but was called %d times", 1, mockTx.execCalls)
+	}
+}
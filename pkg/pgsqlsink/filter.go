@@ -0,0 +1,413 @@
+package pgsqlsink
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/event"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+)
+
+const (
+	filterRuleSeparator      = ";"
+	filterCriterionSeparator = " "
+	filterValueSeparator     = ","
+)
+
+// EventFilter is an interface which describes objects which decide
+// whether a TCP state-change event is to be stored.
+type eventFilter interface {
+	include(event *event.Event) bool
+	filteredCounts() map[string]uint64
+}
+
+// RuleFilter decides whether a TCP state-change event is to be stored
+// based upon include and exclude rules. An event is stored if it matches
+// any of the include rules (or there are none) and none of the exclude rules.
+// A count of the events filtered out is kept for each reason.
+type ruleFilter struct {
+	includeRules []*filterRule
+	excludeRules []*filterRule
+
+	notIncludedCount uint64
+	excludedCounts   []uint64
+}
+
+func newRuleFilter(includeRules, excludeRules []*filterRule) *ruleFilter {
+	return &ruleFilter{
+		includeRules:   includeRules,
+		excludeRules:   excludeRules,
+		excludedCounts: make([]uint64, len(excludeRules)),
+	}
+}
+
+// Include returns whether the event is to be stored.
+func (f *ruleFilter) include(event *event.Event) bool {
+	if len(f.includeRules) != 0 && !matchesAny(f.includeRules, event) {
+		atomic.AddUint64(&f.notIncludedCount, 1)
+		return false
+	}
+
+	for i, rule := range f.excludeRules {
+		if rule.matches(event) {
+			atomic.AddUint64(&f.excludedCounts[i], 1)
+			return false
+		}
+	}
+
+	return true
+}
+
+// FilteredCounts returns the number of events filtered out, keyed by
+// the reason they were filtered out.
+func (f *ruleFilter) filteredCounts() map[string]uint64 {
+	counts := make(map[string]uint64, len(f.excludeRules)+1)
+	if len(f.includeRules) != 0 {
+		counts["not matching any include rule"] = atomic.LoadUint64(&f.notIncludedCount)
+	}
+
+	for i, rule := range f.excludeRules {
+		reason := fmt.Sprintf("matching exclude rule %q", rule.spec)
+		counts[reason] = atomic.LoadUint64(&f.excludedCounts[i])
+	}
+
+	return counts
+}
+
+func matchesAny(rules []*filterRule, event *event.Event) bool {
+	for _, rule := range rules {
+		if rule.matches(event) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PortRange is an inclusive range of TCP ports.
+type portRange struct {
+	from, to uint16
+}
+
+func (pr portRange) contains(port uint16) bool {
+	return port >= pr.from && port <= pr.to
+}
+
+// FilterRule matches TCP state-change events against a set of criteria.
+// An event matches the rule if it matches all of the criteria which are set,
+// and it matches a criterion if it matches any of the criterion's values.
+type filterRule struct {
+	spec string
+
+	nets, srcNets, dstNets    []*net.IPNet
+	ports, srcPorts, dstPorts []portRange
+	comms                     []*regexp.Regexp
+	pids                      []int
+	oldStates, newStates      []tcpstate.State
+	hasSocketInfo             *bool
+}
+
+func (r *filterRule) matches(event *event.Event) bool {
+	if r.nets != nil && !containsIP(r.nets, event.SourceIP) && !containsIP(r.nets, event.DestIP) {
+		return false
+	}
+
+	if r.srcNets != nil && !containsIP(r.srcNets, event.SourceIP) {
+		return false
+	}
+
+	if r.dstNets != nil && !containsIP(r.dstNets, event.DestIP) {
+		return false
+	}
+
+	if r.ports != nil && !containsPort(r.ports, event.SourcePort) && !containsPort(r.ports, event.DestPort) {
+		return false
+	}
+
+	if r.srcPorts != nil && !containsPort(r.srcPorts, event.SourcePort) {
+		return false
+	}
+
+	if r.dstPorts != nil && !containsPort(r.dstPorts, event.DestPort) {
+		return false
+	}
+
+	if r.comms != nil && !matchesComm(r.comms, event.CommandOnCPU) {
+		return false
+	}
+
+	if r.pids != nil && !containsPID(r.pids, event.PIDOnCPU) {
+		return false
+	}
+
+	if r.oldStates != nil && !containsState(r.oldStates, event.OldState) {
+		return false
+	}
+
+	if r.newStates != nil && !containsState(r.newStates, event.NewState) {
+		return false
+	}
+
+	if r.hasSocketInfo != nil && *r.hasSocketInfo != (event.SocketInfo != nil) {
+		return false
+	}
+
+	return true
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsPort(ranges []portRange, port uint16) bool {
+	for _, r := range ranges {
+		if r.contains(port) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesComm(comms []*regexp.Regexp, comm string) bool {
+	for _, c := range comms {
+		if c.MatchString(comm) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsPID(pids []int, pid int) bool {
+	for _, p := range pids {
+		if p == pid {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsState(states []tcpstate.State, state tcpstate.State) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseFilterRules parses a list of filter rules separated by semicolons.
+// Each rule is a list of space-separated criteria of the form key=value,
+// where the value may be a comma-separated list of alternatives. For example:
+//
+//	net=127.0.0.0/8,::1/128; comm=kworker/* dst-port=1024-65535
+//
+// The supported keys are:
+//
+//	net, src-net, dst-net     IP address or CIDR
+//	port, src-port, dst-port  port or inclusive port range (e.g. 1024-65535)
+//	comm                      on-CPU command glob ('*' and '?' are wildcards)
+//	comm-regex                on-CPU command regular expression
+//	pid                       on-CPU process ID
+//	old-state, new-state      TCP state (e.g. ESTABLISHED)
+//	socket-info               true or false, whether socket info is present
+func parseFilterRules(spec string) ([]*filterRule, error) {
+	var rules []*filterRule
+	for _, ruleSpec := range strings.Split(spec, filterRuleSeparator) {
+		ruleSpec = strings.TrimSpace(ruleSpec)
+		if ruleSpec == "" {
+			continue
+		}
+
+		rule, err := parseFilterRule(ruleSpec)
+		if err != nil {
+			return nil, fmt.Errorf("parsing filter rule %q: %w", ruleSpec, err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func parseFilterRule(spec string) (*filterRule, error) {
+	rule := &filterRule{spec: spec}
+	seen := make(map[string]bool)
+
+	for _, criterion := range strings.Split(spec, filterCriterionSeparator) {
+		if criterion == "" {
+			continue
+		}
+
+		keyValue := strings.SplitN(criterion, "=", 2)
+		if len(keyValue) != 2 || keyValue[1] == "" {
+			return nil, fmt.Errorf("criterion %q not of the form key=value", criterion)
+		}
+		key := keyValue[0]
+		values := strings.Split(keyValue[1], filterValueSeparator)
+
+		if seen[key] {
+			return nil, fmt.Errorf("criterion key %q repeated: give its values as a comma-separated list", key)
+		}
+		seen[key] = true
+
+		var err error
+		switch key {
+		case "net":
+			rule.nets, err = parseNets(values)
+		case "src-net":
+			rule.srcNets, err = parseNets(values)
+		case "dst-net":
+			rule.dstNets, err = parseNets(values)
+		case "port":
+			rule.ports, err = parsePortRanges(values)
+		case "src-port":
+			rule.srcPorts, err = parsePortRanges(values)
+		case "dst-port":
+			rule.dstPorts, err = parsePortRanges(values)
+		case "comm":
+			err = rule.appendComms(values, globToRegexp)
+		case "comm-regex":
+			err = rule.appendComms(values, func(value string) string { return value })
+		case "pid":
+			rule.pids, err = parsePIDs(values)
+		case "old-state":
+			rule.oldStates, err = parseStates(values)
+		case "new-state":
+			rule.newStates, err = parseStates(values)
+		case "socket-info":
+			var hasSocketInfo bool
+			hasSocketInfo, err = strconv.ParseBool(keyValue[1])
+			rule.hasSocketInfo = &hasSocketInfo
+		default:
+			return nil, fmt.Errorf("unknown criterion key %q", key)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s criterion: %w", key, err)
+		}
+	}
+
+	return rule, nil
+}
+
+// AppendComms compiles the command patterns, after conversion to regular
+// expressions, adding them to the rule.
+func (r *filterRule) appendComms(values []string, toRegexp func(string) string) error {
+	for _, value := range values {
+		re, err := regexp.Compile(toRegexp(value))
+		if err != nil {
+			return err
+		}
+
+		r.comms = append(r.comms, re)
+	}
+
+	return nil
+}
+
+// GlobToRegexp converts a glob, where '*' matches any sequence of characters
+// and '?' matches any single character, into an anchored regular expression.
+func globToRegexp(glob string) string {
+	quoted := regexp.QuoteMeta(glob)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	quoted = strings.ReplaceAll(quoted, `\?`, ".")
+	return "^" + quoted + "$"
+}
+
+func parseNets(values []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", value)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func parsePortRanges(values []string) ([]portRange, error) {
+	ranges := make([]portRange, 0, len(values))
+	for _, value := range values {
+		fromTo := strings.SplitN(value, "-", 2)
+
+		from, err := strconv.ParseUint(fromTo[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", fromTo[0])
+		}
+
+		to := from
+		if len(fromTo) == 2 {
+			if to, err = strconv.ParseUint(fromTo[1], 10, 16); err != nil {
+				return nil, fmt.Errorf("invalid port %q", fromTo[1])
+			}
+		}
+
+		if to < from {
+			return nil, fmt.Errorf("invalid port range %q", value)
+		}
+
+		ranges = append(ranges, portRange{uint16(from), uint16(to)})
+	}
+
+	return ranges, nil
+}
+
+func parsePIDs(values []string) ([]int, error) {
+	pids := make([]int, 0, len(values))
+	for _, value := range values {
+		pid, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PID %q", value)
+		}
+
+		pids = append(pids, pid)
+	}
+
+	return pids, nil
+}
+
+func parseStates(values []string) ([]tcpstate.State, error) {
+	states := make([]tcpstate.State, 0, len(values))
+	for _, value := range values {
+		state, err := tcpstate.FromString(strings.ToUpper(value))
+		if err != nil {
+			return nil, err
+		}
+
+		states = append(states, state)
+	}
+
+	return states, nil
+}
@@ -0,0 +1,165 @@
+package pgsqlsink
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/event"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/socketstate"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+)
+
+func newMockFilterEvent(srcIP, dstIP string, dstPort uint16, comm string) *event.Event {
+	return &event.Event{
+		Time:         time.Now(),
+		PIDOnCPU:     7337,
+		CommandOnCPU: comm,
+		SourceIP:     net.ParseIP(srcIP),
+		DestIP:       net.ParseIP(dstIP),
+		SourcePort:   49152,
+		DestPort:     dstPort,
+		OldState:     tcpstate.StateSynSent,
+		NewState:     tcpstate.StateEstablished,
+	}
+}
+
+func TestFilterRuleMatches(t *testing.T) {
+	mockEvent := newMockFilterEvent("192.168.1.3", "172.217.16.225", 443, "kworker/u8:2")
+	mockEvent.SocketInfo = &event.SocketInfo{SocketState: socketstate.StateConnected}
+
+	for spec, expectedMatch := range map[string]bool{
+		"net=172.217.0.0/16":           true,
+		"net=10.0.0.0/8":               false,
+		"src-net=192.168.1.3":          true,
+		"dst-net=192.168.1.3":          false,
+		"port=443":                     true,
+		"src-port=443":                 false,
+		"dst-port=1-1023":              true,
+		"dst-port=1024-65535":          false,
+		"comm=kworker/*":               true,
+		"comm=kworker":                 false,
+		"comm-regex=^kworker/u[0-9]+:": true,
+		"pid=1,7337":                   true,
+		"pid=1":                        false,
+		"old-state=syn-sent new-state=ESTABLISHED": true,
+		"new-state=CLOSED":                         false,
+		"socket-info=true":                         true,
+		"socket-info=false":                        false,
+		"dst-net=172.217.0.0/16 dst-port=80,443":   true,
+		"dst-net=172.217.0.0/16 dst-port=80":       false,
+	} {
+		rules, err := parseFilterRules(spec)
+		if err != nil {
+			t.Errorf("expected nil error parsing %q, got %q (of type %T)", spec, err, err)
+			continue
+		}
+
+		if len(rules) != 1 {
+			t.Errorf("expected %d rule parsing %q, got %d", 1, spec, len(rules))
+			continue
+		}
+
+		if match := rules[0].matches(mockEvent); match != expectedMatch {
+			t.Errorf("expected rule %q match to be %t, got %t", spec, expectedMatch, match)
+		}
+	}
+}
+
+func TestParseFilterRulesMultiple(t *testing.T) {
+	rules, err := parseFilterRules("net=127.0.0.0/8,::1; comm=kworker/* ;")
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if len(rules) != 2 {
+		t.Errorf("expected %d rules, got %d", 2, len(rules))
+	}
+}
+
+func TestParseFilterRulesError(t *testing.T) {
+	for _, spec := range []string{
+		"net",
+		"net=",
+		"bogus=1",
+		"net=not-an-ip",
+		"port=65536",
+		"port=1024-1",
+		"comm-regex=(",
+		"pid=one",
+		"new-state=HALF-OPEN",
+		"socket-info=maybe",
+		"port=22 port=443",
+		"comm=sshd comm=nginx",
+	} {
+		_, err := parseFilterRules(spec)
+		if err == nil {
+			t.Errorf("expected error parsing %q, got nil", spec)
+			continue
+		}
+
+		t.Logf("got error %q (of type %T)", err, err)
+	}
+}
+
+func TestParseFilterRuleRepeatedKey(t *testing.T) {
+	_, err := parseFilterRule("net=10.0.0.0/8 port=22 port=443")
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !strings.Contains(err.Error(), `"port"`) {
+		t.Errorf("expected error to name the repeated key, got %q", err)
+	}
+}
+
+func TestRuleFilterInclude(t *testing.T) {
+	includeRules, _ := parseFilterRules("dst-port=443")
+	excludeRules, _ := parseFilterRules("net=127.0.0.0/8; comm=kworker/*")
+	filter := newRuleFilter(includeRules, excludeRules)
+
+	for _, test := range []struct {
+		event           *event.Event
+		expectedInclude bool
+	}{
+		{newMockFilterEvent("192.168.1.3", "172.217.16.225", 443, "curl"), true},
+		{newMockFilterEvent("192.168.1.3", "172.217.16.225", 80, "curl"), false},
+		{newMockFilterEvent("127.0.0.1", "127.0.0.1", 443, "curl"), false},
+		{newMockFilterEvent("192.168.1.3", "172.217.16.225", 443, "kworker/u8:2"), false},
+		{newMockFilterEvent("127.0.0.1", "127.0.0.1", 443, "kworker/u8:2"), false},
+	} {
+		if include := filter.include(test.event); include != test.expectedInclude {
+			t.Errorf("expected include of event [%v] to be %t, got %t", test.event, test.expectedInclude, include)
+		}
+	}
+
+	counts := filter.filteredCounts()
+	t.Logf("got counts %v", counts)
+
+	expectedCounts := map[string]uint64{
+		"not matching any include rule":           1,
+		`matching exclude rule "net=127.0.0.0/8"`: 2,
+		`matching exclude rule "comm=kworker/*"`:  1,
+	}
+
+	for reason, expectedCount := range expectedCounts {
+		if counts[reason] != expectedCount {
+			t.Errorf("expected count for reason %q to be %d, got %d", reason, expectedCount, counts[reason])
+		}
+	}
+}
+
+func TestRuleFilterNoRulesIncludesAll(t *testing.T) {
+	filter := newRuleFilter(nil, nil)
+
+	if !filter.include(newMockFilterEvent("127.0.0.1", "127.0.0.1", 443, "kworker/u8:2")) {
+		t.Error("expected event to be included, but was not")
+	}
+
+	if len(filter.filteredCounts()) != 0 {
+		t.Errorf("expected no filtered counts, got %v", filter.filteredCounts())
+	}
+}
@@ -0,0 +1,238 @@
+package pgsqlsink
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+const (
+	enrichmentGeoIP = "geoip"
+
+	// How often the database files are checked for changes
+	geoIPReloadInterval = time.Minute
+)
+
+// GeoIPCountryRecord is the subset of a MaxMind country (or city) database
+// record which is stored.
+type geoIPCountryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// GeoIPASNRecord is the subset of a MaxMind ASN database record which is
+// stored.
+type geoIPASNRecord struct {
+	Number       uint32 `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// GeoIPDatabase is an interface which describes a MaxMind-format database.
+// It is satisfied by *maxminddb.Reader.
+type geoIPDatabase interface {
+	Lookup(ip net.IP, result interface{}) error
+	Close() error
+}
+
+func openMaxMindDatabase(path string) (geoIPDatabase, error) {
+	return maxminddb.Open(path)
+}
+
+// ReloadingGeoIPDatabase is a MaxMind-format database file which is reopened
+// when the file changes, so that database updates are picked up without
+// restarting the sink.
+type reloadingGeoIPDatabase struct {
+	path   string
+	open   func(path string) (geoIPDatabase, error)
+	now    func() time.Time
+	logger Logger
+
+	db          geoIPDatabase
+	modTime     time.Time
+	lastChecked time.Time
+}
+
+func newReloadingGeoIPDatabase(path string,
+	open func(path string) (geoIPDatabase, error),
+	now func() time.Time,
+	logger Logger) (*reloadingGeoIPDatabase, error) {
+	d := &reloadingGeoIPDatabase{
+		path:        path,
+		open:        open,
+		now:         now,
+		logger:      logger,
+		lastChecked: now(),
+	}
+
+	if err := d.reload(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Lookup looks up the IP address, decoding its record into the result.
+// If the file has changed, it is reopened first. Failing to reopen it is
+// logged rather than returned, as the previous database remains usable.
+func (d *reloadingGeoIPDatabase) lookup(ip net.IP, result interface{}) error {
+	if now := d.now(); now.Sub(d.lastChecked) >= geoIPReloadInterval {
+		d.lastChecked = now
+
+		if err := d.reload(); err != nil {
+			d.logger.Printf("Error reloading GeoIP database %s: %v", d.path, err)
+		}
+	}
+
+	return d.db.Lookup(ip, result)
+}
+
+// Reload opens the database file if it has been modified since it was last
+// opened, closing the previously opened database.
+func (d *reloadingGeoIPDatabase) reload() error {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return fmt.Errorf("getting database file info: %w", err)
+	}
+
+	if d.db != nil && info.ModTime().Equal(d.modTime) {
+		return nil
+	}
+
+	db, err := d.open(d.path)
+	if err != nil {
+		return fmt.Errorf("opening database file: %w", err)
+	}
+
+	if d.db != nil {
+		if err := d.db.Close(); err != nil {
+			d.logger.Printf("Error closing GeoIP database %s: %v", d.path, err)
+		}
+	}
+
+	d.db = db
+	d.modTime = info.ModTime()
+	return nil
+}
+
+// Close closes the currently opened database.
+func (d *reloadingGeoIPDatabase) close() error {
+	if err := d.db.Close(); err != nil {
+		return fmt.Errorf("closing GeoIP database %s: %w", d.path, err)
+	}
+
+	return nil
+}
+
+// GeoIPEnricher adds the country code and autonomous system of the source
+// and destination IP addresses to events, from local MaxMind-format
+// databases. Either database may be nil, in which case its information is
+// not added.
+type geoIPEnricher struct {
+	countryDB, asnDB *reloadingGeoIPDatabase
+}
+
+func newGeoIPEnricher(countryDB, asnDB *reloadingGeoIPDatabase) *geoIPEnricher {
+	return &geoIPEnricher{
+		countryDB: countryDB,
+		asnDB:     asnDB,
+	}
+}
+
+// NewGeoIPEnricherFromFiles returns a GeoIP enricher using the database files
+// at the given paths, either of which may be empty.
+func newGeoIPEnricherFromFiles(countryPath, asnPath string, logger Logger) (*geoIPEnricher, error) {
+	if countryPath == "" && asnPath == "" {
+		return nil, fmt.Errorf("neither a country nor an ASN database is configured")
+	}
+
+	var countryDB, asnDB *reloadingGeoIPDatabase
+	var err error
+	if countryPath != "" {
+		if countryDB, err = newReloadingGeoIPDatabase(countryPath, openMaxMindDatabase, time.Now, logger); err != nil {
+			return nil, fmt.Errorf("opening country database: %w", err)
+		}
+	}
+
+	if asnPath != "" {
+		if asnDB, err = newReloadingGeoIPDatabase(asnPath, openMaxMindDatabase, time.Now, logger); err != nil {
+			if countryDB != nil {
+				if closeErr := countryDB.close(); closeErr != nil {
+					logger.Printf("Error closing country database: %v", closeErr)
+				}
+			}
+
+			return nil, fmt.Errorf("opening ASN database: %w", err)
+		}
+	}
+
+	return newGeoIPEnricher(countryDB, asnDB), nil
+}
+
+func (e *geoIPEnricher) enrich(ctx context.Context, event *tcpEvent, socketInfo *socketInfo) {
+	if e.countryDB != nil {
+		event.srcCountry = e.country(event.srcIP)
+		event.dstCountry = e.country(event.dstIP)
+	}
+
+	if e.asnDB != nil {
+		event.srcASN, event.srcASOrg = e.asn(event.srcIP)
+		event.dstASN, event.dstASOrg = e.asn(event.dstIP)
+	}
+}
+
+// Close closes the databases. Both are closed even if closing the first
+// fails, and the first error is returned.
+func (e *geoIPEnricher) close() error {
+	var firstErr error
+	for _, db := range []*reloadingGeoIPDatabase{e.countryDB, e.asnDB} {
+		if db == nil {
+			continue
+		}
+
+		if err := db.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Country returns the ISO 3166-1 country code of the IP address, or nil if
+// it is not found (e.g. for private addresses).
+func (e *geoIPEnricher) country(ip net.IP) *string {
+	if ip == nil {
+		return nil
+	}
+
+	var record geoIPCountryRecord
+	if err := e.countryDB.lookup(ip, &record); err != nil || record.Country.ISOCode == "" {
+		return nil
+	}
+
+	return &record.Country.ISOCode
+}
+
+// ASN returns the number and organization of the autonomous system of the
+// IP address, or nils if it is not found.
+func (e *geoIPEnricher) asn(ip net.IP) (*int64, *string) {
+	if ip == nil {
+		return nil, nil
+	}
+
+	var record geoIPASNRecord
+	if err := e.asnDB.lookup(ip, &record); err != nil || record.Number == 0 {
+		return nil, nil
+	}
+
+	number := int64(record.Number)
+	if record.Organization == "" {
+		return &number, nil
+	}
+
+	return &number, &record.Organization
+}
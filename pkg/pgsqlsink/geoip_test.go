@@ -0,0 +1,242 @@
+package pgsqlsink
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// MockGeoIPDatabase returns records keyed by IP address, tagged with a
+// version so that reloads can be observed.
+type mockGeoIPDatabase struct {
+	version     string
+	closeCalled bool
+}
+
+func (db *mockGeoIPDatabase) Lookup(ip net.IP, result interface{}) error {
+	switch record := result.(type) {
+	case *geoIPCountryRecord:
+		if ip.Equal(net.ParseIP("172.217.16.225")) {
+			record.Country.ISOCode = "US" + db.version
+		}
+	case *geoIPASNRecord:
+		if ip.Equal(net.ParseIP("172.217.16.225")) {
+			record.Number = 15169
+			record.Organization = "GOOGLE" + db.version
+		}
+	default:
+		return errors.New("mock unexpected record type error")
+	}
+
+	return nil
+}
+
+func (db *mockGeoIPDatabase) Close() error {
+	db.closeCalled = true
+	return nil
+}
+
+func newMockGeoIPDatabaseFile(t *testing.T, modTime time.Time) string {
+	path := filepath.Join(t.TempDir(), "mock.mmdb")
+	if err := ioutil.WriteFile(path, []byte("mock"), 0644); err != nil {
+		t.Fatalf("test bootstrapping: unable to write mock database file: %v", err)
+	}
+
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("test bootstrapping: unable to set mock database file time: %v", err)
+	}
+
+	return path
+}
+
+func TestGeoIPEnricher(t *testing.T) {
+	path := newMockGeoIPDatabaseFile(t, time.Now())
+	open := func(path string) (geoIPDatabase, error) {
+		return new(mockGeoIPDatabase), nil
+	}
+
+	countryDB, err := newReloadingGeoIPDatabase(path, open, time.Now, discardLogger)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	asnDB, err := newReloadingGeoIPDatabase(path, open, time.Now, discardLogger)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	enricher := newGeoIPEnricher(countryDB, asnDB)
+	event := &tcpEvent{
+		srcIP: net.ParseIP("192.168.1.3"),
+		dstIP: net.ParseIP("172.217.16.225"),
+	}
+	enricher.enrich(context.TODO(), event, nil)
+
+	if event.srcCountry != nil || event.srcASN != nil || event.srcASOrg != nil {
+		t.Error("expected no GeoIP information for private source address, but was set")
+	}
+
+	if event.dstCountry == nil || *event.dstCountry != "US" {
+		t.Errorf("expected destination country %q, got %v", "US", event.dstCountry)
+	}
+
+	if event.dstASN == nil || *event.dstASN != 15169 {
+		t.Errorf("expected destination ASN %d, got %v", 15169, event.dstASN)
+	}
+
+	if event.dstASOrg == nil || *event.dstASOrg != "GOOGLE" {
+		t.Errorf("expected destination AS organization %q, got %v", "GOOGLE", event.dstASOrg)
+	}
+}
+
+func TestGeoIPEnricherCountryOnly(t *testing.T) {
+	path := newMockGeoIPDatabaseFile(t, time.Now())
+	countryDB, err := newReloadingGeoIPDatabase(path, func(path string) (geoIPDatabase, error) {
+		return new(mockGeoIPDatabase), nil
+	}, time.Now, discardLogger)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	enricher := newGeoIPEnricher(countryDB, nil)
+	event := &tcpEvent{dstIP: net.ParseIP("172.217.16.225")}
+	enricher.enrich(context.TODO(), event, nil)
+
+	if event.dstCountry == nil {
+		t.Error("expected destination country, got nil")
+	}
+
+	if event.dstASN != nil {
+		t.Errorf("expected nil destination ASN without ASN database, got %d", *event.dstASN)
+	}
+}
+
+func TestGeoIPEnricherClose(t *testing.T) {
+	path := newMockGeoIPDatabaseFile(t, time.Now())
+	var opened []*mockGeoIPDatabase
+	open := func(path string) (geoIPDatabase, error) {
+		db := new(mockGeoIPDatabase)
+		opened = append(opened, db)
+		return db, nil
+	}
+
+	countryDB, err := newReloadingGeoIPDatabase(path, open, time.Now, discardLogger)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	asnDB, err := newReloadingGeoIPDatabase(path, open, time.Now, discardLogger)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	enricher := newGeoIPEnricher(countryDB, asnDB)
+	if err := enricher.close(); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	for i, db := range opened {
+		if !db.closeCalled {
+			t.Errorf("expected database %d to be closed, but was not", i)
+		}
+	}
+}
+
+func TestReloadingGeoIPDatabaseReload(t *testing.T) {
+	modTime := time.Now().Add(-time.Hour)
+	path := newMockGeoIPDatabaseFile(t, modTime)
+
+	var opened []*mockGeoIPDatabase
+	open := func(path string) (geoIPDatabase, error) {
+		db := &mockGeoIPDatabase{version: string(rune('0' + len(opened)))}
+		opened = append(opened, db)
+		return db, nil
+	}
+
+	now := time.Now()
+	db, err := newReloadingGeoIPDatabase(path, open, func() time.Time { return now }, discardLogger)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	var record geoIPCountryRecord
+	ip := net.ParseIP("172.217.16.225")
+
+	// An unchanged file is not reopened
+	now = now.Add(geoIPReloadInterval)
+	if err := db.lookup(ip, &record); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if len(opened) != 1 {
+		t.Errorf("expected database to be opened %d time, got %d", 1, len(opened))
+	}
+
+	// A changed file is reopened, and the previous database closed
+	if err := os.Chtimes(path, modTime.Add(time.Minute), modTime.Add(time.Minute)); err != nil {
+		t.Fatalf("test bootstrapping: unable to set mock database file time: %v", err)
+	}
+
+	now = now.Add(geoIPReloadInterval)
+	if err := db.lookup(ip, &record); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if len(opened) != 2 {
+		t.Fatalf("expected database to be opened %d times, got %d", 2, len(opened))
+	}
+
+	if !opened[0].closeCalled {
+		t.Error("expected previous database to be closed, but was not")
+	}
+
+	if record.Country.ISOCode != "US1" {
+		t.Errorf("expected lookup from reopened database, got %q", record.Country.ISOCode)
+	}
+}
+
+func TestReloadingGeoIPDatabaseKeepsDatabaseOnReloadError(t *testing.T) {
+	modTime := time.Now().Add(-time.Hour)
+	path := newMockGeoIPDatabaseFile(t, modTime)
+
+	opens := 0
+	open := func(path string) (geoIPDatabase, error) {
+		opens++
+		if opens > 1 {
+			return nil, errors.New("mock open error")
+		}
+
+		return new(mockGeoIPDatabase), nil
+	}
+
+	now := time.Now()
+	db, err := newReloadingGeoIPDatabase(path, open, func() time.Time { return now }, discardLogger)
+	if err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if err := os.Chtimes(path, modTime.Add(time.Minute), modTime.Add(time.Minute)); err != nil {
+		t.Fatalf("test bootstrapping: unable to set mock database file time: %v", err)
+	}
+
+	now = now.Add(geoIPReloadInterval)
+	var record geoIPCountryRecord
+	if err := db.lookup(net.ParseIP("172.217.16.225"), &record); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if record.Country.ISOCode != "US" {
+		t.Errorf("expected lookup from previous database, got %q", record.Country.ISOCode)
+	}
+}
+
+func TestNewGeoIPEnricherFromFilesNoDatabases(t *testing.T) {
+	if _, err := newGeoIPEnricherFromFiles("", "", discardLogger); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
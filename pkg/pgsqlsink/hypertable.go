@@ -0,0 +1,88 @@
+package pgsqlsink
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultHypertableChunkInterval = 24 * time.Hour
+
+	// TimescaleExtensionCheckSQL fails with an undefined object error if the
+	// TimescaleDB extension is not installed in the database.
+	timescaleExtensionCheckSQL = `
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb') THEN
+		RAISE EXCEPTION 'timescaledb extension is not installed' USING ERRCODE = 'undefined_object';
+	END IF;
+END
+$$`
+)
+
+// HypertableConfig holds the configuration of the tcp_events hypertable.
+type hypertableConfig struct {
+	chunkInterval time.Duration
+
+	// CompressAfter is the age after which chunks are compressed.
+	// If zero, chunks are not compressed.
+	compressAfter time.Duration
+
+	// Retention is the age after which chunks are dropped.
+	// If zero, chunks are kept forever.
+	retention time.Duration
+}
+
+// IntervalSQL returns a SQL interval literal for the duration.
+// Literals are used as the parameters of DO blocks cannot be bound.
+func intervalSQL(d time.Duration) string {
+	return fmt.Sprintf("INTERVAL '%d microseconds'", d.Microseconds())
+}
+
+// CreateHypertableSQL returns the SQL to convert tcp_events into
+// a hypertable partitioned on the event timestamp, if it is not already.
+// A hypertable's unique constraints must include the partitioning column,
+// and plain tables cannot reference a hypertable by foreign key, so the
+// primary key is widened and the foreign keys of the related tables are
+// dropped first. Existing events are migrated into chunks.
+func createHypertableSQL(chunkInterval time.Duration) string {
+	return `
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM timescaledb_information.hypertables
+	               WHERE hypertable_schema = current_schema()
+	               AND hypertable_name = 'tcp_events') THEN
+		ALTER TABLE tcp_events_socket_info DROP CONSTRAINT IF EXISTS fk_tcp_events;
+		ALTER TABLE tcp_events_process_info DROP CONSTRAINT IF EXISTS fk_tcp_events;
+		ALTER TABLE tcp_events DROP CONSTRAINT IF EXISTS tcp_events_pkey;
+		ALTER TABLE tcp_events ADD PRIMARY KEY (uid, timestamp);
+		PERFORM create_hypertable('tcp_events', 'timestamp',
+			chunk_time_interval => ` + intervalSQL(chunkInterval) + `,
+			migrate_data => TRUE);
+	END IF;
+END
+$$`
+}
+
+// HypertableCompressionSQL returns the SQL to enable compression of the
+// tcp_events hypertable, segmented by host, and to add a policy compressing
+// chunks older than the given age.
+func hypertableCompressionSQL(compressAfter time.Duration) string {
+	return `
+DO $$
+BEGIN
+	IF NOT (SELECT compression_enabled FROM timescaledb_information.hypertables
+	        WHERE hypertable_schema = current_schema()
+	        AND hypertable_name = 'tcp_events') THEN
+		ALTER TABLE tcp_events SET (timescaledb.compress, timescaledb.compress_segmentby = 'host');
+	END IF;
+	PERFORM add_compression_policy('tcp_events', ` + intervalSQL(compressAfter) + `, if_not_exists => TRUE);
+END
+$$`
+}
+
+// HypertableRetentionSQL returns the SQL to add a policy dropping
+// tcp_events chunks older than the given age.
+func hypertableRetentionSQL(retention time.Duration) string {
+	return `SELECT add_retention_policy('tcp_events', ` + intervalSQL(retention) + `, if_not_exists => TRUE)`
+}
@@ -0,0 +1,80 @@
+package pgsqlsink
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+const (
+	indexMethodBTree  = "btree"
+	indexMethodBRIN   = "brin"
+	indexMethodSPGiST = "spgist"
+)
+
+// TableIndex describes an index which speeds up common queries of the
+// stored events.
+type tableIndex struct {
+	name    string
+	table   string
+	method  string
+	columns []string
+}
+
+// TableIndexes are the indexes created if enabled.
+// Events are appended in roughly timestamp order, so a BRIN index finds time
+// ranges at a fraction of the size of a B-tree. SP-GiST indexes on the
+// addresses support CIDR containment (e.g. dst_ip << '10.0.0.0/8').
+var tableIndexes = []*tableIndex{
+	{"tcp_events_time_idx", schema.EventsTable, indexMethodBRIN, []string{"timestamp"}},
+	{"tcp_events_src_ip_idx", schema.EventsTable, indexMethodSPGiST, []string{"src_ip"}},
+	{"tcp_events_dst_ip_idx", schema.EventsTable, indexMethodSPGiST, []string{"dst_ip"}},
+	{"tcp_events_local_ip_idx", schema.EventsTable, indexMethodSPGiST, []string{"local_ip"}},
+	{"tcp_events_remote_ip_idx", schema.EventsTable, indexMethodSPGiST, []string{"remote_ip"}},
+	{"tcp_events_dst_port_idx", schema.EventsTable, indexMethodBTree, []string{"dst_port"}},
+	{"tcp_events_comm_on_cpu_idx", schema.EventsTable, indexMethodBTree, []string{"comm_on_cpu"}},
+	{"tcp_events_socket_idx", schema.EventsTable, indexMethodBTree, []string{"host", "socket_id", "socket_inode"}},
+	{"tcp_events_chain_idx", schema.EventsTable, indexMethodBTree, []string{"host", "chain_seq"}},
+	{"tcp_events_socket_info_tcp_event_uid_idx", schema.SocketInfoTable, indexMethodBTree, []string{"tcp_event_uid"}},
+}
+
+// IndexCreateSQL returns the SQL to create the index using the given method,
+// if it does not already exist. B-trees are created without a USING clause,
+// as the default method of every compatible database.
+// Concurrent builds do not block inserts, but cannot be run in a
+// transaction.
+func indexCreateSQL(index *tableIndex, method string, concurrently bool) string {
+	var sql strings.Builder
+	sql.WriteString("CREATE INDEX ")
+	if concurrently {
+		sql.WriteString("CONCURRENTLY ")
+	}
+
+	fmt.Fprintf(&sql, "IF NOT EXISTS %s ON %s", index.name, index.table)
+	if method != indexMethodBTree {
+		fmt.Fprintf(&sql, " USING %s", method)
+	}
+
+	fmt.Fprintf(&sql, " (%s)", strings.Join(index.columns, ", "))
+	return sql.String()
+}
+
+// InvalidIndexDropSQL returns the SQL to drop the index if it is invalid.
+// A concurrent build which fails, or is interrupted, leaves an invalid index
+// behind, which would otherwise never be rebuilt as it already exists.
+func invalidIndexDropSQL(name string) string {
+	return fmt.Sprintf(`
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM pg_index i
+	           JOIN pg_class c ON c.oid = i.indexrelid
+	           JOIN pg_namespace n ON n.oid = c.relnamespace
+	           WHERE n.nspname = current_schema()
+	           AND c.relname = '%[1]s'
+	           AND NOT i.indisvalid) THEN
+		DROP INDEX %[1]s;
+	END IF;
+END
+$$`, name)
+}
@@ -0,0 +1,423 @@
+package pgsqlsink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/notify"
+)
+
+const (
+	// InsertTCPEventsTableSQL inserts an event and its related rows in a
+	// single statement, so that they are stored atomically in one round trip
+	// without an explicit transaction.
+	// The socket of the event is inserted into tcp_sockets if its ID ($32) is
+	// not NULL and it has not already been inserted by an earlier event. The
+	// state of the socket at the time of the event is stored with the event.
+	// The link of the event in the hash chain of its host ($37 and $38) is
+	// NULL if the chain is not enabled. The foreign keys are checked at the
+	// end of the statement, when all of the rows have been inserted.
+	// Neither RETURNING nor an ON CONFLICT target is used, as these require
+	// the SELECT privilege, and the sink may be run with INSERT only.
+	insertTCPEventsTableSQL = `
+WITH upserted_socket AS (
+	INSERT INTO tcp_sockets (
+		host,
+		id,
+		inode,
+		user_id,
+		group_id
+	)
+	SELECT $11::TEXT, $32::TEXT, $33::BIGINT, $34::BIGINT, $35::BIGINT
+	WHERE $32::TEXT IS NOT NULL
+	ON CONFLICT DO NOTHING
+), inserted_event AS (
+	INSERT INTO tcp_events (
+		uid,
+		timestamp,
+		pid_on_cpu,
+		comm_on_cpu,
+		src_ip,
+		dst_ip,
+		src_port,
+		dst_port,
+		old_state,
+		new_state,
+		host,
+		sample_rate,
+		src_name,
+		dst_name,
+		src_service,
+		dst_service,
+		container_id,
+		pod_namespace,
+		pod_name,
+		container_name,
+		src_country,
+		dst_country,
+		src_asn,
+		dst_asn,
+		src_as_org,
+		dst_as_org,
+		direction,
+		local_ip,
+		local_port,
+		remote_ip,
+		remote_port,
+		socket_id,
+		socket_inode,
+		socket_state,
+		chain_seq,
+		chain_hash
+	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20,
+		$21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31, $32, $33, $36, $37, $38)
+)`
+
+	// InsertTCPEventsTableSQLProcessInfo follows the insert statement if
+	// process info is enabled, inserting the process info if $48 is true.
+	// It is only included if enabled so that the sink otherwise requires no
+	// privilege on tcp_events_process_info.
+	insertTCPEventsTableSQLProcessInfo = `, inserted_process_info AS (
+	INSERT INTO tcp_events_process_info (
+		tcp_event_uid,
+		cmdline,
+		exe,
+		parent_pid,
+		user_id,
+		user_name,
+		cgroup,
+		container_id,
+		socket_user_name,
+		socket_group_name
+	)
+	SELECT $1::TEXT, $39::TEXT, $40::TEXT, $41::INTEGER, $42::BIGINT, $43::TEXT, $44::TEXT, $45::TEXT, $46::TEXT, $47::TEXT
+	WHERE $48::BOOLEAN
+)`
+
+	// The final query of the insert statement when no notification is sent
+	insertTCPEventsTableSQLResult = `
+SELECT 1`
+
+	// The final query of the insert statement when a notification is sent,
+	// which is sent only if the statement succeeds. Its channel and payload
+	// are the last arguments, which are numbered after the process info, if
+	// enabled.
+	insertTCPEventsTableSQLNotify = `
+SELECT pg_notify($%d, $%d)`
+
+	insertTCPEventsTableSQLStmtName = "tcp_events_insert"
+
+	// The number of arguments of the insert statement without process info,
+	// and the number of process info arguments
+	insertTCPEventsTableArgs = 38
+	insertProcessInfoArgs    = 10
+
+	insertSamplingSummaryTableSQL = `
+INSERT INTO tcp_events_sampling_summary (
+	uid,
+	host,
+	mode,
+	period_start,
+	period_end,
+	seen,
+	stored,
+	suppressed
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+)
+
+// Inserter is an interface which describes objects which inserts
+// TCP state-change data into the backing store.
+type inserter interface {
+	prepare(ctx context.Context) error
+	insert(ctx context.Context, event *tcpEvent, socketInfo *socketInfo) error
+	insertSamplingSummary(ctx context.Context, summary *samplingSummary) error
+	close(ctx context.Context) error
+}
+
+// PreparedStatementInserter inserts TCP state-change data into the
+// database using a SQL prepared statement.
+// If named statements are not used, the SQL is executed directly instead,
+// so that no statements are kept on the server between transactions.
+// If a notification channel is set, a notification describing each event
+// is sent on the channel when the insert is committed.
+// An event and its related rows are inserted by a single statement, rather
+// than a transaction of one statement per table.
+// If process info is enabled, the process info of each event is inserted
+// into tcp_events_process_info.
+// If the hash chain is non-nil, each event is linked to the previous event
+// of its host.
+type preparedStatementInserter struct {
+	execer          execer
+	stmtPreparer    statementPreparer
+	notifyChannel   string
+	namedStatements bool
+	processInfo     bool
+	chain           *hashChain
+}
+
+func newPreparedStatementInserter(stmtPreparer statementPreparer,
+	execer execer,
+	notifyChannel string,
+	namedStatements bool,
+	processInfo bool,
+	chain *hashChain) *preparedStatementInserter {
+	return &preparedStatementInserter{
+		stmtPreparer:    stmtPreparer,
+		execer:          execer,
+		notifyChannel:   notifyChannel,
+		namedStatements: namedStatements,
+		processInfo:     processInfo,
+		chain:           chain,
+	}
+}
+
+// Prepare prepares the SQL insert statement for future use in the insert
+// method, if named statements are used.
+func (i *preparedStatementInserter) prepare(ctx context.Context) error {
+	if !i.namedStatements {
+		return nil
+	}
+
+	if err := i.stmtPreparer.prepareStatement(ctx,
+		i.insertSQL(),
+		insertTCPEventsTableSQLStmtName); err != nil {
+		return fmt.Errorf("preparing insert tcp_events statement: %w", err)
+	}
+
+	return nil
+}
+
+// InsertSQL returns the SQL of the insert statement, which inserts process
+// info if enabled, and sends a notification if a notification channel is set.
+func (i *preparedStatementInserter) insertSQL() string {
+	sql := insertTCPEventsTableSQL
+	arguments := insertTCPEventsTableArgs
+	if i.processInfo {
+		sql += insertTCPEventsTableSQLProcessInfo
+		arguments += insertProcessInfoArgs
+	}
+
+	if i.notifyChannel != "" {
+		return sql + fmt.Sprintf(insertTCPEventsTableSQLNotify, arguments+1, arguments+2)
+	}
+
+	return sql + insertTCPEventsTableSQLResult
+}
+
+// Statement returns the statement passed to the execer to execute the
+// given SQL: the name of the prepared statement if named statements are
+// used, or the SQL itself if not.
+func (i *preparedStatementInserter) statement(sql, name string) string {
+	if i.namedStatements {
+		return name
+	}
+
+	return sql
+}
+
+// Insert uses the prepared SQL insert statement created in the prepare
+// method to insert TCP state-change data into the database, in a single
+// round trip.
+func (i *preparedStatementInserter) insert(ctx context.Context,
+	event *tcpEvent,
+	socketInfo *socketInfo) error {
+	// The time is stored to the microsecond, so it is truncated before it is
+	// hashed for the chain to match the stored event
+	if i.chain != nil {
+		event.time = event.time.Truncate(time.Microsecond)
+	}
+
+	// Endpoints which could not be decided are stored as NULL
+	var direction, localIP, localPort, remoteIP, remotePort interface{}
+	if endpoints := event.endpoints; endpoints != nil {
+		direction = endpoints.direction
+		localIP, localPort = storageIP(endpoints.localIP), endpoints.localPort
+		remoteIP, remotePort = storageIP(endpoints.remoteIP), endpoints.remotePort
+	}
+
+	arguments := []interface{}{
+		event.uid,
+		event.time,
+		event.pid,
+		event.comm,
+		event.srcIP.To4(),
+		event.dstIP.To4(),
+		event.srcPort,
+		event.dstPort,
+		event.oldState,
+		event.newState,
+		event.host,
+		event.sampleRate,
+		event.srcName,
+		event.dstName,
+		event.srcService,
+		event.dstService,
+		event.containerID,
+		event.podNamespace,
+		event.podName,
+		event.containerName,
+		event.srcCountry,
+		event.dstCountry,
+		event.srcASN,
+		event.dstASN,
+		event.srcASOrg,
+		event.dstASOrg,
+		direction,
+		localIP,
+		localPort,
+		remoteIP,
+		remotePort,
+	}
+
+	// Absent socket info is passed as NULLs, so that no socket is referenced
+	if socketInfo != nil {
+		arguments = append(arguments,
+			socketInfo.id,
+			socketInfo.iNode,
+			socketInfo.userID,
+			socketInfo.groupID,
+			socketInfo.state)
+	} else {
+		arguments = append(arguments, nil, nil, nil, nil, nil)
+	}
+
+	// Process info which is not inserted is chained as NULLs
+	processInfoArgs := processInfoArguments(nil)
+	if i.processInfo {
+		processInfoArgs = processInfoArguments(event.processInfo)
+	}
+
+	var link *chainLink
+	if i.chain != nil {
+		// The chained columns are those of the event, then the owners of its
+		// socket, then its process info
+		values := append([]interface{}{}, arguments[:33]...)
+		values = append(values, arguments[35], arguments[33], arguments[34])
+		values = append(values, processInfoArgs[:len(chainedProcessInfoColumns)]...)
+
+		var err error
+		if link, err = i.chain.next(ctx, event.host, values); err != nil {
+			return fmt.Errorf("linking event to hash chain: %w", err)
+		}
+
+		arguments = append(arguments, link.seq, link.hash)
+	} else {
+		arguments = append(arguments, nil, nil)
+	}
+
+	if i.processInfo {
+		arguments = append(arguments, processInfoArgs...)
+	}
+
+	if i.notifyChannel != "" {
+		encodedPayload, err := notificationPayload(event, socketInfo).Encode()
+		if err != nil {
+			return fmt.Errorf("encoding notification payload: %w", err)
+		}
+
+		arguments = append(arguments, i.notifyChannel, encodedPayload)
+	}
+
+	if err := i.execer.exec(ctx,
+		i.statement(i.insertSQL(), insertTCPEventsTableSQLStmtName),
+		arguments...); err != nil {
+		if i.chain != nil {
+			i.chain.reset(event.host)
+		}
+
+		return fmt.Errorf("inserting into tcp_events and related tables: %w", err)
+	}
+
+	if i.chain != nil {
+		i.chain.append(ctx, event.host, link)
+	}
+
+	return nil
+}
+
+// ProcessInfoArguments returns the arguments of the insert statement which
+// insert the process info. Absent process info is passed as NULLs, and not
+// inserted.
+func processInfoArguments(processInfo *processInfo) []interface{} {
+	if processInfo == nil {
+		return []interface{}{nil, nil, nil, nil, nil, nil, nil, nil, nil, false}
+	}
+
+	return []interface{}{
+		processInfo.cmdline,
+		processInfo.exe,
+		processInfo.parentPID,
+		processInfo.userID,
+		processInfo.userName,
+		processInfo.cgroup,
+		processInfo.containerID,
+		processInfo.socketUserName,
+		processInfo.socketGroupName,
+		true,
+	}
+}
+
+// NotificationPayload returns the payload of the notification describing
+// the event.
+func notificationPayload(event *tcpEvent, socketInfo *socketInfo) *notify.Payload {
+	payload := &notify.Payload{
+		UID:       event.uid,
+		Host:      event.host,
+		Time:      event.time,
+		PIDOnCPU:  event.pid,
+		CommOnCPU: event.comm,
+		SrcIP:     event.srcIP.To4(),
+		DstIP:     event.dstIP.To4(),
+		SrcPort:   event.srcPort,
+		DstPort:   event.dstPort,
+		OldState:  event.oldState,
+		NewState:  event.newState,
+	}
+
+	if socketInfo != nil {
+		payload.SocketInfo = &notify.SocketInfoPayload{
+			ID:      socketInfo.id,
+			INode:   socketInfo.iNode,
+			UserID:  socketInfo.userID,
+			GroupID: socketInfo.groupID,
+			State:   socketInfo.state,
+		}
+	}
+
+	return payload
+}
+
+// InsertSamplingSummary inserts a summary of the events seen and stored
+// by the sampler during a period.
+// This is infrequent, so a prepared statement is not used.
+func (i *preparedStatementInserter) insertSamplingSummary(ctx context.Context,
+	summary *samplingSummary) error {
+	if err := i.execer.exec(ctx,
+		insertSamplingSummaryTableSQL,
+		summary.uid,
+		summary.host,
+		summary.mode,
+		summary.periodStart,
+		summary.periodEnd,
+		int64(summary.seen),
+		int64(summary.stored),
+		int64(summary.seen-summary.stored)); err != nil {
+		return fmt.Errorf("inserting into tcp_events_sampling_summary: %w", err)
+	}
+
+	return nil
+}
+
+// Close releases the resources held by this Inserter.
+func (i *preparedStatementInserter) close(ctx context.Context) error {
+	if i.chain != nil {
+		i.chain.close(ctx)
+	}
+
+	if err := i.execer.close(ctx); err != nil {
+		return fmt.Errorf("closing execer: %w", err)
+	}
+
+	return nil
+}
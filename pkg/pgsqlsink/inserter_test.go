@@ -0,0 +1,757 @@
+package pgsqlsink
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/socketstate"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/notify"
+)
+
+const (
+	expectedNumberOfPreparedStmts = 1
+
+	// The offsets of the related rows in the arguments of the insert statement
+	socketInfoArgsOffset  = 31
+	chainArgsOffset       = 36
+	processInfoArgsOffset = 38
+	notifyArgsOffset      = 38
+)
+
+type mockStatementPreparer struct {
+	errorToReturn    error
+	errorReturnDelay int
+
+	callCount                 int
+	prepareStatementCalled    bool
+	receivedSQLs              []string
+	receivedPreparedStmtNames []string
+}
+
+func newMockStatementPreparer(errorToReturn error, errorReturnDelay int) *mockStatementPreparer {
+	return &mockStatementPreparer{
+		errorToReturn:             errorToReturn,
+		errorReturnDelay:          errorReturnDelay,
+		receivedSQLs:              []string{},
+		receivedPreparedStmtNames: []string{},
+	}
+}
+
+func (msp *mockStatementPreparer) prepareStatement(ctx context.Context,
+	sql string,
+	name string) error {
+	defer func() {
+		msp.callCount++
+	}()
+
+	msp.prepareStatementCalled = true
+	msp.receivedSQLs = append(msp.receivedSQLs, sql)
+	msp.receivedPreparedStmtNames = append(msp.receivedPreparedStmtNames, name)
+
+	if msp.errorToReturn != nil && msp.callCount >= msp.errorReturnDelay {
+		return msp.errorToReturn
+	}
+
+	return nil
+}
+
+type mockExecer struct {
+	errorToReturn error
+
+	execCalled         bool
+	execMultipleCalled bool
+	closeCalled        bool
+	receivedSQL        string
+	receivedArgs       []interface{}
+	receivedStmts      []*sqlStatement
+}
+
+func newMockExecer(errorToReturn error) *mockExecer {
+	return &mockExecer{errorToReturn: errorToReturn}
+}
+
+func (me *mockExecer) exec(ctx context.Context,
+	sql string,
+	arguments ...interface{}) error {
+
+	me.execCalled = true
+	me.receivedSQL = sql
+	me.receivedArgs = arguments
+
+	if me.errorToReturn != nil {
+		return me.errorToReturn
+	}
+
+	return nil
+}
+
+func (me *mockExecer) execMultiple(ctx context.Context, stmts ...*sqlStatement) error {
+	me.execMultipleCalled = true
+	me.receivedStmts = stmts
+
+	if me.errorToReturn != nil {
+		return me.errorToReturn
+	}
+
+	return nil
+}
+
+func (me *mockExecer) close(ctx context.Context) error {
+	me.closeCalled = true
+
+	if me.errorToReturn != nil {
+		return me.errorToReturn
+	}
+
+	return nil
+}
+
+func TestInsertNoSocketInfo(t *testing.T) {
+	mockStmtPreparer := newMockStatementPreparer(nil, 0)
+	mockExecer := newMockExecer(nil)
+	mockEvent := &tcpEvent{
+		uid:        "mock-uid",
+		host:       "mock-host",
+		time:       time.Now(),
+		pid:        7337,
+		comm:       "mock-command",
+		srcIP:      net.ParseIP("1.2.3.4"),
+		dstIP:      net.ParseIP("7.3.3.7"),
+		srcPort:    1234,
+		dstPort:    7337,
+		oldState:   "mock-old-state",
+		newState:   "mock-new-state",
+		sampleRate: 1,
+	}
+	var mockSocketInfo *socketInfo
+
+	inserter := newPreparedStatementInserter(mockStmtPreparer, mockExecer, "", true, false, nil)
+
+	if err := inserter.insert(context.TODO(), mockEvent, mockSocketInfo); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockExecer.execCalled {
+		t.Error("expected execer exec() to be called, but was not")
+	}
+
+	if mockExecer.receivedSQL == "" {
+		t.Error("expected execer to receive non-empty prepared statement name, but was empty")
+	}
+	t.Logf("execer received prepared statement name: %q", mockExecer.receivedSQL)
+
+	if mockExecer.receivedArgs == nil || len(mockExecer.receivedArgs) == 0 {
+		t.Error("expected execer to receive non-empty arguments, but was empty")
+	}
+}
+
+func TestInsertWithSocketInfo(t *testing.T) {
+	mockStmtPreparer := newMockStatementPreparer(nil, 0)
+	mockExecer := newMockExecer(nil)
+	mockEvent := &tcpEvent{
+		uid:        "mock-uid",
+		host:       "mock-host",
+		time:       time.Now(),
+		pid:        7337,
+		comm:       "mock-command",
+		srcIP:      net.ParseIP("1.2.3.4"),
+		dstIP:      net.ParseIP("7.3.3.7"),
+		srcPort:    1234,
+		dstPort:    7337,
+		oldState:   "mock-old-state",
+		newState:   "mock-new-state",
+		sampleRate: 1,
+	}
+	mockSocketInfo := &socketInfo{
+		id:      "mock-socket-id",
+		iNode:   0xF00DF00D,
+		userID:  0xCAFEBABE,
+		groupID: 0xDEADBEEF,
+		state:   "mock-socket-state",
+	}
+
+	inserter := newPreparedStatementInserter(mockStmtPreparer, mockExecer, "", true, false, nil)
+
+	if err := inserter.insert(context.TODO(), mockEvent, mockSocketInfo); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockExecer.execCalled {
+		t.Error("expected execer exec() to be called, but was not")
+	}
+
+	if mockExecer.execMultipleCalled {
+		t.Error("expected execer execMultiple() to not be called, but was")
+	}
+
+	if mockExecer.receivedSQL != insertTCPEventsTableSQLStmtName {
+		t.Errorf("expected execer to receive prepared statement name %q, but received %q",
+			insertTCPEventsTableSQLStmtName,
+			mockExecer.receivedSQL)
+	}
+
+	if len(mockExecer.receivedArgs) != notifyArgsOffset {
+		t.Fatalf("expected execer to receive %d arguments, but received %d",
+			notifyArgsOffset,
+			len(mockExecer.receivedArgs))
+	}
+
+	if mockExecer.receivedArgs[socketInfoArgsOffset] != mockSocketInfo.id {
+		t.Errorf("expected socket ID to be passed, but was %v", mockExecer.receivedArgs[socketInfoArgsOffset])
+	}
+
+	if mockExecer.receivedArgs[socketInfoArgsOffset+1] != mockSocketInfo.iNode {
+		t.Errorf("expected socket inode to be passed, but was %v", mockExecer.receivedArgs[socketInfoArgsOffset+1])
+	}
+
+	if mockExecer.receivedArgs[socketInfoArgsOffset+4] != mockSocketInfo.state {
+		t.Errorf("expected socket state to be passed, but was %v", mockExecer.receivedArgs[socketInfoArgsOffset+4])
+	}
+
+	if strings.Contains(inserter.insertSQL(), "tcp_events_process_info") {
+		t.Errorf("expected process info to not be inserted, but was")
+	}
+}
+
+func TestInsertErrorNoSocketInfo(t *testing.T) {
+	mockStmtPreparer := newMockStatementPreparer(nil, 0)
+	mockError := errors.New("mock exec error")
+	mockExecer := newMockExecer(mockError)
+	mockEvent := &tcpEvent{
+		uid:        "mock-uid",
+		host:       "mock-host",
+		time:       time.Now(),
+		pid:        7337,
+		comm:       "mock-command",
+		srcIP:      net.ParseIP("1.2.3.4"),
+		dstIP:      net.ParseIP("7.3.3.7"),
+		srcPort:    1234,
+		dstPort:    7337,
+		oldState:   "mock-old-state",
+		newState:   "mock-new-state",
+		sampleRate: 1,
+	}
+	var mockSocketInfo *socketInfo
+
+	inserter := newPreparedStatementInserter(mockStmtPreparer, mockExecer, "", true, false, nil)
+
+	err := inserter.insert(context.TODO(), mockEvent, mockSocketInfo)
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+func TestInsertErrorWithSocketInfo(t *testing.T) {
+	mockStmtPreparer := newMockStatementPreparer(nil, 0)
+	mockError := errors.New("mock exec error")
+	mockExecer := newMockExecer(mockError)
+	mockEvent := &tcpEvent{
+		uid:        "mock-uid",
+		host:       "mock-host",
+		time:       time.Now(),
+		pid:        7337,
+		comm:       "mock-command",
+		srcIP:      net.ParseIP("1.2.3.4"),
+		dstIP:      net.ParseIP("7.3.3.7"),
+		srcPort:    1234,
+		dstPort:    7337,
+		oldState:   "mock-old-state",
+		newState:   "mock-new-state",
+		sampleRate: 1,
+	}
+	mockSocketInfo := &socketInfo{
+		id:      "mock-socket-id",
+		iNode:   0xF00DF00D,
+		userID:  0xCAFEBABE,
+		groupID: 0xDEADBEEF,
+		state:   "mock-socket-state",
+	}
+
+	inserter := newPreparedStatementInserter(mockStmtPreparer, mockExecer, "", true, false, nil)
+
+	err := inserter.insert(context.TODO(), mockEvent, mockSocketInfo)
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+func TestInserterPrepare(t *testing.T) {
+	mockStmtPreparer := newMockStatementPreparer(nil, 0)
+	mockExecer := newMockExecer(nil)
+
+	inserter := newPreparedStatementInserter(mockStmtPreparer, mockExecer, "", true, false, nil)
+	if err := inserter.prepare(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockStmtPreparer.prepareStatementCalled {
+		t.Error("expected statementPreparer to be called, but was not")
+	}
+
+	if mockStmtPreparer.receivedPreparedStmtNames == nil || len(mockStmtPreparer.receivedPreparedStmtNames) == 0 {
+		t.Error("expected statementPreparer to receive non-empty prepared statement names, but was empty")
+	}
+
+	if mockStmtPreparer.receivedSQLs == nil || len(mockStmtPreparer.receivedSQLs) == 0 {
+		t.Error("expected statementPreparer to receive non-empty SQL statements, but was empty")
+	}
+
+	if len(mockStmtPreparer.receivedPreparedStmtNames) != len(mockStmtPreparer.receivedSQLs) {
+		t.Error("expected statementPreparer to receive same number of SQL statements as prepared statement names, but did not")
+	}
+
+	if len(mockStmtPreparer.receivedPreparedStmtNames) != expectedNumberOfPreparedStmts &&
+		len(mockStmtPreparer.receivedSQLs) != expectedNumberOfPreparedStmts {
+		t.Errorf("expected execer to receive %d statements to prepare, but received %d",
+			expectedNumberOfPreparedStmts,
+			len(mockStmtPreparer.receivedPreparedStmtNames))
+	}
+
+	for i, receivedStmtName := range mockStmtPreparer.receivedPreparedStmtNames {
+		j := i + 1
+
+		if receivedStmtName == "" {
+			t.Errorf("expected statementPreparer to receive non-empty prepared statement name for statement %d, but was empty", j)
+		}
+
+		t.Logf("statementPreparer received prepared statement name for statement %d: %q", j, receivedStmtName)
+	}
+
+	for i, receivedSQL := range mockStmtPreparer.receivedSQLs {
+		j := i + 1
+
+		if receivedSQL == "" {
+			t.Errorf("expected statementPreparer to receive non-empty SQL for statement %d, but was empty", j)
+		}
+
+		t.Logf("statementPreparer received SQL for statement %d: %q", j, receivedSQL)
+	}
+}
+
+func TestInserterPrepareStatementPreparerError(t *testing.T) {
+	mockError := errors.New("mock statement preparer error")
+
+	// Ensure the error return is tested for all prepared statements
+	for i := 0; i < expectedNumberOfPreparedStmts; i++ {
+		mockStmtPreparer := newMockStatementPreparer(mockError, i)
+		mockExecer := newMockExecer(nil)
+		inserter := newPreparedStatementInserter(mockStmtPreparer, mockExecer, "", true, false, nil)
+
+		err := inserter.prepare(context.TODO())
+		if err == nil {
+			t.Error("expected error, got nil")
+		}
+
+		t.Logf("got error %q (of type %T)", err, err)
+
+		if !errors.Is(err, mockError) {
+			t.Errorf("expected error chain to include %q, but did not", mockError)
+		}
+	}
+}
+
+func TestInserterClose(t *testing.T) {
+	mockStmtPreparer := newMockStatementPreparer(nil, 0)
+	mockExecer := newMockExecer(nil)
+
+	inserter := newPreparedStatementInserter(mockStmtPreparer, mockExecer, "", true, false, nil)
+
+	if err := inserter.close(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockExecer.closeCalled {
+		t.Error("expected execer to be closed, but was not")
+	}
+}
+
+func TestInserterCloseError(t *testing.T) {
+	mockStmtPreparer := newMockStatementPreparer(nil, 0)
+	mockError := errors.New("mock exec close error")
+	mockExecer := newMockExecer(mockError)
+
+	inserter := newPreparedStatementInserter(mockStmtPreparer, mockExecer, "", true, false, nil)
+
+	err := inserter.close(context.TODO())
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+func TestInsertWithNotify(t *testing.T) {
+	mockStmtPreparer := newMockStatementPreparer(nil, 0)
+	mockExecer := newMockExecer(nil)
+	mockChannel := "mock-channel"
+	mockEvent := &tcpEvent{
+		uid:        "mock-uid",
+		host:       "mock-host",
+		time:       time.Now(),
+		pid:        7337,
+		comm:       "mock-command",
+		srcIP:      net.ParseIP("1.2.3.4"),
+		dstIP:      net.ParseIP("7.3.3.7"),
+		srcPort:    1234,
+		dstPort:    7337,
+		oldState:   "CLOSED",
+		newState:   "SYN-SENT",
+		sampleRate: 1,
+	}
+	var mockSocketInfo *socketInfo
+
+	inserter := newPreparedStatementInserter(mockStmtPreparer, mockExecer, mockChannel, true, false, nil)
+
+	if err := inserter.insert(context.TODO(), mockEvent, mockSocketInfo); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockExecer.execCalled {
+		t.Fatal("expected execer exec() to be called, but was not")
+	}
+
+	if len(mockExecer.receivedArgs) != notifyArgsOffset+2 {
+		t.Fatalf("expected execer to receive %d arguments, but received %d",
+			notifyArgsOffset+2,
+			len(mockExecer.receivedArgs))
+	}
+
+	if mockExecer.receivedArgs[notifyArgsOffset] != mockChannel {
+		t.Errorf("expected notification channel to be %q, but was %q",
+			mockChannel,
+			mockExecer.receivedArgs[notifyArgsOffset])
+	}
+
+	notifiedEvent, err := notify.Decode(mockExecer.receivedArgs[notifyArgsOffset+1].(string))
+	if err != nil {
+		t.Fatalf("expected nil error decoding payload, got %q (of type %T)", err, err)
+	}
+
+	if notifiedEvent.UID != mockEvent.uid {
+		t.Errorf("expected notification UID to be %q, but was %q", mockEvent.uid, notifiedEvent.UID)
+	}
+
+	if !notifiedEvent.DestIP.Equal(mockEvent.dstIP) {
+		t.Errorf("expected notification destination IP to be %q, but was %q", mockEvent.dstIP, notifiedEvent.DestIP)
+	}
+}
+
+func TestInserterPrepareWithNotify(t *testing.T) {
+	mockStmtPreparer := newMockStatementPreparer(nil, 0)
+	mockExecer := newMockExecer(nil)
+
+	inserter := newPreparedStatementInserter(mockStmtPreparer, mockExecer, "mock-channel", true, false, nil)
+	if err := inserter.prepare(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if len(mockStmtPreparer.receivedSQLs) != expectedNumberOfPreparedStmts {
+		t.Fatalf("expected %d statements to be prepared, but %d were",
+			expectedNumberOfPreparedStmts,
+			len(mockStmtPreparer.receivedSQLs))
+	}
+
+	if !strings.Contains(mockStmtPreparer.receivedSQLs[0], "pg_notify($39, $40)") {
+		t.Errorf("expected prepared statement to send notification, but did not: %q",
+			mockStmtPreparer.receivedSQLs[0])
+	}
+}
+
+func TestInsertWithProcessInfo(t *testing.T) {
+	mockStmtPreparer := newMockStatementPreparer(nil, 0)
+	mockExecer := newMockExecer(nil)
+	mockCmdline := "curl https://example.com"
+	mockEvent := &tcpEvent{
+		uid:         "mock-uid",
+		host:        "mock-host",
+		time:        time.Now(),
+		pid:         7337,
+		comm:        "curl",
+		srcIP:       net.ParseIP("1.2.3.4"),
+		dstIP:       net.ParseIP("7.3.3.7"),
+		srcPort:     1234,
+		dstPort:     443,
+		oldState:    "SYN-SENT",
+		newState:    "ESTABLISHED",
+		sampleRate:  1,
+		processInfo: &processInfo{cmdline: &mockCmdline},
+	}
+
+	inserter := newPreparedStatementInserter(mockStmtPreparer, mockExecer, "mock-channel", true, true, nil)
+	if err := inserter.prepare(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if err := inserter.insert(context.TODO(), mockEvent, nil); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	// The notification follows the process info
+	expectedArgs := processInfoArgsOffset + insertProcessInfoArgs + 2
+	if len(mockExecer.receivedArgs) != expectedArgs {
+		t.Fatalf("expected execer to receive %d arguments, but received %d",
+			expectedArgs,
+			len(mockExecer.receivedArgs))
+	}
+
+	if !strings.Contains(mockStmtPreparer.receivedSQLs[0], "INSERT INTO tcp_events_process_info") ||
+		!strings.Contains(mockStmtPreparer.receivedSQLs[0], "pg_notify($49, $50)") {
+		t.Errorf("expected prepared statement to insert process info and notify, but did not: %q",
+			mockStmtPreparer.receivedSQLs[0])
+	}
+
+	if mockExecer.receivedArgs[socketInfoArgsOffset] != nil {
+		t.Errorf("expected no socket to be referenced, but ID was %v", mockExecer.receivedArgs[socketInfoArgsOffset])
+	}
+
+	if mockExecer.receivedArgs[processInfoArgsOffset] != &mockCmdline {
+		t.Errorf("expected process info command line to be passed, but was %v",
+			mockExecer.receivedArgs[processInfoArgsOffset])
+	}
+
+	if mockExecer.receivedArgs[processInfoArgsOffset+insertProcessInfoArgs-1] != true {
+		t.Errorf("expected process info to be inserted, but was not")
+	}
+}
+
+func TestInsertUnnamedStatements(t *testing.T) {
+	mockStmtPreparer := newMockStatementPreparer(nil, 0)
+	mockExecer := newMockExecer(nil)
+	mockEvent := &tcpEvent{
+		uid:        "mock-uid",
+		time:       time.Now(),
+		srcIP:      net.ParseIP("1.2.3.4"),
+		dstIP:      net.ParseIP("7.3.3.7"),
+		sampleRate: 1,
+	}
+
+	inserter := newPreparedStatementInserter(mockStmtPreparer, mockExecer, "", false, false, nil)
+
+	if err := inserter.prepare(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if mockStmtPreparer.prepareStatementCalled {
+		t.Error("expected statementPreparer prepareStatement() to not be called, but was")
+	}
+
+	if err := inserter.insert(context.TODO(), mockEvent, nil); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if mockExecer.receivedSQL != insertTCPEventsTableSQL+insertTCPEventsTableSQLResult {
+		t.Errorf("expected execer to receive the insert SQL, got %q", mockExecer.receivedSQL)
+	}
+}
+
+// The latency of a round trip to the database simulated by roundTripConn
+const benchmarkRoundTripLatency = 100 * time.Microsecond
+
+// The environment variable of the connection string of a database used to
+// benchmark inserts. The benchmarks against a database are skipped if unset.
+const benchmarkConnStringEnvVar = "TCP_AUDIT_PGSQL_BENCHMARK_CONN_STRING"
+
+// The insert of socket info into the table used by earlier versions, used by
+// benchmarkTransactionInsert
+const benchmarkInsertSocketInfoTableSQL = `
+INSERT INTO tcp_events_socket_info (
+	uid,
+	tcp_event_uid,
+	id,
+	inode,
+	user_id,
+	group_id,
+	state
+) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+// RoundTripConn is a connection on which each call is a round trip to a
+// database, which takes a fixed latency.
+type roundTripConn struct {
+	latency    time.Duration
+	roundTrips int
+}
+
+func (c *roundTripConn) roundTrip() error {
+	c.roundTrips++
+	time.Sleep(c.latency)
+	return nil
+}
+
+func (c *roundTripConn) exec(ctx context.Context, sql string, arguments ...interface{}) error {
+	return c.roundTrip()
+}
+
+func (c *roundTripConn) begin(ctx context.Context) (transaction, error) {
+	return &roundTripTransaction{c}, c.roundTrip()
+}
+
+func (c *roundTripConn) prepare(ctx context.Context, name, sql string) error {
+	return c.roundTrip()
+}
+
+func (c *roundTripConn) query(ctx context.Context, sql string, arguments ...interface{}) ([][]interface{}, error) {
+	return nil, c.roundTrip()
+}
+
+func (c *roundTripConn) close(ctx context.Context) error {
+	return nil
+}
+
+func (c *roundTripConn) describe() string {
+	return "round-trip-conn"
+}
+
+type roundTripTransaction struct {
+	conn *roundTripConn
+}
+
+func (t *roundTripTransaction) exec(ctx context.Context, sql string, arguments ...interface{}) error {
+	return t.conn.roundTrip()
+}
+
+func (t *roundTripTransaction) commit(ctx context.Context) error {
+	return t.conn.roundTrip()
+}
+
+func (t *roundTripTransaction) rollback(ctx context.Context) error {
+	return t.conn.roundTrip()
+}
+
+// BenchmarkTransactionInsert inserts an event and its socket info as they
+// were inserted before the single statement insert: in a transaction of one
+// statement per table.
+func benchmarkTransactionInsert(ctx context.Context,
+	execer execer,
+	event *tcpEvent,
+	socketInfo *socketInfo) error {
+	// Record the arguments of the event, without socket info
+	recorder := newMockExecer(nil)
+	if err := newPreparedStatementInserter(nil, recorder, "", false, false, nil).insert(ctx, event, nil); err != nil {
+		return err
+	}
+
+	return execer.execMultiple(ctx,
+		newSQLStatement(recorder.receivedSQL, recorder.receivedArgs...),
+		newSQLStatement(benchmarkInsertSocketInfoTableSQL,
+			uuid.NewString(),
+			event.uid,
+			socketInfo.id,
+			socketInfo.iNode,
+			socketInfo.userID,
+			socketInfo.groupID,
+			socketInfo.state))
+}
+
+func benchmarkEvent() (*tcpEvent, *socketInfo) {
+	event := &tcpEvent{
+		uid:        uuid.NewString(),
+		host:       "benchmark-host",
+		time:       time.Now(),
+		pid:        7337,
+		comm:       "curl",
+		srcIP:      net.ParseIP("1.2.3.4"),
+		dstIP:      net.ParseIP("7.3.3.7"),
+		srcPort:    1234,
+		dstPort:    443,
+		oldState:   tcpstate.StateSynSent.String(),
+		newState:   tcpstate.StateEstablished.String(),
+		sampleRate: 1,
+	}
+	sockInfo := &socketInfo{
+		id:      "benchmark-socket-id",
+		iNode:   7337,
+		userID:  1000,
+		groupID: 1000,
+		state:   socketstate.StateConnected.String(),
+	}
+
+	return event, sockInfo
+}
+
+func benchmarkInserts(b *testing.B, conn conn, singleStatement bool) {
+	execer := newSQLExecer(conn, 0, time.Sleep, discardLogger)
+	inserter := newPreparedStatementInserter(newSQLStatementPreparer(conn), execer, "", false, false, nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		event, sockInfo := benchmarkEvent()
+
+		var err error
+		if singleStatement {
+			err = inserter.insert(context.TODO(), event, sockInfo)
+		} else {
+			err = benchmarkTransactionInsert(context.TODO(), execer, event, sockInfo)
+		}
+
+		if err != nil {
+			b.Fatalf("expected nil error, got %q (of type %T)", err, err)
+		}
+	}
+}
+
+func BenchmarkInsertWithSocketInfoTransactionSimulatedLatency(b *testing.B) {
+	conn := &roundTripConn{latency: benchmarkRoundTripLatency}
+	benchmarkInserts(b, conn, false)
+	b.ReportMetric(float64(conn.roundTrips)/float64(b.N), "round-trips/op")
+}
+
+func BenchmarkInsertWithSocketInfoSingleStatementSimulatedLatency(b *testing.B) {
+	conn := &roundTripConn{latency: benchmarkRoundTripLatency}
+	benchmarkInserts(b, conn, true)
+	b.ReportMetric(float64(conn.roundTrips)/float64(b.N), "round-trips/op")
+}
+
+// BenchmarkDatabaseConn connects to the benchmark database, creating the
+// tables, or skips the benchmark if no database is configured.
+func benchmarkDatabaseConn(b *testing.B) conn {
+	connString := os.Getenv(benchmarkConnStringEnvVar)
+	if connString == "" {
+		b.Skipf("%s not set", benchmarkConnStringEnvVar)
+	}
+
+	conn, err := newPGXConnector(connString, statementModePrepared).connect(context.TODO())
+	if err != nil {
+		b.Fatalf("expected nil error connecting, got %q (of type %T)", err, err)
+	}
+	b.Cleanup(func() {
+		conn.close(context.TODO())
+	})
+
+	tableCreator := newSQLTableCreator(conn, compatModes[compatModePostgreSQL], nil, false, nil, discardLogger)
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		b.Fatalf("expected nil error creating tables, got %q (of type %T)", err, err)
+	}
+
+	return conn
+}
+
+func BenchmarkInsertWithSocketInfoTransactionDatabase(b *testing.B) {
+	benchmarkInserts(b, benchmarkDatabaseConn(b), false)
+}
+
+func BenchmarkInsertWithSocketInfoSingleStatementDatabase(b *testing.B) {
+	benchmarkInserts(b, benchmarkDatabaseConn(b), true)
+}
@@ -0,0 +1,117 @@
+package pgsqlsink
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Migrate creates and migrates the tables in which events are stored, as
+// the sink does when it starts in the migrate schema mode, and grants the
+// privileges required by a sink with the given configuration to each of the
+// roles. Sinks run by those roles may then use the verify schema mode,
+// without the privilege to create tables.
+func Migrate(ctx context.Context, config *Config, roles []string, opts ...Option) error {
+	options := &options{
+		logger: log.New(os.Stderr, "", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	sinkConfig, err := newSinkConfig(config)
+	if err != nil {
+		return fmt.Errorf("validating sink config: %w", err)
+	}
+
+	connector := newPGXConnector(config.ConnString, sinkConfig.statementMode)
+	conn, err := connector.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("connecting to database: %w", err)
+	}
+	defer func() {
+		if err := conn.close(ctx); err != nil {
+			options.logger.Printf("Error closing database connection: %v", err)
+		}
+	}()
+
+	var tableCreator tableCreator = newSQLTableCreator(conn,
+		sinkConfig.compatMode,
+		sinkConfig.hypertable,
+		sinkConfig.indexes,
+		sinkConfig.appendOnly,
+		options.logger)
+	if sinkConfig.compatMode.catalogChecks {
+		tableCreator = newAppendOnlyReportingTableCreator(tableCreator, conn, sinkConfig.appendOnly, options.logger)
+	}
+
+	return migrate(ctx, conn, tableCreator, sinkConfig, roles, options.logger)
+}
+
+func migrate(ctx context.Context,
+	conn conn,
+	tableCreator tableCreator,
+	config *sinkConfig,
+	roles []string,
+	logger Logger) error {
+	if err := tableCreator.createTables(ctx); err != nil {
+		return fmt.Errorf("creating tables: %w", err)
+	}
+
+	if len(roles) == 0 {
+		return nil
+	}
+
+	rows, err := conn.query(ctx, schemaPrivilegeSQL)
+	if err != nil {
+		return fmt.Errorf("querying schema: %w", err)
+	}
+
+	if len(rows) != 1 || rows[0][0] == nil {
+		return fmt.Errorf("no schema in the search path exists")
+	}
+	schemaName := stringValue(rows[0][0])
+
+	for _, role := range roles {
+		for _, sql := range grantSQLs(config, schemaName, role) {
+			if err := conn.exec(ctx, sql); err != nil {
+				return fmt.Errorf("granting privileges to %s: %w", role, err)
+			}
+		}
+
+		logger.Printf("Granted the privileges required by the sink to %s", role)
+	}
+
+	return nil
+}
+
+// GrantSQLs returns the SQL to grant the privileges required by a sink with
+// the given configuration to the role, and no others.
+func grantSQLs(config *sinkConfig, schemaName, role string) []string {
+	sqls := []string{
+		fmt.Sprintf("GRANT USAGE ON SCHEMA %s TO %s", quoteIdentifier(schemaName), quoteIdentifier(role)),
+	}
+	for _, table := range requiredTables(config) {
+		sqls = append(sqls, fmt.Sprintf("GRANT %s ON %s TO %s",
+			strings.Join(table.privileges, ", "),
+			table.name,
+			quoteIdentifier(role)))
+
+		if len(table.selectedColumns) != 0 {
+			sqls = append(sqls, fmt.Sprintf("GRANT SELECT (%s) ON %s TO %s",
+				strings.Join(table.selectedColumns, ", "),
+				table.name,
+				quoteIdentifier(role)))
+		}
+	}
+
+	return sqls
+}
+
+// QuoteIdentifier quotes a SQL identifier, such as a role name, so that it
+// may contain any character.
+func quoteIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
@@ -0,0 +1,118 @@
+package pgsqlsink
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMigrateGrantsPrivileges(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		schemaPrivilegeSQL: {{"public", true}},
+	}
+	mockTableCreator := newMockTableCreator(nil)
+	config := &sinkConfig{
+		samplingMode: samplingModeRatio,
+		hashChain:    &hashChainConfig{keyFile: "mock-key-file"},
+		enrichments:  []string{enrichmentProcess},
+		rollupGranularities: []*rollupGranularity{
+			{table: rollupGranularities[rollupGranularityMinute].table, retention: 1},
+			{table: rollupGranularities[rollupGranularityHour].table},
+		},
+	}
+
+	if err := migrate(context.TODO(), mockConn, mockTableCreator, config, []string{"tcp-audit"}, discardLogger); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockTableCreator.createTablesCalled {
+		t.Error("expected tables to be created, but were not")
+	}
+
+	executed := strings.Join(mockConn.receivedSQL, "\n")
+	for _, expected := range []string{
+		`GRANT USAGE ON SCHEMA "public" TO "tcp-audit"`,
+		`GRANT INSERT ON tcp_events TO "tcp-audit"`,
+		`GRANT SELECT (host, chain_seq, chain_hash) ON tcp_events TO "tcp-audit"`,
+		`GRANT SELECT, INSERT ON tcp_events_chain_checkpoints TO "tcp-audit"`,
+		`GRANT INSERT ON tcp_sockets TO "tcp-audit"`,
+		`GRANT INSERT ON tcp_events_process_info TO "tcp-audit"`,
+		`GRANT INSERT ON tcp_events_sampling_summary TO "tcp-audit"`,
+		`GRANT SELECT, INSERT, UPDATE, DELETE ON tcp_events_rollup_minute TO "tcp-audit"`,
+		`GRANT SELECT, INSERT, UPDATE ON tcp_events_rollup_hour TO "tcp-audit"`,
+	} {
+		if !strings.Contains(executed, expected) {
+			t.Errorf("expected %q to be executed, but was not", expected)
+		}
+	}
+}
+
+func TestMigrateGrantsOnlyConfiguredPrivileges(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		schemaPrivilegeSQL: {{"public", true}},
+	}
+	config := &sinkConfig{samplingMode: samplingModeNone}
+
+	if err := migrate(context.TODO(),
+		mockConn,
+		newMockTableCreator(nil),
+		config,
+		[]string{"tcp-audit"},
+		discardLogger); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	expectedSQLs := []string{
+		`GRANT USAGE ON SCHEMA "public" TO "tcp-audit"`,
+		`GRANT INSERT ON tcp_events TO "tcp-audit"`,
+		`GRANT INSERT ON tcp_sockets TO "tcp-audit"`,
+	}
+	if strings.Join(mockConn.receivedSQL, "\n") != strings.Join(expectedSQLs, "\n") {
+		t.Errorf("expected only SQL:\n%s\ngot:\n%s",
+			strings.Join(expectedSQLs, "\n"),
+			strings.Join(mockConn.receivedSQL, "\n"))
+	}
+}
+
+func TestMigrateWithoutRoles(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	mockTableCreator := newMockTableCreator(nil)
+
+	if err := migrate(context.TODO(), mockConn, mockTableCreator, new(sinkConfig), nil, discardLogger); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if mockConn.execCalled {
+		t.Error("expected no privileges to be granted, but were")
+	}
+}
+
+func TestMigrateCreateTablesError(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	mockError := errors.New("mock create tables error")
+	mockTableCreator := newMockTableCreator(mockError)
+
+	err := migrate(context.TODO(), mockConn, mockTableCreator, new(sinkConfig), []string{"tcp-audit"}, discardLogger)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+
+	if mockConn.execCalled {
+		t.Error("expected no privileges to be granted, but were")
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	if quoted := quoteIdentifier(`tcp"audit`); quoted != `"tcp""audit"` {
+		t.Errorf("expected %q, got %q", `"tcp""audit"`, quoted)
+	}
+}
@@ -0,0 +1,114 @@
+package pgsqlsink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v4"
+)
+
+// PGXConn is a connection to a PostgreSQL database using the PGX library.
+type pgxConn struct {
+	conn *pgx.Conn
+}
+
+func newPGXConn(conn *pgx.Conn) *pgxConn {
+	return &pgxConn{conn}
+}
+
+func (c *pgxConn) exec(ctx context.Context, sql string, arguments ...interface{}) error {
+	_, err := c.conn.Exec(ctx, sql, arguments...)
+	return classifyPGXError(err)
+}
+
+func (c *pgxConn) begin(ctx context.Context) (transaction, error) {
+	pgxTx, err := c.conn.Begin(ctx)
+	if err != nil {
+		return nil, classifyPGXError(err)
+	}
+
+	return &pgxTransaction{pgxTx}, nil
+}
+
+func (c *pgxConn) prepare(ctx context.Context, name, sql string) error {
+	_, err := c.conn.Prepare(ctx, name, sql)
+	return classifyPGXError(err)
+}
+
+func (c *pgxConn) query(ctx context.Context, sql string, arguments ...interface{}) ([][]interface{}, error) {
+	rows, err := c.conn.Query(ctx, sql, arguments...)
+	if err != nil {
+		return nil, classifyPGXError(err)
+	}
+	defer rows.Close()
+
+	var results [][]interface{}
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return nil, fmt.Errorf("reading row values: %w", err)
+		}
+
+		results = append(results, values)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, classifyPGXError(err)
+	}
+
+	return results, nil
+}
+
+func (c *pgxConn) close(ctx context.Context) error {
+	return c.conn.Close(ctx)
+}
+
+func (c *pgxConn) describe() string {
+	return fmt.Sprintf("%s:%d", c.conn.Config().Host, c.conn.Config().Port)
+}
+
+// PGXTransaction is a transaction on a connection to a PostgreSQL database
+// using the PGX library.
+type pgxTransaction struct {
+	tx pgx.Tx
+}
+
+func (t *pgxTransaction) exec(ctx context.Context, sql string, arguments ...interface{}) error {
+	_, err := t.tx.Exec(ctx, sql, arguments...)
+	return classifyPGXError(err)
+}
+
+func (t *pgxTransaction) commit(ctx context.Context) error {
+	return classifyPGXError(t.tx.Commit(ctx))
+}
+
+func (t *pgxTransaction) rollback(ctx context.Context) error {
+	return classifyPGXError(t.tx.Rollback(ctx))
+}
+
+// ClassifyPGXError classifies the PostgreSQL errors which callers handle as
+// backend-neutral errors.
+func classifyPGXError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch pgErr.Code {
+	case pgerrcode.DuplicateObject:
+		return &backendError{errDuplicateObject, err}
+	case pgerrcode.DuplicateTable:
+		return &backendError{errDuplicateTable, err}
+	case pgerrcode.UndefinedObject:
+		return &backendError{errUndefinedObject, err}
+	case pgerrcode.FeatureNotSupported:
+		return &backendError{errFeatureNotSupported, err}
+	case pgerrcode.SerializationFailure:
+		return &backendError{errSerializationFailure, err}
+	default:
+		return err
+	}
+}
@@ -0,0 +1,57 @@
+package pgsqlsink
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+)
+
+func TestClassifyPGXErrorDuplicateTable(t *testing.T) {
+	mockPgErr := &pgconn.PgError{Code: pgerrcode.DuplicateTable}
+
+	err := classifyPGXError(mockPgErr)
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, errDuplicateTable) {
+		t.Errorf("expected error chain to include %q, but did not", errDuplicateTable)
+	}
+
+	if errors.Is(err, errDuplicateObject) {
+		t.Errorf("expected error chain to not include %q, but did", errDuplicateObject)
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		t.Error("expected error chain to include PostgreSQL error, but did not")
+	}
+}
+
+func TestClassifyPGXErrorSerializationFailure(t *testing.T) {
+	mockPgErr := &pgconn.PgError{Code: pgerrcode.SerializationFailure}
+
+	if err := classifyPGXError(mockPgErr); !errors.Is(err, errSerializationFailure) {
+		t.Errorf("expected error chain to include %q, but did not", errSerializationFailure)
+	}
+}
+
+func TestClassifyPGXErrorFeatureNotSupported(t *testing.T) {
+	mockPgErr := &pgconn.PgError{Code: pgerrcode.FeatureNotSupported}
+
+	if err := classifyPGXError(mockPgErr); !errors.Is(err, errFeatureNotSupported) {
+		t.Errorf("expected error chain to include %q, but did not", errFeatureNotSupported)
+	}
+}
+
+func TestClassifyPGXErrorUnclassified(t *testing.T) {
+	mockError := errors.New("mock error")
+
+	if err := classifyPGXError(mockError); err != mockError {
+		t.Errorf("expected error %q to be returned unchanged, got %q (of type %T)", mockError, err, err)
+	}
+
+	if err := classifyPGXError(nil); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+}
@@ -0,0 +1,234 @@
+package pgsqlsink
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	enrichmentProcess = "process"
+
+	defaultProcRoot = "/proc"
+)
+
+// Container runtimes name cgroups after the 64 hex digit container ID,
+// e.g. /docker/<id> or /kubepods/.../cri-containerd-<id>.scope
+var containerIDRegexp = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// ProcessInfo represents information about the process on-CPU at the time
+// of a TCP state-change event, in a form ready to insert into the database.
+// Fields which could not be resolved are nil.
+type processInfo struct {
+	cmdline     *string
+	exe         *string
+	parentPID   *int
+	userID      *uint32
+	userName    *string
+	cgroup      *string
+	containerID *string
+
+	// The names of the owners of the socket, from the socket info
+	socketUserName, socketGroupName *string
+}
+
+// ProcessEnricher adds information about the on-CPU process, read from
+// /proc, to events, and the names of the socket's owning user and group.
+// As the process is inspected when the event is received rather than when
+// it occurred, information is missing if the process has since exited.
+type processEnricher struct {
+	procRoot    string
+	lookupUser  func(uid string) (string, error)
+	lookupGroup func(gid string) (string, error)
+	userNames   map[uint32]*string
+	groupNames  map[uint32]*string
+}
+
+func newProcessEnricher(procRoot string,
+	lookupUser func(uid string) (string, error),
+	lookupGroup func(gid string) (string, error)) *processEnricher {
+	return &processEnricher{
+		procRoot:    procRoot,
+		lookupUser:  lookupUser,
+		lookupGroup: lookupGroup,
+		userNames:   make(map[uint32]*string),
+		groupNames:  make(map[uint32]*string),
+	}
+}
+
+// LookupUserName returns the name of the user with the given ID using
+// the user database.
+func lookupUserName(uid string) (string, error) {
+	u, err := user.LookupId(uid)
+	if err != nil {
+		return "", err
+	}
+
+	return u.Username, nil
+}
+
+// LookupGroupName returns the name of the group with the given ID using
+// the group database.
+func lookupGroupName(gid string) (string, error) {
+	g, err := user.LookupGroupId(gid)
+	if err != nil {
+		return "", err
+	}
+
+	return g.Name, nil
+}
+
+func (e *processEnricher) enrich(ctx context.Context, event *tcpEvent, socketInfo *socketInfo) {
+	info := new(processInfo)
+
+	// PID 0 is the idle task, on-CPU for events occurring in interrupt context
+	if event.pid > 0 {
+		e.readProcess(event.pid, info)
+	}
+
+	if socketInfo != nil {
+		info.socketUserName = e.userName(socketInfo.userID)
+		info.socketGroupName = e.groupName(socketInfo.groupID)
+	}
+
+	event.processInfo = info
+}
+
+func (e *processEnricher) close() error {
+	return nil
+}
+
+// ReadProcess reads the information about the process from /proc.
+// Each item is read independently, so that those which can be read are
+// stored even if others cannot (e.g. the exe link of a kernel thread).
+func (e *processEnricher) readProcess(pid int, info *processInfo) {
+	dir := filepath.Join(e.procRoot, strconv.Itoa(pid))
+
+	if cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline")); err == nil && len(cmdline) != 0 {
+		args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+		joined := strings.Join(args, " ")
+		info.cmdline = &joined
+	}
+
+	if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		info.exe = &exe
+	}
+
+	if parentPID, userID, err := readProcessStatus(filepath.Join(dir, "status")); err == nil {
+		info.parentPID = &parentPID
+		info.userID = &userID
+		info.userName = e.userName(userID)
+	}
+
+	if cgroup, err := readProcessCgroup(filepath.Join(dir, "cgroup")); err == nil {
+		info.cgroup = &cgroup
+
+		if containerID := containerIDRegexp.FindString(cgroup); containerID != "" {
+			info.containerID = &containerID
+		}
+	}
+}
+
+// UserName returns the cached name of the user, or nil if it has none.
+func (e *processEnricher) userName(uid uint32) *string {
+	return cachedName(e.userNames, uid, e.lookupUser)
+}
+
+// GroupName returns the cached name of the group, or nil if it has none.
+func (e *processEnricher) groupName(gid uint32) *string {
+	return cachedName(e.groupNames, gid, e.lookupGroup)
+}
+
+func cachedName(names map[uint32]*string, id uint32, lookup func(string) (string, error)) *string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+
+	var name *string
+	if found, err := lookup(strconv.FormatUint(uint64(id), 10)); err == nil {
+		name = &found
+	}
+
+	names[id] = name
+	return name
+}
+
+// ReadProcessStatus returns the parent PID and real user ID of a process
+// from its /proc/<pid>/status file.
+func readProcessStatus(path string) (int, uint32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	parentPID, userID := -1, int64(-1)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "PPid:":
+			if parentPID, err = strconv.Atoi(fields[1]); err != nil {
+				return 0, 0, fmt.Errorf("parsing parent PID: %w", err)
+			}
+		case "Uid:":
+			if userID, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+				return 0, 0, fmt.Errorf("parsing user ID: %w", err)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	if parentPID == -1 || userID == -1 {
+		return 0, 0, fmt.Errorf("parent PID or user ID not found in %s", path)
+	}
+
+	return parentPID, uint32(userID), nil
+}
+
+// ReadProcessCgroup returns the cgroup path of a process from its
+// /proc/<pid>/cgroup file. The unified (v2) hierarchy is preferred, falling
+// back to the first v1 hierarchy.
+func readProcessCgroup(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	var cgroup string
+	for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+		// Each line is of the form hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		if fields[0] == "0" && fields[1] == "" {
+			return fields[2], nil
+		}
+
+		if cgroup == "" {
+			cgroup = fields[2]
+		}
+	}
+
+	if cgroup == "" {
+		return "", fmt.Errorf("no cgroup found in %s", path)
+	}
+
+	return cgroup, nil
+}
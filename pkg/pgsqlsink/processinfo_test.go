@@ -0,0 +1,144 @@
+package pgsqlsink
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const mockContainerID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// NewMockProc creates a /proc-like directory containing a single process.
+func newMockProc(t *testing.T, pid string) string {
+	root := t.TempDir()
+	dir := filepath.Join(root, pid)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("test bootstrapping: unable to create mock proc: %v", err)
+	}
+
+	files := map[string]string{
+		"cmdline": "/usr/bin/curl\x00-s\x00https://example.com\x00",
+		"status":  "Name:\tcurl\nPid:\t7337\nPPid:\t1234\nUid:\t1000\t1000\t1000\t1000\n",
+		"cgroup":  "12:memory:/docker/" + mockContainerID + "\n0::/system.slice/docker-" + mockContainerID + ".scope\n",
+	}
+
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("test bootstrapping: unable to write mock proc file: %v", err)
+		}
+	}
+
+	if err := os.Symlink("/usr/bin/curl", filepath.Join(dir, "exe")); err != nil {
+		t.Fatalf("test bootstrapping: unable to create mock exe link: %v", err)
+	}
+
+	return root
+}
+
+func mockLookup(names map[string]string) func(string) (string, error) {
+	return func(id string) (string, error) {
+		if name, ok := names[id]; ok {
+			return name, nil
+		}
+
+		return "", errors.New("mock unknown ID error")
+	}
+}
+
+func TestProcessEnricher(t *testing.T) {
+	procRoot := newMockProc(t, "7337")
+	enricher := newProcessEnricher(procRoot,
+		mockLookup(map[string]string{"1000": "alice", "0": "root"}),
+		mockLookup(map[string]string{"1001": "staff"}))
+
+	event := &tcpEvent{pid: 7337}
+	socketInfo := &socketInfo{userID: 0, groupID: 1001}
+	enricher.enrich(context.TODO(), event, socketInfo)
+
+	info := event.processInfo
+	if info == nil {
+		t.Fatal("expected process info, got nil")
+	}
+
+	expectedStrings := []struct {
+		name     string
+		actual   *string
+		expected string
+	}{
+		{"command line", info.cmdline, "/usr/bin/curl -s https://example.com"},
+		{"exe", info.exe, "/usr/bin/curl"},
+		{"user name", info.userName, "alice"},
+		{"cgroup", info.cgroup, "/system.slice/docker-" + mockContainerID + ".scope"},
+		{"container ID", info.containerID, mockContainerID},
+		{"socket user name", info.socketUserName, "root"},
+		{"socket group name", info.socketGroupName, "staff"},
+	}
+
+	for _, s := range expectedStrings {
+		if s.actual == nil || *s.actual != s.expected {
+			t.Errorf("expected %s %q, got %v", s.name, s.expected, s.actual)
+		}
+	}
+
+	if info.parentPID == nil || *info.parentPID != 1234 {
+		t.Errorf("expected parent PID %d, got %v", 1234, info.parentPID)
+	}
+
+	if info.userID == nil || *info.userID != 1000 {
+		t.Errorf("expected user ID %d, got %v", 1000, info.userID)
+	}
+}
+
+func TestProcessEnricherExitedProcess(t *testing.T) {
+	procRoot := newMockProc(t, "7337")
+	enricher := newProcessEnricher(procRoot, mockLookup(nil), mockLookup(nil))
+
+	event := &tcpEvent{pid: 4242}
+	enricher.enrich(context.TODO(), event, nil)
+
+	info := event.processInfo
+	if info == nil {
+		t.Fatal("expected process info, got nil")
+	}
+
+	if info.cmdline != nil || info.exe != nil || info.parentPID != nil || info.cgroup != nil {
+		t.Errorf("expected empty process info for exited process, got %+v", info)
+	}
+}
+
+func TestProcessEnricherCachesNames(t *testing.T) {
+	lookups := 0
+	lookupUser := func(uid string) (string, error) {
+		lookups++
+		return "", errors.New("mock unknown ID error")
+	}
+	enricher := newProcessEnricher(t.TempDir(), lookupUser, mockLookup(nil))
+
+	for i := 0; i < 3; i++ {
+		enricher.enrich(context.TODO(), &tcpEvent{}, &socketInfo{userID: 1000})
+	}
+
+	if lookups != 1 {
+		t.Errorf("expected %d user lookup, got %d", 1, lookups)
+	}
+}
+
+func TestReadProcessCgroupV1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cgroup")
+	contents := "12:memory:/kubepods/pod1234\n11:cpu,cpuacct:/kubepods/pod1234\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("test bootstrapping: unable to write mock cgroup file: %v", err)
+	}
+
+	cgroup, err := readProcessCgroup(path)
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if cgroup != "/kubepods/pod1234" {
+		t.Errorf("expected cgroup %q, got %q", "/kubepods/pod1234", cgroup)
+	}
+}
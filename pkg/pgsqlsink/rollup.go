@@ -0,0 +1,267 @@
+package pgsqlsink
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+const (
+	rollupGranularityMinute = "minute"
+	rollupGranularityHour   = "hour"
+
+	defaultRollupFlushInterval = 10 * time.Second
+)
+
+// RollupTableCreateSQL returns the SQL to create a rollup table, with the
+// primary key leading with either the bucket or the host.
+func rollupTableCreateSQL(table string, keyLeadsWithHost bool) string {
+	primaryKey := "bucket, host"
+	if keyLeadsWithHost {
+		primaryKey = "host, bucket"
+	}
+
+	return `
+CREATE TABLE IF NOT EXISTS ` + table + ` (
+	bucket           TIMESTAMP NOT NULL,
+	host             TEXT NOT NULL,
+	remote_ip        INET NOT NULL,
+	remote_port      INTEGER NOT NULL,
+	comm             TEXT NOT NULL,
+	old_state        tcp_state NOT NULL,
+	new_state        tcp_state NOT NULL,
+	events           BIGINT NOT NULL,
+	estimated_events DOUBLE PRECISION NOT NULL,
+	PRIMARY KEY (` + primaryKey + `, remote_ip, remote_port, comm, old_state, new_state)
+)`
+}
+
+// RollupUpsertSQL returns the SQL to add counts to a rollup table row,
+// creating the row if it does not exist.
+func rollupUpsertSQL(table string) string {
+	return `
+INSERT INTO ` + table + ` (
+	bucket,
+	host,
+	remote_ip,
+	remote_port,
+	comm,
+	old_state,
+	new_state,
+	events,
+	estimated_events
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (bucket, host, remote_ip, remote_port, comm, old_state, new_state) DO UPDATE SET
+	events = ` + table + `.events + EXCLUDED.events,
+	estimated_events = ` + table + `.estimated_events + EXCLUDED.estimated_events`
+}
+
+// RollupRetentionSQL returns the SQL to delete the rows of a rollup table
+// older than a given time.
+func rollupRetentionSQL(table string) string {
+	return `DELETE FROM ` + table + ` WHERE bucket < $1`
+}
+
+// RollupGranularity describes a rollup table, into which events are counted
+// in buckets of a fixed size.
+type rollupGranularity struct {
+	name  string
+	table string
+	size  time.Duration
+
+	// Retention is how long rows are kept. If zero, they are kept forever.
+	retention time.Duration
+}
+
+var rollupGranularities = map[string]*rollupGranularity{
+	rollupGranularityMinute: {name: rollupGranularityMinute, table: schema.RollupMinuteTable, size: time.Minute},
+	rollupGranularityHour:   {name: rollupGranularityHour, table: schema.RollupHourTable, size: time.Hour},
+}
+
+// ParseRollupGranularities parses a comma-separated list of rollup
+// granularity names.
+func parseRollupGranularities(spec string) ([]*rollupGranularity, error) {
+	var granularities []*rollupGranularity
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		granularity, ok := rollupGranularities[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown rollup granularity %q", name)
+		}
+
+		for _, existing := range granularities {
+			if existing.name == name {
+				return nil, fmt.Errorf("duplicate rollup granularity %q", name)
+			}
+		}
+
+		copied := *granularity
+		granularities = append(granularities, &copied)
+	}
+
+	return granularities, nil
+}
+
+// RollupKey identifies the row of a rollup table into which an event is
+// counted.
+type rollupKey struct {
+	bucket             time.Time
+	host               string
+	remoteIP           string
+	remotePort         uint16
+	comm               string
+	oldState, newState string
+}
+
+// RollupCount is the number of events counted into a rollup table row,
+// and the estimated number of events which occurred, accounting for
+// sampling.
+type rollupCount struct {
+	events          int64
+	estimatedEvents float64
+}
+
+// Aggregator is an interface which describes objects which maintain
+// aggregated counts of stored TCP state-change events.
+type aggregator interface {
+	add(event *tcpEvent)
+	flushIfDue(ctx context.Context) error
+	flush(ctx context.Context) error
+}
+
+// RollupAggregator counts events into rollup tables. Counts are accumulated
+// in memory and added to the tables periodically, so that the cost of
+// maintaining the rollups is independent of the event rate.
+// Flushing is driven by the caller, which must serialise it with adding
+// events.
+type rollupAggregator struct {
+	execer        execer
+	granularities []*rollupGranularity
+	flushInterval time.Duration
+	now           func() time.Time
+
+	counts    []map[rollupKey]*rollupCount
+	lastFlush time.Time
+}
+
+func newRollupAggregator(execer execer,
+	granularities []*rollupGranularity,
+	flushInterval time.Duration,
+	now func() time.Time) *rollupAggregator {
+	counts := make([]map[rollupKey]*rollupCount, len(granularities))
+	for i := range counts {
+		counts[i] = make(map[rollupKey]*rollupCount)
+	}
+
+	return &rollupAggregator{
+		execer:        execer,
+		granularities: granularities,
+		flushInterval: flushInterval,
+		now:           now,
+		counts:        counts,
+		lastFlush:     now(),
+	}
+}
+
+// Add counts the event into the rollup of each granularity.
+func (a *rollupAggregator) add(event *tcpEvent) {
+	// Where the endpoints could not be decided, the destination is assumed
+	// to be remote
+	remoteIP, remotePort := event.dstIP, event.dstPort
+	if event.endpoints != nil {
+		remoteIP, remotePort = event.endpoints.remoteIP, event.endpoints.remotePort
+	}
+
+	for i, granularity := range a.granularities {
+		key := rollupKey{
+			bucket:     truncateWallClock(event.time, granularity.size),
+			host:       event.host,
+			remoteIP:   remoteIP.String(),
+			remotePort: remotePort,
+			comm:       event.comm,
+			oldState:   event.oldState,
+			newState:   event.newState,
+		}
+
+		count, ok := a.counts[i][key]
+		if !ok {
+			count = new(rollupCount)
+			a.counts[i][key] = count
+		}
+
+		count.events++
+		if event.sampleRate > 0 {
+			count.estimatedEvents += 1 / event.sampleRate
+		}
+	}
+}
+
+// FlushIfDue flushes the accumulated counts if the flush interval has
+// elapsed since the last flush.
+func (a *rollupAggregator) flushIfDue(ctx context.Context) error {
+	if a.now().Sub(a.lastFlush) < a.flushInterval {
+		return nil
+	}
+
+	return a.flush(ctx)
+}
+
+// Flush adds the accumulated counts to the rollup tables, and deletes the
+// rows which have exceeded their retention, in a single transaction.
+// If this fails, the counts are kept to be retried at the next flush.
+func (a *rollupAggregator) flush(ctx context.Context) error {
+	now := a.now()
+	a.lastFlush = now
+
+	var stmts []*sqlStatement
+	for i, granularity := range a.granularities {
+		upsertSQL := rollupUpsertSQL(granularity.table)
+		for key, count := range a.counts[i] {
+			stmts = append(stmts, newSQLStatement(upsertSQL,
+				key.bucket,
+				key.host,
+				storageIP(net.ParseIP(key.remoteIP)),
+				key.remotePort,
+				key.comm,
+				key.oldState,
+				key.newState,
+				count.events,
+				count.estimatedEvents))
+		}
+
+		if granularity.retention > 0 {
+			stmts = append(stmts, newSQLStatement(rollupRetentionSQL(granularity.table),
+				now.Add(-granularity.retention)))
+		}
+	}
+
+	if len(stmts) == 0 {
+		return nil
+	}
+
+	if err := a.execer.execMultiple(ctx, stmts...); err != nil {
+		return fmt.Errorf("updating rollup tables: %w", err)
+	}
+
+	for i := range a.counts {
+		a.counts[i] = make(map[rollupKey]*rollupCount)
+	}
+
+	return nil
+}
+
+// TruncateWallClock rounds the time down to a multiple of the duration in
+// its own time zone, as timestamps are stored as wall-clock times.
+func truncateWallClock(t time.Time, d time.Duration) time.Time {
+	_, offset := t.Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(d).Add(-shift)
+}
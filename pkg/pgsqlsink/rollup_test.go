@@ -0,0 +1,214 @@
+package pgsqlsink
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+func newRollupTestEvent(eventTime time.Time, dstIP string, sampleRate float64) *tcpEvent {
+	return &tcpEvent{
+		uid:        "mock-uid",
+		host:       "mock-host",
+		time:       eventTime,
+		comm:       "curl",
+		srcIP:      net.ParseIP("1.2.3.4"),
+		dstIP:      net.ParseIP(dstIP),
+		srcPort:    43210,
+		dstPort:    443,
+		oldState:   "SYN-SENT",
+		newState:   "ESTABLISHED",
+		sampleRate: sampleRate,
+	}
+}
+
+func TestRollupAggregatorFlush(t *testing.T) {
+	mockExecer := newMockExecer(nil)
+	granularities, err := parseRollupGranularities("minute,hour")
+	if err != nil {
+		t.Fatalf("test bootstrapping: unable to parse granularities: %v", err)
+	}
+	granularities[0].retention = 24 * time.Hour
+
+	now := time.Date(2021, 6, 1, 12, 34, 56, 0, time.UTC)
+	aggregator := newRollupAggregator(mockExecer, granularities, time.Minute, func() time.Time { return now })
+
+	aggregator.add(newRollupTestEvent(now, "7.3.3.7", 1))
+	aggregator.add(newRollupTestEvent(now.Add(time.Second), "7.3.3.7", 0.25))
+	aggregator.add(newRollupTestEvent(now.Add(-time.Minute), "7.3.3.7", 1))
+
+	if err := aggregator.flush(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	// Two minute rows, one hour row and the minute retention delete
+	if len(mockExecer.receivedStmts) != 4 {
+		t.Fatalf("expected %d statements, got %d", 4, len(mockExecer.receivedStmts))
+	}
+
+	var deletes int
+	for _, stmt := range mockExecer.receivedStmts {
+		if strings.HasPrefix(stmt.sql, "DELETE") {
+			deletes++
+
+			if !strings.Contains(stmt.sql, schema.RollupMinuteTable) {
+				t.Errorf("expected retention to apply to %s, got %q", schema.RollupMinuteTable, stmt.sql)
+			}
+
+			if cutoff := stmt.arguments[0].(time.Time); !cutoff.Equal(now.Add(-24 * time.Hour)) {
+				t.Errorf("expected retention cutoff %v, got %v", now.Add(-24*time.Hour), cutoff)
+			}
+			continue
+		}
+
+		if !strings.Contains(stmt.sql, schema.RollupHourTable) {
+			continue
+		}
+
+		if bucket := stmt.arguments[0].(time.Time); !bucket.Equal(now.Truncate(time.Hour)) {
+			t.Errorf("expected hour bucket %v, got %v", now.Truncate(time.Hour), bucket)
+		}
+
+		if events := stmt.arguments[7].(int64); events != 3 {
+			t.Errorf("expected %d events in hour row, got %d", 3, events)
+		}
+
+		if estimatedEvents := stmt.arguments[8].(float64); estimatedEvents != 6 {
+			t.Errorf("expected %v estimated events in hour row, got %v", 6, estimatedEvents)
+		}
+	}
+
+	if deletes != 1 {
+		t.Errorf("expected %d retention statement, got %d", 1, deletes)
+	}
+
+	// Flushed counts are not flushed again
+	mockExecer.execMultipleCalled = false
+	aggregator.granularities[0].retention = 0
+	if err := aggregator.flush(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if mockExecer.execMultipleCalled {
+		t.Error("expected execer to not be called with no counts, but was")
+	}
+}
+
+func TestRollupAggregatorFlushErrorKeepsCounts(t *testing.T) {
+	mockError := errors.New("mock execer error")
+	mockExecer := newMockExecer(mockError)
+	granularities, _ := parseRollupGranularities("hour")
+	now := time.Now()
+	aggregator := newRollupAggregator(mockExecer, granularities, time.Minute, func() time.Time { return now })
+
+	aggregator.add(newRollupTestEvent(now, "7.3.3.7", 1))
+
+	err := aggregator.flush(context.TODO())
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+
+	mockExecer.errorToReturn = nil
+	if err := aggregator.flush(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if len(mockExecer.receivedStmts) != 1 {
+		t.Errorf("expected counts to be retried, got %d statements", len(mockExecer.receivedStmts))
+	}
+}
+
+func TestRollupAggregatorFlushIfDue(t *testing.T) {
+	mockExecer := newMockExecer(nil)
+	granularities, _ := parseRollupGranularities("minute")
+	start := time.Now()
+	now := start
+	aggregator := newRollupAggregator(mockExecer, granularities, time.Minute, func() time.Time { return now })
+
+	aggregator.add(newRollupTestEvent(now, "7.3.3.7", 1))
+
+	now = start.Add(30 * time.Second)
+	if err := aggregator.flushIfDue(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if mockExecer.execMultipleCalled {
+		t.Error("expected execer to not be called before flush interval, but was")
+	}
+
+	now = start.Add(time.Minute)
+	if err := aggregator.flushIfDue(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockExecer.execMultipleCalled {
+		t.Error("expected execer to be called after flush interval, but was not")
+	}
+}
+
+func TestTruncateWallClock(t *testing.T) {
+	// A time zone offset which is not a whole number of hours
+	zone := time.FixedZone("mock", 5*60*60+30*60)
+	eventTime := time.Date(2021, 6, 1, 12, 34, 56, 0, zone)
+
+	truncated := truncateWallClock(eventTime, time.Hour)
+	expected := time.Date(2021, 6, 1, 12, 0, 0, 0, zone)
+	if !truncated.Equal(expected) {
+		t.Errorf("expected %v, got %v", expected, truncated)
+	}
+}
+
+func TestParseRollupGranularitiesError(t *testing.T) {
+	if _, err := parseRollupGranularities("minute,fortnight"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestRollupAggregatorUsesRemoteEndpoint(t *testing.T) {
+	mockExecer := newMockExecer(nil)
+	granularities, _ := parseRollupGranularities("hour")
+	now := time.Now()
+	aggregator := newRollupAggregator(mockExecer, granularities, time.Minute, func() time.Time { return now })
+
+	// An inbound connection reported with the remote peer as the source
+	event := newRollupTestEvent(now, "192.168.1.3", 1)
+	event.endpoints = &endpoints{
+		direction:  "inbound",
+		localIP:    event.dstIP,
+		localPort:  event.dstPort,
+		remoteIP:   event.srcIP,
+		remotePort: event.srcPort,
+	}
+	aggregator.add(event)
+
+	if err := aggregator.flush(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if len(mockExecer.receivedStmts) != 1 {
+		t.Fatalf("expected %d statement, got %d", 1, len(mockExecer.receivedStmts))
+	}
+
+	arguments := mockExecer.receivedStmts[0].arguments
+	if remoteIP := arguments[2].(net.IP); !remoteIP.Equal(event.srcIP) {
+		t.Errorf("expected remote IP %s, got %s", event.srcIP, remoteIP)
+	}
+
+	if remotePort := arguments[3].(uint16); remotePort != event.srcPort {
+		t.Errorf("expected remote port %d, got %d", event.srcPort, remotePort)
+	}
+}
+
+func TestRollupTableCreateSQLKeyLeadsWithHost(t *testing.T) {
+	sql := rollupTableCreateSQL(schema.RollupMinuteTable, true)
+	t.Logf("got SQL %q", sql)
+
+	if !strings.Contains(sql, "PRIMARY KEY (host, bucket,") {
+		t.Error("expected primary key to lead with host, but did not")
+	}
+}
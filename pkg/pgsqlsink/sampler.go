@@ -0,0 +1,353 @@
+package pgsqlsink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/event"
+)
+
+const (
+	samplingModeNone   = "none"
+	samplingModeRatio  = "ratio"
+	samplingModeFlow   = "flow"
+	samplingModeSource = "source"
+
+	// Beyond this many tracked sources, sources are rate limited together
+	maxTokenBuckets       = 65536
+	tokenBucketPruneDelay = time.Second
+)
+
+// Sampler is an interface which describes objects which decide whether a
+// TCP state-change event is to be stored, in order to limit the rate at which
+// events are stored.
+type sampler interface {
+	// Sample returns whether the event is to be stored and the probability
+	// with which it was, so that analyses can re-weight stored events.
+	// If a summary period has ended, its summary is also returned.
+	sample(event *event.Event) (keep bool, rate float64, summary *samplingSummary)
+
+	// FlushIfDue ends the current summary period if it has ended, returning
+	// its summary, so that summaries are produced when no events are being
+	// sampled.
+	flushIfDue() *samplingSummary
+
+	// Close ends the current summary period, returning its summary.
+	close() *samplingSummary
+}
+
+// SamplingSummary counts the events seen and stored by a sampler during
+// a period, in a form ready to insert into the database.
+type samplingSummary struct {
+	uid                    string
+	host                   string
+	mode                   string
+	periodStart, periodEnd time.Time
+	seen, stored           uint64
+}
+
+// NewSampler returns the sampler described by the sink configuration.
+func newSampler(config *sinkConfig, host string) sampler {
+	var strategy samplingStrategy
+	switch config.samplingMode {
+	case samplingModeRatio:
+		strategy = newRatioSamplingStrategy(config.samplingRatio, rand.Float64)
+	case samplingModeFlow:
+		strategy = newFlowSamplingStrategy(config.samplingRatio)
+	case samplingModeSource:
+		strategy = newSourceSamplingStrategy(config.samplingSourceRate, config.samplingSourceBurst)
+	default:
+		return new(unsampledSampler)
+	}
+
+	return newSummarisingSampler(strategy, host, config.samplingSummaryInterval, time.Now)
+}
+
+// UnsampledSampler stores every event, and produces no summaries.
+type unsampledSampler struct{}
+
+func (s *unsampledSampler) sample(event *event.Event) (bool, float64, *samplingSummary) {
+	return true, 1, nil
+}
+
+func (s *unsampledSampler) flushIfDue() *samplingSummary {
+	return nil
+}
+
+func (s *unsampledSampler) close() *samplingSummary {
+	return nil
+}
+
+// SummarisingSampler samples events using a sampling strategy, counting
+// the events seen and stored in order to produce a summary at the end of
+// each period.
+// A period ends when an event is sampled or the sampler is flushed after the
+// end of the period, or when the sampler is closed.
+type summarisingSampler struct {
+	strategy samplingStrategy
+	host     string
+	interval time.Duration
+	now      func() time.Time
+
+	periodStart  time.Time
+	seen, stored uint64
+}
+
+func newSummarisingSampler(strategy samplingStrategy,
+	host string,
+	interval time.Duration,
+	now func() time.Time) *summarisingSampler {
+	return &summarisingSampler{
+		strategy:    strategy,
+		host:        host,
+		interval:    interval,
+		now:         now,
+		periodStart: now(),
+	}
+}
+
+func (s *summarisingSampler) sample(event *event.Event) (bool, float64, *samplingSummary) {
+	now := s.now()
+
+	var summary *samplingSummary
+	if now.Sub(s.periodStart) >= s.interval {
+		summary = s.endPeriod(now)
+	}
+
+	keep, rate := s.strategy.keep(event, now)
+
+	s.seen++
+	if keep {
+		s.stored++
+	}
+
+	return keep, rate, summary
+}
+
+func (s *summarisingSampler) flushIfDue() *samplingSummary {
+	if now := s.now(); now.Sub(s.periodStart) >= s.interval {
+		return s.endPeriod(now)
+	}
+
+	return nil
+}
+
+func (s *summarisingSampler) close() *samplingSummary {
+	return s.endPeriod(s.now())
+}
+
+func (s *summarisingSampler) endPeriod(now time.Time) *samplingSummary {
+	summary := &samplingSummary{
+		uid:         uuid.NewString(),
+		host:        s.host,
+		mode:        s.strategy.mode(),
+		periodStart: s.periodStart,
+		periodEnd:   now,
+		seen:        s.seen,
+		stored:      s.stored,
+	}
+
+	s.periodStart = now
+	s.seen = 0
+	s.stored = 0
+
+	return summary
+}
+
+// SamplingStrategy is an interface which describes objects which decide
+// whether an individual event is to be stored.
+type samplingStrategy interface {
+	keep(event *event.Event, now time.Time) (bool, float64)
+	mode() string
+}
+
+// RatioSamplingStrategy stores each event independently with a fixed
+// probability.
+type ratioSamplingStrategy struct {
+	ratio  float64
+	random func() float64
+}
+
+func newRatioSamplingStrategy(ratio float64, random func() float64) *ratioSamplingStrategy {
+	return &ratioSamplingStrategy{ratio, random}
+}
+
+func (s *ratioSamplingStrategy) keep(event *event.Event, now time.Time) (bool, float64) {
+	return s.random() < s.ratio, s.ratio
+}
+
+func (s *ratioSamplingStrategy) mode() string {
+	return samplingModeRatio
+}
+
+// FlowSamplingStrategy stores a fixed proportion of flows (connections),
+// storing either all or none of the events of each flow, so that the state
+// timelines of the stored flows are complete.
+type flowSamplingStrategy struct {
+	threshold uint32
+	ratio     float64
+}
+
+func newFlowSamplingStrategy(ratio float64) *flowSamplingStrategy {
+	return &flowSamplingStrategy{
+		threshold: uint32(math.Min(ratio*math.MaxUint32, math.MaxUint32)),
+		ratio:     ratio,
+	}
+}
+
+func (s *flowSamplingStrategy) keep(event *event.Event, now time.Time) (bool, float64) {
+	hash := fnv.New32a()
+	hash.Write(event.SourceIP.To16())
+	hash.Write(event.DestIP.To16())
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports, event.SourcePort)
+	binary.BigEndian.PutUint16(ports[2:], event.DestPort)
+	hash.Write(ports)
+
+	// A ratio of 1 must store all flows, including the one hashing to MaxUint32
+	return s.ratio >= 1 || hash.Sum32() < s.threshold, s.ratio
+}
+
+func (s *flowSamplingStrategy) mode() string {
+	return samplingModeFlow
+}
+
+// SourceSamplingStrategy limits the rate at which the events of each source
+// IP address are stored using a token bucket per source.
+type sourceSamplingStrategy struct {
+	rate, burst float64
+
+	buckets    map[string]*tokenBucket
+	overflow   *tokenBucket
+	lastPruned time.Time
+}
+
+func newSourceSamplingStrategy(rate, burst float64) *sourceSamplingStrategy {
+	return &sourceSamplingStrategy{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (s *sourceSamplingStrategy) keep(event *event.Event, now time.Time) (bool, float64) {
+	return s.bucket(event.SourceIP.String(), now).take(now)
+}
+
+func (s *sourceSamplingStrategy) mode() string {
+	return samplingModeSource
+}
+
+// Bucket returns the token bucket for the source. If too many sources are
+// being tracked, idle buckets are pruned, and if that is not sufficient,
+// the shared overflow bucket is returned.
+func (s *sourceSamplingStrategy) bucket(source string, now time.Time) *tokenBucket {
+	if bucket, ok := s.buckets[source]; ok {
+		return bucket
+	}
+
+	if len(s.buckets) >= maxTokenBuckets && now.Sub(s.lastPruned) >= tokenBucketPruneDelay {
+		for key, bucket := range s.buckets {
+			if bucket.full(now) {
+				delete(s.buckets, key)
+			}
+		}
+		s.lastPruned = now
+	}
+
+	if len(s.buckets) >= maxTokenBuckets {
+		if s.overflow == nil {
+			s.overflow = newTokenBucket(s.rate, s.burst, now)
+		}
+
+		return s.overflow
+	}
+
+	bucket := newTokenBucket(s.rate, s.burst, now)
+	s.buckets[source] = bucket
+	return bucket
+}
+
+// TokenBucket allows events at a sustained rate with bursts up to a size.
+// In order to estimate the probability with which an event is allowed, it
+// counts the events seen and allowed in windows of the time taken to refill
+// the bucket, starting when the bucket is not full. The estimate is the
+// proportion of events allowed in the previous window, or 1 during the first
+// window. So while a source's rate of events is steady the estimate is the
+// proportion in effect, rather than a running proportion biased towards 1 by
+// the burst allowed at the start of the window, but it lags a change in rate
+// by a window.
+type tokenBucket struct {
+	rate, burst float64
+	window      time.Duration
+
+	tokens        float64
+	last          time.Time
+	windowStart   time.Time
+	seen, stored  uint64
+	effectiveRate float64
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:          rate,
+		burst:         burst,
+		window:        time.Duration(burst / rate * float64(time.Second)),
+		tokens:        burst,
+		last:          now,
+		windowStart:   now,
+		effectiveRate: 1,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// Take returns whether an event is allowed, and the estimated probability
+// with which it was.
+func (b *tokenBucket) take(now time.Time) (bool, float64) {
+	switch {
+	case b.full(now):
+		b.windowStart, b.seen, b.stored, b.effectiveRate = now, 0, 0, 1
+	case now.Sub(b.windowStart) >= b.window:
+		if b.seen > 0 {
+			b.effectiveRate = float64(b.stored) / float64(b.seen)
+		}
+		b.windowStart, b.seen, b.stored = now, 0, 0
+	}
+
+	b.seen++
+	if b.tokens < 1 {
+		return false, b.effectiveRate
+	}
+
+	b.tokens--
+	b.stored++
+	return true, b.effectiveRate
+}
+
+// ParseSamplingMode validates a sampling mode, returning the default if empty.
+func parseSamplingMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return samplingModeNone, nil
+	case samplingModeNone, samplingModeRatio, samplingModeFlow, samplingModeSource:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown sampling mode %q", mode)
+	}
+}
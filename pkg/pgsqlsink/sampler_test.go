@@ -0,0 +1,247 @@
+package pgsqlsink
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/event"
+)
+
+func newSamplerTestEvent(srcIP string, srcPort uint16) *event.Event {
+	return &event.Event{
+		SourceIP:   net.ParseIP(srcIP),
+		DestIP:     net.ParseIP("7.3.3.7"),
+		SourcePort: srcPort,
+		DestPort:   443,
+	}
+}
+
+func TestRatioSamplingStrategy(t *testing.T) {
+	randoms := []float64{0.1, 0.5, 0.24, 0.25}
+	random := func() float64 {
+		r := randoms[0]
+		randoms = randoms[1:]
+		return r
+	}
+	strategy := newRatioSamplingStrategy(0.25, random)
+
+	expectedKeeps := []bool{true, false, true, false}
+	for i, expectedKeep := range expectedKeeps {
+		keep, rate := strategy.keep(newSamplerTestEvent("1.2.3.4", 1234), time.Now())
+		if keep != expectedKeep {
+			t.Errorf("event %d: expected keep to be %t, got %t", i, expectedKeep, keep)
+		}
+
+		if rate != 0.25 {
+			t.Errorf("event %d: expected rate %v, got %v", i, 0.25, rate)
+		}
+	}
+}
+
+func TestFlowSamplingStrategyKeepsWholeFlows(t *testing.T) {
+	strategy := newFlowSamplingStrategy(0.5)
+
+	kept := 0
+	for port := uint16(1024); port < 2048; port++ {
+		event := newSamplerTestEvent("1.2.3.4", port)
+		keep, _ := strategy.keep(event, time.Now())
+		if keep {
+			kept++
+		}
+
+		// Every event of the same flow must have the same outcome
+		for i := 0; i < 3; i++ {
+			if again, _ := strategy.keep(event, time.Now()); again != keep {
+				t.Fatalf("expected flow with source port %d to be consistently sampled, but was not", port)
+			}
+		}
+	}
+
+	// Expect roughly half of the flows to be kept
+	if kept < 384 || kept > 640 {
+		t.Errorf("expected approximately %d of %d flows to be kept, got %d", 512, 1024, kept)
+	}
+}
+
+func TestFlowSamplingStrategyRatioOneKeepsAll(t *testing.T) {
+	strategy := newFlowSamplingStrategy(1)
+
+	for port := uint16(1024); port < 2048; port++ {
+		if keep, _ := strategy.keep(newSamplerTestEvent("1.2.3.4", port), time.Now()); !keep {
+			t.Fatalf("expected flow with source port %d to be kept, but was not", port)
+		}
+	}
+}
+
+func TestSourceSamplingStrategy(t *testing.T) {
+	now := time.Now()
+	strategy := newSourceSamplingStrategy(1, 2)
+
+	// The burst is allowed, and then the source is limited
+	expectedKeeps := []bool{true, true, false, false}
+	for i, expectedKeep := range expectedKeeps {
+		keep, _ := strategy.keep(newSamplerTestEvent("1.2.3.4", 1234), now)
+		if keep != expectedKeep {
+			t.Errorf("event %d: expected keep to be %t, got %t", i, expectedKeep, keep)
+		}
+	}
+
+	// Other sources are limited separately
+	if keep, _ := strategy.keep(newSamplerTestEvent("4.3.2.1", 1234), now); !keep {
+		t.Error("expected event from another source to be kept, but was not")
+	}
+
+	// The source's bucket refills over time
+	if keep, _ := strategy.keep(newSamplerTestEvent("1.2.3.4", 1234), now.Add(time.Second)); !keep {
+		t.Error("expected event after refill to be kept, but was not")
+	}
+}
+
+func TestTokenBucketRateOfPreviousWindow(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(1, 2, now)
+
+	// During the first window, the rate is not yet known
+	for i, expectedKeep := range []bool{true, true, false, false} {
+		keep, rate := bucket.take(now)
+		if keep != expectedKeep || rate != 1 {
+			t.Errorf("event %d: expected %t with rate %v, got %t with rate %v", i, expectedKeep, 1, keep, rate)
+		}
+	}
+
+	bucket.take(now.Add(time.Second))
+	bucket.take(now.Add(time.Second))
+
+	// Three of the six events of the first window were stored, and the
+	// events of the next window are stored at that rate
+	keep, rate := bucket.take(now.Add(2 * time.Second))
+	if !keep {
+		t.Error("expected event to be kept, but was not")
+	}
+
+	if rate != 0.5 {
+		t.Errorf("expected rate %v, got %v", 0.5, rate)
+	}
+
+	if _, rate := bucket.take(now.Add(3 * time.Second)); rate != 0.5 {
+		t.Errorf("expected rate %v, got %v", 0.5, rate)
+	}
+}
+
+func TestTokenBucketRateResetsWhenFull(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(1, 1, now)
+
+	bucket.take(now)
+	bucket.take(now)
+	keep, rate := bucket.take(now.Add(10 * time.Second))
+	if !keep {
+		t.Error("expected event to be kept, but was not")
+	}
+
+	if rate != 1 {
+		t.Errorf("expected rate %v, got %v", 1, rate)
+	}
+}
+
+type mockSamplingStrategy struct {
+	keepToReturn bool
+}
+
+func (s *mockSamplingStrategy) keep(event *event.Event, now time.Time) (bool, float64) {
+	return s.keepToReturn, 0.5
+}
+
+func (s *mockSamplingStrategy) mode() string {
+	return "mock"
+}
+
+func TestSummarisingSamplerSummary(t *testing.T) {
+	start := time.Now()
+	now := start
+	strategy := &mockSamplingStrategy{keepToReturn: true}
+	sampler := newSummarisingSampler(strategy, mockHost, time.Minute, func() time.Time { return now })
+
+	if _, _, summary := sampler.sample(newSamplerTestEvent("1.2.3.4", 1234)); summary != nil {
+		t.Errorf("expected no summary before end of period, got %+v", summary)
+	}
+
+	strategy.keepToReturn = false
+	now = start.Add(30 * time.Second)
+	sampler.sample(newSamplerTestEvent("1.2.3.4", 1234))
+
+	now = start.Add(time.Minute)
+	keep, rate, summary := sampler.sample(newSamplerTestEvent("1.2.3.4", 1234))
+	if keep || rate != 0.5 {
+		t.Errorf("expected strategy result to be returned, got %t, %v", keep, rate)
+	}
+
+	if summary == nil {
+		t.Fatal("expected summary at end of period, got nil")
+	}
+
+	if summary.seen != 2 || summary.stored != 1 {
+		t.Errorf("expected summary of %d seen and %d stored, got %d and %d", 2, 1, summary.seen, summary.stored)
+	}
+
+	if summary.host != mockHost || summary.mode != "mock" || summary.uid == "" {
+		t.Errorf("expected summary to be identified, got %+v", summary)
+	}
+
+	if !summary.periodStart.Equal(start) || !summary.periodEnd.Equal(now) {
+		t.Errorf("expected summary period %v to %v, got %v to %v",
+			start,
+			now,
+			summary.periodStart,
+			summary.periodEnd)
+	}
+
+	// The event which ended the period is counted in the next period
+	summary = sampler.close()
+	if summary.seen != 1 || summary.stored != 0 {
+		t.Errorf("expected final summary of %d seen and %d stored, got %d and %d", 1, 0, summary.seen, summary.stored)
+	}
+}
+
+func TestSummarisingSamplerFlushIfDue(t *testing.T) {
+	start := time.Now()
+	now := start
+	sampler := newSummarisingSampler(&mockSamplingStrategy{true}, mockHost, time.Minute, func() time.Time { return now })
+	sampler.sample(newSamplerTestEvent("1.2.3.4", 1234))
+
+	now = start.Add(59 * time.Second)
+	if summary := sampler.flushIfDue(); summary != nil {
+		t.Errorf("expected no summary before end of period, got %+v", summary)
+	}
+
+	// The period ends without another event being sampled
+	now = start.Add(time.Minute)
+	summary := sampler.flushIfDue()
+	if summary == nil {
+		t.Fatal("expected summary at end of period, got nil")
+	}
+
+	if summary.seen != 1 || summary.stored != 1 || !summary.periodEnd.Equal(now) {
+		t.Errorf("expected summary of %d seen and %d stored ending at %v, got %+v", 1, 1, now, summary)
+	}
+
+	if summary := sampler.flushIfDue(); summary != nil {
+		t.Errorf("expected no summary before end of next period, got %+v", summary)
+	}
+}
+
+func TestParseSamplingMode(t *testing.T) {
+	mode, err := parseSamplingMode("")
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if mode != samplingModeNone {
+		t.Errorf("expected default mode %q, got %q", samplingModeNone, mode)
+	}
+
+	if _, err := parseSamplingMode("bogus"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
@@ -0,0 +1,566 @@
+package pgsqlsink
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+const (
+	// SchemaCheckWarn logs the differences between the schema and the layout
+	// expected by the sink, and starts the sink regardless.
+	schemaCheckWarn = "warn"
+
+	// SchemaCheckFail fails to start the sink if the schema differs from the
+	// layout expected.
+	schemaCheckFail = "fail"
+
+	// SchemaCheckOff does not check the layout of the schema.
+	schemaCheckOff = "off"
+
+	// TimescaleInstalledSQL returns whether the TimescaleDB extension is
+	// installed, in which case tcp_events is expected to be a hypertable if
+	// configured as such.
+	timescaleInstalledSQL = `SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_extension WHERE extname = 'timescaledb')`
+
+	// LayoutColumnsSQL returns the columns of the tables of the current
+	// schema with the given names, with their types and nullability.
+	layoutColumnsSQL = `
+SELECT c.relname::TEXT, a.attname::TEXT, format_type(a.atttypid, a.atttypmod), a.attnotnull
+FROM pg_catalog.pg_attribute a
+JOIN pg_catalog.pg_class c ON c.oid = a.attrelid
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = current_schema()
+AND c.relname = ANY($1)
+AND a.attnum > 0
+AND NOT a.attisdropped`
+
+	// LayoutConstraintsSQL returns the primary key, unique, foreign key,
+	// check and exclusion constraints of the tables of the current schema
+	// with the given names.
+	layoutConstraintsSQL = `
+SELECT c.relname::TEXT, con.conname::TEXT, pg_get_constraintdef(con.oid)
+FROM pg_catalog.pg_constraint con
+JOIN pg_catalog.pg_class c ON c.oid = con.conrelid
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = current_schema()
+AND c.relname = ANY($1)
+AND con.contype IN ('p', 'u', 'f', 'c', 'x')`
+
+	// LayoutIndexesSQL returns the indexes of the tables of the current
+	// schema with the given names, other than those backing constraints,
+	// with their method, key columns and validity.
+	layoutIndexesSQL = `
+SELECT t.relname::TEXT, ic.relname::TEXT, am.amname::TEXT,
+	array_to_string(ARRAY(SELECT pg_get_indexdef(i.indexrelid, k, TRUE)
+	                      FROM generate_series(1, i.indnkeyatts) k
+	                      ORDER BY k), ', '),
+	i.indisvalid
+FROM pg_catalog.pg_index i
+JOIN pg_catalog.pg_class ic ON ic.oid = i.indexrelid
+JOIN pg_catalog.pg_class t ON t.oid = i.indrelid
+JOIN pg_catalog.pg_namespace n ON n.oid = t.relnamespace
+JOIN pg_catalog.pg_am am ON am.oid = ic.relam
+WHERE n.nspname = current_schema()
+AND t.relname = ANY($1)
+AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_constraint con
+                WHERE con.conindid = i.indexrelid
+                AND con.contype IN ('p', 'u', 'x'))`
+)
+
+// ParseSchemaCheck parses the action taken when the schema differs from the
+// layout expected by the sink. If empty, differences are logged where the
+// compatibility mode supports the check, and not checked otherwise.
+func parseSchemaCheck(check string, compatMode *compatMode) (string, error) {
+	switch check = strings.TrimSpace(check); check {
+	case "":
+		if !compatMode.catalogChecks {
+			return schemaCheckOff, nil
+		}
+
+		return schemaCheckWarn, nil
+	case schemaCheckOff:
+		return check, nil
+	case schemaCheckWarn, schemaCheckFail:
+		if !compatMode.catalogChecks {
+			return "", fmt.Errorf("%s is not supported in %s compatibility mode", check, compatMode.name)
+		}
+
+		return check, nil
+	default:
+		return "", fmt.Errorf("unknown schema check %q", check)
+	}
+}
+
+// TableLayout is the layout of a table expected by the sink.
+type tableLayout struct {
+	name        string
+	columns     []*columnLayout
+	constraints []*constraintLayout
+	indexes     []*indexLayout
+
+	// AllowedIndexes are the names of indexes which may exist but are not
+	// expected, such as the indexes created by the sink when they are
+	// disabled.
+	allowedIndexes []string
+}
+
+// ColumnLayout is a column of a table, with its type as reported by
+// format_type.
+type columnLayout struct {
+	name     string
+	dataType string
+	notNull  bool
+}
+
+func (c *columnLayout) String() string {
+	if c.notNull {
+		return fmt.Sprintf("column %s %s NOT NULL", c.name, c.dataType)
+	}
+
+	return fmt.Sprintf("column %s %s", c.name, c.dataType)
+}
+
+// ConstraintLayout is a constraint of a table, with its definition as
+// reported by pg_get_constraintdef.
+type constraintLayout struct {
+	name       string
+	definition string
+}
+
+func (c *constraintLayout) String() string {
+	return fmt.Sprintf("constraint %s %s", c.name, unquoteIdentifiers(c.definition))
+}
+
+// IndexLayout is an index of a table, other than one backing a constraint.
+type indexLayout struct {
+	name    string
+	method  string
+	columns string
+	invalid bool
+}
+
+func (i *indexLayout) String() string {
+	if i.invalid {
+		return fmt.Sprintf("index %s USING %s (%s) INVALID", i.name, i.method, unquoteIdentifiers(i.columns))
+	}
+
+	return fmt.Sprintf("index %s USING %s (%s)", i.name, i.method, unquoteIdentifiers(i.columns))
+}
+
+// UnquoteIdentifiers removes the quotes which the catalog functions add to
+// identifiers which are keywords, such as timestamp.
+func unquoteIdentifiers(definition string) string {
+	return strings.ReplaceAll(definition, `"`, "")
+}
+
+// ExpectedLayout returns the layout of the tables created by a table creator
+// with the given configuration. If hypertable is true, tcp_events is
+// expected to have been made a hypertable.
+func expectedLayout(compatMode *compatMode, hypertable, indexes bool) []*tableLayout {
+	events := &tableLayout{
+		name: schema.EventsTable,
+		columns: []*columnLayout{
+			{"uid", "text", true},
+			{"timestamp", "timestamp without time zone", hypertable},
+			{"pid_on_cpu", "integer", false},
+			{"comm_on_cpu", "text", false},
+			{"src_ip", "inet", false},
+			{"dst_ip", "inet", false},
+			{"src_port", "integer", false},
+			{"dst_port", "integer", false},
+			{"old_state", schema.TCPStateType, false},
+			{"new_state", schema.TCPStateType, false},
+			{"host", "text", false},
+			{"sample_rate", "double precision", true},
+			{"src_name", "text", false},
+			{"dst_name", "text", false},
+			{"src_service", "text", false},
+			{"dst_service", "text", false},
+			{"container_id", "text", false},
+			{"pod_namespace", "text", false},
+			{"pod_name", "text", false},
+			{"container_name", "text", false},
+			{"src_country", "text", false},
+			{"dst_country", "text", false},
+			{"src_asn", "bigint", false},
+			{"dst_asn", "bigint", false},
+			{"src_as_org", "text", false},
+			{"dst_as_org", "text", false},
+			{"direction", schema.DirectionType, false},
+			{"local_ip", "inet", false},
+			{"local_port", "integer", false},
+			{"remote_ip", "inet", false},
+			{"remote_port", "integer", false},
+			{"socket_id", "text", false},
+			{"socket_inode", "bigint", false},
+			{"socket_state", schema.SocketStateType, false},
+			{"chain_seq", "bigint", false},
+			{"chain_hash", "text", false},
+		},
+		constraints: []*constraintLayout{
+			{"fk_tcp_sockets", "FOREIGN KEY (host, socket_id, socket_inode) REFERENCES tcp_sockets(host, id, inode)"},
+		},
+	}
+
+	sockets := &tableLayout{
+		name: schema.SocketsTable,
+		columns: []*columnLayout{
+			{"host", "text", true},
+			{"id", "text", true},
+			{"inode", "bigint", true},
+			{"user_id", "bigint", false},
+			{"group_id", "bigint", false},
+		},
+		constraints: []*constraintLayout{
+			{"tcp_sockets_pkey", "PRIMARY KEY (host, id, inode)"},
+		},
+	}
+
+	socketInfo := &tableLayout{
+		name: schema.SocketInfoTable,
+		columns: []*columnLayout{
+			{"uid", "text", true},
+			{"tcp_event_uid", "text", false},
+			{"id", "text", false},
+			{"inode", "integer", false},
+			{"user_id", "integer", false},
+			{"group_id", "integer", false},
+			{"state", schema.SocketStateType, false},
+		},
+		constraints: []*constraintLayout{
+			{"tcp_events_socket_info_pkey", "PRIMARY KEY (uid)"},
+		},
+	}
+
+	processInfo := &tableLayout{
+		name: schema.ProcessInfoTable,
+		columns: []*columnLayout{
+			{"tcp_event_uid", "text", true},
+			{"cmdline", "text", false},
+			{"exe", "text", false},
+			{"parent_pid", "integer", false},
+			{"user_id", "bigint", false},
+			{"user_name", "text", false},
+			{"cgroup", "text", false},
+			{"container_id", "text", false},
+			{"socket_user_name", "text", false},
+			{"socket_group_name", "text", false},
+		},
+		constraints: []*constraintLayout{
+			{"tcp_events_process_info_pkey", "PRIMARY KEY (tcp_event_uid)"},
+		},
+	}
+
+	samplingSummary := &tableLayout{
+		name: schema.SamplingSummaryTable,
+		columns: []*columnLayout{
+			{"uid", "text", true},
+			{"host", "text", false},
+			{"mode", "text", false},
+			{"period_start", "timestamp without time zone", false},
+			{"period_end", "timestamp without time zone", false},
+			{"seen", "bigint", false},
+			{"stored", "bigint", false},
+			{"suppressed", "bigint", false},
+		},
+		constraints: []*constraintLayout{
+			{"tcp_events_sampling_summary_pkey", "PRIMARY KEY (uid)"},
+		},
+	}
+
+	chainCheckpoints := &tableLayout{
+		name: schema.ChainCheckpointsTable,
+		columns: []*columnLayout{
+			{"host", "text", true},
+			{"chain_seq", "bigint", true},
+			{"chain_hash", "text", true},
+			{"created_at", "timestamp without time zone", true},
+			{"key_id", "text", true},
+			{"signature", "text", true},
+		},
+		constraints: []*constraintLayout{
+			{"tcp_events_chain_checkpoints_pkey", "PRIMARY KEY (host, chain_seq)"},
+		},
+	}
+
+	// Plain tables cannot reference a hypertable, so the foreign keys to
+	// tcp_events are dropped when it is converted
+	if hypertable {
+		events.constraints = append(events.constraints,
+			&constraintLayout{"tcp_events_pkey", "PRIMARY KEY (uid, timestamp)"})
+		events.indexes = append(events.indexes,
+			&indexLayout{name: "tcp_events_timestamp_idx", method: indexMethodBTree, columns: "timestamp"})
+	} else {
+		events.constraints = append(events.constraints,
+			&constraintLayout{"tcp_events_pkey", "PRIMARY KEY (uid)"})
+		socketInfo.constraints = append(socketInfo.constraints,
+			&constraintLayout{"fk_tcp_events", "FOREIGN KEY (tcp_event_uid) REFERENCES tcp_events(uid) ON DELETE CASCADE"})
+		processInfo.constraints = append(processInfo.constraints,
+			&constraintLayout{"fk_tcp_events", "FOREIGN KEY (tcp_event_uid) REFERENCES tcp_events(uid) ON DELETE CASCADE"})
+	}
+
+	layout := []*tableLayout{events, sockets, socketInfo, processInfo, samplingSummary, chainCheckpoints}
+
+	rollupKey := "bucket, host"
+	if compatMode.rollupKeyLeadsWithHost {
+		rollupKey = "host, bucket"
+	}
+
+	for _, table := range []string{schema.RollupMinuteTable, schema.RollupHourTable} {
+		layout = append(layout, &tableLayout{
+			name: table,
+			columns: []*columnLayout{
+				{"bucket", "timestamp without time zone", true},
+				{"host", "text", true},
+				{"remote_ip", "inet", true},
+				{"remote_port", "integer", true},
+				{"comm", "text", true},
+				{"old_state", schema.TCPStateType, true},
+				{"new_state", schema.TCPStateType, true},
+				{"events", "bigint", true},
+				{"estimated_events", "double precision", true},
+			},
+			constraints: []*constraintLayout{
+				{table + "_pkey", "PRIMARY KEY (" + rollupKey + ", remote_ip, remote_port, comm, old_state, new_state)"},
+			},
+		})
+	}
+
+	tables := make(map[string]*tableLayout, len(layout))
+	for _, table := range layout {
+		tables[table.name] = table
+	}
+
+	for _, index := range tableIndexes {
+		table := tables[index.table]
+		if !indexes {
+			table.allowedIndexes = append(table.allowedIndexes, index.name)
+			continue
+		}
+
+		method := index.method
+		if !compatMode.indexMethods {
+			method = indexMethodBTree
+		}
+
+		table.indexes = append(table.indexes, &indexLayout{
+			name:    index.name,
+			method:  method,
+			columns: strings.Join(index.columns, ", "),
+		})
+	}
+
+	return layout
+}
+
+// SchemaLayoutError describes the differences between the schema and the
+// layout expected by the sink.
+type schemaLayoutError struct {
+	diff string
+}
+
+func (e *schemaLayoutError) Error() string {
+	return "schema differs from the layout expected by the sink (- expected, + found):\n" + e.diff
+}
+
+// LayoutCheckingTableCreator is a table creator which, after the tables are
+// created (or verified), compares the schema to the layout expected by the
+// sink, so that tables altered by hand are reported at startup rather than
+// when inserts start failing. In the warn schema check, the differences are
+// logged rather than returned.
+type layoutCheckingTableCreator struct {
+	tableCreator tableCreator
+	conn         conn
+	compatMode   *compatMode
+	hypertable   bool
+	indexes      bool
+	check        string
+	logger       Logger
+}
+
+func newLayoutCheckingTableCreator(tableCreator tableCreator,
+	conn conn,
+	compatMode *compatMode,
+	hypertable bool,
+	indexes bool,
+	check string,
+	logger Logger) *layoutCheckingTableCreator {
+	return &layoutCheckingTableCreator{tableCreator, conn, compatMode, hypertable, indexes, check, logger}
+}
+
+// CreateTables creates the tables, then checks their layout.
+func (tc *layoutCheckingTableCreator) createTables(ctx context.Context) error {
+	if err := tc.tableCreator.createTables(ctx); err != nil {
+		return err
+	}
+
+	diff, err := tc.diff(ctx)
+	if err != nil {
+		return fmt.Errorf("checking schema layout: %w", err)
+	}
+
+	if diff == "" {
+		return nil
+	}
+
+	layoutErr := &schemaLayoutError{diff}
+	if tc.check == schemaCheckFail {
+		return layoutErr
+	}
+
+	tc.logger.Printf("Warning: %v", layoutErr)
+	return nil
+}
+
+// Diff returns the differences between the schema and the expected layout,
+// grouped by table, or the empty string if there are none.
+func (tc *layoutCheckingTableCreator) diff(ctx context.Context) (string, error) {
+	hypertable := false
+	if tc.hypertable {
+		rows, err := tc.conn.query(ctx, timescaleInstalledSQL)
+		if err != nil {
+			return "", fmt.Errorf("querying TimescaleDB extension: %w", err)
+		}
+
+		// The table creator leaves tcp_events as a plain table if the
+		// extension is not installed
+		hypertable = len(rows) == 1 && boolValue(rows[0][0])
+	}
+
+	layout := expectedLayout(tc.compatMode, hypertable, tc.indexes)
+	names := make([]string, 0, len(layout))
+	for _, table := range layout {
+		names = append(names, table.name)
+	}
+
+	found := make(map[string][]string, len(layout))
+	rows, err := tc.conn.query(ctx, layoutColumnsSQL, names)
+	if err != nil {
+		return "", fmt.Errorf("querying columns: %w", err)
+	}
+
+	for _, row := range rows {
+		column := &columnLayout{stringValue(row[1]), stringValue(row[2]), boolValue(row[3])}
+		found[stringValue(row[0])] = append(found[stringValue(row[0])], column.String())
+	}
+
+	if rows, err = tc.conn.query(ctx, layoutConstraintsSQL, names); err != nil {
+		return "", fmt.Errorf("querying constraints: %w", err)
+	}
+
+	for _, row := range rows {
+		constraint := &constraintLayout{stringValue(row[1]), stringValue(row[2])}
+		found[stringValue(row[0])] = append(found[stringValue(row[0])], constraint.String())
+	}
+
+	if rows, err = tc.conn.query(ctx, layoutIndexesSQL, names); err != nil {
+		return "", fmt.Errorf("querying indexes: %w", err)
+	}
+
+	allowed := make(map[string]bool)
+	for _, table := range layout {
+		for _, name := range table.allowedIndexes {
+			allowed[name] = true
+		}
+	}
+
+	for _, row := range rows {
+		index := &indexLayout{stringValue(row[1]), stringValue(row[2]), stringValue(row[3]), !boolValue(row[4])}
+		if !allowed[index.name] {
+			found[stringValue(row[0])] = append(found[stringValue(row[0])], index.String())
+		}
+	}
+
+	var diff strings.Builder
+	for _, table := range layout {
+		// Tables without columns do not exist, as every table has at least one
+		if len(found[table.name]) == 0 {
+			fmt.Fprintf(&diff, "%s:\n\t- table %s\n", table.name, table.name)
+			continue
+		}
+
+		var expected []string
+		for _, column := range table.columns {
+			expected = append(expected, column.String())
+		}
+
+		for _, constraint := range table.constraints {
+			expected = append(expected, constraint.String())
+		}
+
+		for _, index := range table.indexes {
+			expected = append(expected, index.String())
+		}
+
+		if lines := diffLines(expected, found[table.name]); len(lines) != 0 {
+			fmt.Fprintf(&diff, "%s:\n\t%s\n", table.name, strings.Join(lines, "\n\t"))
+		}
+	}
+
+	return diff.String(), nil
+}
+
+// DiffLines returns the lines which are expected but not found, prefixed
+// with -, and those found but not expected, prefixed with +. The lines are
+// sorted by kind and name, so that a changed column is shown as a - line
+// followed by a + line.
+func diffLines(expected, found []string) []string {
+	isExpected := make(map[string]bool, len(expected))
+	for _, line := range expected {
+		isExpected[line] = true
+	}
+
+	isFound := make(map[string]bool, len(found))
+	for _, line := range found {
+		isFound[line] = true
+	}
+
+	type diffLine struct {
+		sign, line string
+	}
+
+	var lines []diffLine
+	for _, line := range expected {
+		if !isFound[line] {
+			lines = append(lines, diffLine{"-", line})
+		}
+	}
+
+	for _, line := range found {
+		if !isExpected[line] {
+			lines = append(lines, diffLine{"+", line})
+		}
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		iKey, jKey := diffLineKey(lines[i].line), diffLineKey(lines[j].line)
+		if iKey != jKey {
+			return iKey < jKey
+		}
+
+		// Lines of the same object are - then + as appended
+		return false
+	})
+
+	diff := make([]string, 0, len(lines))
+	for _, line := range lines {
+		diff = append(diff, line.sign+" "+line.line)
+	}
+
+	return diff
+}
+
+// DiffLineKey returns the kind and name of the object described by a line,
+// e.g. "column uid".
+func diffLineKey(line string) string {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 2 {
+		return line
+	}
+
+	return fields[0] + " " + fields[1]
+}
@@ -0,0 +1,276 @@
+package pgsqlsink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+// MockLayoutConn returns a conn whose catalog holds the tables exactly as
+// laid out.
+func mockLayoutConn(layout []*tableLayout, timescaleInstalled bool) *mockConn {
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		timescaleInstalledSQL: {{timescaleInstalled}},
+	}
+
+	for _, table := range layout {
+		for _, column := range table.columns {
+			mockConn.queryResultsToReturn[layoutColumnsSQL] = append(mockConn.queryResultsToReturn[layoutColumnsSQL],
+				[]interface{}{table.name, column.name, column.dataType, column.notNull})
+		}
+
+		for _, constraint := range table.constraints {
+			mockConn.queryResultsToReturn[layoutConstraintsSQL] = append(mockConn.queryResultsToReturn[layoutConstraintsSQL],
+				[]interface{}{table.name, constraint.name, constraint.definition})
+		}
+
+		for _, index := range table.indexes {
+			mockConn.queryResultsToReturn[layoutIndexesSQL] = append(mockConn.queryResultsToReturn[layoutIndexesSQL],
+				[]interface{}{table.name, index.name, index.method, index.columns, true})
+		}
+	}
+
+	return mockConn
+}
+
+func TestCheckLayout(t *testing.T) {
+	compatMode := compatModes[compatModePostgreSQL]
+	mockConn := mockLayoutConn(expectedLayout(compatMode, true, true), true)
+	mockTableCreator := newMockTableCreator(nil)
+	var logged bytes.Buffer
+	tableCreator := newLayoutCheckingTableCreator(mockTableCreator,
+		mockConn,
+		compatMode,
+		true,
+		true,
+		schemaCheckFail,
+		log.New(&logged, "", 0))
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockTableCreator.createTablesCalled {
+		t.Error("expected tables to be created, but were not")
+	}
+
+	if logged.Len() != 0 {
+		t.Errorf("expected nothing to be logged, got %q", logged.String())
+	}
+}
+
+func TestCheckLayoutReportsDifferences(t *testing.T) {
+	compatMode := compatModes[compatModePostgreSQL]
+	mockConn := mockLayoutConn(expectedLayout(compatMode, false, false), false)
+
+	// Change the type of a column, drop a constraint and the sockets table,
+	// and add an index and an index created by the sink, which is allowed
+	var columns, constraints [][]interface{}
+	for _, row := range mockConn.queryResultsToReturn[layoutColumnsSQL] {
+		switch {
+		case row[0] == schema.SocketsTable:
+			continue
+		case row[0] == schema.EventsTable && row[1] == "socket_state":
+			row = []interface{}{row[0], row[1], "text", false}
+		}
+
+		columns = append(columns, row)
+	}
+
+	for _, row := range mockConn.queryResultsToReturn[layoutConstraintsSQL] {
+		if row[0] == schema.SocketsTable || row[1] == "fk_tcp_sockets" {
+			continue
+		}
+
+		constraints = append(constraints, row)
+	}
+
+	mockConn.queryResultsToReturn[layoutColumnsSQL] = columns
+	mockConn.queryResultsToReturn[layoutConstraintsSQL] = constraints
+	mockConn.queryResultsToReturn[layoutIndexesSQL] = [][]interface{}{
+		{schema.EventsTable, "tcp_events_host_idx", indexMethodBTree, "host", false},
+		{schema.EventsTable, "tcp_events_dst_port_idx", indexMethodBTree, "dst_port", true},
+	}
+
+	tableCreator := newLayoutCheckingTableCreator(newMockTableCreator(nil),
+		mockConn,
+		compatMode,
+		false,
+		false,
+		schemaCheckFail,
+		discardLogger)
+
+	err := tableCreator.createTables(context.TODO())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	var layoutErr *schemaLayoutError
+	if !errors.As(err, &layoutErr) {
+		t.Fatalf("expected error chain to include schema layout error, but did not")
+	}
+
+	expectedDiff := `tcp_events:
+	- column socket_state socket_state
+	+ column socket_state text
+	- constraint fk_tcp_sockets FOREIGN KEY (host, socket_id, socket_inode) REFERENCES tcp_sockets(host, id, inode)
+	+ index tcp_events_host_idx USING btree (host) INVALID
+tcp_sockets:
+	- table tcp_sockets
+`
+	if layoutErr.diff != expectedDiff {
+		t.Errorf("expected diff:\n%s\ngot:\n%s", expectedDiff, layoutErr.diff)
+	}
+}
+
+func TestCheckLayoutWarns(t *testing.T) {
+	compatMode := compatModes[compatModePostgreSQL]
+
+	// The hypertable layout is not expected, as TimescaleDB is not installed
+	mockConn := mockLayoutConn(expectedLayout(compatMode, false, false), false)
+	mockConn.queryResultsToReturn[layoutIndexesSQL] = [][]interface{}{
+		{schema.EventsTable, "tcp_events_host_idx", indexMethodBTree, "host", true},
+	}
+
+	var logged bytes.Buffer
+	tableCreator := newLayoutCheckingTableCreator(newMockTableCreator(nil),
+		mockConn,
+		compatMode,
+		true,
+		false,
+		schemaCheckWarn,
+		log.New(&logged, "", 0))
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !strings.Contains(logged.String(), "+ index tcp_events_host_idx USING btree (host)") {
+		t.Errorf("expected differences to be logged, got %q", logged.String())
+	}
+}
+
+func TestCheckLayoutTableCreatorError(t *testing.T) {
+	mockError := errors.New("mock table creator error")
+	mockConn := newMockConn(nil, nil)
+	tableCreator := newLayoutCheckingTableCreator(newMockTableCreator(mockError),
+		mockConn,
+		compatModes[compatModePostgreSQL],
+		false,
+		false,
+		schemaCheckFail,
+		discardLogger)
+
+	err := tableCreator.createTables(context.TODO())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+func TestCheckLayoutQueryError(t *testing.T) {
+	mockError := errors.New("mock query error")
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryErrorToReturn = mockError
+	tableCreator := newLayoutCheckingTableCreator(newMockTableCreator(nil),
+		mockConn,
+		compatModes[compatModePostgreSQL],
+		false,
+		false,
+		schemaCheckWarn,
+		discardLogger)
+
+	err := tableCreator.createTables(context.TODO())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+func TestExpectedLayoutColumnsAreCreated(t *testing.T) {
+	// Every expected column is created by the table creator, and no other
+	mockConn := newMockConn(nil, nil)
+	tableCreator := newSQLTableCreator(mockConn, compatModes[compatModePostgreSQL], nil, false, nil, discardLogger)
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	columnPattern := regexp.MustCompile(`(?m)^\s*(?:ADD COLUMN IF NOT EXISTS )?([a-z_]+)\s+[A-Za-z_]`)
+	for _, table := range expectedLayout(compatModes[compatModePostgreSQL], false, false) {
+		tableSQLPattern := regexp.MustCompile(`(CREATE TABLE IF NOT EXISTS|ALTER TABLE) ` + table.name + `\s`)
+
+		created := make(map[string]bool)
+		for _, sql := range mockConn.receivedSQL {
+			if !tableSQLPattern.MatchString(sql) {
+				continue
+			}
+
+			for _, match := range columnPattern.FindAllStringSubmatch(sql, -1) {
+				switch match[1] {
+				case "PRIMARY", "CONSTRAINT", "REFERENCES", "FOREIGN", "ALTER":
+					continue
+				}
+
+				created[match[1]] = true
+			}
+		}
+
+		expected := make(map[string]bool, len(table.columns))
+		for _, column := range table.columns {
+			expected[column.name] = true
+			if !created[column.name] {
+				t.Errorf("expected column %s.%s to be created, but was not", table.name, column.name)
+			}
+		}
+
+		for column := range created {
+			if !expected[column] {
+				t.Errorf("expected column %s.%s to be in the layout, but was not", table.name, column)
+			}
+		}
+	}
+}
+
+func TestParseSchemaCheck(t *testing.T) {
+	postgreSQL := compatModes[compatModePostgreSQL]
+	cockroachDB := compatModes[compatModeCockroachDB]
+
+	if check, err := parseSchemaCheck("", postgreSQL); err != nil || check != schemaCheckWarn {
+		t.Errorf("expected default check %q, got %q (error %v)", schemaCheckWarn, check, err)
+	}
+
+	if check, err := parseSchemaCheck("", cockroachDB); err != nil || check != schemaCheckOff {
+		t.Errorf("expected default check %q, got %q (error %v)", schemaCheckOff, check, err)
+	}
+
+	if check, err := parseSchemaCheck(" fail ", postgreSQL); err != nil || check != schemaCheckFail {
+		t.Errorf("expected check %q, got %q (error %v)", schemaCheckFail, check, err)
+	}
+
+	if _, err := parseSchemaCheck(schemaCheckFail, cockroachDB); err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	if _, err := parseSchemaCheck("mock-check", postgreSQL); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
@@ -0,0 +1,304 @@
+package pgsqlsink
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+const (
+	// SchemaModeMigrate creates and migrates the tables when the sink starts,
+	// which requires the privilege to create tables.
+	schemaModeMigrate = "migrate"
+
+	// SchemaModeVerify only verifies that the tables have been created with
+	// the columns and privileges required, for example by the migration
+	// command, so that the sink may run with the privilege to insert only.
+	schemaModeVerify = "verify"
+
+	// SchemaPrivilegeSQL returns the schema in which tables are looked up,
+	// and whether the user may use it. Both are NULL if no schema in the
+	// search path exists.
+	schemaPrivilegeSQL = `SELECT current_schema()::TEXT, has_schema_privilege(current_schema(), 'USAGE')`
+
+	// TablePrivilegesSQL returns the tables of the current schema with the
+	// given names, and whether the user has each privilege on them.
+	// The system catalogs are used rather than information_schema, which
+	// only shows the objects on which the user has privileges.
+	tablePrivilegesSQL = `
+SELECT c.relname::TEXT,
+	has_table_privilege(c.oid, 'SELECT'),
+	has_table_privilege(c.oid, 'INSERT'),
+	has_table_privilege(c.oid, 'UPDATE'),
+	has_table_privilege(c.oid, 'DELETE')
+FROM pg_catalog.pg_class c
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = current_schema()
+AND c.relkind IN ('r', 'p')
+AND c.relname = ANY($1)`
+
+	// ColumnsSQL returns the columns of the tables of the current schema with
+	// the given names, and whether the user may select them.
+	columnsSQL = `
+SELECT c.relname::TEXT, a.attname::TEXT, has_column_privilege(c.oid, a.attnum, 'SELECT')
+FROM pg_catalog.pg_attribute a
+JOIN pg_catalog.pg_class c ON c.oid = a.attrelid
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = current_schema()
+AND c.relname = ANY($1)
+AND a.attnum > 0
+AND NOT a.attisdropped`
+)
+
+// The privileges returned by tablePrivilegesSQL, in order.
+var tablePrivileges = []string{"SELECT", "INSERT", "UPDATE", "DELETE"}
+
+// ParseSchemaMode parses the mode in which the sink prepares the schema
+// when it starts. If empty, the migrate mode is used.
+func parseSchemaMode(mode string) (string, error) {
+	switch mode = strings.TrimSpace(mode); mode {
+	case "":
+		return schemaModeMigrate, nil
+	case schemaModeMigrate, schemaModeVerify:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown schema mode %q", mode)
+	}
+}
+
+// RequiredTable is a table written by the sink, with the columns it writes
+// and the privileges it requires.
+type requiredTable struct {
+	name       string
+	columns    []string
+	privileges []string
+
+	// SelectedColumns are the columns read by the sink, on which the SELECT
+	// privilege is required if it is not granted on the table.
+	selectedColumns []string
+}
+
+// RequiredTables returns the tables written by a sink with the given
+// configuration.
+// The insert of events names the sockets table even if the event has no
+// socket, so it is always required. The process info table is only named
+// if process enrichment is enabled.
+func requiredTables(config *sinkConfig) []*requiredTable {
+	tables := []*requiredTable{
+		{
+			name: schema.EventsTable,
+			columns: []string{
+				"uid", "timestamp", "pid_on_cpu", "comm_on_cpu", "src_ip", "dst_ip", "src_port", "dst_port",
+				"old_state", "new_state", "host", "sample_rate", "src_name", "dst_name", "src_service",
+				"dst_service", "container_id", "pod_namespace", "pod_name", "container_name", "src_country",
+				"dst_country", "src_asn", "dst_asn", "src_as_org", "dst_as_org", "direction", "local_ip",
+				"local_port", "remote_ip", "remote_port", "socket_id", "socket_inode", "socket_state",
+				"chain_seq", "chain_hash",
+			},
+			privileges: []string{"INSERT"},
+		},
+		{
+			name:       schema.SocketsTable,
+			columns:    []string{"host", "id", "inode", "user_id", "group_id"},
+			privileges: []string{"INSERT"},
+		},
+	}
+
+	if hasEnrichment(config, enrichmentProcess) {
+		tables = append(tables, &requiredTable{
+			name: schema.ProcessInfoTable,
+			columns: []string{
+				"tcp_event_uid", "cmdline", "exe", "parent_pid", "user_id", "user_name", "cgroup",
+				"container_id", "socket_user_name", "socket_group_name",
+			},
+			privileges: []string{"INSERT"},
+		})
+	}
+
+	if config.samplingMode != samplingModeNone {
+		tables = append(tables, &requiredTable{
+			name: schema.SamplingSummaryTable,
+			columns: []string{
+				"uid", "host", "mode", "period_start", "period_end", "seen", "stored", "suppressed",
+			},
+			privileges: []string{"INSERT"},
+		})
+	}
+
+	if config.hashChain != nil {
+		// The head of the chain of each host is read when the sink starts
+		tables[0].selectedColumns = []string{"host", "chain_seq", "chain_hash"}
+
+		if config.hashChain.keyFile != "" {
+			tables = append(tables, &requiredTable{
+				name:       schema.ChainCheckpointsTable,
+				columns:    []string{"host", "chain_seq", "chain_hash", "created_at", "key_id", "signature"},
+				privileges: []string{"SELECT", "INSERT"},
+			})
+		}
+	}
+
+	for _, granularity := range config.rollupGranularities {
+		// The upsert reads the existing counts, and the retention deletes
+		// old rows
+		privileges := []string{"SELECT", "INSERT", "UPDATE"}
+		if granularity.retention > 0 {
+			privileges = append(privileges, "DELETE")
+		}
+
+		tables = append(tables, &requiredTable{
+			name: granularity.table,
+			columns: []string{
+				"bucket", "host", "remote_ip", "remote_port", "comm", "old_state", "new_state", "events",
+				"estimated_events",
+			},
+			privileges: privileges,
+		})
+	}
+
+	return tables
+}
+
+// SchemaVerificationError lists the problems found when verifying that the
+// schema meets the requirements of the sink.
+type schemaVerificationError struct {
+	problems []string
+}
+
+func (e *schemaVerificationError) Error() string {
+	return fmt.Sprintf("schema does not meet the requirements of the sink:\n\t%s",
+		strings.Join(e.problems, "\n\t"))
+}
+
+// SQLSchemaVerifier is a table creator which creates nothing, instead
+// verifying that the required tables exist with the columns and privileges
+// required.
+type sqlSchemaVerifier struct {
+	conn   conn
+	tables []*requiredTable
+}
+
+func newSQLSchemaVerifier(conn conn, tables []*requiredTable) *sqlSchemaVerifier {
+	return &sqlSchemaVerifier{conn, tables}
+}
+
+// CreateTables verifies the schema, returning a schemaVerificationError
+// listing every problem found.
+func (v *sqlSchemaVerifier) createTables(ctx context.Context) error {
+	problems, err := v.verify(ctx)
+	if err != nil {
+		return fmt.Errorf("verifying schema: %w", err)
+	}
+
+	if len(problems) != 0 {
+		return &schemaVerificationError{problems}
+	}
+
+	return nil
+}
+
+// Verify returns a description of each way in which the schema does not
+// meet the requirements of the sink.
+func (v *sqlSchemaVerifier) verify(ctx context.Context) ([]string, error) {
+	rows, err := v.conn.query(ctx, schemaPrivilegeSQL)
+	if err != nil {
+		return nil, fmt.Errorf("querying schema privilege: %w", err)
+	}
+
+	if len(rows) != 1 || rows[0][0] == nil {
+		return []string{"no schema in the search path exists"}, nil
+	}
+
+	var problems []string
+	schemaName := stringValue(rows[0][0])
+	if !boolValue(rows[0][1]) {
+		problems = append(problems, fmt.Sprintf("USAGE privilege on schema %s is not granted", schemaName))
+	}
+
+	names := make([]string, 0, len(v.tables))
+	for _, table := range v.tables {
+		names = append(names, table.name)
+	}
+
+	if rows, err = v.conn.query(ctx, tablePrivilegesSQL, names); err != nil {
+		return nil, fmt.Errorf("querying table privileges: %w", err)
+	}
+
+	granted := make(map[string]map[string]bool, len(rows))
+	for _, row := range rows {
+		privileges := make(map[string]bool, len(tablePrivileges))
+		for i, privilege := range tablePrivileges {
+			privileges[privilege] = boolValue(row[i+1])
+		}
+
+		granted[stringValue(row[0])] = privileges
+	}
+
+	if rows, err = v.conn.query(ctx, columnsSQL, names); err != nil {
+		return nil, fmt.Errorf("querying columns: %w", err)
+	}
+
+	// The columns of each table, and whether each may be selected
+	columns := make(map[string]map[string]bool, len(v.tables))
+	for _, row := range rows {
+		table := stringValue(row[0])
+		if columns[table] == nil {
+			columns[table] = make(map[string]bool)
+		}
+
+		columns[table][stringValue(row[1])] = boolValue(row[2])
+	}
+
+	for _, table := range v.tables {
+		privileges, ok := granted[table.name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("table %s does not exist in schema %s", table.name, schemaName))
+			continue
+		}
+
+		var missingColumns []string
+		for _, column := range table.columns {
+			if _, ok := columns[table.name][column]; !ok {
+				missingColumns = append(missingColumns, column)
+			}
+		}
+
+		if len(missingColumns) != 0 {
+			sort.Strings(missingColumns)
+			problems = append(problems, fmt.Sprintf("table %s has no column %s",
+				table.name,
+				strings.Join(missingColumns, ", ")))
+		}
+
+		for _, privilege := range table.privileges {
+			if !privileges[privilege] {
+				problems = append(problems, fmt.Sprintf("%s privilege on table %s is not granted", privilege, table.name))
+			}
+		}
+
+		for _, column := range table.selectedColumns {
+			if selectable, ok := columns[table.name][column]; ok && !selectable {
+				problems = append(problems, fmt.Sprintf("SELECT privilege on column %s.%s is not granted", table.name, column))
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// StringValue returns a text value returned by a query, or the empty string
+// if it is NULL.
+func stringValue(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+// BoolValue returns a boolean value returned by a query, or false if it is
+// NULL.
+func boolValue(value interface{}) bool {
+	b, _ := value.(bool)
+	return b
+}
@@ -0,0 +1,239 @@
+package pgsqlsink
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+// MockSchemaConn returns a conn whose catalog holds the tables, with all of
+// their required columns and privileges.
+func mockSchemaConn(tables []*requiredTable) *mockConn {
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		schemaPrivilegeSQL: {{"public", true}},
+	}
+
+	for _, table := range tables {
+		mockConn.queryResultsToReturn[tablePrivilegesSQL] = append(mockConn.queryResultsToReturn[tablePrivilegesSQL],
+			[]interface{}{table.name, true, true, true, true})
+
+		for _, column := range table.columns {
+			mockConn.queryResultsToReturn[columnsSQL] = append(mockConn.queryResultsToReturn[columnsSQL],
+				[]interface{}{table.name, column, true})
+		}
+	}
+
+	return mockConn
+}
+
+func TestVerifySchema(t *testing.T) {
+	tables := requiredTables(&sinkConfig{samplingMode: samplingModeNone})
+	mockConn := mockSchemaConn(tables)
+	verifier := newSQLSchemaVerifier(mockConn, tables)
+
+	if err := verifier.createTables(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if mockConn.execCalled {
+		t.Error("expected no SQL to be executed, but was")
+	}
+}
+
+func TestVerifySchemaReportsProblems(t *testing.T) {
+	tables := requiredTables(&sinkConfig{
+		samplingMode:        samplingModeNone,
+		rollupGranularities: []*rollupGranularity{{table: schema.RollupHourTable, retention: 1}},
+	})
+	mockConn := mockSchemaConn(tables)
+	mockConn.queryResultsToReturn[schemaPrivilegeSQL] = [][]interface{}{{"public", false}}
+
+	// Remove the sockets table, a column of tcp_events, and the DELETE
+	// privilege on the rollup table
+	var privileges, columns [][]interface{}
+	for _, row := range mockConn.queryResultsToReturn[tablePrivilegesSQL] {
+		switch row[0] {
+		case schema.SocketsTable:
+			continue
+		case schema.RollupHourTable:
+			row = []interface{}{row[0], true, true, true, false}
+		}
+
+		privileges = append(privileges, row)
+	}
+
+	for _, row := range mockConn.queryResultsToReturn[columnsSQL] {
+		if row[0] == schema.EventsTable && row[1] == "socket_state" {
+			continue
+		}
+
+		columns = append(columns, row)
+	}
+
+	mockConn.queryResultsToReturn[tablePrivilegesSQL] = privileges
+	mockConn.queryResultsToReturn[columnsSQL] = columns
+	verifier := newSQLSchemaVerifier(mockConn, tables)
+
+	err := verifier.createTables(context.TODO())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	var verificationErr *schemaVerificationError
+	if !errors.As(err, &verificationErr) {
+		t.Fatalf("expected error chain to include schema verification error, but did not")
+	}
+
+	expectedProblems := []string{
+		"USAGE privilege on schema public is not granted",
+		"table tcp_events has no column socket_state",
+		"table tcp_sockets does not exist in schema public",
+		"DELETE privilege on table tcp_events_rollup_hour is not granted",
+	}
+
+	if len(verificationErr.problems) != len(expectedProblems) {
+		t.Fatalf("expected %d problems, got %d: %q",
+			len(expectedProblems),
+			len(verificationErr.problems),
+			verificationErr.problems)
+	}
+
+	for i, problem := range expectedProblems {
+		if verificationErr.problems[i] != problem {
+			t.Errorf("expected problem %d to be %q, got %q", i+1, problem, verificationErr.problems[i])
+		}
+	}
+}
+
+func TestVerifySchemaReportsColumnPrivileges(t *testing.T) {
+	tables := requiredTables(&sinkConfig{
+		samplingMode: samplingModeNone,
+		hashChain:    &hashChainConfig{},
+	})
+	mockConn := mockSchemaConn(tables)
+
+	// The chain columns may not be selected
+	for _, row := range mockConn.queryResultsToReturn[columnsSQL] {
+		if row[0] == schema.EventsTable && strings.HasPrefix(row[1].(string), "chain_") {
+			row[2] = false
+		}
+	}
+
+	verifier := newSQLSchemaVerifier(mockConn, tables)
+
+	err := verifier.createTables(context.TODO())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	var verificationErr *schemaVerificationError
+	if !errors.As(err, &verificationErr) {
+		t.Fatalf("expected error chain to include schema verification error, but did not")
+	}
+
+	expectedProblems := []string{
+		"SELECT privilege on column tcp_events.chain_seq is not granted",
+		"SELECT privilege on column tcp_events.chain_hash is not granted",
+	}
+
+	if strings.Join(verificationErr.problems, "\n") != strings.Join(expectedProblems, "\n") {
+		t.Errorf("expected problems %q, got %q", expectedProblems, verificationErr.problems)
+	}
+}
+
+func TestVerifySchemaNoSchema(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	mockConn.queryResultsToReturn = map[string][][]interface{}{
+		schemaPrivilegeSQL: {{nil, nil}},
+	}
+	verifier := newSQLSchemaVerifier(mockConn, requiredTables(&sinkConfig{}))
+
+	err := verifier.createTables(context.TODO())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !strings.Contains(err.Error(), "no schema in the search path exists") {
+		t.Errorf("expected error to report the missing schema, but did not")
+	}
+}
+
+func TestVerifySchemaQueryError(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	mockError := errors.New("mock query error")
+	mockConn.queryErrorToReturn = mockError
+	verifier := newSQLSchemaVerifier(mockConn, requiredTables(&sinkConfig{}))
+
+	err := verifier.createTables(context.TODO())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+func TestRequiredTablesColumnsAreCreated(t *testing.T) {
+	config := &sinkConfig{
+		samplingMode:        samplingModeRatio,
+		rollupGranularities: []*rollupGranularity{rollupGranularities[rollupGranularityMinute]},
+	}
+
+	// Every required column is created by the table creator
+	mockConn := newMockConn(nil, nil)
+	tableCreator := newSQLTableCreator(mockConn, compatModes[compatModePostgreSQL], nil, false, nil, discardLogger)
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Fatalf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	for _, table := range requiredTables(config) {
+		tableSQLPattern := regexp.MustCompile(`(CREATE TABLE IF NOT EXISTS|ALTER TABLE) ` + table.name + `\s`)
+
+		var tableSQL []string
+		for _, sql := range mockConn.receivedSQL {
+			if tableSQLPattern.MatchString(sql) {
+				tableSQL = append(tableSQL, sql)
+			}
+		}
+
+		if len(tableSQL) == 0 {
+			t.Errorf("expected table %s to be created, but was not", table.name)
+			continue
+		}
+
+		executed := strings.Join(tableSQL, "\n")
+		for _, column := range table.columns {
+			if !regexp.MustCompile(`(?m)^\s*(ADD COLUMN IF NOT EXISTS )?` + column + `\s`).MatchString(executed) {
+				t.Errorf("expected column %s.%s to be created, but was not", table.name, column)
+			}
+		}
+	}
+}
+
+func TestParseSchemaMode(t *testing.T) {
+	if mode, err := parseSchemaMode(""); err != nil || mode != schemaModeMigrate {
+		t.Errorf("expected default mode %q, got %q (error %v)", schemaModeMigrate, mode, err)
+	}
+
+	if mode, err := parseSchemaMode(" verify "); err != nil || mode != schemaModeVerify {
+		t.Errorf("expected mode %q, got %q (error %v)", schemaModeVerify, mode, err)
+	}
+
+	if _, err := parseSchemaMode("mock-mode"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
@@ -0,0 +1,381 @@
+// Package pgsqlsink provides a tcp-audit sink which stores TCP state-change
+// events in a PostgreSQL database. It is built as a plugin by the root
+// package of this module, and may be imported to embed the sink in other
+// programs.
+package pgsqlsink
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/event"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/sink"
+)
+
+var _ sink.Sinker = (*Sinker)(nil)
+
+const (
+	// TickInterval is the interval at which sampling summaries and rollups
+	// which are due are stored while no events are being received.
+	tickInterval = time.Second
+
+	// FilteredCountsReportInterval is the interval at which the numbers of
+	// events filtered out are logged, if they have changed.
+	filteredCountsReportInterval = 5 * time.Minute
+)
+
+// Logger is an interface which describes the loggers to which the sink
+// reports errors which do not prevent events from being stored.
+// It is satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Option configures optional dependencies of the sink.
+type Option func(*options)
+
+type options struct {
+	logger Logger
+}
+
+// WithLogger sets the logger of the sink. By default, the standard logger
+// is used.
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Sinker stores TCP state-change events in a PostgreSQL database.
+// It must not be used concurrently.
+type Sinker struct {
+	// Mu serialises storing events with the periodic storing of sampling
+	// summaries and rollups, which share the connection.
+	mu sync.Mutex
+
+	host       string
+	inserter   inserter
+	filter     eventFilter
+	sampler    sampler
+	aggregator aggregator
+	enrichers  []enricher
+	logger     Logger
+
+	stop           chan struct{}
+	stopped        chan struct{}
+	lastReported   time.Time
+	reportedCounts map[string]uint64
+}
+
+// New connects to the database described by the config, creating and
+// migrating the tables in which events are stored, or in the verify schema
+// mode, verifying that they have been, and then reports their append-only
+// protection and checks their layout. The context applies to this setup
+// only.
+func New(ctx context.Context, config *Config, opts ...Option) (*Sinker, error) {
+	options := &options{
+		logger: log.New(os.Stderr, "", log.LstdFlags),
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	sinkConfig, err := newSinkConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("validating sink config: %w", err)
+	}
+
+	host := config.Host
+	if host == "" {
+		if host, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("getting hostname: %w", err)
+		}
+	}
+
+	enrichers, err := newEnrichers(sinkConfig, options.logger)
+	if err != nil {
+		return nil, fmt.Errorf("creating enrichers: %w", err)
+	}
+
+	var signingKey ed25519.PrivateKey
+	if sinkConfig.hashChain != nil && sinkConfig.hashChain.keyFile != "" {
+		if signingKey, err = loadChainSigningKey(sinkConfig.hashChain.keyFile); err != nil {
+			closeEnrichers(enrichers, options.logger)
+			return nil, fmt.Errorf("loading hash chain signing key: %w", err)
+		}
+	}
+
+	connector := newPGXConnector(config.ConnString, sinkConfig.statementMode)
+	conn, err := connector.connect(ctx)
+	if err != nil {
+		closeEnrichers(enrichers, options.logger)
+		return nil, fmt.Errorf("connecting to database: %w", err)
+	}
+
+	var tableCreator tableCreator = newSQLTableCreator(conn,
+		sinkConfig.compatMode,
+		sinkConfig.hypertable,
+		sinkConfig.indexes,
+		sinkConfig.appendOnly,
+		options.logger)
+	if sinkConfig.schemaMode == schemaModeVerify {
+		tableCreator = newSQLSchemaVerifier(conn, requiredTables(sinkConfig))
+	}
+	if sinkConfig.compatMode.catalogChecks {
+		tableCreator = newAppendOnlyReportingTableCreator(tableCreator, conn, sinkConfig.appendOnly, options.logger)
+	}
+	if sinkConfig.schemaCheck != schemaCheckOff {
+		tableCreator = newLayoutCheckingTableCreator(tableCreator,
+			conn,
+			sinkConfig.compatMode,
+			sinkConfig.hypertable != nil,
+			sinkConfig.indexes,
+			sinkConfig.schemaCheck,
+			options.logger)
+	}
+	stmtPreparer := newSQLStatementPreparer(conn)
+	execer := newSQLExecer(conn, sinkConfig.compatMode.serializationRetries, time.Sleep, options.logger)
+	var chain *hashChain
+	if sinkConfig.hashChain != nil {
+		chain = newHashChain(conn,
+			execer,
+			signingKey,
+			sinkConfig.hashChain.checkpointInterval,
+			time.Now,
+			options.logger)
+	}
+
+	inserter := newPreparedStatementInserter(stmtPreparer,
+		execer,
+		sinkConfig.notifyChannel,
+		sinkConfig.statementMode == statementModePrepared,
+		hasEnrichment(sinkConfig, enrichmentProcess),
+		chain)
+
+	filter := newRuleFilter(sinkConfig.includeRules, sinkConfig.excludeRules)
+	sampler := newSampler(sinkConfig, host)
+	aggregator := newRollupAggregator(execer,
+		sinkConfig.rollupGranularities,
+		sinkConfig.rollupFlushInterval,
+		time.Now)
+
+	sinker, err := newSinker(ctx, options.logger, host, tableCreator, inserter, filter, sampler, aggregator, enrichers)
+	if err != nil {
+		if closeErr := execer.close(ctx); closeErr != nil {
+			options.logger.Printf("Error closing database connection: %v", closeErr)
+		}
+
+		closeEnrichers(enrichers, options.logger)
+		return nil, err
+	}
+
+	sinker.start(tickInterval)
+
+	return sinker, nil
+}
+
+func newSinker(ctx context.Context,
+	logger Logger,
+	host string,
+	tableCreator tableCreator,
+	inserter inserter,
+	filter eventFilter,
+	sampler sampler,
+	aggregator aggregator,
+	enrichers []enricher) (*Sinker, error) {
+	if err := tableCreator.createTables(ctx); err != nil {
+		return nil, fmt.Errorf("creating table: %w", err)
+	}
+
+	if err := inserter.prepare(ctx); err != nil {
+		return nil, fmt.Errorf("preparing inserter: %w", err)
+	}
+
+	return &Sinker{
+		host:       host,
+		inserter:   inserter,
+		filter:     filter,
+		sampler:    sampler,
+		aggregator: aggregator,
+		enrichers:  enrichers,
+		logger:     logger,
+	}, nil
+}
+
+// Sink stores the event as having occurred on the sink's host.
+func (s *Sinker) Sink(event *event.Event) error {
+	return s.SinkFrom(s.host, event)
+}
+
+// SinkFrom stores the event as having occurred on the given host, for sinks
+// which receive events from other hosts.
+func (s *Sinker) SinkFrom(host string, event *event.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.filter.include(event) {
+		return nil
+	}
+
+	keep, sampleRate, summary := s.sampler.sample(event)
+
+	// Failing to store the summary should not prevent the event from being stored
+	s.insertSamplingSummary(summary)
+
+	if !keep {
+		return nil
+	}
+
+	dbEvent := &tcpEvent{
+		uid:        uuid.NewString(),
+		host:       host,
+		time:       event.Time,
+		pid:        event.PIDOnCPU,
+		comm:       event.CommandOnCPU,
+		srcIP:      event.SourceIP,
+		dstIP:      event.DestIP,
+		srcPort:    event.SourcePort,
+		dstPort:    event.DestPort,
+		oldState:   event.OldState.String(),
+		newState:   event.NewState.String(),
+		sampleRate: sampleRate,
+	}
+
+	var sockInfo *socketInfo
+	if event.SocketInfo != nil {
+		sockInfo = &socketInfo{
+			id:      event.SocketInfo.ID,
+			iNode:   event.SocketInfo.INode,
+			userID:  event.SocketInfo.UID,
+			groupID: event.SocketInfo.GID,
+			state:   event.SocketInfo.SocketState.String(),
+		}
+	}
+
+	for _, enricher := range s.enrichers {
+		enricher.enrich(context.TODO(), dbEvent, sockInfo)
+	}
+
+	if err := s.inserter.insert(context.TODO(), dbEvent, sockInfo); err != nil {
+		return fmt.Errorf("inserting event: %w", err)
+	}
+
+	s.aggregator.add(dbEvent)
+
+	// Failing to update the rollups should not fail the stored event,
+	// and the counts are retried at the next flush
+	if err := s.aggregator.flushIfDue(context.TODO()); err != nil {
+		s.logger.Printf("Error flushing rollups: %v", err)
+	}
+
+	return nil
+}
+
+// FilteredCounts returns the number of events filtered out for each reason
+// since the sink was created. Unlike the sink's other methods, it may be
+// called concurrently.
+func (s *Sinker) FilteredCounts() map[string]uint64 {
+	return s.filter.filteredCounts()
+}
+
+// Start starts ticking at the interval, until the sink is closed.
+func (s *Sinker) start(interval time.Duration) {
+	s.stop = make(chan struct{})
+	s.stopped = make(chan struct{})
+	s.lastReported = time.Now()
+
+	go func() {
+		defer close(s.stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				s.tick(now)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Tick stores the sampling summary and rollups if they are due, and logs
+// the numbers of events filtered out if they are due to be reported, so that
+// this does not wait for the next event.
+func (s *Sinker) tick(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.insertSamplingSummary(s.sampler.flushIfDue())
+
+	if err := s.aggregator.flushIfDue(context.TODO()); err != nil {
+		s.logger.Printf("Error flushing rollups: %v", err)
+	}
+
+	if now.Sub(s.lastReported) >= filteredCountsReportInterval {
+		s.reportFilteredCounts()
+		s.lastReported = now
+	}
+}
+
+// InsertSamplingSummary inserts the summary, if any, logging any error.
+func (s *Sinker) insertSamplingSummary(summary *samplingSummary) {
+	if summary == nil {
+		return
+	}
+
+	if err := s.inserter.insertSamplingSummary(context.TODO(), summary); err != nil {
+		s.logger.Printf("Error inserting sampling summary: %v", err)
+	}
+}
+
+// ReportFilteredCounts logs the numbers of events filtered out for each
+// reason which has changed since they were last reported.
+func (s *Sinker) reportFilteredCounts() {
+	if s.reportedCounts == nil {
+		s.reportedCounts = make(map[string]uint64)
+	}
+
+	for reason, count := range s.filter.filteredCounts() {
+		if count != s.reportedCounts[reason] {
+			s.logger.Printf("Filtered out %d events %s", count, reason)
+			s.reportedCounts[reason] = count
+		}
+	}
+}
+
+func (s *Sinker) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.stopped
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for reason, count := range s.filter.filteredCounts() {
+		s.logger.Printf("Filtered out %d events %s", count, reason)
+	}
+
+	s.insertSamplingSummary(s.sampler.close())
+
+	if err := s.aggregator.flush(context.TODO()); err != nil {
+		s.logger.Printf("Error flushing rollups: %v", err)
+	}
+
+	closeEnrichers(s.enrichers, s.logger)
+
+	if err := s.inserter.close(context.TODO()); err != nil {
+		return fmt.Errorf("closing connection: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,820 @@
+package pgsqlsink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/event"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/socketstate"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+)
+
+const mockHost = "mock-host"
+
+var discardLogger = log.New(ioutil.Discard, "", 0)
+
+type mockInserter struct {
+	errorToReturnOnPrepare error
+	errorToReturnOnInsert  error
+	errorToReturnOnClose   error
+
+	prepareCalled bool
+	insertCalled  bool
+	closeCalled   bool
+
+	receivedUID        string
+	receivedHost       string
+	receivedTime       time.Time
+	receivedPID        int
+	receivedComm       string
+	receivedSrcIP      net.IP
+	receivedDstIP      net.IP
+	receivedSrcPort    uint16
+	receivedDstPort    uint16
+	receivedOldState   string
+	receivedNewState   string
+	receivedSampleRate float64
+	receivedSocketInfo *socketInfo
+	receivedEvent      *tcpEvent
+
+	receivedSamplingSummaries []*samplingSummary
+}
+
+func newMockInserter(errorToReturnOnPrepare error,
+	errorToReturnOnInsert error,
+	errorToReturnOnClose error) *mockInserter {
+	return &mockInserter{
+		errorToReturnOnPrepare: errorToReturnOnPrepare,
+		errorToReturnOnInsert:  errorToReturnOnInsert,
+		errorToReturnOnClose:   errorToReturnOnClose,
+	}
+}
+
+func (mi *mockInserter) prepare(ctx context.Context) error {
+	mi.prepareCalled = true
+
+	if mi.errorToReturnOnPrepare != nil {
+		return mi.errorToReturnOnPrepare
+	}
+
+	return nil
+}
+
+func (mi *mockInserter) insert(ctx context.Context,
+	event *tcpEvent,
+	socketInfo *socketInfo) error {
+	mi.insertCalled = true
+
+	mi.receivedEvent = event
+	mi.receivedUID = event.uid
+	mi.receivedHost = event.host
+	mi.receivedTime = event.time
+	mi.receivedPID = event.pid
+	mi.receivedComm = event.comm
+	mi.receivedSrcIP = event.srcIP
+	mi.receivedDstIP = event.dstIP
+	mi.receivedSrcPort = event.srcPort
+	mi.receivedDstPort = event.dstPort
+	mi.receivedOldState = event.oldState
+	mi.receivedNewState = event.newState
+	mi.receivedSampleRate = event.sampleRate
+	mi.receivedSocketInfo = socketInfo
+
+	if mi.errorToReturnOnInsert != nil {
+		return mi.errorToReturnOnInsert
+	}
+
+	return nil
+}
+
+func (mi *mockInserter) insertSamplingSummary(ctx context.Context, summary *samplingSummary) error {
+	mi.receivedSamplingSummaries = append(mi.receivedSamplingSummaries, summary)
+	return nil
+}
+
+func (mi *mockInserter) close(ctx context.Context) error {
+	mi.closeCalled = true
+
+	if mi.errorToReturnOnClose != nil {
+		return mi.errorToReturnOnClose
+	}
+
+	return nil
+}
+
+type mockTableCreator struct {
+	errorToReturn error
+
+	createTablesCalled bool
+}
+
+func newMockTableCreator(errorToReturn error) *mockTableCreator {
+	return &mockTableCreator{errorToReturn: errorToReturn}
+}
+
+func (mtc *mockTableCreator) createTables(ctx context.Context) error {
+	mtc.createTablesCalled = true
+
+	if mtc.errorToReturn != nil {
+		return mtc.errorToReturn
+	}
+
+	return nil
+}
+
+type mockFilter struct {
+	includeToReturn bool
+	countsToReturn  map[string]uint64
+
+	includeCalled bool
+}
+
+func newMockFilter(includeToReturn bool) *mockFilter {
+	return &mockFilter{includeToReturn: includeToReturn}
+}
+
+func (mf *mockFilter) include(event *event.Event) bool {
+	mf.includeCalled = true
+	return mf.includeToReturn
+}
+
+func (mf *mockFilter) filteredCounts() map[string]uint64 {
+	return mf.countsToReturn
+}
+
+type mockSampler struct {
+	keepToReturn    bool
+	rateToReturn    float64
+	summaryToReturn *samplingSummary
+
+	sampleCalled     bool
+	flushIfDueCalled bool
+	closeCalled      bool
+}
+
+func newMockSampler(keepToReturn bool, rateToReturn float64, summaryToReturn *samplingSummary) *mockSampler {
+	return &mockSampler{
+		keepToReturn:    keepToReturn,
+		rateToReturn:    rateToReturn,
+		summaryToReturn: summaryToReturn,
+	}
+}
+
+func (ms *mockSampler) sample(event *event.Event) (bool, float64, *samplingSummary) {
+	ms.sampleCalled = true
+	return ms.keepToReturn, ms.rateToReturn, ms.summaryToReturn
+}
+
+func (ms *mockSampler) flushIfDue() *samplingSummary {
+	ms.flushIfDueCalled = true
+	return ms.summaryToReturn
+}
+
+func (ms *mockSampler) close() *samplingSummary {
+	ms.closeCalled = true
+	return ms.summaryToReturn
+}
+
+type mockAggregator struct {
+	errorToReturn error
+
+	addedEvents      []*tcpEvent
+	flushIfDueCalled bool
+	flushCalled      bool
+}
+
+func newMockAggregator(errorToReturn error) *mockAggregator {
+	return &mockAggregator{errorToReturn: errorToReturn}
+}
+
+func (ma *mockAggregator) add(event *tcpEvent) {
+	ma.addedEvents = append(ma.addedEvents, event)
+}
+
+func (ma *mockAggregator) flushIfDue(ctx context.Context) error {
+	ma.flushIfDueCalled = true
+	return ma.errorToReturn
+}
+
+func (ma *mockAggregator) flush(ctx context.Context) error {
+	ma.flushCalled = true
+	return ma.errorToReturn
+}
+
+type mockEnricher struct {
+	enrichedEvents []*tcpEvent
+	closeCalled    bool
+}
+
+func (me *mockEnricher) enrich(ctx context.Context, event *tcpEvent, socketInfo *socketInfo) {
+	me.enrichedEvents = append(me.enrichedEvents, event)
+
+	name := "mock-name"
+	event.dstName = &name
+}
+
+func (me *mockEnricher) close() error {
+	me.closeCalled = true
+	return nil
+}
+
+func TestSinkerConstructor(t *testing.T) {
+	mockTableCreator := newMockTableCreator(nil)
+	mockInserter := newMockInserter(nil, nil, nil)
+	mockFilter := newMockFilter(true)
+	mockSampler := newMockSampler(true, 1, nil)
+	mockAggregator := newMockAggregator(nil)
+	_, err := newSinker(context.TODO(), discardLogger, mockHost, mockTableCreator, mockInserter, mockFilter, mockSampler, mockAggregator, nil)
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockTableCreator.createTablesCalled {
+		t.Error("expected table creator to be called, but was not")
+	}
+
+	if !mockInserter.prepareCalled {
+		t.Error("expected inserter prepare() to be called, but was not")
+	}
+}
+
+func TestSinkerConstructorTableCreatorError(t *testing.T) {
+	mockError := errors.New("mock table creator error")
+	mockTableCreator := newMockTableCreator(mockError)
+	mockInserter := newMockInserter(nil, nil, nil)
+	mockFilter := newMockFilter(true)
+	mockSampler := newMockSampler(true, 1, nil)
+	mockAggregator := newMockAggregator(nil)
+	_, err := newSinker(context.TODO(), discardLogger, mockHost, mockTableCreator, mockInserter, mockFilter, mockSampler, mockAggregator, nil)
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+func TestSinkerConstructorInserterPrepareError(t *testing.T) {
+	mockTableCreator := newMockTableCreator(nil)
+	mockError := errors.New("mock inserter prepare error")
+	mockInserter := newMockInserter(mockError, nil, nil)
+	mockFilter := newMockFilter(true)
+	mockSampler := newMockSampler(true, 1, nil)
+	mockAggregator := newMockAggregator(nil)
+	_, err := newSinker(context.TODO(), discardLogger, mockHost, mockTableCreator, mockInserter, mockFilter, mockSampler, mockAggregator, nil)
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+func TestSink(t *testing.T) {
+	mockTableCreator := newMockTableCreator(nil)
+	mockInserter := newMockInserter(nil, nil, nil)
+	mockFilter := newMockFilter(true)
+	mockSampler := newMockSampler(true, 1, nil)
+	mockAggregator := newMockAggregator(nil)
+	sinker, err := newSinker(context.TODO(), discardLogger, mockHost, mockTableCreator, mockInserter, mockFilter, mockSampler, mockAggregator, nil)
+	if err != nil {
+		t.Errorf("expected nil Sinker construction error, got %q (of type %T)", err, err)
+	}
+
+	mockSocketInfo := &event.SocketInfo{
+		ID:          "mock-socket-id",
+		INode:       0xF00DF00D,
+		UID:         0xCAFEBABE,
+		GID:         0xDEADBEEF,
+		SocketState: socketstate.StateConnecting,
+	}
+
+	mockEvent := &event.Event{
+		Time:         time.Now(),
+		PIDOnCPU:     7337,
+		CommandOnCPU: "test",
+		SourceIP:     net.ParseIP("1.2.3.4"),
+		DestIP:       net.ParseIP("7.3.3.7"),
+		SourcePort:   1234,
+		DestPort:     7337,
+		OldState:     tcpstate.StateClosed,
+		NewState:     tcpstate.StateSynReceived,
+		SocketInfo:   mockSocketInfo,
+	}
+
+	if err := sinker.Sink(mockEvent); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockInserter.insertCalled {
+		t.Error("expected inserter insert() to be called, but was not")
+	}
+
+	if mockInserter.receivedUID == "" {
+		t.Error("expected inserter received UID to be non-empty, but was empty")
+	}
+
+	if mockInserter.receivedHost != mockHost {
+		t.Errorf("expected inserter received host to be %q, but was %q",
+			mockHost,
+			mockInserter.receivedHost)
+	}
+
+	if !mockInserter.receivedTime.Equal(mockEvent.Time) {
+		t.Errorf("expected inserter received time to be %q, but was %q",
+			mockEvent.Time,
+			mockInserter.receivedTime)
+	}
+
+	if mockInserter.receivedPID != mockEvent.PIDOnCPU {
+		t.Errorf("expected inserter received PID to be %d, but was %d",
+			mockEvent.PIDOnCPU,
+			mockInserter.receivedPID)
+	}
+
+	if mockInserter.receivedComm != mockEvent.CommandOnCPU {
+		t.Errorf("expected inserter received command to be %q, but was %q",
+			mockEvent.CommandOnCPU,
+			mockInserter.receivedComm)
+	}
+
+	if !mockInserter.receivedSrcIP.Equal(mockEvent.SourceIP) {
+		t.Errorf("expected inserter received source IP to be %q, but was %q",
+			mockEvent.SourceIP,
+			mockInserter.receivedSrcIP)
+	}
+
+	if !mockInserter.receivedDstIP.Equal(mockEvent.DestIP) {
+		t.Errorf("expected inserter received destination IP to be %q, but was %q",
+			mockEvent.DestIP,
+			mockInserter.receivedDstIP)
+	}
+
+	if mockInserter.receivedSrcPort != mockEvent.SourcePort {
+		t.Errorf("expected inserter received source port to be %d, but was %d",
+			mockEvent.SourcePort,
+			mockInserter.receivedSrcPort)
+	}
+
+	if mockInserter.receivedDstPort != mockEvent.DestPort {
+		t.Errorf("expected inserter received destination port to be %d, but was %d",
+			mockEvent.DestPort,
+			mockInserter.receivedDstPort)
+	}
+
+	if mockInserter.receivedOldState != mockEvent.OldState.String() {
+		t.Errorf("expected inserter received old state to be %q, but was %q",
+			mockEvent.OldState,
+			mockInserter.receivedOldState)
+	}
+
+	if mockInserter.receivedNewState != mockEvent.NewState.String() {
+		t.Errorf("expected inserter received old state to be %q, but was %q",
+			mockEvent.NewState,
+			mockInserter.receivedNewState)
+	}
+
+	if mockInserter.receivedSocketInfo.id != mockEvent.SocketInfo.ID {
+		t.Errorf("expected inserter received socket info ID to be %q, but was %q",
+			mockEvent.SocketInfo.ID,
+			mockInserter.receivedSocketInfo.id)
+	}
+
+	if mockInserter.receivedSocketInfo.iNode != mockEvent.SocketInfo.INode {
+		t.Errorf("expected inserter received socket info inode to be %d, but was %d",
+			mockEvent.SocketInfo.INode,
+			mockInserter.receivedSocketInfo.iNode)
+	}
+
+	if mockInserter.receivedSocketInfo.userID != mockEvent.SocketInfo.UID {
+		t.Errorf("expected inserter received socket info user ID to be %d, but was %d",
+			mockEvent.SocketInfo.UID,
+			mockInserter.receivedSocketInfo.userID)
+	}
+
+	if mockInserter.receivedSocketInfo.groupID != mockEvent.SocketInfo.GID {
+		t.Errorf("expected inserter received socket info group ID to be %d, but was %d",
+			mockEvent.SocketInfo.GID,
+			mockInserter.receivedSocketInfo.groupID)
+	}
+
+	if mockInserter.receivedSocketInfo.state != mockEvent.SocketInfo.SocketState.String() {
+		t.Errorf("expected inserter received socket info user ID to be %q, but was %q",
+			mockEvent.SocketInfo.SocketState.String(),
+			mockInserter.receivedSocketInfo.state)
+	}
+
+	if len(mockAggregator.addedEvents) != 1 || mockAggregator.addedEvents[0].uid != mockInserter.receivedUID {
+		t.Error("expected stored event to be added to aggregator, but was not")
+	}
+
+	if !mockAggregator.flushIfDueCalled {
+		t.Error("expected aggregator flushIfDue() to be called, but was not")
+	}
+}
+
+func TestSinkInserterError(t *testing.T) {
+	mockTableCreator := newMockTableCreator(nil)
+	mockError := errors.New("mock inserter insert error")
+	mockInserter := newMockInserter(nil, mockError, nil)
+	mockFilter := newMockFilter(true)
+	mockSampler := newMockSampler(true, 1, nil)
+	mockAggregator := newMockAggregator(nil)
+	sinker, err := newSinker(context.TODO(), discardLogger, mockHost, mockTableCreator, mockInserter, mockFilter, mockSampler, mockAggregator, nil)
+	if err != nil {
+		t.Errorf("expected nil Sinker construction error, got %q (of type %T)", err, err)
+	}
+
+	mockEvent := &event.Event{
+		Time:         time.Now(),
+		PIDOnCPU:     7337,
+		CommandOnCPU: "test",
+		SourceIP:     net.ParseIP("1.2.3.4"),
+		DestIP:       net.ParseIP("7.3.3.7"),
+		SourcePort:   1234,
+		DestPort:     7337,
+		OldState:     tcpstate.StateClosed,
+		NewState:     tcpstate.StateSynReceived,
+	}
+
+	err = sinker.Sink(mockEvent)
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+
+	if len(mockAggregator.addedEvents) != 0 {
+		t.Error("expected unstored event to not be added to aggregator, but was")
+	}
+}
+
+func TestClose(t *testing.T) {
+	mockTableCreator := newMockTableCreator(nil)
+	mockInserter := new(mockInserter)
+	mockFilter := newMockFilter(true)
+	mockSampler := newMockSampler(true, 1, nil)
+	mockAggregator := newMockAggregator(nil)
+	mockEnricher := new(mockEnricher)
+	sinker, err := newSinker(context.TODO(), discardLogger, mockHost,
+		mockTableCreator,
+		mockInserter,
+		mockFilter,
+		mockSampler,
+		mockAggregator,
+		[]enricher{mockEnricher})
+	if err != nil {
+		t.Errorf("expected nil Sinker construction error, got %q (of type %T)", err, err)
+	}
+
+	if err := sinker.Close(); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockEnricher.closeCalled {
+		t.Error("expected enricher to be closed, but was not")
+	}
+
+	if !mockInserter.closeCalled {
+		t.Error("expected inserter to be called closed, but was not")
+	}
+
+	if !mockAggregator.flushCalled {
+		t.Error("expected aggregator flush() to be called, but was not")
+	}
+}
+
+func TestCloseInserterError(t *testing.T) {
+	mockTableCreator := newMockTableCreator(nil)
+	mockError := errors.New("mock inserter close error")
+	mockInserter := newMockInserter(nil, nil, mockError)
+	mockFilter := newMockFilter(true)
+	mockSampler := newMockSampler(true, 1, nil)
+	mockAggregator := newMockAggregator(nil)
+	sinker, err := newSinker(context.TODO(), discardLogger, mockHost, mockTableCreator, mockInserter, mockFilter, mockSampler, mockAggregator, nil)
+	if err != nil {
+		t.Errorf("expected nil Sinker construction error, got %q (of type %T)", err, err)
+	}
+
+	err = sinker.Close()
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+func TestSinkFilteredOut(t *testing.T) {
+	mockTableCreator := newMockTableCreator(nil)
+	mockInserter := newMockInserter(nil, nil, nil)
+	mockFilter := newMockFilter(false)
+	mockSampler := newMockSampler(true, 1, nil)
+	mockAggregator := newMockAggregator(nil)
+	sinker, err := newSinker(context.TODO(), discardLogger, mockHost, mockTableCreator, mockInserter, mockFilter, mockSampler, mockAggregator, nil)
+	if err != nil {
+		t.Errorf("expected nil Sinker construction error, got %q (of type %T)", err, err)
+	}
+
+	mockEvent := &event.Event{
+		Time:         time.Now(),
+		PIDOnCPU:     7337,
+		CommandOnCPU: "test",
+		SourceIP:     net.ParseIP("127.0.0.1"),
+		DestIP:       net.ParseIP("127.0.0.1"),
+		SourcePort:   1234,
+		DestPort:     7337,
+		OldState:     tcpstate.StateClosed,
+		NewState:     tcpstate.StateSynSent,
+	}
+
+	if err := sinker.Sink(mockEvent); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockFilter.includeCalled {
+		t.Error("expected filter include() to be called, but was not")
+	}
+
+	if mockInserter.insertCalled {
+		t.Error("expected inserter insert() to not be called, but was")
+	}
+}
+
+func TestReportFilteredCounts(t *testing.T) {
+	mockFilter := newMockFilter(false)
+	mockFilter.countsToReturn = map[string]uint64{"mock reason a": 1, "mock reason b": 2}
+	var logged bytes.Buffer
+	sinker, err := newSinker(context.TODO(),
+		log.New(&logged, "", 0),
+		mockHost,
+		newMockTableCreator(nil),
+		newMockInserter(nil, nil, nil),
+		mockFilter,
+		newMockSampler(true, 1, nil),
+		newMockAggregator(nil),
+		nil)
+	if err != nil {
+		t.Errorf("expected nil Sinker construction error, got %q (of type %T)", err, err)
+	}
+
+	sinker.reportFilteredCounts()
+	mockFilter.countsToReturn = map[string]uint64{"mock reason a": 1, "mock reason b": 3}
+	sinker.reportFilteredCounts()
+
+	// Only changed counts are reported again
+	expectedLines := []string{
+		"Filtered out 1 events mock reason a",
+		"Filtered out 2 events mock reason b",
+		"Filtered out 3 events mock reason b",
+	}
+	lines := strings.Split(strings.TrimSpace(logged.String()), "\n")
+	sort.Strings(lines)
+	if strings.Join(lines, "\n") != strings.Join(expectedLines, "\n") {
+		t.Errorf("expected log lines %q, got %q", expectedLines, lines)
+	}
+
+	if counts := sinker.FilteredCounts(); counts["mock reason b"] != 3 {
+		t.Errorf("expected filtered counts %v, got %v", mockFilter.countsToReturn, counts)
+	}
+}
+
+func TestTick(t *testing.T) {
+	mockInserter := newMockInserter(nil, nil, nil)
+	mockSummary := &samplingSummary{uid: "mock-uid"}
+	mockSampler := newMockSampler(true, 1, mockSummary)
+	mockAggregator := newMockAggregator(nil)
+	mockFilter := newMockFilter(true)
+	mockFilter.countsToReturn = map[string]uint64{"mock reason": 1}
+	var logged bytes.Buffer
+	sinker, err := newSinker(context.TODO(),
+		log.New(&logged, "", 0),
+		mockHost,
+		newMockTableCreator(nil),
+		mockInserter,
+		mockFilter,
+		mockSampler,
+		mockAggregator,
+		nil)
+	if err != nil {
+		t.Errorf("expected nil Sinker construction error, got %q (of type %T)", err, err)
+	}
+
+	now := time.Now()
+	sinker.lastReported = now
+	sinker.tick(now.Add(time.Second))
+
+	if len(mockInserter.receivedSamplingSummaries) != 1 || mockInserter.receivedSamplingSummaries[0] != mockSummary {
+		t.Errorf("expected due sampling summary to be inserted, got %v", mockInserter.receivedSamplingSummaries)
+	}
+
+	if !mockAggregator.flushIfDueCalled {
+		t.Error("expected aggregator flushIfDue() to be called, but was not")
+	}
+
+	if logged.Len() != 0 {
+		t.Errorf("expected filtered counts to not be reported before due, got %q", logged.String())
+	}
+
+	sinker.tick(now.Add(filteredCountsReportInterval))
+
+	if logged.String() != "Filtered out 1 events mock reason\n" {
+		t.Errorf("expected filtered counts to be reported when due, got %q", logged.String())
+	}
+}
+
+func TestSinkSampledOut(t *testing.T) {
+	mockTableCreator := newMockTableCreator(nil)
+	mockInserter := newMockInserter(nil, nil, nil)
+	mockFilter := newMockFilter(true)
+	mockSummary := &samplingSummary{uid: "mock-summary-uid", seen: 10, stored: 1}
+	mockSampler := newMockSampler(false, 0.1, mockSummary)
+	mockAggregator := newMockAggregator(nil)
+	sinker, err := newSinker(context.TODO(), discardLogger, mockHost, mockTableCreator, mockInserter, mockFilter, mockSampler, mockAggregator, nil)
+	if err != nil {
+		t.Errorf("expected nil Sinker construction error, got %q (of type %T)", err, err)
+	}
+
+	mockEvent := &event.Event{
+		Time:         time.Now(),
+		PIDOnCPU:     7337,
+		CommandOnCPU: "test",
+		SourceIP:     net.ParseIP("1.2.3.4"),
+		DestIP:       net.ParseIP("7.3.3.7"),
+		SourcePort:   1234,
+		DestPort:     7337,
+		OldState:     tcpstate.StateListen,
+		NewState:     tcpstate.StateSynReceived,
+	}
+
+	if err := sinker.Sink(mockEvent); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockSampler.sampleCalled {
+		t.Error("expected sampler sample() to be called, but was not")
+	}
+
+	if mockInserter.insertCalled {
+		t.Error("expected inserter insert() to not be called, but was")
+	}
+
+	if len(mockInserter.receivedSamplingSummaries) != 1 ||
+		mockInserter.receivedSamplingSummaries[0] != mockSummary {
+		t.Errorf("expected inserter to receive sampling summary, but received %v",
+			mockInserter.receivedSamplingSummaries)
+	}
+}
+
+func TestSinkSampleRate(t *testing.T) {
+	mockTableCreator := newMockTableCreator(nil)
+	mockInserter := newMockInserter(nil, nil, nil)
+	mockFilter := newMockFilter(true)
+	mockSampler := newMockSampler(true, 0.25, nil)
+	mockAggregator := newMockAggregator(nil)
+	sinker, err := newSinker(context.TODO(), discardLogger, mockHost, mockTableCreator, mockInserter, mockFilter, mockSampler, mockAggregator, nil)
+	if err != nil {
+		t.Errorf("expected nil Sinker construction error, got %q (of type %T)", err, err)
+	}
+
+	mockEvent := &event.Event{
+		Time:         time.Now(),
+		PIDOnCPU:     7337,
+		CommandOnCPU: "test",
+		SourceIP:     net.ParseIP("1.2.3.4"),
+		DestIP:       net.ParseIP("7.3.3.7"),
+		SourcePort:   1234,
+		DestPort:     7337,
+		OldState:     tcpstate.StateListen,
+		NewState:     tcpstate.StateSynReceived,
+	}
+
+	if err := sinker.Sink(mockEvent); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if mockInserter.receivedSampleRate != 0.25 {
+		t.Errorf("expected inserter received sample rate to be %v, but was %v",
+			0.25,
+			mockInserter.receivedSampleRate)
+	}
+}
+
+func TestCloseWritesSamplingSummary(t *testing.T) {
+	mockTableCreator := newMockTableCreator(nil)
+	mockInserter := newMockInserter(nil, nil, nil)
+	mockFilter := newMockFilter(true)
+	mockSummary := &samplingSummary{uid: "mock-summary-uid"}
+	mockSampler := newMockSampler(true, 1, mockSummary)
+	mockAggregator := newMockAggregator(nil)
+	sinker, err := newSinker(context.TODO(), discardLogger, mockHost, mockTableCreator, mockInserter, mockFilter, mockSampler, mockAggregator, nil)
+	if err != nil {
+		t.Errorf("expected nil Sinker construction error, got %q (of type %T)", err, err)
+	}
+
+	if err := sinker.Close(); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockSampler.closeCalled {
+		t.Error("expected sampler close() to be called, but was not")
+	}
+
+	if len(mockInserter.receivedSamplingSummaries) != 1 {
+		t.Errorf("expected inserter to receive %d sampling summary, but received %d",
+			1,
+			len(mockInserter.receivedSamplingSummaries))
+	}
+}
+
+func TestSinkEnriched(t *testing.T) {
+	mockTableCreator := newMockTableCreator(nil)
+	mockInserter := newMockInserter(nil, nil, nil)
+	mockFilter := newMockFilter(true)
+	mockSampler := newMockSampler(true, 1, nil)
+	mockAggregator := newMockAggregator(nil)
+	mockEnricher := new(mockEnricher)
+	sinker, err := newSinker(context.TODO(), discardLogger, mockHost,
+		mockTableCreator,
+		mockInserter,
+		mockFilter,
+		mockSampler,
+		mockAggregator,
+		[]enricher{mockEnricher})
+	if err != nil {
+		t.Errorf("expected nil Sinker construction error, got %q (of type %T)", err, err)
+	}
+
+	mockEvent := &event.Event{
+		Time:         time.Now(),
+		PIDOnCPU:     7337,
+		CommandOnCPU: "test",
+		SourceIP:     net.ParseIP("1.2.3.4"),
+		DestIP:       net.ParseIP("7.3.3.7"),
+		SourcePort:   1234,
+		DestPort:     7337,
+		OldState:     tcpstate.StateSynSent,
+		NewState:     tcpstate.StateEstablished,
+	}
+
+	if err := sinker.Sink(mockEvent); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if len(mockEnricher.enrichedEvents) != 1 {
+		t.Fatalf("expected %d enriched events, got %d", 1, len(mockEnricher.enrichedEvents))
+	}
+
+	if mockInserter.receivedEvent != mockEnricher.enrichedEvents[0] {
+		t.Error("expected inserter to receive enriched event, but did not")
+	}
+}
+
+func TestSinkFromRecordsHost(t *testing.T) {
+	mockTableCreator := newMockTableCreator(nil)
+	mockInserter := newMockInserter(nil, nil, nil)
+	mockFilter := newMockFilter(true)
+	mockSampler := newMockSampler(true, 1, nil)
+	mockAggregator := newMockAggregator(nil)
+	sinker, err := newSinker(context.TODO(), discardLogger, mockHost, mockTableCreator, mockInserter, mockFilter, mockSampler, mockAggregator, nil)
+	if err != nil {
+		t.Errorf("expected nil Sinker construction error, got %q (of type %T)", err, err)
+	}
+
+	mockEvent := &event.Event{
+		Time:     time.Now(),
+		SourceIP: net.ParseIP("1.2.3.4"),
+		DestIP:   net.ParseIP("7.3.3.7"),
+		OldState: tcpstate.StateClosed,
+		NewState: tcpstate.StateSynSent,
+	}
+
+	if err := sinker.SinkFrom("mock-remote-host", mockEvent); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if mockInserter.receivedHost != "mock-remote-host" {
+		t.Errorf("expected event host %q, got %q", "mock-remote-host", mockInserter.receivedHost)
+	}
+}
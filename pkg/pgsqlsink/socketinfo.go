@@ -1,9 +1,9 @@
-package main
+package pgsqlsink
 
 // SocketInfo represents Linux-internal information about a socket,
 // in a form ready to insert into the database.
+// The socket is identified on its host by its ID and inode.
 type socketInfo struct {
-	uid             string
 	id              string
 	iNode           uint32
 	userID, groupID uint32
@@ -0,0 +1,63 @@
+package pgsqlsink
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	// StatementModePrepared prepares the insert statements as named
+	// statements once per connection, and executes them by name.
+	statementModePrepared = "prepared"
+
+	// StatementModeDescribe executes the SQL using the unnamed statement of
+	// the extended protocol, describing it before each execution.
+	statementModeDescribe = "describe"
+
+	// StatementModeSimple executes the SQL using the simple protocol, with
+	// the arguments encoded into the SQL on the client.
+	statementModeSimple = "simple"
+)
+
+// ParseStatementMode parses the mode in which SQL statements are executed.
+// Only the prepared mode keeps state on the server between transactions,
+// which connection poolers in transaction mode do not support. If empty,
+// the prepared mode is used.
+func parseStatementMode(mode string) (string, error) {
+	switch mode = strings.TrimSpace(mode); mode {
+	case "":
+		return statementModePrepared, nil
+	case statementModePrepared, statementModeDescribe, statementModeSimple:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown statement mode %q", mode)
+	}
+}
+
+// StatementPreparer is an interface which describes objects which prepare
+// named SQL statements for future use.
+type statementPreparer interface {
+	prepareStatement(ctx context.Context, sql, name string) error
+}
+
+// SQLStatementPreparer prepares named SQL statements for future use on
+// a database connection.
+type sqlStatementPreparer struct {
+	conn conn
+}
+
+func newSQLStatementPreparer(conn conn) *sqlStatementPreparer {
+	return &sqlStatementPreparer{conn}
+}
+
+// PrepareStatement prepares the SQL statement with the given name.
+func (sp *sqlStatementPreparer) prepareStatement(ctx context.Context,
+	sql string,
+	name string) error {
+	if err := sp.conn.prepare(ctx, name, sql); err != nil {
+		return fmt.Errorf("preparing statement on connection: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,549 @@
+package pgsqlsink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+const (
+	eventsTableCreateSQL = `
+CREATE TABLE IF NOT EXISTS tcp_events (
+	uid            TEXT PRIMARY KEY,
+	timestamp      TIMESTAMP,
+	pid_on_cpu     INTEGER,
+	comm_on_cpu    TEXT,
+	src_ip         INET,
+	dst_ip         INET,
+	src_port       INTEGER,
+	dst_port       INTEGER,
+	old_state      tcp_state,
+	new_state      tcp_state,
+	host           TEXT,
+	sample_rate    DOUBLE PRECISION NOT NULL DEFAULT 1,
+	src_name       TEXT,
+	dst_name       TEXT,
+	src_service    TEXT,
+	dst_service    TEXT,
+	container_id   TEXT,
+	pod_namespace  TEXT,
+	pod_name       TEXT,
+	container_name TEXT,
+	src_country    TEXT,
+	dst_country    TEXT,
+	src_asn        BIGINT,
+	dst_asn        BIGINT,
+	src_as_org     TEXT,
+	dst_as_org     TEXT,
+	direction      tcp_direction,
+	local_ip       INET,
+	local_port     INTEGER,
+	remote_ip      INET,
+	remote_port    INTEGER,
+	socket_id      TEXT,
+	socket_inode   BIGINT,
+	socket_state   socket_state,
+	chain_seq      BIGINT,
+	chain_hash     TEXT
+)`
+
+	socketsTableCreateSQL = `
+CREATE TABLE IF NOT EXISTS tcp_sockets (
+	host     TEXT NOT NULL,
+	id       TEXT NOT NULL,
+	inode    BIGINT NOT NULL,
+	user_id  BIGINT,
+	group_id BIGINT,
+	PRIMARY KEY (host, id, inode)
+)`
+
+	// Tables created by earlier versions stored the socket information of each
+	// event in tcp_events_socket_info, which is kept for those events.
+	eventsTableSocketMigrationSQL = `
+ALTER TABLE tcp_events
+	ADD COLUMN IF NOT EXISTS socket_id TEXT,
+	ADD COLUMN IF NOT EXISTS socket_inode BIGINT,
+	ADD COLUMN IF NOT EXISTS socket_state socket_state`
+
+	// There is no ADD CONSTRAINT IF NOT EXISTS, so the error returned if the
+	// constraint already exists is ignored.
+	eventsTableSocketForeignKeySQL = `
+ALTER TABLE tcp_events ADD CONSTRAINT fk_tcp_sockets
+	FOREIGN KEY (host, socket_id, socket_inode) REFERENCES tcp_sockets (host, id, inode)`
+
+	// Tables created by earlier versions did not link events by a hash
+	// chain.
+	eventsTableChainMigrationSQL = `
+ALTER TABLE tcp_events
+	ADD COLUMN IF NOT EXISTS chain_seq BIGINT,
+	ADD COLUMN IF NOT EXISTS chain_hash TEXT`
+
+	chainCheckpointsTableCreateSQL = `
+CREATE TABLE IF NOT EXISTS tcp_events_chain_checkpoints (
+	host       TEXT NOT NULL,
+	chain_seq  BIGINT NOT NULL,
+	chain_hash TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	key_id     TEXT NOT NULL,
+	signature  TEXT NOT NULL,
+	PRIMARY KEY (host, chain_seq)
+)`
+
+	socketInfoTableCreateSQL = `
+CREATE TABLE IF NOT EXISTS tcp_events_socket_info (
+	uid           TEXT PRIMARY KEY,
+	tcp_event_uid TEXT,
+	id            TEXT,
+	inode         INTEGER,
+	user_id       INTEGER,
+	group_id      INTEGER,
+	state         socket_state,	
+	CONSTRAINT fk_tcp_events FOREIGN KEY(tcp_event_uid) 
+		REFERENCES tcp_events(uid) ON DELETE CASCADE
+)`
+
+	processInfoTableCreateSQL = `
+CREATE TABLE IF NOT EXISTS tcp_events_process_info (
+	tcp_event_uid     TEXT PRIMARY KEY,
+	cmdline           TEXT,
+	exe               TEXT,
+	parent_pid        INTEGER,
+	user_id           BIGINT,
+	user_name         TEXT,
+	cgroup            TEXT,
+	container_id      TEXT,
+	socket_user_name  TEXT,
+	socket_group_name TEXT,
+	CONSTRAINT fk_tcp_events FOREIGN KEY(tcp_event_uid)
+		REFERENCES tcp_events(uid) ON DELETE CASCADE
+)`
+
+	// Tables created by earlier versions stored states as free text.
+	// Convert them in-place to the enumerated types, which fails (and rolls back)
+	// if any existing value is not a valid label.
+	eventsTableStateMigrationSQL = `
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns
+	           WHERE table_schema = current_schema()
+	           AND table_name = 'tcp_events'
+	           AND column_name = 'old_state'
+	           AND data_type = 'text') THEN
+		ALTER TABLE tcp_events
+			ALTER COLUMN old_state TYPE tcp_state USING old_state::tcp_state,
+			ALTER COLUMN new_state TYPE tcp_state USING new_state::tcp_state;
+	END IF;
+END
+$$`
+
+	// Tables created by earlier versions did not record the host on which
+	// the event occurred.
+	eventsTableHostMigrationSQL = `
+ALTER TABLE tcp_events ADD COLUMN IF NOT EXISTS host TEXT`
+
+	// Tables created by earlier versions did not sample events, so all
+	// existing events were stored with a rate of 1.
+	eventsTableSampleRateMigrationSQL = `
+ALTER TABLE tcp_events ADD COLUMN IF NOT EXISTS sample_rate DOUBLE PRECISION NOT NULL DEFAULT 1`
+
+	// Tables created by earlier versions did not enrich events.
+	eventsTableEnrichmentMigrationSQL = `
+ALTER TABLE tcp_events
+	ADD COLUMN IF NOT EXISTS src_name TEXT,
+	ADD COLUMN IF NOT EXISTS dst_name TEXT,
+	ADD COLUMN IF NOT EXISTS src_service TEXT,
+	ADD COLUMN IF NOT EXISTS dst_service TEXT,
+	ADD COLUMN IF NOT EXISTS container_id TEXT,
+	ADD COLUMN IF NOT EXISTS pod_namespace TEXT,
+	ADD COLUMN IF NOT EXISTS pod_name TEXT,
+	ADD COLUMN IF NOT EXISTS container_name TEXT,
+	ADD COLUMN IF NOT EXISTS src_country TEXT,
+	ADD COLUMN IF NOT EXISTS dst_country TEXT,
+	ADD COLUMN IF NOT EXISTS src_asn BIGINT,
+	ADD COLUMN IF NOT EXISTS dst_asn BIGINT,
+	ADD COLUMN IF NOT EXISTS src_as_org TEXT,
+	ADD COLUMN IF NOT EXISTS dst_as_org TEXT,
+	ADD COLUMN IF NOT EXISTS direction tcp_direction,
+	ADD COLUMN IF NOT EXISTS local_ip INET,
+	ADD COLUMN IF NOT EXISTS local_port INTEGER,
+	ADD COLUMN IF NOT EXISTS remote_ip INET,
+	ADD COLUMN IF NOT EXISTS remote_port INTEGER`
+
+	samplingSummaryTableCreateSQL = `
+CREATE TABLE IF NOT EXISTS tcp_events_sampling_summary (
+	uid          TEXT PRIMARY KEY,
+	host         TEXT,
+	mode         TEXT,
+	period_start TIMESTAMP,
+	period_end   TIMESTAMP,
+	seen         BIGINT,
+	stored       BIGINT,
+	suppressed   BIGINT
+)`
+
+	socketInfoTableStateMigrationSQL = `
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns
+	           WHERE table_schema = current_schema()
+	           AND table_name = 'tcp_events_socket_info'
+	           AND column_name = 'state'
+	           AND data_type = 'text') THEN
+		ALTER TABLE tcp_events_socket_info
+			ALTER COLUMN state TYPE socket_state USING state::socket_state;
+	END IF;
+END
+$$`
+)
+
+// EnumTypeCreateSQL returns the SQL to create an enumerated type with the
+// given name and labels.
+func enumTypeCreateSQL(name string, labels []string) string {
+	quotedLabels := make([]string, 0, len(labels))
+	for _, label := range labels {
+		quotedLabels = append(quotedLabels, "'"+label+"'")
+	}
+
+	return fmt.Sprintf("CREATE TYPE %s AS ENUM (%s)", name, strings.Join(quotedLabels, ", "))
+}
+
+func tcpStateTypeCreateSQL() string {
+	labels := make([]string, 0, len(schema.TCPStates))
+	for _, state := range schema.TCPStates {
+		labels = append(labels, state.String())
+	}
+
+	return enumTypeCreateSQL(schema.TCPStateType, labels)
+}
+
+func directionTypeCreateSQL() string {
+	return enumTypeCreateSQL(schema.DirectionType, schema.Directions)
+}
+
+func socketStateTypeCreateSQL() string {
+	labels := make([]string, 0, len(schema.SocketStates))
+	for _, state := range schema.SocketStates {
+		labels = append(labels, state.String())
+	}
+
+	return enumTypeCreateSQL(schema.SocketStateType, labels)
+}
+
+// TableCreator is an interface which describes objects which create
+// the database tables required to store TCP state-change events.
+type tableCreator interface {
+	createTables(ctx context.Context) error
+}
+
+// SQLTableCreator creates the database tables required to store TCP
+// state-change events on a database connection.
+// If the hypertable config is non-nil, tcp_events is made a TimescaleDB
+// hypertable. If indexes is true, the indexes which speed up common queries
+// are created. If the append-only config is non-nil, the append-only tables
+// are protected against updates and deletes.
+type sqlTableCreator struct {
+	conn       conn
+	compatMode *compatMode
+	hypertable *hypertableConfig
+	indexes    bool
+	appendOnly *appendOnlyConfig
+	logger     Logger
+}
+
+func newSQLTableCreator(conn conn,
+	compatMode *compatMode,
+	hypertable *hypertableConfig,
+	indexes bool,
+	appendOnly *appendOnlyConfig,
+	logger Logger) *sqlTableCreator {
+	return &sqlTableCreator{conn, compatMode, hypertable, indexes, appendOnly, logger}
+}
+
+// CreateTables creates the types and tables in the database if they do not
+// already exist, and migrates tables created by earlier versions.
+func (tc *sqlTableCreator) createTables(ctx context.Context) error {
+	if err := tc.createType(ctx, tcpStateTypeCreateSQL()); err != nil {
+		return fmt.Errorf("creating %s type: %w", schema.TCPStateType, err)
+	}
+
+	if err := tc.createType(ctx, socketStateTypeCreateSQL()); err != nil {
+		return fmt.Errorf("creating %s type: %w", schema.SocketStateType, err)
+	}
+
+	if err := tc.createType(ctx, directionTypeCreateSQL()); err != nil {
+		return fmt.Errorf("creating %s type: %w", schema.DirectionType, err)
+	}
+
+	if err := tc.conn.exec(ctx, eventsTableCreateSQL); err != nil {
+		if errors.Is(err, errDuplicateTable) {
+			// Table already created - nothing to do!
+			// This should not happen as we use CREATE TABLE IF NOT EXISTS, but it is a easy check to do.
+			return nil
+		}
+
+		return fmt.Errorf("creating tcp_events table: %w", err)
+	}
+
+	if err := tc.conn.exec(ctx, socketInfoTableCreateSQL); err != nil {
+		if errors.Is(err, errDuplicateTable) {
+			// Table already created - nothing to do!
+			// This should not happen as we use CREATE TABLE IF NOT EXISTS, but it is a easy check to do.
+			return nil
+		}
+
+		return fmt.Errorf("creating tcp_events_socket_info table: %w", err)
+	}
+
+	if tc.compatMode.doBlocks {
+		if err := tc.conn.exec(ctx, eventsTableStateMigrationSQL); err != nil {
+			return fmt.Errorf("migrating tcp_events state columns: %w", err)
+		}
+	}
+
+	if err := tc.conn.exec(ctx, eventsTableHostMigrationSQL); err != nil {
+		return fmt.Errorf("migrating tcp_events host column: %w", err)
+	}
+
+	if err := tc.conn.exec(ctx, eventsTableSampleRateMigrationSQL); err != nil {
+		return fmt.Errorf("migrating tcp_events sample_rate column: %w", err)
+	}
+
+	if err := tc.conn.exec(ctx, eventsTableEnrichmentMigrationSQL); err != nil {
+		return fmt.Errorf("migrating tcp_events enrichment columns: %w", err)
+	}
+
+	if tc.compatMode.doBlocks {
+		if err := tc.conn.exec(ctx, socketInfoTableStateMigrationSQL); err != nil {
+			return fmt.Errorf("migrating tcp_events_socket_info state column: %w", err)
+		}
+	}
+
+	if err := tc.conn.exec(ctx, socketsTableCreateSQL); err != nil {
+		return fmt.Errorf("creating tcp_sockets table: %w", err)
+	}
+
+	if err := tc.conn.exec(ctx, eventsTableSocketMigrationSQL); err != nil {
+		return fmt.Errorf("migrating tcp_events socket columns: %w", err)
+	}
+
+	if err := tc.conn.exec(ctx, eventsTableSocketForeignKeySQL); err != nil {
+		if !errors.Is(err, errDuplicateObject) {
+			return fmt.Errorf("adding tcp_events foreign key to tcp_sockets: %w", err)
+		}
+
+		// Foreign key already added - nothing to do!
+	}
+
+	if err := tc.conn.exec(ctx, eventsTableChainMigrationSQL); err != nil {
+		return fmt.Errorf("migrating tcp_events chain columns: %w", err)
+	}
+
+	if err := tc.conn.exec(ctx, chainCheckpointsTableCreateSQL); err != nil {
+		return fmt.Errorf("creating tcp_events_chain_checkpoints table: %w", err)
+	}
+
+	if err := tc.conn.exec(ctx, samplingSummaryTableCreateSQL); err != nil {
+		return fmt.Errorf("creating tcp_events_sampling_summary table: %w", err)
+	}
+
+	if err := tc.conn.exec(ctx, processInfoTableCreateSQL); err != nil {
+		return fmt.Errorf("creating tcp_events_process_info table: %w", err)
+	}
+
+	for _, table := range []string{schema.RollupMinuteTable, schema.RollupHourTable} {
+		if err := tc.conn.exec(ctx, rollupTableCreateSQL(table, tc.compatMode.rollupKeyLeadsWithHost)); err != nil {
+			return fmt.Errorf("creating %s table: %w", table, err)
+		}
+	}
+
+	if tc.hypertable != nil {
+		if err := tc.createHypertable(ctx); err != nil {
+			return fmt.Errorf("creating tcp_events hypertable: %w", err)
+		}
+	}
+
+	if tc.indexes {
+		if err := tc.createIndexes(ctx); err != nil {
+			return fmt.Errorf("creating indexes: %w", err)
+		}
+	}
+
+	// Protect the tables last, so that the migrations above, such as moving
+	// existing events into hypertable chunks, are not rejected
+	if tc.appendOnly != nil {
+		if err := tc.createAppendOnly(ctx); err != nil {
+			return fmt.Errorf("protecting append-only tables: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// CreateAppendOnly installs the triggers rejecting updates and deletes of
+// the append-only tables, except by members of the retention role, and
+// revokes the privileges to update, delete and truncate them from every
+// other role but the owner. Truncation does not fire the triggers, so is
+// not permitted even to the retention role. Triggers are only replaced if
+// missing, disabled or exempting another role, as dropping a trigger blocks
+// inserts into its table.
+func (tc *sqlTableCreator) createAppendOnly(ctx context.Context) error {
+	if err := tc.conn.exec(ctx, appendOnlyFunctionCreateSQL); err != nil {
+		return fmt.Errorf("creating trigger function: %w", err)
+	}
+
+	statuses, err := queryAppendOnlyStatus(ctx, tc.conn)
+	if err != nil {
+		return fmt.Errorf("querying triggers: %w", err)
+	}
+
+	retentionRole := tc.appendOnly.retentionRole
+	for _, table := range appendOnlyTables {
+		rows, err := tc.conn.query(ctx, appendOnlyGranteesSQL, table)
+		if err != nil {
+			return fmt.Errorf("querying privileges on %s: %w", table, err)
+		}
+
+		for _, row := range rows {
+			grantee, privileges := stringValue(row[0]), []string{"UPDATE", "DELETE", "TRUNCATE"}
+			if row[0] != nil && grantee == retentionRole {
+				privileges = []string{"UPDATE", "TRUNCATE"}
+			}
+
+			if err := tc.conn.exec(ctx, appendOnlyRevokeSQL(table, grantee, privileges)); err != nil {
+				return fmt.Errorf("revoking privileges on %s: %w", table, err)
+			}
+		}
+
+		if retentionRole != "" {
+			if err := tc.conn.exec(ctx, appendOnlyGrantSQL(table, retentionRole)); err != nil {
+				return fmt.Errorf("granting privileges on %s to %s: %w", table, retentionRole, err)
+			}
+		}
+
+		if status := statuses[table]; status.enabled && status.retentionRole == retentionRole {
+			continue
+		}
+
+		if err := tc.replaceAppendOnlyTrigger(ctx, table); err != nil {
+			return fmt.Errorf("creating %s trigger: %w", table, err)
+		}
+
+		tc.logger.Printf("Installed append-only protection of %s", table)
+	}
+
+	return nil
+}
+
+// ReplaceAppendOnlyTrigger replaces the append-only trigger of the table in
+// a transaction, so that the table is never left unprotected.
+func (tc *sqlTableCreator) replaceAppendOnlyTrigger(ctx context.Context, table string) (err error) {
+	tx, err := tc.conn.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("starting database transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.rollback(ctx); rollbackErr != nil {
+				tc.logger.Printf("Error rolling-back database transaction: %v", rollbackErr)
+			}
+			return
+		}
+
+		if commitErr := tx.commit(ctx); commitErr != nil {
+			err = fmt.Errorf("committing database transaction: %w", commitErr)
+		}
+	}()
+
+	if err := tx.exec(ctx, appendOnlyTriggerDropSQL(table)); err != nil {
+		return fmt.Errorf("dropping trigger: %w", err)
+	}
+
+	if err := tx.exec(ctx, appendOnlyTriggerCreateSQL(table, tc.appendOnly.retentionRole)); err != nil {
+		return fmt.Errorf("creating trigger: %w", err)
+	}
+
+	return nil
+}
+
+// CreateIndexes creates the indexes which speed up common queries, if they
+// do not already exist. Indexes are built concurrently where supported, so
+// that other sinks inserting into the tables are not blocked.
+func (tc *sqlTableCreator) createIndexes(ctx context.Context) error {
+	for _, index := range tableIndexes {
+		method := index.method
+		if !tc.compatMode.indexMethods {
+			method = indexMethodBTree
+		}
+
+		if tc.compatMode.doBlocks {
+			if err := tc.conn.exec(ctx, invalidIndexDropSQL(index.name)); err != nil {
+				return fmt.Errorf("dropping invalid index %s: %w", index.name, err)
+			}
+		}
+
+		if err := tc.conn.exec(ctx, indexCreateSQL(index, method, true)); err != nil {
+			if !errors.Is(err, errFeatureNotSupported) {
+				return fmt.Errorf("creating index %s: %w", index.name, err)
+			}
+
+			// Concurrent builds are not supported for the table (e.g. a
+			// TimescaleDB hypertable), so build it while blocking inserts
+			if err := tc.conn.exec(ctx, indexCreateSQL(index, method, false)); err != nil {
+				return fmt.Errorf("creating index %s: %w", index.name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// CreateHypertable makes tcp_events a TimescaleDB hypertable and adds its
+// compression and retention policies. If the extension is not installed,
+// tcp_events is left as a plain table.
+func (tc *sqlTableCreator) createHypertable(ctx context.Context) error {
+	if err := tc.conn.exec(ctx, timescaleExtensionCheckSQL); err != nil {
+		if errors.Is(err, errUndefinedObject) {
+			tc.logger.Printf("TimescaleDB extension is not installed: storing events in a plain table")
+			return nil
+		}
+
+		return fmt.Errorf("checking for TimescaleDB extension: %w", err)
+	}
+
+	if err := tc.conn.exec(ctx, createHypertableSQL(tc.hypertable.chunkInterval)); err != nil {
+		return fmt.Errorf("converting table: %w", err)
+	}
+
+	if tc.hypertable.compressAfter != 0 {
+		if err := tc.conn.exec(ctx, hypertableCompressionSQL(tc.hypertable.compressAfter)); err != nil {
+			return fmt.Errorf("adding compression policy: %w", err)
+		}
+	}
+
+	if tc.hypertable.retention != 0 {
+		if err := tc.conn.exec(ctx, hypertableRetentionSQL(tc.hypertable.retention)); err != nil {
+			return fmt.Errorf("adding retention policy: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// CreateType creates an enumerated type in the database, ignoring the error
+// returned if it already exists. There is no CREATE TYPE IF NOT EXISTS.
+func (tc *sqlTableCreator) createType(ctx context.Context, sql string) error {
+	if err := tc.conn.exec(ctx, sql); err != nil {
+		if errors.Is(err, errDuplicateObject) {
+			// Type already created - nothing to do!
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
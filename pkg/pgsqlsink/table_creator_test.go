@@ -0,0 +1,202 @@
+package pgsqlsink
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+func TestTCPStateTypeCreateSQL(t *testing.T) {
+	sql := tcpStateTypeCreateSQL()
+	t.Logf("got SQL %q", sql)
+
+	for _, state := range schema.TCPStates {
+		if !strings.Contains(sql, "'"+state.String()+"'") {
+			t.Errorf("expected SQL to contain label for TCP state %q, but did not", state)
+		}
+	}
+}
+
+func TestSocketStateTypeCreateSQL(t *testing.T) {
+	sql := socketStateTypeCreateSQL()
+	t.Logf("got SQL %q", sql)
+
+	for _, state := range schema.SocketStates {
+		if !strings.Contains(sql, "'"+state.String()+"'") {
+			t.Errorf("expected SQL to contain label for socket state %q, but did not", state)
+		}
+	}
+}
+
+func TestCreateTablesHypertable(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	hypertable := &hypertableConfig{
+		chunkInterval: time.Hour,
+		compressAfter: 24 * time.Hour,
+	}
+	tableCreator := newSQLTableCreator(mockConn, compatModes[compatModePostgreSQL], hypertable, false, nil, discardLogger)
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	executed := strings.Join(mockConn.receivedSQL, "\n")
+	if !strings.Contains(executed, createHypertableSQL(time.Hour)) {
+		t.Error("expected hypertable to be created, but was not")
+	}
+
+	if !strings.Contains(executed, "INTERVAL '3600000000 microseconds'") {
+		t.Error("expected chunk interval of 1 hour, but was not")
+	}
+
+	if !strings.Contains(executed, "add_compression_policy") {
+		t.Error("expected compression policy to be added, but was not")
+	}
+
+	if strings.Contains(executed, "add_retention_policy") {
+		t.Error("expected retention policy to not be added, but was")
+	}
+}
+
+func TestCreateTablesHypertableFallbackWithoutExtension(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	mockConn.execErrorsToReturn = map[string]error{
+		timescaleExtensionCheckSQL: &backendError{errUndefinedObject, errors.New("mock extension not installed")},
+	}
+	hypertable := &hypertableConfig{chunkInterval: time.Hour, retention: 24 * time.Hour}
+	tableCreator := newSQLTableCreator(mockConn, compatModes[compatModePostgreSQL], hypertable, false, nil, discardLogger)
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	executed := strings.Join(mockConn.receivedSQL, "\n")
+	if strings.Contains(executed, "create_hypertable") || strings.Contains(executed, "add_retention_policy") {
+		t.Error("expected tcp_events to be left as a plain table, but was not")
+	}
+}
+
+func TestCreateTablesSocketForeignKeyAlreadyAdded(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	mockConn.execErrorsToReturn = map[string]error{
+		eventsTableSocketForeignKeySQL: &backendError{errDuplicateObject, errors.New("mock constraint already exists")},
+	}
+	tableCreator := newSQLTableCreator(mockConn, compatModes[compatModePostgreSQL], nil, false, nil, discardLogger)
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	executed := strings.Join(mockConn.receivedSQL, "\n")
+	if !strings.Contains(executed, "CREATE TABLE IF NOT EXISTS tcp_sockets") {
+		t.Error("expected tcp_sockets table to be created, but was not")
+	}
+}
+
+func TestCreateTablesSocketForeignKeyError(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	mockError := errors.New("mock foreign key error")
+	mockConn.execErrorsToReturn = map[string]error{
+		eventsTableSocketForeignKeySQL: mockError,
+	}
+	tableCreator := newSQLTableCreator(mockConn, compatModes[compatModePostgreSQL], nil, false, nil, discardLogger)
+
+	err := tableCreator.createTables(context.TODO())
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+func TestCreateTablesIndexes(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	tableCreator := newSQLTableCreator(mockConn, compatModes[compatModePostgreSQL], nil, true, nil, discardLogger)
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	executed := strings.Join(mockConn.receivedSQL, "\n")
+	for _, index := range tableIndexes {
+		if !strings.Contains(executed, indexCreateSQL(index, index.method, true)) {
+			t.Errorf("expected index %s to be created concurrently, but was not", index.name)
+		}
+
+		if !strings.Contains(executed, invalidIndexDropSQL(index.name)) {
+			t.Errorf("expected invalid index %s to be dropped, but was not", index.name)
+		}
+	}
+}
+
+func TestCreateTablesIndexesDisabled(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	tableCreator := newSQLTableCreator(mockConn, compatModes[compatModePostgreSQL], nil, false, nil, discardLogger)
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if executed := strings.Join(mockConn.receivedSQL, "\n"); strings.Contains(executed, "CREATE INDEX") {
+		t.Error("expected no indexes to be created, but were")
+	}
+}
+
+func TestCreateTablesIndexesNotConcurrentlyIfUnsupported(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	index := tableIndexes[0]
+	mockConn.execErrorsToReturn = map[string]error{
+		indexCreateSQL(index, index.method, true): &backendError{errFeatureNotSupported,
+			errors.New("mock hypertables do not support concurrent index creation")},
+	}
+	tableCreator := newSQLTableCreator(mockConn, compatModes[compatModePostgreSQL], nil, true, nil, discardLogger)
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	executed := strings.Join(mockConn.receivedSQL, "\n")
+	if !strings.Contains(executed, indexCreateSQL(index, index.method, false)) {
+		t.Errorf("expected index %s to be created without CONCURRENTLY, but was not", index.name)
+	}
+}
+
+func TestCreateTablesIndexesDistributed(t *testing.T) {
+	mockConn := newMockConn(nil, nil)
+	tableCreator := newSQLTableCreator(mockConn, compatModes[compatModeCockroachDB], nil, true, nil, discardLogger)
+
+	if err := tableCreator.createTables(context.TODO()); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	executed := strings.Join(mockConn.receivedSQL, "\n")
+	if strings.Contains(executed, "USING") || strings.Contains(executed, "pg_index") {
+		t.Errorf("expected only B-tree indexes to be created, without DO blocks, but was:\n%s", executed)
+	}
+
+	if count := strings.Count(executed, "CREATE INDEX"); count != len(tableIndexes) {
+		t.Errorf("expected %d indexes to be created, but %d were", len(tableIndexes), count)
+	}
+}
+
+func TestIndexCreateSQL(t *testing.T) {
+	index := &tableIndex{"mock_idx", "mock_table", indexMethodSPGiST, []string{"a", "b"}}
+
+	expected := "CREATE INDEX CONCURRENTLY IF NOT EXISTS mock_idx ON mock_table USING spgist (a, b)"
+	if sql := indexCreateSQL(index, index.method, true); sql != expected {
+		t.Errorf("expected %q, got %q", expected, sql)
+	}
+
+	expected = "CREATE INDEX IF NOT EXISTS mock_idx ON mock_table (a, b)"
+	if sql := indexCreateSQL(index, indexMethodBTree, false); sql != expected {
+		t.Errorf("expected %q, got %q", expected, sql)
+	}
+}
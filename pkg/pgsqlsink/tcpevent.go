@@ -0,0 +1,46 @@
+package pgsqlsink
+
+import (
+	"net"
+	"time"
+)
+
+// TCPEvent represents a TCP state-change event, in a form ready to insert
+// into the database.
+type tcpEvent struct {
+	uid                string
+	host               string
+	time               time.Time
+	pid                int
+	comm               string
+	srcIP, dstIP       net.IP
+	srcPort, dstPort   uint16
+	oldState, newState string
+	sampleRate         float64
+
+	// Optional enrichment, which is nil if not available
+	srcName, dstName       *string
+	srcService, dstService *string
+	processInfo            *processInfo
+
+	// The container and Kubernetes workload of the on-CPU process
+	containerID, podNamespace, podName, containerName *string
+
+	// The country and autonomous system of the endpoints
+	srcCountry, dstCountry *string
+	srcASN, dstASN         *int64
+	srcASOrg, dstASOrg     *string
+
+	// The local and remote endpoints, or nil if they could not be decided
+	endpoints *endpoints
+}
+
+// StorageIP returns the IP address in the form in which it is stored,
+// where IPv4 addresses are stored in their 4-byte form.
+func storageIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+
+	return ip
+}
@@ -0,0 +1,254 @@
+package query
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+// Filter describes the TCP state-change events to be returned by a query.
+// Zero-valued fields do not constrain the results, and events must match
+// all of the non-zero fields.
+type Filter struct {
+	// From and To bound the time range of the events. From is inclusive,
+	// and To is exclusive.
+	From, To time.Time
+
+	// UID matches the event with this UID only.
+	UID string
+
+	// Host is the host on which the events were recorded.
+	Host string
+
+	// Net matches events where either endpoint is within the network.
+	// SourceNet and DestNet match the source or destination endpoint only.
+	// A single address can be matched using a network with a full-length mask.
+	Net, SourceNet, DestNet *net.IPNet
+
+	// Port matches events where either endpoint has the port.
+	// SourcePort and DestPort match the source or destination endpoint only.
+	Port, SourcePort, DestPort uint16
+
+	// Command is the name of the process on-CPU at the time of the event.
+	// A '*' matches any sequence of characters.
+	Command string
+
+	// PID is the ID of the process on-CPU at the time of the event.
+	// Nil matches any PID, as zero is a valid PID for events occurring
+	// in interrupt context.
+	PID *int
+
+	// OldState and NewState match the TCP state transition.
+	OldState, NewState tcpstate.State
+
+	// ContainerID, PodNamespace, PodName and ContainerName match the
+	// container and Kubernetes workload of the process on-CPU at the time
+	// of the event, for events stored with container enrichment.
+	ContainerID, PodNamespace, PodName, ContainerName string
+
+	// Direction matches the direction of the connection relative to the host,
+	// one of schema.DirectionInbound, DirectionOutbound or DirectionLoopback.
+	Direction string
+}
+
+// Cursor identifies the position of an event in the result ordering, so that
+// a subsequent query can resume from it.
+type Cursor struct {
+	Time time.Time
+	UID  string
+}
+
+// Page describes a subset of the results of a query.
+type Page struct {
+	// Limit is the maximum number of events to return. If zero, all events
+	// are returned.
+	Limit int
+
+	// After, if not nil, causes only the events positioned after this
+	// cursor to be returned.
+	After *Cursor
+
+	// Descending causes the newest events to be returned first.
+	Descending bool
+}
+
+// The socket of an event is read from the sockets table, or for events
+// stored by earlier versions, from the socket info table.
+const selectSQL = `
+SELECT
+	e.uid,
+	e.host,
+	e.timestamp,
+	e.pid_on_cpu,
+	e.comm_on_cpu,
+	host(e.src_ip),
+	host(e.dst_ip),
+	e.src_port,
+	e.dst_port,
+	e.old_state::text,
+	e.new_state::text,
+	e.container_id,
+	e.pod_namespace,
+	e.pod_name,
+	e.container_name,
+	e.direction::text,
+	host(e.local_ip),
+	e.local_port,
+	host(e.remote_ip),
+	e.remote_port,
+	COALESCE(s.id, si.id),
+	COALESCE(s.inode, si.inode),
+	COALESCE(s.user_id, si.user_id),
+	COALESCE(s.group_id, si.group_id),
+	COALESCE(e.socket_state, si.state)::text
+FROM ` + schema.EventsTable + ` e
+LEFT JOIN ` + schema.SocketsTable + ` s ON s.host = e.host AND s.id = e.socket_id AND s.inode = e.socket_inode
+LEFT JOIN ` + schema.SocketInfoTable + ` si ON si.tcp_event_uid = e.uid`
+
+// QueryBuilder accumulates SQL conditions and their positional arguments.
+type queryBuilder struct {
+	conditions []string
+	arguments  []interface{}
+}
+
+// Arg records an argument, returning its positional placeholder.
+func (qb *queryBuilder) arg(argument interface{}) string {
+	qb.arguments = append(qb.arguments, argument)
+	return fmt.Sprintf("$%d", len(qb.arguments))
+}
+
+func (qb *queryBuilder) where(format string, arguments ...interface{}) {
+	placeholders := make([]interface{}, 0, len(arguments))
+	for _, argument := range arguments {
+		placeholders = append(placeholders, qb.arg(argument))
+	}
+
+	qb.conditions = append(qb.conditions, fmt.Sprintf(format, placeholders...))
+}
+
+// BuildQuery returns the SQL and arguments to select the events matching the
+// filter, restricted to the given page.
+func buildQuery(filter *Filter, page *Page) (string, []interface{}) {
+	qb := new(queryBuilder)
+
+	if !filter.From.IsZero() {
+		qb.where("e.timestamp >= %s", filter.From)
+	}
+
+	if !filter.To.IsZero() {
+		qb.where("e.timestamp < %s", filter.To)
+	}
+
+	if filter.UID != "" {
+		qb.where("e.uid = %s", filter.UID)
+	}
+
+	if filter.Host != "" {
+		qb.where("e.host = %s", filter.Host)
+	}
+
+	if filter.Net != nil {
+		placeholder := qb.arg(filter.Net)
+		qb.conditions = append(qb.conditions,
+			fmt.Sprintf("(e.src_ip <<= %[1]s OR e.dst_ip <<= %[1]s)", placeholder))
+	}
+
+	if filter.SourceNet != nil {
+		qb.where("e.src_ip <<= %s", filter.SourceNet)
+	}
+
+	if filter.DestNet != nil {
+		qb.where("e.dst_ip <<= %s", filter.DestNet)
+	}
+
+	if filter.Port != 0 {
+		placeholder := qb.arg(int32(filter.Port))
+		qb.conditions = append(qb.conditions,
+			fmt.Sprintf("(e.src_port = %[1]s OR e.dst_port = %[1]s)", placeholder))
+	}
+
+	if filter.SourcePort != 0 {
+		qb.where("e.src_port = %s", int32(filter.SourcePort))
+	}
+
+	if filter.DestPort != 0 {
+		qb.where("e.dst_port = %s", int32(filter.DestPort))
+	}
+
+	if filter.Command != "" {
+		qb.where("e.comm_on_cpu LIKE %s", globToLike(filter.Command))
+	}
+
+	if filter.PID != nil {
+		qb.where("e.pid_on_cpu = %s", int32(*filter.PID))
+	}
+
+	if filter.OldState != "" {
+		qb.where("e.old_state = %s::"+schema.TCPStateType, filter.OldState.String())
+	}
+
+	if filter.NewState != "" {
+		qb.where("e.new_state = %s::"+schema.TCPStateType, filter.NewState.String())
+	}
+
+	if filter.ContainerID != "" {
+		qb.where("e.container_id = %s", filter.ContainerID)
+	}
+
+	if filter.PodNamespace != "" {
+		qb.where("e.pod_namespace = %s", filter.PodNamespace)
+	}
+
+	if filter.PodName != "" {
+		qb.where("e.pod_name = %s", filter.PodName)
+	}
+
+	if filter.ContainerName != "" {
+		qb.where("e.container_name = %s", filter.ContainerName)
+	}
+
+	if filter.Direction != "" {
+		qb.where("e.direction = %s::"+schema.DirectionType, filter.Direction)
+	}
+
+	comparison, order := ">", "ASC"
+	if page.Descending {
+		comparison, order = "<", "DESC"
+	}
+
+	if page.After != nil {
+		timePlaceholder := qb.arg(page.After.Time)
+		uidPlaceholder := qb.arg(page.After.UID)
+		qb.conditions = append(qb.conditions, fmt.Sprintf("(e.timestamp, e.uid) %s (%s, %s)",
+			comparison,
+			timePlaceholder,
+			uidPlaceholder))
+	}
+
+	sql := new(strings.Builder)
+	sql.WriteString(selectSQL)
+
+	if len(qb.conditions) != 0 {
+		sql.WriteString("\nWHERE ")
+		sql.WriteString(strings.Join(qb.conditions, "\nAND "))
+	}
+
+	fmt.Fprintf(sql, "\nORDER BY e.timestamp %[1]s, e.uid %[1]s", order)
+
+	if page.Limit > 0 {
+		fmt.Fprintf(sql, "\nLIMIT %s", qb.arg(page.Limit))
+	}
+
+	return sql.String(), qb.arguments
+}
+
+// GlobToLike converts a pattern where '*' matches any sequence of characters
+// into an equivalent SQL LIKE pattern.
+func globToLike(glob string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`, "*", "%")
+	return escaper.Replace(glob)
+}
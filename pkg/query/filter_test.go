@@ -0,0 +1,166 @@
+package query
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+)
+
+func TestBuildQueryNoFilter(t *testing.T) {
+	sql, args := buildQuery(new(Filter), new(Page))
+	t.Logf("got SQL %q", sql)
+
+	if strings.Contains(sql, "WHERE") {
+		t.Error("expected SQL to not contain WHERE clause, but did")
+	}
+
+	if strings.Contains(sql, "LIMIT") {
+		t.Error("expected SQL to not contain LIMIT clause, but did")
+	}
+
+	if !strings.Contains(sql, "ORDER BY e.timestamp ASC, e.uid ASC") {
+		t.Error("expected SQL to order by ascending timestamp, but did not")
+	}
+
+	if len(args) != 0 {
+		t.Errorf("expected no arguments, got %d", len(args))
+	}
+}
+
+func TestBuildQueryAllFilters(t *testing.T) {
+	_, mockNet, _ := net.ParseCIDR("10.0.0.0/8")
+	mockPID := 0
+	mockFilter := &Filter{
+		From:       time.Now().Add(-time.Hour),
+		To:         time.Now(),
+		Host:       "mock-host",
+		Net:        mockNet,
+		SourceNet:  mockNet,
+		DestNet:    mockNet,
+		Port:       443,
+		SourcePort: 1234,
+		DestPort:   7337,
+		Command:    "kworker/*",
+		PID:        &mockPID,
+		OldState:   tcpstate.StateFinWait2,
+		NewState:   tcpstate.StateClosed,
+	}
+
+	sql, args := buildQuery(mockFilter, new(Page))
+	t.Logf("got SQL %q", sql)
+
+	expectedConditions := []string{
+		"e.timestamp >= $1",
+		"e.timestamp < $2",
+		"e.host = $3",
+		"(e.src_ip <<= $4 OR e.dst_ip <<= $4)",
+		"e.src_ip <<= $5",
+		"e.dst_ip <<= $6",
+		"(e.src_port = $7 OR e.dst_port = $7)",
+		"e.src_port = $8",
+		"e.dst_port = $9",
+		"e.comm_on_cpu LIKE $10",
+		"e.pid_on_cpu = $11",
+		"e.old_state = $12::tcp_state",
+		"e.new_state = $13::tcp_state",
+	}
+
+	for _, condition := range expectedConditions {
+		if !strings.Contains(sql, condition) {
+			t.Errorf("expected SQL to contain condition %q, but did not", condition)
+		}
+	}
+
+	if len(args) != len(expectedConditions) {
+		t.Errorf("expected %d arguments, got %d", len(expectedConditions), len(args))
+	}
+
+	if args[9] != "kworker/%" {
+		t.Errorf("expected command argument to be %q, got %q", "kworker/%", args[9])
+	}
+}
+
+func TestBuildQueryWorkload(t *testing.T) {
+	filter := &Filter{
+		PodNamespace:  "mock-namespace",
+		PodName:       "mock-pod",
+		ContainerName: "mock-container",
+		Direction:     "inbound",
+	}
+
+	sql, args := buildQuery(filter, new(Page))
+	t.Logf("got SQL %q", sql)
+
+	expectedClauses := []string{
+		"e.pod_namespace = $1",
+		"e.pod_name = $2",
+		"e.container_name = $3",
+		"e.direction = $4::tcp_direction",
+	}
+
+	for _, clause := range expectedClauses {
+		if !strings.Contains(sql, clause) {
+			t.Errorf("expected SQL to contain %q, but did not", clause)
+		}
+	}
+
+	if len(args) != 4 || args[0] != "mock-namespace" {
+		t.Errorf("expected workload arguments, got %v", args)
+	}
+}
+
+func TestBuildQueryUID(t *testing.T) {
+	sql, args := buildQuery(&Filter{UID: "mock-uid", Host: "mock-host"}, new(Page))
+	t.Logf("got SQL %q", sql)
+
+	if !strings.Contains(sql, "e.uid = $1") {
+		t.Errorf("expected SQL to contain %q, but did not", "e.uid = $1")
+	}
+
+	if len(args) != 2 || args[0] != "mock-uid" {
+		t.Errorf("expected UID argument, got %v", args)
+	}
+}
+
+func TestBuildQueryPage(t *testing.T) {
+	mockPage := &Page{
+		Limit:      10,
+		After:      &Cursor{Time: time.Now(), UID: "mock-uid"},
+		Descending: true,
+	}
+
+	sql, args := buildQuery(&Filter{Host: "mock-host"}, mockPage)
+	t.Logf("got SQL %q", sql)
+
+	expectedClauses := []string{
+		"e.host = $1",
+		"(e.timestamp, e.uid) < ($2, $3)",
+		"ORDER BY e.timestamp DESC, e.uid DESC",
+		"LIMIT $4",
+	}
+
+	for _, clause := range expectedClauses {
+		if !strings.Contains(sql, clause) {
+			t.Errorf("expected SQL to contain clause %q, but did not", clause)
+		}
+	}
+
+	if len(args) != 4 {
+		t.Errorf("expected %d arguments, got %d", 4, len(args))
+	}
+}
+
+func TestGlobToLike(t *testing.T) {
+	for glob, expectedLike := range map[string]string{
+		"kworker/*": "kworker/%",
+		"100%_done": `100\%\_done`,
+		`a\b*`:      `a\\b%`,
+	} {
+		if like := globToLike(glob); like != expectedLike {
+			t.Errorf("expected glob %q to convert to %q, got %q", glob, expectedLike, like)
+		}
+	}
+}
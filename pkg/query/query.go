@@ -0,0 +1,275 @@
+// Package query provides read access to the TCP state-change events stored
+// in the database by the sink.
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/event"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/pgconfig"
+	"github.com/jhwbarlow/tcp-audit-pgsql-sink/pkg/schema"
+)
+
+// ErrStopStream may be returned by the function passed to Stream in order to
+// stop the stream early without Stream returning an error.
+var ErrStopStream = errors.New("stop stream")
+
+// Event is a TCP state-change event read from the database.
+type Event struct {
+	event.Event
+
+	// UID is the unique ID of the event in the database.
+	UID string
+
+	// Host is the host on which the event was recorded. It is empty for
+	// events stored by versions of the sink which did not record it.
+	Host string
+
+	// Workload is the container and Kubernetes workload of the process
+	// on-CPU at the time of the event. It is nil for events stored without
+	// container enrichment, or where the process was not in a container.
+	Workload *Workload
+
+	// Endpoints are the local and remote endpoints of the connection, and
+	// its direction relative to the host. It is nil where these could not be
+	// decided, or for events stored by versions of the sink which did not
+	// record them.
+	Endpoints *Endpoints
+}
+
+// Endpoints are the local and remote endpoints of a connection, and its
+// direction relative to the host.
+type Endpoints struct {
+	Direction             string
+	LocalIP, RemoteIP     net.IP
+	LocalPort, RemotePort uint16
+}
+
+// Workload identifies a container, and the Kubernetes workload to which it
+// belongs. The Kubernetes fields are empty if they could not be resolved.
+type Workload struct {
+	ContainerID   string
+	PodNamespace  string
+	PodName       string
+	ContainerName string
+}
+
+// Cursor returns the position of the event in the result ordering.
+func (e *Event) Cursor() *Cursor {
+	return &Cursor{Time: e.Time, UID: e.UID}
+}
+
+// Conn is an interface which is a wrapper around the *pgx.Conn struct.
+type Conn interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	Close(ctx context.Context) error
+}
+
+// Querier queries the TCP state-change events stored in the database.
+type Querier struct {
+	conn Conn
+}
+
+func NewQuerier(conn Conn) *Querier {
+	return &Querier{conn}
+}
+
+// Connect creates a Querier connected to the PostgreSQL database described by
+// the configuration returned by the ConfigGetter.
+func Connect(ctx context.Context, configGetter pgconfig.ConfigGetter) (*Querier, error) {
+	connString, err := configGetter.Config()
+	if err != nil {
+		return nil, fmt.Errorf("getting connection string from config: %w", err)
+	}
+
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		return nil, fmt.Errorf("establishing connection to database: %w", err)
+	}
+
+	return NewQuerier(conn), nil
+}
+
+// Stream calls fn for each event matching the filter, oldest first, without
+// holding all of the events in memory. If fn returns an error, the stream
+// is stopped and that error returned, unless it is ErrStopStream.
+func (q *Querier) Stream(ctx context.Context, filter *Filter, fn func(*Event) error) error {
+	return q.StreamPage(ctx, filter, new(Page), fn)
+}
+
+// StreamPage is like Stream, but restricts the events to the given page.
+func (q *Querier) StreamPage(ctx context.Context,
+	filter *Filter,
+	page *Page,
+	fn func(*Event) error) error {
+	sql, args := buildQuery(filter, page)
+
+	rows, err := q.conn.Query(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("querying events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return fmt.Errorf("scanning event: %w", err)
+		}
+
+		if err := fn(event); err != nil {
+			if errors.Is(err, ErrStopStream) {
+				return nil
+			}
+
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reading events: %w", err)
+	}
+
+	return nil
+}
+
+// List returns the events matching the filter, restricted to the given page.
+// If the page may not be the last, a cursor is returned which can be used
+// as the After field of the next page.
+func (q *Querier) List(ctx context.Context, filter *Filter, page *Page) ([]*Event, *Cursor, error) {
+	var events []*Event
+	if err := q.StreamPage(ctx, filter, page, func(event *Event) error {
+		events = append(events, event)
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	if page.Limit == 0 || len(events) < page.Limit {
+		return events, nil, nil
+	}
+
+	return events, events[len(events)-1].Cursor(), nil
+}
+
+// Close releases the resources held by this Querier, namely the
+// database connection.
+func (q *Querier) Close(ctx context.Context) error {
+	if err := q.conn.Close(ctx); err != nil {
+		return fmt.Errorf("closing connection: %w", err)
+	}
+
+	return nil
+}
+
+// ScanEvent scans the current row, selected by the SQL returned from
+// buildQuery, into an event.
+func scanEvent(rows pgx.Rows) (*Event, error) {
+	var (
+		uid, comm                    string
+		host                         *string
+		timestamp                    time.Time
+		pid                          int32
+		srcIP, dstIP                 string
+		srcPort, dstPort             int32
+		oldState, newState           string
+		containerID, podNamespace    *string
+		podName, containerName       *string
+		direction, localIP, remoteIP *string
+		localPort, remotePort        *int32
+		sockID, sockState            *string
+		sockINode, sockUID, sockGID  *int64
+	)
+
+	if err := rows.Scan(&uid,
+		&host,
+		&timestamp,
+		&pid,
+		&comm,
+		&srcIP,
+		&dstIP,
+		&srcPort,
+		&dstPort,
+		&oldState,
+		&newState,
+		&containerID,
+		&podNamespace,
+		&podName,
+		&containerName,
+		&direction,
+		&localIP,
+		&localPort,
+		&remoteIP,
+		&remotePort,
+		&sockID,
+		&sockINode,
+		&sockUID,
+		&sockGID,
+		&sockState); err != nil {
+		return nil, err
+	}
+
+	storedEvent := &Event{UID: uid}
+	if host != nil {
+		storedEvent.Host = *host
+	}
+
+	storedEvent.Time = timestamp
+	storedEvent.PIDOnCPU = int(pid)
+	storedEvent.CommandOnCPU = comm
+	storedEvent.SourceIP = net.ParseIP(srcIP)
+	storedEvent.DestIP = net.ParseIP(dstIP)
+	storedEvent.SourcePort = uint16(srcPort)
+	storedEvent.DestPort = uint16(dstPort)
+
+	var err error
+	if storedEvent.OldState, err = tcpstate.FromString(oldState); err != nil {
+		return nil, fmt.Errorf("parsing old state: %w", err)
+	}
+
+	if storedEvent.NewState, err = tcpstate.FromString(newState); err != nil {
+		return nil, fmt.Errorf("parsing new state: %w", err)
+	}
+
+	if containerID != nil {
+		storedEvent.Workload = &Workload{ContainerID: *containerID}
+
+		if podNamespace != nil && podName != nil && containerName != nil {
+			storedEvent.Workload.PodNamespace = *podNamespace
+			storedEvent.Workload.PodName = *podName
+			storedEvent.Workload.ContainerName = *containerName
+		}
+	}
+
+	if direction != nil && localIP != nil && localPort != nil && remoteIP != nil && remotePort != nil {
+		storedEvent.Endpoints = &Endpoints{
+			Direction:  *direction,
+			LocalIP:    net.ParseIP(*localIP),
+			LocalPort:  uint16(*localPort),
+			RemoteIP:   net.ParseIP(*remoteIP),
+			RemotePort: uint16(*remotePort),
+		}
+	}
+
+	if sockID != nil {
+		socketState, err := schema.SocketStateFromString(*sockState)
+		if err != nil {
+			return nil, fmt.Errorf("parsing socket state: %w", err)
+		}
+
+		storedEvent.SocketInfo = &event.SocketInfo{
+			ID:          *sockID,
+			INode:       uint32(*sockINode),
+			UID:         uint32(*sockUID),
+			GID:         uint32(*sockGID),
+			SocketState: socketState,
+		}
+	}
+
+	return storedEvent, nil
+}
@@ -0,0 +1,274 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/socketstate"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+)
+
+type mockConn struct {
+	rowsToReturn       *mockRows
+	queryErrorToReturn error
+
+	queryCalled  bool
+	closeCalled  bool
+	receivedSQL  string
+	receivedArgs []interface{}
+}
+
+func newMockConn(rowsToReturn *mockRows, queryErrorToReturn error) *mockConn {
+	return &mockConn{
+		rowsToReturn:       rowsToReturn,
+		queryErrorToReturn: queryErrorToReturn,
+	}
+}
+
+func (mc *mockConn) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	mc.queryCalled = true
+	mc.receivedSQL = sql
+	mc.receivedArgs = args
+
+	if mc.queryErrorToReturn != nil {
+		return nil, mc.queryErrorToReturn
+	}
+
+	return mc.rowsToReturn, nil
+}
+
+func (mc *mockConn) Close(ctx context.Context) error {
+	mc.closeCalled = true
+	return nil
+}
+
+type mockRows struct {
+	// Embedded so that the methods not used by the Querier need not be implemented
+	pgx.Rows
+
+	rows [][]interface{}
+	next int
+
+	closeCalled bool
+}
+
+func newMockRows(rows ...[]interface{}) *mockRows {
+	return &mockRows{rows: rows}
+}
+
+func (mr *mockRows) Next() bool {
+	mr.next++
+	return mr.next <= len(mr.rows)
+}
+
+func (mr *mockRows) Scan(dest ...interface{}) error {
+	row := mr.rows[mr.next-1]
+	for i, value := range row {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(value))
+	}
+
+	return nil
+}
+
+func (mr *mockRows) Err() error {
+	return nil
+}
+
+func (mr *mockRows) Close() {
+	mr.closeCalled = true
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+func newMockRow(uid string, withSocketInfo bool) []interface{} {
+	row := []interface{}{
+		uid,
+		stringPtr("mock-host"),
+		time.Date(2021, 8, 31, 22, 46, 5, 0, time.UTC),
+		int32(7337),
+		"mock-command",
+		"1.2.3.4",
+		"7.3.3.7",
+		int32(1234),
+		int32(443),
+		tcpstate.StateFinWait2.String(),
+		tcpstate.StateClosed.String(),
+		stringPtr("mock-container-id"),
+		stringPtr("mock-namespace"),
+		stringPtr("mock-pod"),
+		stringPtr("mock-container"),
+		stringPtr("outbound"),
+		stringPtr("1.2.3.4"),
+		int32Ptr(1234),
+		stringPtr("7.3.3.7"),
+		int32Ptr(443),
+	}
+
+	if !withSocketInfo {
+		return append(row, (*string)(nil), (*int64)(nil), (*int64)(nil), (*int64)(nil), (*string)(nil))
+	}
+
+	return append(row,
+		stringPtr("mock-socket-id"),
+		int64Ptr(0xF00D),
+		int64Ptr(0xCAFE),
+		int64Ptr(0xBEEF),
+		stringPtr(socketstate.StateUnconnected.String()))
+}
+
+func TestStream(t *testing.T) {
+	mockRows := newMockRows(newMockRow("mock-uid-1", false), newMockRow("mock-uid-2", true))
+	mockConn := newMockConn(mockRows, nil)
+	querier := NewQuerier(mockConn)
+
+	var events []*Event
+	if err := querier.Stream(context.TODO(), new(Filter), func(event *Event) error {
+		events = append(events, event)
+		return nil
+	}); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if !mockRows.closeCalled {
+		t.Error("expected rows to be closed, but were not")
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected %d events, got %d", 2, len(events))
+	}
+
+	if events[0].UID != "mock-uid-1" {
+		t.Errorf("expected event UID to be %q, but was %q", "mock-uid-1", events[0].UID)
+	}
+
+	if events[0].Host != "mock-host" {
+		t.Errorf("expected event host to be %q, but was %q", "mock-host", events[0].Host)
+	}
+
+	if events[0].Workload == nil || events[0].Workload.PodName != "mock-pod" {
+		t.Errorf("expected event workload pod to be %q, but was %+v", "mock-pod", events[0].Workload)
+	}
+
+	if events[0].Endpoints == nil ||
+		events[0].Endpoints.Direction != "outbound" ||
+		!events[0].Endpoints.RemoteIP.Equal(net.ParseIP("7.3.3.7")) ||
+		events[0].Endpoints.RemotePort != 443 {
+		t.Errorf("expected event to be outbound to 7.3.3.7:443, but was %+v", events[0].Endpoints)
+	}
+
+	if !events[0].SourceIP.Equal(net.ParseIP("1.2.3.4")) {
+		t.Errorf("expected event source IP to be %q, but was %q", "1.2.3.4", events[0].SourceIP)
+	}
+
+	if events[0].OldState != tcpstate.StateFinWait2 || events[0].NewState != tcpstate.StateClosed {
+		t.Errorf("expected event transition to be %q -> %q, but was %q -> %q",
+			tcpstate.StateFinWait2,
+			tcpstate.StateClosed,
+			events[0].OldState,
+			events[0].NewState)
+	}
+
+	if events[0].SocketInfo != nil {
+		t.Error("expected first event socket info to be nil, but was not")
+	}
+
+	if events[1].SocketInfo == nil {
+		t.Fatal("expected second event socket info to be non-nil, but was nil")
+	}
+
+	if events[1].SocketInfo.SocketState != socketstate.StateUnconnected {
+		t.Errorf("expected event socket state to be %q, but was %q",
+			socketstate.StateUnconnected,
+			events[1].SocketInfo.SocketState)
+	}
+}
+
+func TestStreamStopped(t *testing.T) {
+	mockRows := newMockRows(newMockRow("mock-uid-1", false), newMockRow("mock-uid-2", false))
+	mockConn := newMockConn(mockRows, nil)
+	querier := NewQuerier(mockConn)
+
+	calls := 0
+	if err := querier.Stream(context.TODO(), new(Filter), func(event *Event) error {
+		calls++
+		return ErrStopStream
+	}); err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if calls != 1 {
+		t.Errorf("expected stream function to be called %d times, but was called %d times", 1, calls)
+	}
+}
+
+func TestStreamQueryError(t *testing.T) {
+	mockError := errors.New("mock query error")
+	mockConn := newMockConn(nil, mockError)
+	querier := NewQuerier(mockConn)
+
+	err := querier.Stream(context.TODO(), new(Filter), func(event *Event) error {
+		return nil
+	})
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+
+	t.Logf("got error %q (of type %T)", err, err)
+
+	if !errors.Is(err, mockError) {
+		t.Errorf("expected error chain to include %q, but did not", mockError)
+	}
+}
+
+func TestListFullPageReturnsCursor(t *testing.T) {
+	mockRows := newMockRows(newMockRow("mock-uid-1", false), newMockRow("mock-uid-2", false))
+	mockConn := newMockConn(mockRows, nil)
+	querier := NewQuerier(mockConn)
+
+	events, cursor, err := querier.List(context.TODO(), new(Filter), &Page{Limit: 2})
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if len(events) != 2 {
+		t.Errorf("expected %d events, got %d", 2, len(events))
+	}
+
+	if cursor == nil {
+		t.Fatal("expected non-nil cursor, got nil")
+	}
+
+	if cursor.UID != "mock-uid-2" {
+		t.Errorf("expected cursor UID to be %q, but was %q", "mock-uid-2", cursor.UID)
+	}
+}
+
+func TestListPartialPageReturnsNoCursor(t *testing.T) {
+	mockRows := newMockRows(newMockRow("mock-uid-1", false))
+	mockConn := newMockConn(mockRows, nil)
+	querier := NewQuerier(mockConn)
+
+	_, cursor, err := querier.List(context.TODO(), new(Filter), &Page{Limit: 2})
+	if err != nil {
+		t.Errorf("expected nil error, got %q (of type %T)", err, err)
+	}
+
+	if cursor != nil {
+		t.Errorf("expected nil cursor, got %v", cursor)
+	}
+}
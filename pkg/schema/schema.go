@@ -0,0 +1,99 @@
+// Package schema describes the layout of the database tables in which the
+// sink stores TCP state-change events, so that tools which read the data need
+// not duplicate it.
+package schema
+
+import (
+	"fmt"
+
+	"github.com/jhwbarlow/tcp-audit-common/pkg/socketstate"
+	"github.com/jhwbarlow/tcp-audit-common/pkg/tcpstate"
+)
+
+const (
+	// EventsTable is the name of the table storing TCP state-change events.
+	EventsTable = "tcp_events"
+
+	// SocketInfoTable is the name of the table storing the socket information
+	// related to a TCP state-change event, if available, for events stored by
+	// earlier versions. Events now reference a row of SocketsTable.
+	SocketInfoTable = "tcp_events_socket_info"
+
+	// SocketsTable is the name of the table storing each socket once, keyed
+	// by the host, and the kernel ID and inode of the socket.
+	SocketsTable = "tcp_sockets"
+
+	// ProcessInfoTable is the name of the table storing information about
+	// the process on-CPU at the time of a TCP state-change event, if enriched.
+	ProcessInfoTable = "tcp_events_process_info"
+
+	// SamplingSummaryTable is the name of the table storing the number of
+	// events seen and stored by the sampler in each period.
+	SamplingSummaryTable = "tcp_events_sampling_summary"
+
+	// ChainCheckpointsTable is the name of the table storing the signed
+	// checkpoints of the hash chain linking the events of each host.
+	ChainCheckpointsTable = "tcp_events_chain_checkpoints"
+
+	// RollupMinuteTable and RollupHourTable are the names of the tables
+	// storing counts of TCP state-change events per minute and per hour.
+	RollupMinuteTable = "tcp_events_rollup_minute"
+	RollupHourTable   = "tcp_events_rollup_hour"
+
+	// TCPStateType is the name of the enumerated type storing TCP states.
+	TCPStateType = "tcp_state"
+
+	// SocketStateType is the name of the enumerated type storing socket states.
+	SocketStateType = "socket_state"
+
+	// DirectionType is the name of the enumerated type storing the direction
+	// of a connection relative to the host.
+	DirectionType = "tcp_direction"
+
+	// The directions of a connection relative to the host.
+	DirectionInbound  = "inbound"
+	DirectionOutbound = "outbound"
+	DirectionLoopback = "loopback"
+)
+
+// Directions lists every connection direction which may be stored.
+var Directions = []string{
+	DirectionInbound,
+	DirectionOutbound,
+	DirectionLoopback,
+}
+
+// TCPStates lists every TCP state which may be stored, in RFC 793 order.
+var TCPStates = []tcpstate.State{
+	tcpstate.StateListen,
+	tcpstate.StateSynSent,
+	tcpstate.StateSynReceived,
+	tcpstate.StateEstablished,
+	tcpstate.StateFinWait1,
+	tcpstate.StateFinWait2,
+	tcpstate.StateCloseWait,
+	tcpstate.StateClosing,
+	tcpstate.StateLastAck,
+	tcpstate.StateTimeWait,
+	tcpstate.StateClosed,
+}
+
+// SocketStates lists every kernel socket state which may be stored.
+var SocketStates = []socketstate.State{
+	socketstate.StateFree,
+	socketstate.StateUnconnected,
+	socketstate.StateConnecting,
+	socketstate.StateConnected,
+	socketstate.StateDisconnecting,
+}
+
+// SocketStateFromString returns the socket state with the given stored label.
+func SocketStateFromString(state string) (socketstate.State, error) {
+	for _, socketState := range SocketStates {
+		if socketState.String() == state {
+			return socketState, nil
+		}
+	}
+
+	return socketstate.State(0xFF), fmt.Errorf("illegal socket state string: %q", state)
+}